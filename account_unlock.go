@@ -0,0 +1,158 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// accountUnlockPurpose scopes TokenService tokens to the unlock flow
+const accountUnlockPurpose = "account_unlock"
+
+// UnlockAccountForm is the request body for unlocking an account from an emailed link
+type UnlockAccountForm struct {
+	Token string `json:"token" binding:"required"` // The signed unlock token
+}
+
+var selfServiceUnlock atomic.Bool
+
+// SetSelfServiceUnlock turns on emailing an unlock link when Login locks an account
+func SetSelfServiceUnlock(enabled bool) {
+	selfServiceUnlock.Store(enabled)
+}
+
+// accountUnlockBinding ties unlock tokens to one lockout, so a link stops working once the
+// account is unlocked or locked again
+func accountUnlockBinding(user *User) string {
+	if user.LockedUntil == nil {
+		return ""
+	}
+	return strconv.FormatInt(user.LockedUntil.UnixMilli(), 10)
+}
+
+// sendAccountUnlockEmail emails a signed unlock link for a just-locked account
+func sendAccountUnlockEmail(r *http.Request, user *User, secret string) {
+	tokens, err := NewTokenService(secret)
+	if err != nil {
+		log.Printf("Failed to create token service for account unlock: %v", err)
+		return
+	}
+
+	// The link lasts as long as the lockout; after it the account unlocks by itself
+	token, err := tokens.Issue(accountUnlockPurpose, user.ID, accountUnlockBinding(user), time.Until(user.LockedUntil.Time))
+	if err != nil {
+		log.Printf("Failed to issue account unlock token: %v", err)
+		return
+	}
+
+	if err := SendAccountUnlockEmail(user.Email, "", user.Name, "", token, ResolveLocale(r, user)); err != nil {
+		log.Printf("Failed to send account unlock email: %v", err)
+	}
+}
+
+// UnlockAccount handles unlocking an account from the emailed link
+func UnlockAccount(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) {
+	var form UnlockAccountForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+
+	form.Token = SanitizeInput(form.Token)
+
+	tokens, err := NewTokenService(secret)
+	if err != nil {
+		log.Printf("Failed to create token service for account unlock: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server configuration error"})
+		return
+	}
+
+	claims, err := tokens.Verify(form.Token, accountUnlockPurpose)
+	if err != nil {
+		RespondWithJSON(w, 400, map[string]string{"error": "Invalid or expired unlock link"})
+		return
+	}
+
+	collection := database.Collection("users")
+
+	var user User
+	err = collection.FindOne(r.Context(), bson.M{"_id": claims.Subject}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			RespondWithJSON(w, 400, map[string]string{"error": "Invalid or expired unlock link"})
+			return
+		}
+		log.Printf("Failed to find user by ID: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	// The token only works for the lockout it was issued for
+	if user.LockedUntil == nil || !claims.MatchesBinding(accountUnlockBinding(&user)) {
+		RespondWithJSON(w, 400, map[string]string{"error": "Invalid or expired unlock link"})
+		return
+	}
+
+	_, err = collection.UpdateOne(r.Context(), bson.M{"_id": user.ID, "locked_until": user.LockedUntil}, bson.M{
+		"$set": bson.M{
+			"login_attempts": 0,
+			"locked_until":   nil,
+			"updated_at":     Now(),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to unlock account: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	log.Printf("SECURITY: account %s unlocked via emailed link from %s", user.ID, r.RemoteAddr)
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventAccountUnlocked, UserID: user.ID, Email: user.Email, Method: "email_link"})
+
+	RespondWithJSON(w, 200, map[string]string{
+		"message": "Your account has been unlocked. You can now log in.",
+	})
+}
+
+// SendAccountUnlockEmail sends a link that unlocks an account locked after failed logins
+// A registered "account_unlock.html" template (e.g. "account_unlock.es.html") overrides the built-in body
+func (s *EmailService) SendAccountUnlockEmail(toEmail, fromEmail, name, baseURL, unlockToken, locale string) error {
+	config := s.Config()
+	unlockLink := config.link(LinkUnlockAccount, baseURL, unlockToken)
+
+	subject := localizedSubject("account_unlock", locale, config.AppName)
+	body, err := s.renderBody("account_unlock.html", locale, config, map[string]string{
+		"Name":       name,
+		"UnlockLink": unlockLink,
+	})
+	if err != nil {
+		log.Printf("Failed to render account unlock email: %v", err)
+		return err
+	}
+
+	err = s.Queue(EmailMessage{
+		From:          fromEmail,
+		To:            []string{toEmail},
+		Subject:       subject,
+		HTMLBody:      body,
+		Transactional: true,
+		Type:          EmailTypeAccountUnlock,
+	})
+	if err != nil {
+		log.Printf("Failed to send account unlock email to %s: %v", RedactedEmail(toEmail), err)
+		return fmt.Errorf("failed to send account unlock email: %w", err)
+	}
+
+	log.Printf("Account unlock email sent successfully to %s", RedactedEmail(toEmail))
+	return nil
+}
+
+// SendAccountUnlockEmail sends an account unlock link using the default service
+func SendAccountUnlockEmail(toEmail, fromEmail, name, baseURL, unlockToken, locale string) error {
+	return defaultEmailService.SendAccountUnlockEmail(toEmail, fromEmail, name, baseURL, unlockToken, locale)
+}
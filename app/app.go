@@ -0,0 +1,377 @@
+// Package app wires the common package's middlewares, auth handlers, email and MongoDB helpers
+// into a runnable HTTP service, so new services start from a working composition
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	common "github.com/adhiravishankar/ar-go-common"
+	"github.com/adhiravishankar/ar-go-common/mongoutil"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HandlerFunc is the signature of the package's database-backed handlers
+type HandlerFunc func(database *mongo.Database, w http.ResponseWriter, r *http.Request)
+
+// Config holds the settings needed to run a service
+type Config struct {
+	Addr                 string             // Listen address, e.g. ":8080"
+	MongoURI             string             // MongoDB connection string
+	DatabaseName         string             // MongoDB database name
+	JWTSecret            string             // Secret for signed links, and access tokens unless a secret store is set
+	JWTSecretID          string             // Secrets Manager secret holding the access token secret, see common.WatchJWTSecret
+	JWTSecretParameter   string             // SSM parameter holding the access token secret, if JWTSecretID is unset
+	BaseURL              string             // Frontend URL used in emailed links
+	VerificationTemplate string             // Template for verification emails
+	Email                common.EmailConfig // Branding and sender identity
+	ShutdownTimeout      time.Duration      // Time allowed for in-flight requests on shutdown
+	CookieAuth           bool               // Set access and refresh tokens in HttpOnly cookies, see common.AuthConfig.Cookie
+	RotateRefreshTokens  bool               // Replace refresh tokens on use, see common.AuthConfig.RotateRefreshTokens
+	TrustedProxies       []string           // Addresses or CIDRs of proxies whose X-Forwarded-For is believed, see common.SetTrustedProxies
+}
+
+// ConfigFromEnv reads a Config from PORT, MONGODB_URL, MONGODB_DATABASE, JWT_SECRET, JWT_SECRET_ID,
+// JWT_SECRET_PARAMETER, FRONTEND_URL, EMAIL_FROM, APP_NAME and TRUSTED_PROXIES (comma-separated)
+func ConfigFromEnv() Config {
+	config := Config{
+		Addr:                 ":" + getenv("PORT", "8080"),
+		MongoURI:             os.Getenv("MONGODB_URL"),
+		DatabaseName:         getenv("MONGODB_DATABASE", "app"),
+		JWTSecret:            os.Getenv("JWT_SECRET"),
+		JWTSecretID:          os.Getenv("JWT_SECRET_ID"),
+		JWTSecretParameter:   os.Getenv("JWT_SECRET_PARAMETER"),
+		BaseURL:              os.Getenv("FRONTEND_URL"),
+		VerificationTemplate: "templates/verify.html",
+		Email:                common.DefaultEmailConfig(),
+		ShutdownTimeout:      15 * time.Second,
+	}
+	config.Email.FromAddress = os.Getenv("EMAIL_FROM")
+	config.Email.BaseURL = config.BaseURL
+	if name := os.Getenv("APP_NAME"); name != "" {
+		config.Email.AppName = name
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		config.TrustedProxies = strings.Split(proxies, ",")
+	}
+	return config
+}
+
+// getenv returns an environment variable or a fallback when it is unset
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// App is a composed service: a database, an email setup and a route table
+type App struct {
+	Config   Config
+	Client   *mongo.Client
+	Database *mongo.Database
+	Mux      *http.ServeMux
+	Auth     *common.Auth
+
+	// Replay, if set, rejects replayed password reset and account unlock requests
+	// The module has no two-factor verification endpoint to guard; apps adding one should register it
+	// behind Replay.Middleware too.
+	Replay *common.ReplayGuard
+
+	// RateLimits, if set, counts the per-client-IP limits on login, registration and email and SMS sending routes;
+	// see authRateLimits. Use a shared store, e.g. common.NewMongoRateLimitStore, when running several instances.
+	RateLimits common.RateLimitStore
+
+	secretWatcher *common.JWTSecretWatcher
+}
+
+// New validates the configuration, connects to MongoDB and configures email
+// SES is only initialized outside dry-run mode, so development runs without AWS credentials.
+func New(config Config) (*App, error) {
+	if err := common.SetTrustedProxies(config.TrustedProxies...); err != nil {
+		return nil, err
+	}
+
+	secretWatcher, err := watchJWTSecret(config)
+	if err != nil {
+		return nil, err
+	}
+
+	authConfig := common.DefaultAuthConfig(config.JWTSecret)
+	authConfig.RotateRefreshTokens = config.RotateRefreshTokens
+	if config.CookieAuth {
+		cookie := common.DefaultAuthCookieConfig()
+		authConfig.Cookie = &cookie
+	}
+	auth, err := common.NewAuth(authConfig)
+	if err != nil {
+		stopWatcher(secretWatcher)
+		return nil, err
+	}
+
+	if common.EmailDryRun() {
+		if err := common.SetEmailConfig(config.Email); err != nil {
+			return nil, err
+		}
+	} else if err := common.InitializeEmail(config.Email); err != nil {
+		stopWatcher(secretWatcher)
+		return nil, fmt.Errorf("failed to initialize email: %w", err)
+	}
+
+	client, err := mongoutil.NewOptimizedClient(config.MongoURI, nil)
+	if err != nil {
+		stopWatcher(secretWatcher)
+		return nil, err
+	}
+
+	return &App{
+		Config:        config,
+		Client:        client,
+		Database:      client.Database(config.DatabaseName),
+		Mux:           http.NewServeMux(),
+		Auth:          auth,
+		secretWatcher: secretWatcher,
+	}, nil
+}
+
+// watchJWTSecret loads the access token secret from the configured secret store and keeps it current,
+// returning nil if none is configured
+func watchJWTSecret(config Config) (*common.JWTSecretWatcher, error) {
+	ctx := context.Background()
+	var source common.JWTSecretSource
+	switch {
+	case config.JWTSecretID != "":
+		secret, err := common.NewSecretsManagerJWTSecret(ctx, config.JWTSecretID)
+		if err != nil {
+			return nil, err
+		}
+		source = secret
+	case config.JWTSecretParameter != "":
+		secret, err := common.NewSSMJWTSecret(ctx, config.JWTSecretParameter)
+		if err != nil {
+			return nil, err
+		}
+		source = secret
+	default:
+		return nil, nil
+	}
+	return common.WatchJWTSecret(ctx, source, nil, 0)
+}
+
+// stopWatcher stops a secret watcher, if there is one
+func stopWatcher(watcher *common.JWTSecretWatcher) {
+	if watcher != nil {
+		watcher.Stop()
+	}
+}
+
+// Handle registers a database-backed handler
+func (a *App) Handle(pattern string, handler HandlerFunc) {
+	a.Mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	})
+}
+
+// authRateLimits are the per-client-IP limits on auth routes, see common.KeyByIP, by limiter name; RuntimeConfig.RateLimits overrides them
+var authRateLimits = map[string]common.RateLimit{
+	"register":       common.PerMinute(5),
+	"login":          common.PerMinute(10),
+	"guest":          common.PerMinute(10),
+	"verify_email":   common.PerMinute(10),
+	"email_sending":  common.PerMinute(5),
+	"password_reset": common.PerMinute(10),
+	"unlock_account": common.PerMinute(10),
+	"sms_send":       common.PerMinute(5),
+	"sms_verify":     common.PerMinute(10),
+}
+
+// handleLimited registers a database-backed handler behind the named auth rate limit, if RateLimits is set
+func (a *App) handleLimited(pattern, limit string, handler HandlerFunc) {
+	a.Mux.Handle(pattern, a.rateLimited(limit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	})))
+}
+
+// rateLimited wraps h in the named auth rate limit, if RateLimits is set
+func (a *App) rateLimited(limit string, h http.Handler) http.Handler {
+	if a.RateLimits == nil {
+		return h
+	}
+	return common.RateLimitMiddleware(limit, authRateLimits[limit], common.KeyByIP, a.RateLimits)(h)
+}
+
+// handleReplayProtected registers a database-backed handler behind the replay guard, if one is set,
+// and the named auth rate limit
+func (a *App) handleReplayProtected(pattern, limit string, handler HandlerFunc) {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	})
+	if a.Replay != nil {
+		h = a.Replay.Middleware(h)
+	}
+	a.Mux.Handle(pattern, a.rateLimited(limit, h))
+}
+
+// HandleAuthenticated registers a database-backed handler behind the app's Auth middleware
+func (a *App) HandleAuthenticated(pattern string, handler HandlerFunc) {
+	a.Mux.Handle(pattern, a.Auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	})))
+}
+
+// HandleAuthorized registers a database-backed handler behind the app's Auth middleware and a check that the
+// default policy set allows action on the resource resolve loads, see common.RequirePolicy
+func (a *App) HandleAuthorized(pattern, action string, resolve common.ResourceResolver, handler HandlerFunc) {
+	authorized := common.RequirePolicy(action, resolve)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	}))
+	a.Mux.Handle(pattern, a.Auth.Middleware(authorized))
+}
+
+// handleAdmin registers an admin tooling handler behind the app's Auth middleware and the admin role
+func (a *App) handleAdmin(pattern string, h http.Handler) {
+	a.Mux.Handle(pattern, a.Auth.Middleware(common.RequireRole(common.RoleAdmin)(h)))
+}
+
+// HandleOptional registers a database-backed handler that registered users, guests and anonymous clients
+// can all call, see common.Auth.OptionalMiddleware
+func (a *App) HandleOptional(pattern string, handler HandlerFunc) {
+	a.Mux.Handle(pattern, a.Auth.OptionalMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	})))
+}
+
+// HandleStream registers a WebSocket or EventSource handler that also accepts stream tickets, see
+// common.IssueStreamTicket
+func (a *App) HandleStream(pattern string, handler HandlerFunc) {
+	a.Mux.Handle(pattern, a.Auth.StreamMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(a.Database, w, r)
+	})))
+}
+
+// RegisterAuthRoutes registers registration, login, guest login, logout, token refresh, verification, password reset,
+// account unlock, profile, email change, security overview, auth history, session management, stream ticket, SMS and OIDC login routes
+// under prefix, e.g. "/auth", and the JWKS at /.well-known/jwks.json
+// Set Replay first to guard password reset and account unlock against replayed requests, and RateLimits
+// to limit attempts per IP.
+func (a *App) RegisterAuthRoutes(prefix string) {
+	config := a.Config
+	from := config.Email.FromAddress
+
+	a.handleLimited("POST "+prefix+"/register", "register", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.Register(db, w, r, config.JWTSecret, config.VerificationTemplate, config.BaseURL, from)
+	})
+	a.handleLimited("POST "+prefix+"/login", "login", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		a.Auth.Login(db, w, r)
+	})
+	a.handleLimited("POST "+prefix+"/verify-email", "verify_email", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.VerifyEmail(db, w, r, from)
+	})
+	a.handleLimited("POST "+prefix+"/resend-verification", "email_sending", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.ResendVerificationEmail(db, w, r, from, config.VerificationTemplate, config.BaseURL)
+	})
+	a.handleLimited("POST "+prefix+"/forgot-password", "email_sending", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.ForgotPassword(db, w, r, config.BaseURL, from)
+	})
+	a.handleReplayProtected("POST "+prefix+"/reset-password", "password_reset", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.ResetPassword(db, w, r, from)
+	})
+	a.Handle("POST "+prefix+"/token/refresh", a.Auth.RefreshAccessToken)
+	a.Mux.Handle("POST "+prefix+"/guest", a.rateLimited("guest", http.HandlerFunc(a.Auth.GuestLogin)))
+	a.handleReplayProtected("POST "+prefix+"/unlock-account", "unlock_account", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.UnlockAccount(db, w, r, config.JWTSecret)
+	})
+	a.HandleAuthenticated("POST "+prefix+"/logout", a.Auth.Logout)
+	a.HandleAuthenticated("GET "+prefix+"/me", common.GetUser)
+	a.HandleAuthenticated("PATCH "+prefix+"/me", common.UpdateUser)
+	a.HandleAuthenticated("POST "+prefix+"/me/email", func(db *mongo.Database, w http.ResponseWriter, r *http.Request) {
+		common.RequestEmailChange(db, w, r, config.BaseURL, from, config.JWTSecret)
+	})
+	a.HandleAuthenticated("POST "+prefix+"/me/email/confirm", common.ConfirmEmailChange)
+	a.HandleAuthenticated("GET "+prefix+"/security-overview", common.GetSecurityOverview)
+	a.HandleAuthenticated("GET "+prefix+"/me/auth-events", common.ListMyAuthEvents)
+	a.Mux.Handle("GET "+prefix+"/me/sessions", a.Auth.Middleware(http.HandlerFunc(common.ListMySessions)))
+	a.Mux.Handle("DELETE "+prefix+"/me/sessions/{id}", a.Auth.Middleware(http.HandlerFunc(common.RevokeMySession)))
+	a.Mux.Handle("POST "+prefix+"/me/sessions/revoke-others", a.Auth.Middleware(http.HandlerFunc(common.RevokeMyOtherSessions)))
+	a.HandleAuthenticated("POST "+prefix+"/stream-ticket", common.IssueStreamTicket)
+	a.handleLimited("POST "+prefix+"/sms/send", "sms_send", common.SendSMSLoginCode)
+	a.handleLimited("POST "+prefix+"/sms/verify", "sms_verify", a.Auth.VerifySMSLoginCode)
+	a.HandleAuthenticated("POST "+prefix+"/me/phone", common.StartPhoneVerification)
+	a.HandleAuthenticated("POST "+prefix+"/me/phone/verify", common.ConfirmPhoneVerification)
+	a.Mux.HandleFunc("GET "+prefix+"/oidc/{provider}", common.OIDCLogin)
+	a.Handle("GET "+prefix+"/oidc/{provider}/callback", a.Auth.OIDCCallback)
+	a.handleLimited("POST "+prefix+"/oidc/link", "login", a.Auth.LinkOIDCAccount)
+	a.HandleAuthenticated("POST "+prefix+"/me/oidc/link", a.Auth.LinkOIDCAccount)
+	a.Mux.HandleFunc("GET /.well-known/jwks.json", common.JWKSHandler)
+}
+
+// RegisterOperationalRoutes registers health, diagnostics, runtime configuration and job status routes
+// Everything but the health check requires authentication, and diagnostics and runtime configuration the
+// admin role.
+func (a *App) RegisterOperationalRoutes() {
+	a.Mux.HandleFunc("GET /health", common.HealthCheck)
+	a.handleAdmin("GET /debug/diagnostics", http.HandlerFunc(common.DiagnosticsHandler))
+	a.handleAdmin("/debug/runtime-config", http.HandlerFunc(common.RuntimeConfigHandler))
+	a.HandleAuthenticated("GET /jobs/{id}", common.GetJobHandler)
+}
+
+// Handler returns the route table wrapped in the standard middleware stack
+func (a *App) Handler() http.Handler {
+	cors := common.RuntimeCorsMiddleware(
+		[]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		[]string{"Authorization", "Content-Type", "If-Match", common.NonceHeader, common.TimestampHeader, common.RequestIDHeader},
+		true,
+		600,
+	)
+
+	var handler http.Handler = a.Mux
+	handler = common.OptionsHandler(handler)
+	handler = cors(handler)
+	handler = common.SecurityHeaders(handler)
+	handler = common.SecurityLogging(handler)
+	handler = common.RecoveryMiddleware(handler)
+	return common.RequestIDMiddleware(handler)
+}
+
+// Run serves until ctx is cancelled, then drains in-flight requests and background tasks and disconnects from MongoDB
+func (a *App) Run(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              a.Config.Addr,
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", a.Config.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Config.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	stopWatcher(a.secretWatcher)
+	if tasksErr := common.ShutdownBackgroundTasks(shutdownCtx); tasksErr != nil {
+		log.Printf("Failed to finish background tasks: %v", tasksErr)
+	}
+	if disconnectErr := a.Client.Disconnect(shutdownCtx); disconnectErr != nil {
+		log.Printf("Failed to disconnect from MongoDB: %v", disconnectErr)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
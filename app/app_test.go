@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	common "github.com/adhiravishankar/ar-go-common"
+	"github.com/adhiravishankar/ar-go-common/commontest"
+)
+
+// newTestApp returns an app with routes but no database, for routes that reject requests before using it
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	return &App{Mux: http.NewServeMux(), Auth: commontest.Auth(t)}
+}
+
+func TestOperationalRoutesRequireAdmin(t *testing.T) {
+	a := newTestApp(t)
+	a.RegisterOperationalRoutes()
+	handler := a.Handler()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		roles  []string
+		want   int
+	}{
+		{"diagnostics as user", http.MethodGet, "/debug/diagnostics", "", nil, http.StatusForbidden},
+		{"runtime config as user", http.MethodGet, "/debug/runtime-config", "", nil, http.StatusForbidden},
+		{"runtime config update as user", http.MethodPut, "/debug/runtime-config", `{"cors_origins":["*"]}`, []string{"editor"}, http.StatusForbidden},
+		{"runtime config as admin", http.MethodGet, "/debug/runtime-config", "", []string{common.RoleAdmin}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := commontest.AuthenticatedRequest(t, tt.method, tt.target, strings.NewReader(tt.body), "",
+				commontest.WithRoles(tt.roles...))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestOperationalRoutesRequireAuthentication(t *testing.T) {
+	a := newTestApp(t)
+	a.RegisterOperationalRoutes()
+
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/runtime-config", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
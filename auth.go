@@ -0,0 +1,293 @@
+package common
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Claims set on tokens issued by the package-level Login, kept so existing clients see no change
+const (
+	defaultTokenIssuer   = "flight-history-app"
+	defaultTokenAudience = "flight-history-users"
+)
+
+// TokenValidation holds the iss and aud claims the package-level Login sets and Authenticate requires,
+// and the clock skew Authenticate allows
+type TokenValidation struct {
+	Issuer   string        // Expected iss claim; empty uses the default
+	Audience string        // Expected aud claim; empty uses the default
+	Leeway   time.Duration // Clock skew allowed when checking exp and iat
+}
+
+var (
+	tokenValidationMu sync.RWMutex
+	tokenValidation   TokenValidation
+)
+
+// SetTokenValidation sets the issuer, audience and clock skew used by the package-level handlers that take a
+// secret and by Authenticate. Call it before wrapping handlers, since Authenticate reads it once.
+func SetTokenValidation(validation TokenValidation) {
+	tokenValidationMu.Lock()
+	defer tokenValidationMu.Unlock()
+	tokenValidation = validation
+}
+
+// currentTokenValidation returns the token validation settings with defaults filled in
+func currentTokenValidation() TokenValidation {
+	tokenValidationMu.RLock()
+	validation := tokenValidation
+	tokenValidationMu.RUnlock()
+
+	if validation.Issuer == "" {
+		validation.Issuer = defaultTokenIssuer
+	}
+	if validation.Audience == "" {
+		validation.Audience = defaultTokenAudience
+	}
+	return validation
+}
+
+// AuthConfig holds the keys, claims and lifetimes used to issue and verify access tokens
+type AuthConfig struct {
+	Secret          string        // HMAC secret, used when Keys has none; also signs emailed links
+	Keys            *JWTKeySet    // Signing and verification keys; nil uses the keys set with SetJWTSigningKey
+	Issuer          string        // iss claim set on issued tokens and, if set, required of presented ones
+	Audience        string        // aud claim set on issued tokens and, if set, required of presented ones
+	Leeway          time.Duration // Clock skew allowed when checking exp and iat
+	AccessTokenTTL  time.Duration // Access token lifetime; defaults to AccessTokenTTL
+	RefreshTokenTTL time.Duration // Refresh token lifetime; defaults to the one passed to EnableRefreshTokens
+	RememberMeTTL   time.Duration // Refresh token lifetime for remember-me logins; defaults to DefaultRememberMeTTL
+	GuestTokenTTL   time.Duration // Guest token lifetime; defaults to DefaultGuestTokenTTL
+
+	// RefreshIdleTimeout enables sliding expiration: refresh tokens unused for this long expire, and each use
+	// extends them, never beyond their lifetime above. Zero keeps the fixed lifetime.
+	RefreshIdleTimeout time.Duration
+
+	// RotateRefreshTokens replaces the refresh token on every refresh. Presenting a replaced token again
+	// means it was stolen, so the whole session is revoked and the reuse alert is raised; clients must
+	// therefore not refresh concurrently with the same token. See SetRefreshTokenReuseAlert.
+	RotateRefreshTokens bool
+
+	// Cookie enables cookie auth mode: logins and refreshes set the access and refresh tokens in HttpOnly
+	// cookies instead of the response body, Middleware accepts the access token cookie when there is no
+	// Authorization header, and RefreshAccessToken reads the refresh token cookie
+	Cookie *AuthCookieConfig
+}
+
+// Auth issues and verifies access tokens with a configuration fixed at construction,
+// so requests don't read or validate the environment
+type Auth struct {
+	config    AuthConfig
+	secretErr error // Why config.Secret is unusable; only matters when Keys has no keys
+	parser    *jwt.Parser
+}
+
+// NewAuth creates an Auth, failing if it has neither usable keys nor a valid secret, or has an invalid cookie
+// configuration
+func NewAuth(config AuthConfig) (*Auth, error) {
+	if config.Cookie != nil {
+		if err := config.Cookie.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	auth := newAuth(config)
+	if err := auth.signingError(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// newAuth creates an Auth without validating it; a missing secret is reported when it is used
+func newAuth(config AuthConfig) *Auth {
+	if config.Keys == nil {
+		config.Keys = defaultJWTKeys
+	}
+	if config.AccessTokenTTL <= 0 {
+		config.AccessTokenTTL = AccessTokenTTL
+	}
+	if config.RememberMeTTL <= 0 {
+		config.RememberMeTTL = DefaultRememberMeTTL
+	}
+	if config.GuestTokenTTL <= 0 {
+		config.GuestTokenTTL = DefaultGuestTokenTTL
+	}
+	noteAccessTokenLifetime(config.AccessTokenTTL + config.Leeway)
+
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(config.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	return &Auth{
+		config:    config,
+		secretErr: ValidateJWTSecret(config.Secret),
+		parser:    jwt.NewParser(options...),
+	}
+}
+
+// DefaultAuthConfig returns the configuration the package-level Login, RefreshAccessToken and Authenticate
+// use with secret, with the claims and clock skew set with SetTokenValidation
+func DefaultAuthConfig(secret string) AuthConfig {
+	validation := currentTokenValidation()
+	return AuthConfig{Secret: secret, Issuer: validation.Issuer, Audience: validation.Audience, Leeway: validation.Leeway}
+}
+
+// secretAuth returns the Auth behind the package-level handlers that take a secret
+func secretAuth(secret string) *Auth {
+	return newAuth(DefaultAuthConfig(secret))
+}
+
+// signingError returns why tokens can't be signed, if the secret is needed and invalid
+func (a *Auth) signingError() error {
+	if signing, _ := a.config.Keys.keys(); signing != nil {
+		return nil
+	}
+	return a.secretErr
+}
+
+// verifyingError returns why tokens can't be verified, if the secret is needed and invalid
+func (a *Auth) verifyingError() error {
+	if !a.config.Keys.usesSecret() {
+		return nil
+	}
+	return a.secretErr
+}
+
+// IssueToken signs an access token for userID, bound to the requesting client if token binding is enabled
+func (a *Auth) IssueToken(r *http.Request, userID string) (string, error) {
+	return a.IssueClaims(r, &AppClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID}})
+}
+
+// IssueClaims signs a token with claims, filling in jti, iat, exp, iss, aud, token type and client binding
+// where they are unset
+func (a *Auth) IssueClaims(r *http.Request, claims *AppClaims) (string, error) {
+	if err := a.signingError(); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if claims.ID == "" {
+		claims.ID = uuid.New().String()
+	}
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(a.config.AccessTokenTTL))
+	}
+	if claims.Issuer == "" {
+		claims.Issuer = a.config.Issuer
+	}
+	if len(claims.Audience) == 0 && a.config.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{a.config.Audience}
+	}
+	if claims.TokenType == "" {
+		claims.TokenType = TokenTypeAccess
+	}
+	if claims.Binding == "" {
+		claims.Binding = TokenBindingHash(r)
+	}
+
+	return a.config.Keys.sign(claims, a.config.Secret)
+}
+
+// Authenticate requires a valid bearer token, verified with the keys set with SetJWTSigningKey or
+// HMAC with JWT_SECRET, and issued for the issuer and audience set with SetTokenValidation.
+// JWT_SECRET is read once, when next is wrapped.
+func Authenticate(next http.Handler) http.Handler {
+	return newAuth(DefaultAuthConfig(os.Getenv("JWT_SECRET"))).Middleware(next)
+}
+
+// Middleware requires a valid bearer access token, or access token cookie in cookie auth mode,
+// and stores its claims in the request context, see ClaimsFromContext. Guest tokens are refused;
+// see GuestMiddleware.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return a.authenticate(next, registeredOnly)
+}
+
+// authenticate verifies the request's access token like Middleware, letting through who access allows
+func (a *Auth) authenticate(next http.Handler, access guestAccess) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.verifyingError(); err != nil {
+			log.Printf("JWT secret validation failed: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server configuration error"})
+			return
+		}
+
+		tokenString, message := a.requestToken(r)
+		if tokenString == "" && message == errAuthorizationRequired && access == allowAnonymous {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if tokenString == "" {
+			RespondWithJSON(w, 401, map[string]string{"error": message})
+			return
+		}
+
+		// The key function only accepts configured algorithms, so tokens can't switch to another one
+		claims := &AppClaims{}
+		token, err := a.parser.ParseWithClaims(tokenString, claims, a.config.Keys.keyfunc(a.config.Secret))
+		if err != nil || !token.Valid {
+			switch {
+			case errors.Is(err, jwt.ErrTokenExpired):
+				RespondWithJSON(w, 401, map[string]string{"error": "Token expired"})
+			case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+				RespondWithJSON(w, 401, map[string]string{"error": "Token not valid yet"})
+			default:
+				RespondWithJSON(w, 401, map[string]string{"error": "Invalid token"})
+			}
+			return
+		}
+
+		// Validate the claims every access token carries, and the user ID format
+		if claims.IssuedAt == nil || !claims.isAccessToken() {
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid token claims"})
+			return
+		}
+		userID := claims.Subject
+		if _, err := uuid.Parse(userID); err != nil {
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid token claims"})
+			return
+		}
+
+		// Reject tokens replayed from a different client when token binding is enabled
+		if !verifyTokenBinding(r, claims.Binding) {
+			log.Printf("SECURITY: token binding mismatch for user %s", userID)
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid token"})
+			return
+		}
+
+		// Reject tokens revoked by logout or a compromised-account response
+		revoked, err := isTokenRevoked(r.Context(), claims.ID, claims.SessionID, userID, claims.IssuedAt.Time)
+		if err != nil {
+			log.Printf("Failed to check token revocation: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+		if revoked {
+			RespondWithJSON(w, 401, map[string]string{"error": "Token revoked"})
+			return
+		}
+
+		if claims.IsGuest() && access == registeredOnly {
+			RespondWithJSON(w, 403, map[string]string{"error": "An account is required"})
+			return
+		}
+
+		next.ServeHTTP(w, SetClaims(r, claims))
+	})
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuthCookieConfig holds the attributes of the access and refresh token cookies set in cookie auth mode
+type AuthCookieConfig struct {
+	Name        string        // Name of the access token cookie
+	RefreshName string        // Name of the refresh token cookie
+	RefreshPath string        // Path of the refresh token cookie: the refresh route, so it isn't sent anywhere else
+	Domain      string        // Optional cookie domain
+	Path        string        // Access token cookie path
+	Secure      bool          // Only send the cookies over HTTPS
+	SameSite    http.SameSite // Cookie SameSite mode; Lax or Strict keep other sites from sending them on POSTs, None is rejected
+}
+
+// DefaultAuthCookieConfig returns a secure cookie configuration; Secure is relaxed in development
+// so logins work over plain http://localhost. RefreshPath is the refresh route app.RegisterAuthRoutes("/auth")
+// registers; change it if the route is mounted elsewhere.
+func DefaultAuthCookieConfig() AuthCookieConfig {
+	return AuthCookieConfig{
+		Name:        "access_token",
+		RefreshName: "refresh_token",
+		RefreshPath: "/auth/token/refresh",
+		Path:        "/",
+		Secure:      !IsDevelopment(),
+		SameSite:    http.SameSiteLaxMode,
+	}
+}
+
+// Validate checks the cookie names, and the attributes browsers require of __Host- and __Secure- cookies,
+// which they would otherwise silently refuse to store
+func (c AuthCookieConfig) Validate() error {
+	if c.Name == "" || c.RefreshName == "" {
+		return errors.New("auth cookies need an access token and a refresh token cookie name")
+	}
+	if c.Name == c.RefreshName {
+		return errors.New("auth cookies need different access token and refresh token cookie names")
+	}
+	if c.RefreshPath == "" {
+		return errors.New("auth cookies need the refresh route as the refresh token cookie path")
+	}
+	// Cookie auth has no CSRF token, so SameSite is the only thing keeping other sites from using the cookies
+	if c.SameSite == http.SameSiteNoneMode {
+		return errors.New("auth cookies can't use SameSite=None: other sites could send authenticated requests")
+	}
+
+	for _, cookie := range []struct{ name, path string }{{c.Name, c.Path}, {c.RefreshName, c.RefreshPath}} {
+		if err := (&http.Cookie{Name: cookie.name, Value: "x"}).Valid(); err != nil {
+			return fmt.Errorf("invalid auth cookie name %q: %w", cookie.name, err)
+		}
+		if strings.HasPrefix(cookie.name, "__Host-") && (!c.Secure || cookie.path != "/" || c.Domain != "") {
+			return fmt.Errorf("auth cookie %s needs Secure, path / and no domain for its __Host- prefix", cookie.name)
+		}
+		if strings.HasPrefix(cookie.name, "__Secure-") && !c.Secure {
+			return fmt.Errorf("auth cookie %s needs Secure for its __Secure- prefix", cookie.name)
+		}
+	}
+	return nil
+}
+
+// setTokenCookie sets the access token cookie on w, expiring with the token
+func (a *Auth) setTokenCookie(w http.ResponseWriter, token string) {
+	a.writeTokenCookie(w, token, time.Now().Add(a.config.AccessTokenTTL))
+}
+
+// clearTokenCookie tells the browser to delete the access token cookie
+func (a *Auth) clearTokenCookie(w http.ResponseWriter) {
+	a.writeTokenCookie(w, "", time.Unix(0, 0))
+}
+
+func (a *Auth) writeTokenCookie(w http.ResponseWriter, value string, expires time.Time) {
+	a.writeCookie(w, a.config.Cookie.Name, a.config.Cookie.Path, value, expires)
+}
+
+// setRefreshCookie sets the refresh token cookie on w, expiring with the token's session
+func (a *Auth) setRefreshCookie(w http.ResponseWriter, token string, expires time.Time) {
+	a.writeCookie(w, a.config.Cookie.RefreshName, a.config.Cookie.RefreshPath, token, expires)
+}
+
+// clearRefreshCookie tells the browser to delete the refresh token cookie
+func (a *Auth) clearRefreshCookie(w http.ResponseWriter) {
+	a.writeCookie(w, a.config.Cookie.RefreshName, a.config.Cookie.RefreshPath, "", time.Unix(0, 0))
+}
+
+// writeCookie sets an HttpOnly auth cookie, deleting it if value is empty
+func (a *Auth) writeCookie(w http.ResponseWriter, name, path, value string, expires time.Time) {
+	config := a.config.Cookie
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   config.Domain,
+		Path:     path,
+		Expires:  expires,
+		Secure:   config.Secure,
+		HttpOnly: true,
+		SameSite: config.SameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// requestRefreshToken returns the refresh token cookie in cookie auth mode, or "" if there is none
+func (a *Auth) requestRefreshToken(r *http.Request) string {
+	if a.config.Cookie == nil {
+		return ""
+	}
+	if cookie, err := r.Cookie(a.config.Cookie.RefreshName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// errAuthorizationRequired is requestToken's message when no token was presented at all
+const errAuthorizationRequired = "Authorization required"
+
+// requestToken returns the access token presented with r: the bearer token if there is an Authorization header,
+// otherwise the cookie in cookie auth mode. The message says why there is none.
+func (a *Auth) requestToken(r *http.Request) (token string, message string) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		const bearerPrefix = "Bearer "
+		if !strings.HasPrefix(authHeader, bearerPrefix) {
+			return "", "Invalid authorization format"
+		}
+		return strings.TrimPrefix(authHeader, bearerPrefix), ""
+	}
+
+	if a.config.Cookie != nil {
+		if cookie, err := r.Cookie(a.config.Cookie.Name); err == nil && cookie.Value != "" {
+			return cookie.Value, ""
+		}
+	}
+	return "", errAuthorizationRequired
+}
+
+// Logout is Logout that also clears the access and refresh token cookies in cookie auth mode
+func (a *Auth) Logout(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	if a.config.Cookie != nil {
+		a.clearTokenCookie(w)
+		a.clearRefreshCookie(w)
+	}
+	Logout(database, w, r)
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAuthCookieConfigValidate(t *testing.T) {
+	valid := AuthCookieConfig{Name: "access_token", RefreshName: "refresh_token", RefreshPath: "/auth/token/refresh", Path: "/", Secure: true}
+
+	tests := []struct {
+		name    string
+		modify  func(c *AuthCookieConfig)
+		wantErr bool
+	}{
+		{"default", func(c *AuthCookieConfig) {}, false},
+		{"no name", func(c *AuthCookieConfig) { c.Name = "" }, true},
+		{"no refresh name", func(c *AuthCookieConfig) { c.RefreshName = "" }, true},
+		{"same names", func(c *AuthCookieConfig) { c.RefreshName = c.Name }, true},
+		{"no refresh path", func(c *AuthCookieConfig) { c.RefreshPath = "" }, true},
+		{"SameSite Lax", func(c *AuthCookieConfig) { c.SameSite = http.SameSiteLaxMode }, false},
+		{"SameSite Strict", func(c *AuthCookieConfig) { c.SameSite = http.SameSiteStrictMode }, false},
+		{"SameSite None", func(c *AuthCookieConfig) { c.SameSite = http.SameSiteNoneMode }, true},
+		{"invalid name", func(c *AuthCookieConfig) { c.Name = "access token" }, true},
+		{"__Host- prefix", func(c *AuthCookieConfig) { c.Name = "__Host-access" }, false},
+		{"__Host- prefix without Secure", func(c *AuthCookieConfig) { c.Name, c.Secure = "__Host-access", false }, true},
+		{"__Host- prefix with a path", func(c *AuthCookieConfig) { c.Name, c.Path = "__Host-access", "/app" }, true},
+		{"__Host- prefix with a domain", func(c *AuthCookieConfig) { c.Name, c.Domain = "__Host-access", "example.com" }, true},
+		{"__Host- refresh cookie scoped to its route", func(c *AuthCookieConfig) { c.RefreshName = "__Host-refresh" }, true},
+		{"__Secure- prefix without Secure", func(c *AuthCookieConfig) { c.RefreshName, c.Secure = "__Secure-refresh", false }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+			if err := config.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+
+			_, err := NewAuth(AuthConfig{Secret: testSecret, Cookie: &config})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewAuth() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshAccessTokenCookieMode(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("refresh token stays in its cookie", func(mt *mtest.T) {
+		useRefreshTokens(mt.T, mt.Coll)
+		cookie := AuthCookieConfig{Name: "access_token", RefreshName: "refresh_token", RefreshPath: "/auth/token/refresh", Path: "/", Secure: true}
+		auth, err := NewAuth(AuthConfig{Secret: testSecret, Cookie: &cookie, RotateRefreshTokens: true})
+		if err != nil {
+			mt.Fatal(err)
+		}
+
+		const userID = "0d9f8a57-3a5e-4f7e-9b38-3c1f0b7c2a11"
+		expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+		stored := bson.D{
+			{Key: "_id", Value: "session-1"},
+			{Key: "user_id", Value: userID},
+			{Key: "token_hash", Value: hashOpaqueToken("old-refresh-token")},
+			{Key: "expires_at", Value: expires},
+			{Key: "absolute_expires_at", Value: expires},
+		}
+		mt.AddMockResponses(
+			// The presented token is read and the user loaded, then the token is claimed and its replacement stored
+			mtest.CreateCursorResponse(0, "db.refresh_tokens", mtest.FirstBatch, stored),
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, bson.D{{Key: "_id", Value: userID}, {Key: "email", Value: "user@example.com"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: stored}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		r := httptest.NewRequest(http.MethodPost, "/auth/token/refresh", nil)
+		r.AddCookie(&http.Cookie{Name: "refresh_token", Value: "old-refresh-token"})
+		w := httptest.NewRecorder()
+		auth.RefreshAccessToken(mt.DB, w, r)
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		// The token from the cookie was looked up
+		lookup := mt.GetStartedEvent()
+		if hash := lookup.Command.Lookup("filter", "token_hash").StringValue(); hash != hashOpaqueToken("old-refresh-token") {
+			mt.Fatalf("looked up token hash = %s, want the cookie's", hash)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			mt.Fatal(err)
+		}
+		if _, ok := body["refresh_token"]; ok {
+			mt.Fatal("response body contains the refresh token")
+		}
+		if _, ok := body["token"]; ok {
+			mt.Fatal("response body contains the access token")
+		}
+
+		cookies := map[string]*http.Cookie{}
+		for _, c := range w.Result().Cookies() {
+			cookies[c.Name] = c
+		}
+		refresh := cookies["refresh_token"]
+		if refresh == nil || refresh.Value == "" || refresh.Value == "old-refresh-token" {
+			mt.Fatalf("refresh cookie = %v, want the rotated token", refresh)
+		}
+		if !refresh.HttpOnly || !refresh.Secure || refresh.Path != "/auth/token/refresh" {
+			mt.Fatalf("refresh cookie = %v, want HttpOnly, Secure and scoped to the refresh route", refresh)
+		}
+		if access := cookies["access_token"]; access == nil || access.Value == "" || access.Path != "/" {
+			mt.Fatalf("access cookie = %v, want the new access token", access)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuthEventType identifies what happened in an authentication event
+type AuthEventType string
+
+const (
+	AuthEventLogin                  AuthEventType = "login"
+	AuthEventLogout                 AuthEventType = "logout"
+	AuthEventAccountLocked          AuthEventType = "account_locked"
+	AuthEventAccountUnlocked        AuthEventType = "account_unlocked"
+	AuthEventPasswordResetRequested AuthEventType = "password_reset_requested"
+	AuthEventPasswordResetCompleted AuthEventType = "password_reset_completed"
+	AuthEventEmailVerified          AuthEventType = "email_verified"
+	AuthEventPhoneVerified          AuthEventType = "phone_verified"
+	AuthEventTokenRefreshed         AuthEventType = "token_refreshed"
+	AuthEventRefreshTokenReused     AuthEventType = "refresh_token_reused"
+	AuthEventEmailChangeRequested   AuthEventType = "email_change_requested"
+	AuthEventEmailChanged           AuthEventType = "email_changed"
+)
+
+// AuthEventOutcome is whether the action in an authentication event succeeded
+type AuthEventOutcome string
+
+const (
+	AuthOutcomeSuccess AuthEventOutcome = "success"
+	AuthOutcomeFailure AuthEventOutcome = "failure"
+)
+
+// AuthEvent is one entry in the authentication audit trail
+type AuthEvent struct {
+	ID        string           `json:"id" bson:"_id"`
+	Type      AuthEventType    `json:"type" bson:"type"`
+	Outcome   AuthEventOutcome `json:"outcome" bson:"outcome"`
+	Reason    string           `json:"reason,omitempty" bson:"reason,omitempty"`   // Why it failed, e.g. "bad_password"
+	UserID    string           `json:"user_id,omitempty" bson:"user_id,omitempty"` // Account the event concerns, if known
+	Email     string           `json:"email,omitempty" bson:"email,omitempty"`     // Lower-cased email given, if any
+	Actor     string           `json:"actor,omitempty" bson:"actor,omitempty"`     // Who acted: the user's ID, or e.g. "admin"
+	Method    string           `json:"method,omitempty" bson:"method,omitempty"`   // How the user authenticated, e.g. "password"
+	IP        string           `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent string           `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	CreatedAt time.Time        `json:"created_at" bson:"created_at"`
+}
+
+var (
+	authEventsMu sync.RWMutex
+	authEvents   *mongo.Collection
+)
+
+// EnableAuthEvents records logins, lockouts, password resets, verifications and token refreshes in the
+// database's auth_events collection. Events older than retention are deleted; zero keeps them forever.
+func EnableAuthEvents(ctx context.Context, database *mongo.Database, retention time.Duration) error {
+	collection := database.Collection("auth_events")
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+	if retention > 0 {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+		})
+	}
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+
+	authEventsMu.Lock()
+	defer authEventsMu.Unlock()
+	authEvents = collection
+	return nil
+}
+
+// requestOrigin is the client details of a request, copied out of it for work that outlives the request
+type requestOrigin struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// originOf copies r's client IP, user agent and request ID
+func originOf(r *http.Request) requestOrigin {
+	return requestOrigin{IP: GetClientIP(r), UserAgent: r.UserAgent(), RequestID: GetRequestID(r)}
+}
+
+// recordAuthEvent stores event if the audit trail is enabled, taking the client's IP and user agent from r
+// if there is a request. Actor defaults to the user. Failures are logged and otherwise ignored.
+func recordAuthEvent(ctx context.Context, r *http.Request, event AuthEvent) {
+	authEventsMu.RLock()
+	collection := authEvents
+	authEventsMu.RUnlock()
+	if collection == nil {
+		return
+	}
+
+	id, err := NewID()
+	if err != nil {
+		log.Printf("Failed to generate auth event ID: %v", err)
+		return
+	}
+
+	event.ID = id
+	event.Email = normalizeEmail(event.Email)
+	if event.Outcome == "" {
+		event.Outcome = AuthOutcomeSuccess
+	}
+	if event.Actor == "" {
+		event.Actor = event.UserID
+	}
+	if r != nil {
+		event.IP = GetClientIP(r)
+		event.UserAgent = r.UserAgent()
+	}
+	event.CreatedAt = time.Now()
+
+	if _, err := collection.InsertOne(ctx, event); err != nil {
+		log.Printf("Failed to record auth event: %v", err)
+	}
+}
+
+// recordLoginSuccess records a login in the audit trail; method is e.g. "password", "sms" or "oidc:google"
+func recordLoginSuccess(r *http.Request, method string, user *User) {
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogin, UserID: user.ID, Email: user.Email, Method: method})
+}
+
+// recordLoginFailure counts a failed login in the metrics and the audit trail
+// user is nil if no account matched.
+func recordLoginFailure(r *http.Request, method, email string, user *User, outcome LoginOutcome) {
+	currentLoginMetrics().LoginAttempt(outcome)
+
+	event := AuthEvent{Type: AuthEventLogin, Outcome: AuthOutcomeFailure, Reason: string(outcome), Email: email, Method: method}
+	if user != nil {
+		event.UserID = user.ID
+		event.Email = user.Email
+	}
+	recordAuthEvent(r.Context(), r, event)
+}
+
+// AuthEventQuery filters auth events; zero fields match everything
+type AuthEventQuery struct {
+	UserID  string           // Account the events concern
+	Email   string           // Email given
+	Type    AuthEventType    // Event type
+	Outcome AuthEventOutcome // Success or failure
+	Since   time.Time        // Earliest creation time
+	Until   time.Time        // Latest creation time
+	Limit   int64            // Maximum events; defaults to 100
+}
+
+// QueryAuthEvents returns matching auth events, newest first
+func QueryAuthEvents(ctx context.Context, database *mongo.Database, query AuthEventQuery) ([]AuthEvent, error) {
+	filter := bson.M{}
+	if query.UserID != "" {
+		filter["user_id"] = query.UserID
+	}
+	if query.Email != "" {
+		filter["email"] = normalizeEmail(query.Email)
+	}
+	if query.Type != "" {
+		filter["type"] = query.Type
+	}
+	if query.Outcome != "" {
+		filter["outcome"] = query.Outcome
+	}
+
+	created := bson.M{}
+	if !query.Since.IsZero() {
+		created["$gte"] = query.Since
+	}
+	if !query.Until.IsZero() {
+		created["$lte"] = query.Until
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(query.Limit)
+	cursor, err := database.Collection("auth_events").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query auth events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []AuthEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode auth events: %w", err)
+	}
+	return events, nil
+}
+
+// authEventQueryFromRequest reads the type, outcome, since, until and limit query parameters
+// It responds with a validation error and returns false if one is malformed.
+func authEventQueryFromRequest(w http.ResponseWriter, r *http.Request) (AuthEventQuery, bool) {
+	params := r.URL.Query()
+	query := AuthEventQuery{
+		Type:    AuthEventType(params.Get("type")),
+		Outcome: AuthEventOutcome(params.Get("outcome")),
+		Limit:   100,
+	}
+
+	for _, field := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if value := params.Get(field.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				RespondWithValidationError(w, field.name, "must be an RFC 3339 timestamp")
+				return query, false
+			}
+			*field.dest = parsed
+		}
+	}
+
+	if value := params.Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			RespondWithValidationError(w, "limit", "must be between 1 and 1000")
+			return query, false
+		}
+		query.Limit = parsed
+	}
+	return query, true
+}
+
+// respondWithAuthEvents runs query and responds with the events
+func respondWithAuthEvents(database *mongo.Database, w http.ResponseWriter, r *http.Request, query AuthEventQuery) {
+	events, err := QueryAuthEvents(r.Context(), database, query)
+	if err != nil {
+		log.Printf("Failed to query auth events: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, events)
+}
+
+// ListAuthEventsHandler lists auth events for support tooling
+// Supports the optional query parameters user_id, email, type, outcome, since and until (RFC 3339),
+// and limit (default 100, max 1000)
+func ListAuthEventsHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	query, ok := authEventQueryFromRequest(w, r)
+	if !ok {
+		return
+	}
+	query.UserID = SanitizeInput(r.URL.Query().Get("user_id"))
+	query.Email = SanitizeInput(r.URL.Query().Get("email"))
+
+	respondWithAuthEvents(database, w, r, query)
+}
+
+// ListMyAuthEvents lists the authenticated user's own auth events, e.g. for a "Recent activity" page
+// Supports the same filters as ListAuthEventsHandler except user_id and email.
+func ListMyAuthEvents(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	query, ok := authEventQueryFromRequest(w, r)
+	if !ok {
+		return
+	}
+	query.UserID = userID
+
+	respondWithAuthEvents(database, w, r, query)
+}
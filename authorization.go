@@ -3,17 +3,10 @@ package common
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
 	"strings"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"golang.org/x/crypto/argon2"
 )
 
@@ -66,134 +59,6 @@ func ValidateJWTSecret(secret string) error {
 	return nil
 }
 
-func Authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		secret := os.Getenv("JWT_SECRET")
-
-		// Validate JWT secret first
-		if err := ValidateJWTSecret(secret); err != nil {
-			log.Printf("JWT secret validation failed: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(500)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Server configuration error"})
-			return
-		}
-
-		// Get Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(401)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Authorization required"})
-			return
-		}
-
-		// Check if it starts with "Bearer "
-		const bearerPrefix = "Bearer "
-		if !strings.HasPrefix(authHeader, bearerPrefix) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(401)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid authorization format"})
-			return
-		}
-
-		// Extract the token
-		tokenString := strings.TrimPrefix(authHeader, bearerPrefix)
-
-		// Parse and validate the token with improved error handling
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(secret), nil
-		})
-
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(401)
-			switch {
-			case errors.Is(err, jwt.ErrTokenMalformed):
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token"})
-			case errors.Is(err, jwt.ErrTokenSignatureInvalid):
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token"})
-			case errors.Is(err, jwt.ErrTokenExpired):
-				json.NewEncoder(w).Encode(map[string]string{"error": "Token expired"})
-			case errors.Is(err, jwt.ErrTokenNotValidYet):
-				json.NewEncoder(w).Encode(map[string]string{"error": "Token not valid yet"})
-			default:
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token"})
-			}
-			return
-		}
-
-		if !token.Valid {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(401)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token"})
-			return
-		}
-
-		// Extract and validate claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			expiresAt, err := claims.GetExpirationTime()
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(401)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token claims"})
-				return
-			}
-
-			if expiresAt.Before(time.Now()) {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(401)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Token expired"})
-				return
-			}
-
-			issuedAt, err := claims.GetIssuedAt()
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(401)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token claims"})
-				return
-			}
-
-			if issuedAt.After(time.Now()) {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(401)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Token not valid yet"})
-				return
-			}
-
-			userID, err := claims.GetSubject()
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(401)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token claims"})
-				return
-			}
-
-			// Validate user ID format
-			if _, err := uuid.Parse(userID); err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(401)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token claims"})
-				return
-			}
-
-			// Set the user ID in the context for later use
-			r = SetUserID(r, userID)
-			next.ServeHTTP(w, r)
-		} else {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(401)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token claims"})
-			return
-		}
-	})
-}
-
 func GenerateFromPassword(password string, p *PasswordParams) (encodedHash string, err error) {
 	// Generate a cryptographically secure random salt.
 	salt, err := GenerateRandomBytes(p.saltLength)
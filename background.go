@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	ErrBackgroundBusy    = errors.New("too many background tasks running")
+	ErrBackgroundStopped = errors.New("background tasks are shutting down")
+)
+
+// DefaultBackgroundConcurrency is how many tasks the default runner runs at once
+const DefaultBackgroundConcurrency = 64
+
+// BackgroundRunner runs fire-and-forget tasks with bounded concurrency, tied to the server's lifecycle
+// Tasks receive a context that is cancelled if they are still running when Shutdown gives up waiting.
+type BackgroundRunner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	tasks  sync.WaitGroup
+
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// NewBackgroundRunner creates a runner that runs at most maxConcurrent tasks at once
+func NewBackgroundRunner(maxConcurrent int) *BackgroundRunner {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultBackgroundConcurrency
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BackgroundRunner{
+		ctx:    ctx,
+		cancel: cancel,
+		slots:  make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Go starts task without blocking, returning ErrBackgroundBusy when every slot is taken
+// and ErrBackgroundStopped after Shutdown. Panics are recovered and logged.
+func (b *BackgroundRunner) Go(name string, task func(ctx context.Context)) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.stopped {
+		return ErrBackgroundStopped
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+	default:
+		return ErrBackgroundBusy
+	}
+
+	b.tasks.Add(1)
+	go func() {
+		defer b.tasks.Done()
+		defer func() { <-b.slots }()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Printf("Background task %s panicked: %v", name, recovered)
+			}
+		}()
+		task(b.ctx)
+	}()
+	return nil
+}
+
+// Shutdown stops accepting tasks and waits for running ones to finish
+// If ctx expires first, running tasks' contexts are cancelled and ctx's error is returned.
+func (b *BackgroundRunner) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+var (
+	backgroundRunnerMu sync.RWMutex
+	backgroundRunner   = NewBackgroundRunner(DefaultBackgroundConcurrency)
+)
+
+// SetBackgroundRunner replaces the runner used by RunInBackground, e.g. to change its concurrency
+func SetBackgroundRunner(runner *BackgroundRunner) {
+	backgroundRunnerMu.Lock()
+	defer backgroundRunnerMu.Unlock()
+	backgroundRunner = runner
+}
+
+// currentBackgroundRunner returns the runner used by RunInBackground
+func currentBackgroundRunner() *BackgroundRunner {
+	backgroundRunnerMu.RLock()
+	defer backgroundRunnerMu.RUnlock()
+	return backgroundRunner
+}
+
+// RunInBackground starts task on the default background runner, logging if it can't be started
+func RunInBackground(name string, task func(ctx context.Context)) error {
+	err := currentBackgroundRunner().Go(name, task)
+	if err != nil {
+		log.Printf("Failed to start background task %s: %v", name, err)
+	}
+	return err
+}
+
+// ShutdownBackgroundTasks shuts down the default background runner; call it during server shutdown
+func ShutdownBackgroundTasks(ctx context.Context) error {
+	return currentBackgroundRunner().Shutdown(ctx)
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RoleAdmin is the role of users allowed into admin tooling, e.g. RuntimeConfigHandler and DiagnosticsHandler
+const RoleAdmin = "admin"
+
+// TokenTypeAccess is the token_type of access tokens; tokens without one are access tokens too
+const TokenTypeAccess = "access"
+
+// AppClaims are the claims of the package's JWTs, both when issuing and when parsing
+type AppClaims struct {
+	jwt.RegisteredClaims          // sub, jti, iss, aud, iat and exp
+	Roles                []string `json:"roles,omitempty"`
+	Scopes               []string `json:"scopes,omitempty"`
+	TokenType            string   `json:"token_type,omitempty"` // What the token may be used for, e.g. TokenTypeAccess
+	Binding              string   `json:"bnd,omitempty"`        // Hash of the client binding material, see SetTokenBinding
+	SessionID            string   `json:"sid,omitempty"`        // ID of the refresh token the token was issued with
+
+	// When the user last signed in with credentials; refreshed tokens keep their session's sign-in time
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+}
+
+// HasRole reports whether the claims grant role
+func (c *AppClaims) HasRole(role string) bool {
+	return c != nil && slices.Contains(c.Roles, role)
+}
+
+// HasScope reports whether the claims grant scope
+func (c *AppClaims) HasScope(scope string) bool {
+	return c != nil && slices.Contains(c.Scopes, scope)
+}
+
+// RequireRole lets a request through only if its token grants role, responding 403 otherwise
+// Put it behind an authentication middleware.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ClaimsFromContext(r).HasRole(role) {
+				log.Printf("SECURITY: %s denied %s %s without role %s", GetUserID(r), r.Method, r.URL.Path, role)
+				RespondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Forbidden"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isAccessToken reports whether the claims are for an access token
+func (c *AppClaims) isAccessToken() bool {
+	return c.TokenType == "" || c.TokenType == TokenTypeAccess
+}
+
+// SetClaims stores a verified token's claims, and its subject as the user ID, in the request context
+func SetClaims(r *http.Request, claims *AppClaims) *http.Request {
+	r = SetUserID(r, claims.Subject)
+	return r.WithContext(context.WithValue(r.Context(), claimsKey, claims))
+}
+
+// ClaimsFromContext returns the claims of the token that authenticated the request, or nil if there are none
+func ClaimsFromContext(r *http.Request) *AppClaims {
+	claims, _ := r.Context().Value(claimsKey).(*AppClaims)
+	return claims
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testSecret signs the tokens of tests in this package
+const testSecret = "common-test-secret-0123456789abcdef"
+
+// captureLog collects the standard logger's output for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return &buf
+}
+
+// useRefreshTokens stores refresh tokens in collection for the rest of the test, like EnableRefreshTokens
+// without creating indexes, so a mock deployment can back it
+func useRefreshTokens(t *testing.T, collection *mongo.Collection) {
+	t.Helper()
+	refreshTokensMu.Lock()
+	refreshTokens, refreshTokenTTL = collection, DefaultRefreshTokenTTL
+	refreshTokensMu.Unlock()
+	t.Cleanup(func() {
+		refreshTokensMu.Lock()
+		refreshTokens, refreshTokenTTL = nil, 0
+		refreshTokensMu.Unlock()
+	})
+}
+
+// useTokenRevocation tracks revoked tokens in database for the rest of the test, like EnableTokenRevocation
+// without creating indexes, so a mock deployment can back it
+func useTokenRevocation(t *testing.T, database *mongo.Database) {
+	t.Helper()
+	revocationMu.Lock()
+	revocation = &tokenRevocation{
+		tokens: database.Collection("revoked_tokens"),
+		cutoff: database.Collection("token_revocations"),
+		cache:  map[string]cachedRevocation{},
+	}
+	revocationMu.Unlock()
+	t.Cleanup(func() {
+		revocationMu.Lock()
+		revocation = nil
+		revocationMu.Unlock()
+	})
+}
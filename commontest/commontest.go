@@ -0,0 +1,160 @@
+// Package commontest helps downstream services test handlers protected by the common package's auth:
+// it mints access tokens, builds authenticated requests and fakes the Authenticate middleware.
+// It is meant to be imported from _test.go files only.
+package commontest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	common "github.com/adhiravishankar/ar-go-common"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Secret is the HMAC secret tokens are signed with unless WithSecret is given; UseSecret makes
+// Authenticate accept it
+const Secret = "commontest-secret-not-for-production-use"
+
+// TokenOption changes the claims or signing of a token minted by Token
+type TokenOption func(*tokenOptions)
+
+type tokenOptions struct {
+	secret string
+	claims common.AppClaims
+}
+
+// WithSecret signs the token with secret instead of Secret
+func WithSecret(secret string) TokenOption {
+	return func(o *tokenOptions) { o.secret = secret }
+}
+
+// WithRoles sets the token's roles claim
+func WithRoles(roles ...string) TokenOption {
+	return func(o *tokenOptions) { o.claims.Roles = roles }
+}
+
+// WithScopes sets the token's scopes claim
+func WithScopes(scopes ...string) TokenOption {
+	return func(o *tokenOptions) { o.claims.Scopes = scopes }
+}
+
+// WithIssuer sets the token's iss claim instead of the expected one
+func WithIssuer(issuer string) TokenOption {
+	return func(o *tokenOptions) { o.claims.Issuer = issuer }
+}
+
+// WithAudience sets the token's aud claim instead of the expected one
+func WithAudience(audience string) TokenOption {
+	return func(o *tokenOptions) { o.claims.Audience = jwt.ClaimStrings{audience} }
+}
+
+// WithExpiry sets when the token was issued and when it expires
+func WithExpiry(issuedAt, expiresAt time.Time) TokenOption {
+	return func(o *tokenOptions) {
+		o.claims.IssuedAt = jwt.NewNumericDate(issuedAt)
+		o.claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+	}
+}
+
+// WithTokenType sets the token_type claim, e.g. to check that non-access tokens are refused
+func WithTokenType(tokenType string) TokenOption {
+	return func(o *tokenOptions) { o.claims.TokenType = tokenType }
+}
+
+// UseSecret points Authenticate and the package-level handlers at Secret for the rest of the test
+// Call it before wrapping handlers, since Authenticate reads JWT_SECRET once.
+func UseSecret(t testing.TB) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", Secret)
+}
+
+// Auth returns an Auth that verifies tokens minted with Secret, for testing handlers wrapped with its Middleware
+func Auth(t testing.TB) *common.Auth {
+	t.Helper()
+	auth, err := common.NewAuth(common.DefaultAuthConfig(Secret))
+	if err != nil {
+		t.Fatalf("commontest: failed to create Auth: %v", err)
+	}
+	return auth
+}
+
+// Token mints an access token for userID that Authenticate accepts, unless options make it invalid
+// An empty userID gets a random one.
+func Token(t testing.TB, userID string, options ...TokenOption) string {
+	t.Helper()
+	if userID == "" {
+		userID = uuid.NewString()
+	}
+
+	o := tokenOptions{secret: Secret}
+	for _, option := range options {
+		option(&o)
+	}
+	o.claims.Subject = userID
+
+	auth, err := common.NewAuth(common.DefaultAuthConfig(o.secret))
+	if err != nil {
+		t.Fatalf("commontest: failed to create Auth: %v", err)
+	}
+	token, err := auth.IssueClaims(httptest.NewRequest(http.MethodGet, "/", nil), &o.claims)
+	if err != nil {
+		t.Fatalf("commontest: failed to sign token: %v", err)
+	}
+	return token
+}
+
+// ExpiredToken mints a token for userID that expired an hour ago
+func ExpiredToken(t testing.TB, userID string, options ...TokenOption) string {
+	t.Helper()
+	now := time.Now()
+	return Token(t, userID, append(options, WithExpiry(now.Add(-2*time.Hour), now.Add(-time.Hour)))...)
+}
+
+// WrongAudienceToken mints a token for userID issued for another app's audience
+func WrongAudienceToken(t testing.TB, userID string, options ...TokenOption) string {
+	t.Helper()
+	return Token(t, userID, append(options, WithAudience("commontest-other-audience"))...)
+}
+
+// GuestToken mints a guest token like Auth.GuestLogin issues, for a random guest ID
+func GuestToken(t testing.TB, options ...TokenOption) string {
+	t.Helper()
+	return Token(t, "", append([]TokenOption{WithRoles(common.RoleGuest)}, options...)...)
+}
+
+// AuthenticatedRequest builds a request carrying a bearer token for userID, like httptest.NewRequest
+func AuthenticatedRequest(t testing.TB, method, target string, body io.Reader, userID string, options ...TokenOption) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Authorization", "Bearer "+Token(t, userID, options...))
+	return r
+}
+
+// RequestAs returns r as the Authenticate middleware would pass it on for a user with roles, without any token
+func RequestAs(r *http.Request, userID string, roles ...string) *http.Request {
+	claims := &common.AppClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(common.AccessTokenTTL)),
+		},
+		Roles:     roles,
+		TokenType: common.TokenTypeAccess,
+	}
+	return common.SetClaims(r, claims)
+}
+
+// FakeAuthenticate returns a middleware that treats every request as authenticated by userID with roles,
+// for testing handlers in isolation from token handling
+func FakeAuthenticate(userID string, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, RequestAs(r, userID, roles...))
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package commontest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/adhiravishankar/ar-go-common"
+	"github.com/adhiravishankar/ar-go-common/httpx"
+)
+
+// echoClaims responds 200 with the authenticated user's ID
+var echoClaims = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	claims := common.ClaimsFromContext(r)
+	if claims == nil {
+		w.WriteHeader(http.StatusTeapot)
+		return
+	}
+	httpx.RespondWithJSON(w, http.StatusOK, map[string]any{"user": claims.Subject, "admin": claims.HasRole(common.RoleAdmin)})
+})
+
+func TestTokensAgainstMiddleware(t *testing.T) {
+	const userID = "0d9f8a57-3a5e-4f7e-9b38-3c1f0b7c2a11"
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+		want  int
+	}{
+		{"token", func(t *testing.T) string { return Token(t, userID) }, http.StatusOK},
+		{"random user", func(t *testing.T) string { return Token(t, "") }, http.StatusOK},
+		{"roles", func(t *testing.T) string { return Token(t, userID, WithRoles(common.RoleAdmin)) }, http.StatusOK},
+		{"expired", func(t *testing.T) string { return ExpiredToken(t, userID) }, http.StatusUnauthorized},
+		{"wrong audience", func(t *testing.T) string { return WrongAudienceToken(t, userID) }, http.StatusUnauthorized},
+		{"wrong issuer", func(t *testing.T) string { return Token(t, userID, WithIssuer("someone-else")) }, http.StatusUnauthorized},
+		{"other secret", func(t *testing.T) string { return Token(t, userID, WithSecret("another-secret-0123456789abcdefghij")) }, http.StatusUnauthorized},
+		{"not an access token", func(t *testing.T) string { return Token(t, userID, WithTokenType("stream")) }, http.StatusUnauthorized},
+		{"guest", func(t *testing.T) string { return GuestToken(t) }, http.StatusForbidden},
+	}
+	handler := Auth(t).Middleware(echoClaims)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/me", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token(t))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestUseSecret(t *testing.T) {
+	UseSecret(t)
+	handler := common.Authenticate(echoClaims)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, AuthenticatedRequest(t, http.MethodGet, "/me", nil, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestFakeAuthenticate(t *testing.T) {
+	handler := FakeAuthenticate("user-1", common.RoleAdmin)(echoClaims)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"admin\":true,\"user\":\"user-1\"}\n" {
+		t.Fatalf("response = %d %s, want the fake user", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,180 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CorsPolicy represents allowed CORS origins for a tenant and route prefix in the database
+type CorsPolicy struct {
+	ID          string    `json:"id" bson:"_id"`                    // Unique ID for the policy
+	Tenant      string    `json:"tenant" bson:"tenant"`             // Tenant the policy applies to; empty for every tenant
+	RoutePrefix string    `json:"route_prefix" bson:"route_prefix"` // Path prefix the policy applies to; empty for every route
+	Origins     []string  `json:"origins" bson:"origins"`           // Allowed origins
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`     // When the policy last changed
+}
+
+// matches reports whether the policy applies to a tenant and path
+func (p CorsPolicy) matches(tenant, path string) bool {
+	return (p.Tenant == "" || p.Tenant == tenant) && strings.HasPrefix(path, p.RoutePrefix)
+}
+
+// SaveCorsPolicy creates or replaces a CORS policy, generating an ID if it has none
+func SaveCorsPolicy(ctx context.Context, database *mongo.Database, policy CorsPolicy) (CorsPolicy, error) {
+	for _, origin := range policy.Origins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return policy, fmt.Errorf("cors origin %q must start with http:// or https://", origin)
+		}
+	}
+
+	if policy.ID == "" {
+		id, err := NewID()
+		if err != nil {
+			return policy, err
+		}
+		policy.ID = id
+	}
+	policy.UpdatedAt = time.Now()
+
+	_, err := database.Collection("cors_policies").ReplaceOne(ctx, bson.M{"_id": policy.ID}, policy, options.Replace().SetUpsert(true))
+	if err != nil {
+		return policy, fmt.Errorf("failed to save cors policy: %w", err)
+	}
+	return policy, nil
+}
+
+// TenantFromHeader returns a tenant resolver that reads the tenant from a request header
+func TenantFromHeader(header string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// TenantFromHost returns a tenant resolver that uses the request's host name
+func TenantFromHost() func(*http.Request) string {
+	return func(r *http.Request) string {
+		host := r.Host
+		if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+		return strings.ToLower(host)
+	}
+}
+
+// CorsOriginStore caches the cors_policies collection and resolves allowed origins per tenant and route
+// Policies are reloaded after the TTL expires; if a reload fails, the previous policies stay in use
+type CorsOriginStore struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+	tenantFor  func(*http.Request) string
+
+	mu       sync.RWMutex
+	policies []CorsPolicy
+	loadedAt time.Time
+	loading  sync.Mutex
+}
+
+// NewCorsOriginStore creates a store for the database's cors_policies collection
+// If tenantFor is nil, every request belongs to the empty tenant and only global policies apply
+func NewCorsOriginStore(database *mongo.Database, ttl time.Duration, tenantFor func(*http.Request) string) *CorsOriginStore {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	if tenantFor == nil {
+		tenantFor = func(*http.Request) string { return "" }
+	}
+	return &CorsOriginStore{
+		collection: database.Collection("cors_policies"),
+		ttl:        ttl,
+		tenantFor:  tenantFor,
+	}
+}
+
+// OriginsFor returns the union of origins from every policy matching the request's tenant and path
+// If no policy matches, the runtime configuration's origins are used
+func (s *CorsOriginStore) OriginsFor(r *http.Request) []string {
+	s.refreshIfStale(r.Context())
+
+	tenant := s.tenantFor(r)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var origins []string
+	matched := false
+	for _, policy := range s.policies {
+		if policy.matches(tenant, r.URL.Path) {
+			matched = true
+			origins = append(origins, policy.Origins...)
+		}
+	}
+
+	if !matched {
+		return CurrentRuntimeConfig().CorsOrigins
+	}
+	if len(origins) == 0 {
+		// A matching policy with no origins allows none, rather than every origin
+		return []string{"null"}
+	}
+	return origins
+}
+
+// Refresh reloads the policies from the database
+func (s *CorsOriginStore) Refresh(ctx context.Context) error {
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to load cors policies: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var policies []CorsPolicy
+	if err := cursor.All(ctx, &policies); err != nil {
+		return fmt.Errorf("failed to decode cors policies: %w", err)
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Invalidate forces the next lookup to reload the policies
+func (s *CorsOriginStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadedAt = time.Time{}
+}
+
+// refreshIfStale reloads the policies once the TTL has expired, letting a single request do the work
+func (s *CorsOriginStore) refreshIfStale(ctx context.Context) {
+	s.mu.RLock()
+	fresh := time.Since(s.loadedAt) < s.ttl
+	s.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	if !s.loading.TryLock() {
+		return
+	}
+	defer s.loading.Unlock()
+
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh CORS policies, keeping cached policies: %v", err)
+
+		// Back off until the next TTL rather than hitting the database on every request
+		s.mu.Lock()
+		s.loadedAt = time.Now()
+		s.mu.Unlock()
+	}
+}
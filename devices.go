@@ -0,0 +1,285 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// longestAccessTokenLifetime is the longest access token lifetime plus clock skew of any Auth created, in nanoseconds
+var longestAccessTokenLifetime atomic.Int64
+
+// noteAccessTokenLifetime records the lifetime plus clock skew of an Auth's access tokens
+func noteAccessTokenLifetime(lifetime time.Duration) {
+	for {
+		longest := longestAccessTokenLifetime.Load()
+		if int64(lifetime) <= longest || longestAccessTokenLifetime.CompareAndSwap(longest, int64(lifetime)) {
+			return
+		}
+	}
+}
+
+// sessionRevocationTTL is how long a revoked session's access tokens stay rejected: as long as any access token
+// issued by this process can be accepted
+func sessionRevocationTTL() time.Duration {
+	return max(time.Duration(longestAccessTokenLifetime.Load()), AccessTokenTTL+currentTokenValidation().Leeway)
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// deviceFingerprint hashes the request headers that tell a user's devices apart, so session lists can group
+// logins from the same browser. Like FingerprintBinder, it is easy to spoof and only meant for display.
+func deviceFingerprint(r *http.Request) string {
+	return hashBindingMaterial(FingerprintBinder("Accept-Language")(r))
+}
+
+// ListUserSessions returns a user's active login sessions, i.e. their unexpired refresh tokens, most recently
+// used first. The session with ID current is marked as the caller's.
+func ListUserSessions(ctx context.Context, userID, current string) ([]RefreshToken, error) {
+	collection, _ := refreshTokenStore()
+	if collection == nil {
+		return []RefreshToken{}, nil
+	}
+
+	cursor, err := collection.Find(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.M{"last_used_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []RefreshToken{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		// A session's ID stays the same as its token is rotated
+		sessions[i].ID = sessions[i].sessionID()
+		sessions[i].Current = sessions[i].ID == current
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession signs a user out of one session: its refresh token stops working immediately and,
+// if token revocation is enabled, so do the access tokens issued with it
+func RevokeUserSession(ctx context.Context, userID, sessionID string) error {
+	collection, _ := refreshTokenStore()
+	if collection == nil {
+		return ErrSessionNotFound
+	}
+
+	filter := sessionFilter(sessionID)
+	filter["user_id"] = userID
+	filter["revoked_at"] = nil
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return revokeSessionAccessTokens(ctx, userID, sessionID)
+}
+
+// RevokeOtherUserSessions signs a user out everywhere except the session with ID current, which may be empty
+// to sign out of every session
+func RevokeOtherUserSessions(ctx context.Context, userID, current string) error {
+	collection, _ := refreshTokenStore()
+	if collection == nil {
+		return nil
+	}
+
+	filter := bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": time.Now()}}
+	if current != "" {
+		filter["$nor"] = bson.A{sessionFilter(current)}
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "family_id": 1}))
+	if err != nil {
+		return err
+	}
+	var sessions []RefreshToken
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return err
+	}
+
+	if err := revokeUserRefreshTokens(ctx, userID, current); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := revokeSessionAccessTokens(ctx, userID, session.sessionID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeSessionAccessTokens rejects the access tokens issued with a session, if token revocation is enabled
+func revokeSessionAccessTokens(ctx context.Context, userID, sessionID string) error {
+	err := RevokeToken(ctx, sessionRevocationPrefix+sessionID, userID, time.Now().Add(sessionRevocationTTL()))
+	if errors.Is(err, ErrTokenRevocationDisabled) {
+		return nil
+	}
+	return err
+}
+
+// ListMySessions lists the authenticated user's active sessions, marking the one making the request
+func ListMySessions(w http.ResponseWriter, r *http.Request) {
+	claims := ClaimsFromContext(r)
+	if claims == nil {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := ListUserSessions(r.Context(), claims.Subject, claims.SessionID)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	RespondWithJSON(w, 200, sessions)
+}
+
+// RevokeMySession signs the authenticated user out of the session in the {id} path value
+func RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	claims := ClaimsFromContext(r)
+	if claims == nil {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID := r.PathValue("id")
+	err := RevokeUserSession(r.Context(), claims.Subject, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		RespondWithJSON(w, 404, map[string]string{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	log.Printf("SECURITY: user %s revoked session %s", claims.Subject, sessionID)
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogout, UserID: claims.Subject, Method: "session_revoked"})
+	RespondWithJSON(w, 200, map[string]string{"message": "Session revoked"})
+}
+
+// RevokeMyOtherSessions signs the authenticated user out everywhere but the session making the request
+func RevokeMyOtherSessions(w http.ResponseWriter, r *http.Request) {
+	claims := ClaimsFromContext(r)
+	if claims == nil {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	if err := RevokeOtherUserSessions(r.Context(), claims.Subject, claims.SessionID); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	log.Printf("SECURITY: user %s signed out of their other sessions", claims.Subject)
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogout, UserID: claims.Subject, Method: "other_sessions_revoked"})
+	RespondWithJSON(w, 200, map[string]string{"message": "Signed out of all other sessions"})
+}
+
+// List returns a user's active sessions, most recently seen first, marking the one r belongs to
+func (m *SessionManager) List(ctx context.Context, r *http.Request, userID string) ([]Session, error) {
+	cursor, err := m.sessions.Find(ctx,
+		bson.M{"user_id": userID, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.M{"last_seen_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	current := m.currentID(r)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	return sessions, nil
+}
+
+// Revoke ends one of a user's sessions
+func (m *SessionManager) Revoke(ctx context.Context, userID, sessionID string) error {
+	result, err := m.sessions.DeleteOne(ctx, bson.M{"_id": sessionID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOthers ends every session of a user except the one r belongs to
+func (m *SessionManager) RevokeOthers(ctx context.Context, r *http.Request, userID string) error {
+	_, err := m.sessions.DeleteMany(ctx, bson.M{"user_id": userID, "_id": bson.M{"$ne": m.currentID(r)}})
+	return err
+}
+
+// currentID returns the ID of the session identified by the request's cookie, or "" if it has none
+func (m *SessionManager) currentID(r *http.Request) string {
+	cookie, err := r.Cookie(m.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	return hashOpaqueToken(cookie.Value)
+}
+
+// ListMySessions lists the authenticated user's sessions; mount it behind the manager's Middleware
+func (m *SessionManager) ListMySessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := m.List(r.Context(), r, GetUserID(r))
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	RespondWithJSON(w, 200, sessions)
+}
+
+// RevokeMySession ends the authenticated user's session in the {id} path value
+func (m *SessionManager) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	err := m.Revoke(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, ErrSessionNotFound) {
+		RespondWithJSON(w, 404, map[string]string{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	log.Printf("SECURITY: user %s revoked a session", userID)
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogout, UserID: userID, Method: "session_revoked"})
+	RespondWithJSON(w, 200, map[string]string{"message": "Session revoked"})
+}
+
+// RevokeMyOtherSessions ends every session of the authenticated user but the one making the request
+func (m *SessionManager) RevokeMyOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if err := m.RevokeOthers(r.Context(), r, userID); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	log.Printf("SECURITY: user %s signed out of their other sessions", userID)
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogout, UserID: userID, Method: "other_sessions_revoked"})
+	RespondWithJSON(w, 200, map[string]string{"message": "Signed out of all other sessions"})
+}
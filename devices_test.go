@@ -0,0 +1,29 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionRevocationOutlivesAccessTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		leeway time.Duration
+	}{
+		{"default lifetime", 0, 0},
+		{"long lifetime", 30 * 24 * time.Hour, time.Minute},
+		{"short lifetime", 15 * time.Minute, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewAuth(AuthConfig{Secret: testSecret, AccessTokenTTL: tt.ttl, Leeway: tt.leeway})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lifetime := auth.config.AccessTokenTTL + tt.leeway; sessionRevocationTTL() < lifetime {
+				t.Fatalf("sessionRevocationTTL = %v, want at least %v", sessionRevocationTTL(), lifetime)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redactedValue replaces secrets in diagnostics output
+const redactedValue = "[REDACTED]"
+
+// Key fragments that mark a diagnostics value as secret
+var secretKeyFragments = []string{"secret", "password", "token", "key", "credential", "dsn"}
+
+// DiagnosticsReport describes the effective configuration of a running service for support triage
+type DiagnosticsReport struct {
+	GeneratedAt   time.Time         `json:"generated_at"`
+	Environment   Environment       `json:"environment"`
+	GoVersion     string            `json:"go_version"`
+	Module        string            `json:"module"`
+	ModuleVersion string            `json:"module_version"`
+	Dependencies  map[string]string `json:"dependencies"`
+	Configuration map[string]any    `json:"configuration"`
+	Features      map[string]bool   `json:"features"`
+	Sections      map[string]any    `json:"sections,omitempty"`
+}
+
+var (
+	diagnosticsMu        sync.RWMutex
+	diagnosticsProviders = map[string]func() any{}
+)
+
+// RegisterDiagnostics adds a named section to the diagnostics report, e.g. an application's
+// cache settings. Map values under secret-looking keys are redacted.
+func RegisterDiagnostics(name string, provider func() any) {
+	diagnosticsMu.Lock()
+	defer diagnosticsMu.Unlock()
+	diagnosticsProviders[name] = provider
+}
+
+// Diagnostics returns the effective configuration, dependency versions and enabled features
+// Secrets are reported only as set or missing
+func Diagnostics() DiagnosticsReport {
+	profile := CurrentProfile()
+	runtimeConfig := CurrentRuntimeConfig()
+
+	report := DiagnosticsReport{
+		GeneratedAt:  time.Now().UTC(),
+		Environment:  profile.Environment,
+		GoVersion:    runtime.Version(),
+		Dependencies: map[string]string{},
+		Features:     map[string]bool{},
+		Sections:     map[string]any{},
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.Module = info.Main.Path
+		report.ModuleVersion = info.Main.Version
+		for _, dep := range info.Deps {
+			version := dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Path + " " + dep.Replace.Version
+			}
+			report.Dependencies[dep.Path] = version
+		}
+	}
+
+	client, _, queue, _, suppressions := defaultEmailService.state()
+	suppressionEnabled := suppressions != nil
+
+	supportedLocalesMu.RLock()
+	locales := make([]string, len(supportedLocales))
+	for i, tag := range supportedLocales {
+		locales[i] = tag.String()
+	}
+	supportedLocalesMu.RUnlock()
+
+	passwordParams := CurrentPasswordParams()
+	report.Configuration = map[string]any{
+		"app_env":         os.Getenv("APP_ENV"),
+		"jwt_secret":      describeSecret(os.Getenv("JWT_SECRET")),
+		"log_level":       runtimeConfig.LogLevel,
+		"cors_origins":    runtimeConfig.CorsOrigins,
+		"rate_limits":     runtimeConfig.RateLimits,
+		"password_policy": profile.PasswordPolicy,
+		"password_hashing": map[string]any{
+			"algorithm":   "argon2id",
+			"memory_kib":  passwordParams.memory,
+			"iterations":  passwordParams.iterations,
+			"parallelism": passwordParams.parallelism,
+		},
+		"email": map[string]any{
+			"ses_initialized":   client != nil,
+			"dry_run":           defaultEmailService.DryRun(),
+			"queued":            queue != nil,
+			"suppression":       suppressionEnabled,
+			"supported_locales": locales,
+		},
+	}
+
+	for name, enabled := range runtimeConfig.FeatureFlags {
+		report.Features[name] = enabled
+	}
+	report.Features["email_dry_run"] = defaultEmailService.DryRun()
+	report.Features["email_queue"] = queue != nil
+	report.Features["email_suppression"] = suppressionEnabled
+
+	diagnosticsMu.RLock()
+	for name, provider := range diagnosticsProviders {
+		report.Sections[name] = redactSecrets(provider())
+	}
+	diagnosticsMu.RUnlock()
+
+	return report
+}
+
+// LogDiagnostics logs the diagnostics report as a single JSON line, typically at startup
+func LogDiagnostics() {
+	data, err := json.Marshal(Diagnostics())
+	if err != nil {
+		log.Printf("Failed to encode diagnostics: %v", err)
+		return
+	}
+	log.Printf("DIAGNOSTICS: %s", data)
+}
+
+// DiagnosticsHandler serves the diagnostics report for admin tooling
+func DiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSON(w, http.StatusOK, Diagnostics())
+}
+
+// describeSecret reports whether a secret is configured without revealing it
+func describeSecret(secret string) string {
+	if secret == "" {
+		return "missing"
+	}
+	return "set"
+}
+
+// isSecretKey reports whether a configuration key looks like it holds a secret
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, fragment := range secretKeyFragments {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets replaces values under secret-looking keys in (nested) string-keyed maps
+func redactSecrets(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		redacted := make(map[string]any, len(v))
+		for key, inner := range v {
+			if isSecretKey(key) {
+				redacted[key] = redactedValue
+				continue
+			}
+			redacted[key] = redactSecrets(inner)
+		}
+		return redacted
+	case map[string]string:
+		redacted := make(map[string]string, len(v))
+		for key, inner := range v {
+			if isSecretKey(key) {
+				inner = redactedValue
+			}
+			redacted[key] = inner
+		}
+		return redacted
+	default:
+		return value
+	}
+}
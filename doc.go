@@ -0,0 +1,18 @@
+// Package common provides shared authentication, email and database helpers.
+//
+// # Sub-packages and API stability
+//
+// Helpers are moving into focused sub-packages that carry their own stability guarantees:
+//
+//   - httpx: JSON responses, request binding and conditional request helpers
+//   - mongoutil: MongoDB connections, safe cursors and versioned updates
+//   - commontest: tokens, authenticated requests and a fake Authenticate for testing protected handlers
+//
+// The flat functions and types in this package that have moved remain as thin wrappers or
+// type aliases forwarding to the sub-packages. They are not deprecated: the authentication and
+// email helpers, which have not moved, are built on them, and existing importers keep compiling.
+// New code outside this package should import httpx and mongoutil directly.
+//
+// Exported identifiers in the sub-packages follow semantic versioning: they are not renamed
+// or removed, and their behavior does not change incompatibly, within a major version.
+package common
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// builtinEmailTemplates are the bodies used when no template is registered under the same name
+// They are html/template so every value, including user-supplied names, is escaped.
+var builtinEmailTemplates = template.Must(template.New("builtin").Parse(`
+{{define "password_reset.html"}}
+		<html>
+		<body>
+			<h2>Password Reset Request</h2>
+			<p>Hello {{.Name}},</p>
+			<p>You have requested to reset your password for your {{.AppName}} account.</p>
+			<p>Click the link below to reset your password:</p>
+			<p><a href="{{.ResetLink}}" style="background-color: #007bff; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Reset Password</a></p>
+			<p>Or copy and paste this link into your browser:</p>
+			<p>{{.ResetLink}}</p>
+			<p>This link will expire in 1 hour for security reasons.</p>
+			<p>If you didn't request this password reset, please ignore this email.</p>
+			<br>
+			{{.Footer}}
+		</body>
+		</html>
+{{end}}
+
+{{define "password_changed.html"}}
+		<html>
+		<body>
+			<h2>Password Successfully Changed</h2>
+			<p>Hello {{.Name}},</p>
+			<p>Your password for your {{.AppName}} account has been successfully changed.</p>
+			<p>If you made this change, no further action is required.</p>
+			<p>If you did not make this change, please contact our support team immediately.</p>
+			<br>
+			{{.Footer}}
+		</body>
+		</html>
+{{end}}
+
+{{define "account_unlock.html"}}
+		<html>
+		<body>
+			<h2>Your Account Was Locked</h2>
+			<p>Hello {{.Name}},</p>
+			<p>Your {{.AppName}} account was temporarily locked after several failed login attempts.</p>
+			<p>If this was you, click the link below to unlock it now:</p>
+			<p><a href="{{.UnlockLink}}" style="background-color: #007bff; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Unlock Account</a></p>
+			<p>Otherwise it will unlock automatically when the lockout ends.</p>
+			<p>If you didn't try to log in, someone may be guessing your password. Consider changing it.</p>
+			<br>
+			{{.Footer}}
+		</body>
+		</html>
+{{end}}
+
+{{define "email_change.html"}}
+		<html>
+		<body>
+			<h2>Confirm Your New Email</h2>
+			<p>Hello {{.Name}},</p>
+			<p>You asked to use this address for your {{.AppName}} account.</p>
+			<p>Click the link below while logged in to confirm the change:</p>
+			<p><a href="{{.ConfirmLink}}" style="background-color: #007bff; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Confirm Email</a></p>
+			<p>Or enter this code: <strong>{{.ConfirmationToken}}</strong></p>
+			<p>This code will expire in 24 hours. Until then your account keeps its current address.</p>
+			<p>If you didn't request this change, please ignore this email.</p>
+			<br>
+			{{.Footer}}
+		</body>
+		</html>
+{{end}}
+
+{{define "email_change_notice.html"}}
+		<html>
+		<body>
+			<h2>Email Change Requested</h2>
+			<p>Hello {{.Name}},</p>
+			<p>Someone asked to change the email address of your {{.AppName}} account to {{.NewEmail}}.</p>
+			<p>Nothing changes until the new address is confirmed.</p>
+			<p>If you didn't make this request, please change your password and contact our support team immediately.</p>
+			<br>
+			{{.Footer}}
+		</body>
+		</html>
+{{end}}
+
+{{define "account_locked.html"}}
+		<html>
+		<body>
+			<h2>Your Account Was Locked</h2>
+			<p>Hello {{.Name}},</p>
+			{{if .Permanent}}
+			<p>Your {{.AppName}} account was locked after repeated failed login attempts. Please contact our support team to unlock it.</p>
+			{{else}}
+			<p>Your {{.AppName}} account was temporarily locked after several failed login attempts. It will unlock automatically when the lockout ends.</p>
+			{{end}}
+			<p>If you didn't try to log in, someone may be guessing your password. Consider changing it.</p>
+			<br>
+			{{.Footer}}
+		</body>
+		</html>
+{{end}}
+`))
+
+// renderBody renders the template registered under name for a locale, falling back to the built-in body
+// data gets the branding fields from config; built-in bodies also get the escaped footer
+func (s *EmailService) renderBody(name, locale string, config EmailConfig, data map[string]string) (string, error) {
+	data = config.templateData(data)
+	if body, ok := s.renderRegisteredTemplate(name, locale, data); ok {
+		return body, nil
+	}
+
+	builtinData := make(map[string]any, len(data)+1)
+	for key, value := range data {
+		builtinData[key] = value
+	}
+	builtinData["Footer"] = template.HTML(config.footerHTML())
+
+	var body strings.Builder
+	if err := builtinEmailTemplates.ExecuteTemplate(&body, name, builtinData); err != nil {
+		return "", fmt.Errorf("failed to execute email template %s: %w", name, err)
+	}
+	return body.String(), nil
+}
@@ -0,0 +1,225 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sesMaxBulkDestinations is the most destinations SES accepts in one SendBulkEmail call
+const sesMaxBulkDestinations = 50
+
+// EmailTypeBulk labels bulk templated sends in delivery metrics
+const EmailTypeBulk EmailType = "bulk"
+
+// Recipient is a single addressee of a bulk email
+type Recipient struct {
+	Email string `json:"email" bson:"email"`
+	Name  string `json:"name" bson:"name"`
+}
+
+// BulkEmailResult reports the outcome of a bulk send for one recipient
+type BulkEmailResult struct {
+	Email     string `json:"email"`
+	MessageID string `json:"message_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SendBulkEmail sends the SES template templateName to each recipient, chunked to respect SES limits
+// Every recipient's template data contains "name"; entries in perRecipientData, keyed by email
+// address, are merged on top when they are maps and used as-is otherwise.
+// Suppressed recipients are skipped. The returned slice has one result per recipient, in order;
+// the error is only set when no chunk could be attempted.
+func (s *EmailService) SendBulkEmail(recipients []Recipient, templateName string, perRecipientData map[string]any, fromEmail string) ([]BulkEmailResult, error) {
+	ctx := context.TODO()
+	results := make([]BulkEmailResult, len(recipients))
+	client, config, _, metrics, suppressions := s.state()
+	fromEmail = config.sender(fromEmail)
+
+	if !s.DryRun() && client == nil {
+		return nil, fmt.Errorf("SES client not initialized")
+	}
+
+	var pending []int
+	for i, recipient := range recipients {
+		results[i].Email = recipient.Email
+		if err := ValidateEmail(recipient.Email); err != nil {
+			results[i].Status = "InvalidRecipient"
+			results[i].Error = err.Error()
+			continue
+		}
+		if len(filterSuppressedRecipients(ctx, suppressions, []string{recipient.Email}, false)) == 0 {
+			results[i].Status = "Suppressed"
+			results[i].Error = ErrEmailSuppressed.Error()
+			metrics.EmailSuppressed(EmailTypeBulk)
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	for start := 0; start < len(pending); start += sesMaxBulkDestinations {
+		end := min(start+sesMaxBulkDestinations, len(pending))
+		s.sendBulkChunk(ctx, recipients, pending[start:end], templateName, perRecipientData, fromEmail, results)
+	}
+
+	subject := "SES template " + templateName
+	for _, result := range results {
+		switch {
+		case result.Status == string(types.BulkEmailStatusSuccess) && s.DryRun():
+			s.logEmail(ctx, []string{result.Email}, EmailTypeBulk, subject, "", EmailLogDryRun, nil)
+		case result.Status == string(types.BulkEmailStatusSuccess):
+			s.logEmail(ctx, []string{result.Email}, EmailTypeBulk, subject, result.MessageID, EmailLogSent, nil)
+		case result.Status == "Suppressed":
+			s.logEmail(ctx, []string{result.Email}, EmailTypeBulk, subject, "", EmailLogSuppressed, nil)
+		default:
+			s.logEmail(ctx, []string{result.Email}, EmailTypeBulk, subject, result.MessageID, EmailLogFailed, fmt.Errorf("%s", result.Error))
+		}
+	}
+
+	return results, nil
+}
+
+// SendBulkEmail sends an SES template to each recipient using the default email service
+func SendBulkEmail(recipients []Recipient, templateName string, perRecipientData map[string]any, fromEmail string) ([]BulkEmailResult, error) {
+	return defaultEmailService.SendBulkEmail(recipients, templateName, perRecipientData, fromEmail)
+}
+
+// sendBulkChunk sends one SendBulkEmail request and records per-recipient results
+func (s *EmailService) sendBulkChunk(ctx context.Context, recipients []Recipient, indexes []int, templateName string, perRecipientData map[string]any, fromEmail string, results []BulkEmailResult) {
+	client, config, _, metrics, _ := s.state()
+
+	entries := make([]types.BulkEmailEntry, 0, len(indexes))
+	sent := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		data, err := bulkTemplateData(recipients[i], perRecipientData[recipients[i].Email])
+		if err != nil {
+			results[i].Status = "InvalidTemplateData"
+			results[i].Error = err.Error()
+			metrics.EmailFailed(EmailTypeBulk, err)
+			continue
+		}
+
+		entries = append(entries, types.BulkEmailEntry{
+			Destination: &types.Destination{ToAddresses: []string{recipients[i].Email}},
+			ReplacementEmailContent: &types.ReplacementEmailContent{
+				ReplacementTemplate: &types.ReplacementTemplate{ReplacementTemplateData: aws.String(data)},
+			},
+		})
+		sent = append(sent, i)
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	if s.DryRun() {
+		for _, i := range sent {
+			s.recordDryRun(EmailMessage{
+				From:    fromEmail,
+				To:      []string{recipients[i].Email},
+				Subject: "SES template " + templateName,
+				Type:    EmailTypeBulk,
+			})
+			results[i].Status = string(types.BulkEmailStatusSuccess)
+			metrics.EmailSent(EmailTypeBulk)
+		}
+		return
+	}
+
+	output, err := client.SendBulkEmail(ctx, &sesv2.SendBulkEmailInput{
+		FromEmailAddress: aws.String(fromEmail),
+		DefaultContent: &types.BulkEmailContent{
+			Template: &types.Template{
+				TemplateName: aws.String(templateName),
+				TemplateData: aws.String("{}"),
+			},
+		},
+		BulkEmailEntries:     entries,
+		ReplyToAddresses:     config.replyTo(),
+		ConfigurationSetName: config.configurationSetFor(EmailTypeBulk, ""),
+		DefaultEmailTags:     config.messageTags(EmailTypeBulk, nil),
+	})
+	if err != nil {
+		log.Printf("Failed to send bulk email chunk of %d recipients: %v", len(sent), err)
+		for _, i := range sent {
+			results[i].Status = string(types.BulkEmailStatusFailed)
+			results[i].Error = err.Error()
+			metrics.EmailFailed(EmailTypeBulk, err)
+		}
+		return
+	}
+
+	// SES returns one status per destination, in request order
+	for n, i := range sent {
+		if n >= len(output.BulkEmailEntryResults) {
+			results[i].Status = string(types.BulkEmailStatusFailed)
+			results[i].Error = "no status returned"
+			metrics.EmailFailed(EmailTypeBulk, nil)
+			continue
+		}
+
+		status := output.BulkEmailEntryResults[n]
+		results[i].Status = string(status.Status)
+		results[i].MessageID = aws.ToString(status.MessageId)
+		results[i].Error = aws.ToString(status.Error)
+		if status.Status == types.BulkEmailStatusSuccess {
+			metrics.EmailSent(EmailTypeBulk)
+		} else {
+			metrics.EmailFailed(EmailTypeBulk, fmt.Errorf("%s", status.Status))
+		}
+	}
+}
+
+// bulkTemplateData encodes the replacement data for one recipient
+func bulkTemplateData(recipient Recipient, extra any) (string, error) {
+	data := map[string]any{"name": recipient.Name}
+
+	switch v := extra.(type) {
+	case nil:
+	case map[string]any:
+		for key, value := range v {
+			data[key] = value
+		}
+	case map[string]string:
+		for key, value := range v {
+			data[key] = value
+		}
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode template data: %w", err)
+		}
+		return string(encoded), nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode template data: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// VerifiedUserRecipients returns every verified user as a bulk email recipient, e.g. for announcements
+// Suppressed addresses are filtered out later by SendBulkEmail
+func VerifiedUserRecipients(ctx context.Context, database *mongo.Database) ([]Recipient, error) {
+	opts := options.Find().SetProjection(bson.M{"email": 1, "name": 1})
+	cursor, err := database.Collection("users").Find(ctx, bson.M{"is_verified": true}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find verified users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var recipients []Recipient
+	if err := cursor.All(ctx, &recipients); err != nil {
+		return nil, fmt.Errorf("failed to decode verified users: %w", err)
+	}
+	return recipients, nil
+}
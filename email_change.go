@@ -0,0 +1,338 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// emailChangeTTL is how long the new address has to confirm an email change
+const emailChangeTTL = 24 * time.Hour
+
+// emailChangeReauthWindow is how recently a user without a password must have signed in to change their email
+const emailChangeReauthWindow = 10 * time.Minute
+
+type EmailChangeForm struct {
+	NewEmail string `json:"new_email" binding:"required"` // The address to move the account to
+	Password string `json:"password"`                     // The current password, required if the account has one
+}
+
+// RequestEmailChange starts moving the authenticated user to a new address: a confirmation code goes to the
+// new address and a notice to the old one, and the account keeps its address until ConfirmEmailChange.
+// Wrong passwords count towards the lockout like failed logins; secret signs the unlock email that may send.
+// Accounts without a password, e.g. signed in with OIDC or SMS, must have signed in within the last
+// emailChangeReauthWindow instead, so a refreshed session isn't enough.
+func RequestEmailChange(database *mongo.Database, w http.ResponseWriter, r *http.Request, baseURL, fromEmail, secret string) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var form EmailChangeForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+	form.NewEmail = SanitizeInput(form.NewEmail)
+	if err := ValidateEmail(form.NewEmail); err != nil {
+		RespondWithValidationError(w, "new_email", err.Error())
+		return
+	}
+
+	usersCollection := database.Collection("users")
+	var user User
+	if err := usersCollection.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		log.Printf("Failed to find user by ID: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	if form.NewEmail == user.Email {
+		RespondWithValidationError(w, "new_email", "must differ from the current email")
+		return
+	}
+
+	// Someone holding only a stolen access token must not be able to take over the account
+	if user.Password != "" {
+		if user.isLocked() {
+			RespondWithJSON(w, 423, map[string]string{"error": "Account temporarily locked"})
+			return
+		}
+		match, err := ComparePasswordAndHash(form.Password, user.Password)
+		if err != nil {
+			log.Printf("Password comparison error for user %s: %v", RedactedEmail(user.Email), err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+		if !match {
+			// Count the failure like a failed login, so the password can't be guessed here instead
+			recordFailedLogin(r, database, &user, secret)
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid password"})
+			return
+		}
+	} else if !recentlyAuthenticated(r, emailChangeReauthWindow) {
+		RespondWithJSON(w, 403, map[string]string{"error": "Please sign in again to change your email"})
+		return
+	}
+
+	taken, err := usersCollection.CountDocuments(r.Context(), bson.M{"email": form.NewEmail})
+	if err != nil {
+		log.Printf("Failed to check email availability: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	if taken > 0 {
+		RespondWithJSON(w, 409, map[string]string{"error": "Email already in use"})
+		return
+	}
+
+	token, err := GenerateVerificationToken()
+	if err != nil {
+		log.Printf("Failed to generate verification token: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	verificationID, err := NewID()
+	if err != nil {
+		log.Printf("Failed to generate verification ID: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	// Only the latest request can be confirmed
+	now := time.Now()
+	verificationsCollection := database.Collection("email_verifications")
+	_, err = verificationsCollection.UpdateMany(r.Context(),
+		bson.M{"user_id": user.ID, "type": EmailVerificationChange, "used": false},
+		bson.M{"$set": bson.M{"used": true, "used_at": now}},
+	)
+	if err != nil {
+		log.Printf("Failed to cancel earlier email change requests: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	_, err = verificationsCollection.InsertOne(r.Context(), EmailVerification{
+		ID:            verificationID,
+		Name:          user.Name,
+		UserID:        user.ID,
+		Email:         form.NewEmail,
+		Token:         token,
+		ExpiresAt:     NewTime(now.Add(emailChangeTTL)),
+		CreatedAt:     NewTime(now),
+		Type:          EmailVerificationChange,
+		PreviousEmail: user.Email,
+	})
+	if err != nil {
+		log.Printf("Failed to create email change request: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	locale := ResolveLocale(r, &user)
+	if err := SendEmailChangeConfirmation(form.NewEmail, user.Name, baseURL, fromEmail, token, locale); err != nil {
+		var rateLimitErr *EmailRateLimitError
+		if errors.As(err, &rateLimitErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(rateLimitErr.RetryAfter.Seconds())+1))
+			RespondWithJSON(w, 429, map[string]string{"error": "Too many emails requested. Please try again later."})
+			return
+		}
+		log.Printf("Failed to send email change confirmation: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	if err := SendEmailChangeNotice(user.Email, fromEmail, user.Name, form.NewEmail, locale); err != nil {
+		log.Printf("Failed to send email change notice: %v", err)
+		// Continue anyway, the change still needs the new address to confirm it
+	}
+
+	log.Printf("SECURITY: user %s requested an email change to %s", user.ID, RedactedEmail(form.NewEmail))
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventEmailChangeRequested, UserID: user.ID, Email: user.Email})
+
+	RespondWithJSON(w, 200, map[string]string{
+		"message": "Please check your new email to confirm the change.",
+		"email":   form.NewEmail,
+	})
+}
+
+// recentlyAuthenticated reports whether the request's token comes from a sign-in within window
+func recentlyAuthenticated(r *http.Request, window time.Duration) bool {
+	claims := ClaimsFromContext(r)
+	return claims != nil && claims.AuthTime != nil && time.Since(claims.AuthTime.Time) <= window
+}
+
+// ConfirmEmailChange completes the authenticated user's email change with the code sent to the new address,
+// which also counts as verifying it
+// Every session is then signed out and outstanding password resets, sent to the old address, are invalidated.
+func ConfirmEmailChange(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var form VerifyEmailForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+	form.Token = SanitizeInput(form.Token)
+	if err := ValidateVerificationToken(form.Token); err != nil {
+		RespondWithJSON(w, 400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Claim the request atomically so a code can't be confirmed twice
+	now := time.Now()
+	var verification EmailVerification
+	err := database.Collection("email_verifications").FindOneAndUpdate(r.Context(),
+		bson.M{
+			"token":      form.Token,
+			"user_id":    userID,
+			"type":       EmailVerificationChange,
+			"used":       false,
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used": true, "used_at": now}},
+	).Decode(&verification)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		RespondWithJSON(w, 400, map[string]string{"error": "Invalid or expired confirmation code"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to find email change request: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	// The address may have been taken, or the account's address changed, since the request
+	usersCollection := database.Collection("users")
+	taken, err := usersCollection.CountDocuments(r.Context(), bson.M{"email": verification.Email})
+	if err != nil {
+		log.Printf("Failed to check email availability: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	if taken > 0 {
+		RespondWithJSON(w, 409, map[string]string{"error": "Email already in use"})
+		return
+	}
+	result, err := usersCollection.UpdateOne(r.Context(),
+		bson.M{"_id": userID, "email": verification.PreviousEmail},
+		bson.M{
+			"$set": bson.M{
+				"email":       verification.Email,
+				"is_verified": true,
+				"updated_at":  now,
+			},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if mongo.IsDuplicateKeyError(err) || (err == nil && result.MatchedCount == 0) {
+		RespondWithJSON(w, 409, map[string]string{"error": "Email change is no longer possible, please request it again"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to change user email: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	log.Printf("SECURITY: user %s changed their email from %s to %s", userID, RedactedEmail(verification.PreviousEmail), RedactedEmail(verification.Email))
+
+	if err := RevokeUserTokens(r.Context(), userID); err != nil && !errors.Is(err, ErrTokenRevocationDisabled) {
+		log.Printf("Failed to revoke tokens after email change: %v", err)
+	}
+	_, err = database.Collection("password_resets").UpdateMany(r.Context(),
+		bson.M{"user_id": userID, "used": false},
+		bson.M{"$set": bson.M{"used": true, "used_at": now}},
+	)
+	if err != nil {
+		log.Printf("Failed to invalidate password resets after email change: %v", err)
+	}
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventEmailChanged, UserID: userID, Email: verification.Email})
+
+	RespondWithJSON(w, 200, map[string]string{
+		"message": "Email changed successfully.",
+		"email":   verification.Email,
+	})
+}
+
+// SendEmailChangeConfirmation sends the code that confirms an email change to the new address
+// A registered "email_change.html" template overrides the built-in body; it gets ConfirmLink and ConfirmationToken.
+func (s *EmailService) SendEmailChangeConfirmation(toEmail, name, baseURL, fromEmail, token, locale string) error {
+	config := s.Config()
+
+	subject := localizedSubject("email_change", locale, config.AppName)
+	body, err := s.renderBody("email_change.html", locale, config, map[string]string{
+		"Name":              name,
+		"ConfirmLink":       config.link(LinkConfirmEmailChange, baseURL, token),
+		"ConfirmationToken": token,
+	})
+	if err != nil {
+		log.Printf("Failed to render email change confirmation: %v", err)
+		return err
+	}
+
+	err = s.Queue(EmailMessage{
+		From:           fromEmail,
+		To:             []string{toEmail},
+		Subject:        subject,
+		HTMLBody:       body,
+		Transactional:  true,
+		Type:           EmailTypeEmailChange,
+		IdempotencyKey: "email_change:" + token,
+	})
+	if err != nil {
+		log.Printf("Failed to send email change confirmation to %s: %v", RedactedEmail(toEmail), err)
+		return fmt.Errorf("failed to send email change confirmation: %w", err)
+	}
+
+	log.Printf("Email change confirmation sent successfully to %s", RedactedEmail(toEmail))
+	return nil
+}
+
+// SendEmailChangeNotice tells the old address that an email change to newEmail was requested
+// A registered "email_change_notice.html" template overrides the built-in body; it gets NewEmail, redacted.
+func (s *EmailService) SendEmailChangeNotice(toEmail, fromEmail, name, newEmail, locale string) error {
+	config := s.Config()
+
+	subject := localizedSubject("email_change_notice", locale, config.AppName)
+	body, err := s.renderBody("email_change_notice.html", locale, config, map[string]string{
+		"Name":     name,
+		"NewEmail": RedactEmail(newEmail),
+	})
+	if err != nil {
+		log.Printf("Failed to render email change notice: %v", err)
+		return err
+	}
+
+	err = s.Queue(EmailMessage{
+		From:          fromEmail,
+		To:            []string{toEmail},
+		Subject:       subject,
+		HTMLBody:      body,
+		Transactional: true,
+		Type:          EmailTypeEmailChangeNotice,
+	})
+	if err != nil {
+		log.Printf("Failed to send email change notice to %s: %v", RedactedEmail(toEmail), err)
+		return fmt.Errorf("failed to send email change notice: %w", err)
+	}
+
+	log.Printf("Email change notice sent successfully to %s", RedactedEmail(toEmail))
+	return nil
+}
+
+// SendEmailChangeConfirmation sends an email change confirmation using the default service
+func SendEmailChangeConfirmation(toEmail, name, baseURL, fromEmail, token, locale string) error {
+	return defaultEmailService.SendEmailChangeConfirmation(toEmail, name, baseURL, fromEmail, token, locale)
+}
+
+// SendEmailChangeNotice sends an email change notice using the default service
+func SendEmailChangeNotice(toEmail, fromEmail, name, newEmail, locale string) error {
+	return defaultEmailService.SendEmailChangeNotice(toEmail, fromEmail, name, newEmail, locale)
+}
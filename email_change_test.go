@@ -0,0 +1,162 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRequestEmailChangeCountsWrongPasswords(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	hash, err := GenerateFromPassword("correct horse", CurrentPasswordParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := func(attempts int, lockedUntil any) bson.D {
+		return bson.D{
+			{Key: "_id", Value: testUserID},
+			{Key: "email", Value: "user@example.com"},
+			{Key: "password", Value: hash},
+			{Key: "login_attempts", Value: attempts},
+			{Key: "locked_until", Value: lockedUntil},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		user         bson.D
+		want         int
+		wantAttempts int64 // The failed attempts stored afterwards; -1 if none are stored
+	}{
+		{"wrong password", user(0, nil), http.StatusUnauthorized, 1},
+		{"wrong password after earlier failures", user(3, nil), http.StatusUnauthorized, 4},
+		{"locked account", user(0, time.Now().Add(time.Hour)), http.StatusLocked, -1},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, tt.user),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			)
+			r := httptest.NewRequest(http.MethodPost, "/auth/me/email", strings.NewReader(`{"new_email":"new@example.com","password":"wrong"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r = SetUserID(r, testUserID)
+			w := httptest.NewRecorder()
+
+			RequestEmailChange(mt.DB, w, r, "https://example.com", "noreply@example.com", testSecret)
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			mt.GetStartedEvent()
+			update := mt.GetStartedEvent()
+			if tt.wantAttempts < 0 {
+				if update != nil {
+					mt.Fatalf("unexpected %s", update.CommandName)
+				}
+				return
+			}
+			if update == nil || update.CommandName != "update" {
+				mt.Fatal("the failure was not recorded")
+			}
+			set := update.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+			if attempts := set.Lookup("login_attempts").AsInt64(); attempts != tt.wantAttempts {
+				mt.Fatalf("login_attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRequestEmailChangeWithoutPasswordNeedsRecentSignIn(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	captureLog(t)
+
+	user := bson.D{{Key: "_id", Value: testUserID}, {Key: "email", Value: "user@example.com"}}
+	tests := []struct {
+		name    string
+		claims  *AppClaims // The authenticating token's claims, nil if there are none
+		allowed bool
+	}{
+		{"no token claims", nil, false},
+		{"token without a sign-in time", &AppClaims{}, false},
+		{"signed in long ago", &AppClaims{AuthTime: jwt.NewNumericDate(time.Now().Add(-time.Hour))}, false},
+		{"signed in just now", &AppClaims{AuthTime: jwt.NewNumericDate(time.Now())}, true},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, user),
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch),
+			)
+			r := httptest.NewRequest(http.MethodPost, "/auth/me/email", strings.NewReader(`{"new_email":"new@example.com"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.claims != nil {
+				tt.claims.Subject = testUserID
+				r = SetClaims(r, tt.claims)
+			} else {
+				r = SetUserID(r, testUserID)
+			}
+			w := httptest.NewRecorder()
+
+			RequestEmailChange(mt.DB, w, r, "https://example.com", "noreply@example.com", testSecret)
+			if !tt.allowed && w.Code != http.StatusForbidden {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+			}
+
+			mt.GetStartedEvent()
+			if proceeded := mt.GetStartedEvent() != nil; proceeded != tt.allowed {
+				mt.Fatalf("proceeded past the sign-in check = %v, want %v", proceeded, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestConfirmEmailChangeSignsOutAndInvalidatesResets(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	captureLog(t)
+
+	mt.Run("confirmed", func(mt *mtest.T) {
+		useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+		useTokenRevocation(mt.T, mt.DB)
+
+		verification := bson.D{
+			{Key: "_id", Value: "verification-id"},
+			{Key: "user_id", Value: testUserID},
+			{Key: "email", Value: "new@example.com"},
+			{Key: "previous_email", Value: "user@example.com"},
+		}
+		ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: verification}),
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch),
+			ok, ok, ok, ok,
+		)
+		r := httptest.NewRequest(http.MethodPost, "/auth/me/email/confirm", strings.NewReader(`{"token":"12345678"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r = SetUserID(r, testUserID)
+		w := httptest.NewRecorder()
+
+		ConfirmEmailChange(mt.DB, w, r)
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		var updated []string
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			updated = append(updated, event.Command.Lookup("update").StringValue())
+		}
+		want := []string{"users", "refresh_tokens", "token_revocations", "password_resets"}
+		if strings.Join(updated, ",") != strings.Join(want, ",") {
+			mt.Fatalf("updated %v, want %v", updated, want)
+		}
+	})
+}
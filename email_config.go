@@ -0,0 +1,180 @@
+package common
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// EmailConfig holds the branding and sender identity used by the package's emails
+type EmailConfig struct {
+	AppName        string // Product name used in subjects and body copy
+	FromName       string // Display name for the sender; defaults to AppName
+	FromAddress    string // Sender address used when a Send* function is given none
+	ReplyTo        string // Optional reply-to address
+	BaseURL        string // Frontend URL used for links when a Send* function is given none
+	SupportAddress string // Optional support address shown in email footers
+
+	// ConfigurationSet is the SES configuration set used to publish deliverability events
+	ConfigurationSet string
+	// TypeConfigurationSets overrides ConfigurationSet per email type
+	TypeConfigurationSets map[EmailType]string
+	// TypeTags adds SES message tags per email type; every message is also tagged with email_type
+	TypeTags map[EmailType]map[string]string
+
+	// Links builds the verification, reset and unlock URLs; defaults to DefaultLinkBuilder
+	Links LinkBuilder
+}
+
+// DefaultEmailConfig returns the branding used before InitializeEmail is called
+func DefaultEmailConfig() EmailConfig {
+	return EmailConfig{AppName: "Flight History App"}
+}
+
+// Validate checks the addresses in the configuration
+func (c EmailConfig) Validate() error {
+	if strings.TrimSpace(c.AppName) == "" {
+		return fmt.Errorf("email app name is required")
+	}
+	for name, address := range map[string]string{
+		"from address":    c.FromAddress,
+		"reply-to":        c.ReplyTo,
+		"support address": c.SupportAddress,
+	} {
+		if address == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid email %s %q: %w", name, address, err)
+		}
+	}
+	return nil
+}
+
+// sender returns the From header for a message, preferring an explicit address
+func (c EmailConfig) sender(fromEmail string) string {
+	if fromEmail != "" {
+		return fromEmail
+	}
+	if c.FromAddress == "" {
+		return ""
+	}
+
+	name := c.FromName
+	if name == "" {
+		name = c.AppName
+	}
+	return (&mail.Address{Name: name, Address: c.FromAddress}).String()
+}
+
+// baseURL returns the link base for a message, preferring an explicit URL
+func (c EmailConfig) baseURL(baseURL string) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+// link builds the URL for a token, falling back to the default routes if the builder fails
+func (c EmailConfig) link(kind LinkKind, baseURL, token string) string {
+	baseURL = c.baseURL(baseURL)
+	if c.Links != nil {
+		link, err := c.Links.BuildLink(kind, baseURL, token)
+		if err == nil {
+			return link
+		}
+		log.Printf("CONFIG: failed to build %s link, using default route: %v", kind, err)
+	}
+
+	link, _ := DefaultLinkBuilder().BuildLink(kind, baseURL, token)
+	return link
+}
+
+// replyTo returns the configured reply-to address as a list for SES
+func (c EmailConfig) replyTo() []string {
+	if c.ReplyTo != "" {
+		return []string{c.ReplyTo}
+	}
+	return nil
+}
+
+// configurationSetFor returns the SES configuration set for a message, or nil for none
+func (c EmailConfig) configurationSetFor(emailType EmailType, override string) *string {
+	if override != "" {
+		return aws.String(override)
+	}
+	if set := c.TypeConfigurationSets[emailType]; set != "" {
+		return aws.String(set)
+	}
+	if c.ConfigurationSet != "" {
+		return aws.String(c.ConfigurationSet)
+	}
+	return nil
+}
+
+// messageTags returns the SES message tags for an email type, sorted by name
+func (c EmailConfig) messageTags(emailType EmailType, extra map[string]string) []types.MessageTag {
+	if emailType == "" {
+		emailType = EmailTypeOther
+	}
+
+	tags := map[string]string{"email_type": string(emailType)}
+	for name, value := range c.TypeTags[emailType] {
+		tags[name] = value
+	}
+	for name, value := range extra {
+		tags[name] = value
+	}
+
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messageTags := make([]types.MessageTag, 0, len(names))
+	for _, name := range names {
+		messageTags = append(messageTags, types.MessageTag{Name: aws.String(name), Value: aws.String(tags[name])})
+	}
+	return messageTags
+}
+
+// footerHTML returns the sign-off shown at the end of built-in emails
+func (c EmailConfig) footerHTML() string {
+	footer := fmt.Sprintf("<p>Best regards,<br>%s Team</p>", html.EscapeString(c.AppName))
+	if c.SupportAddress != "" {
+		footer += fmt.Sprintf(`<p>Questions? Contact us at <a href="mailto:%[1]s">%[1]s</a>.</p>`, html.EscapeString(c.SupportAddress))
+	}
+	return footer
+}
+
+// templateData adds the branding fields available to every email template
+func (c EmailConfig) templateData(data map[string]string) map[string]string {
+	data["AppName"] = c.AppName
+	data["SupportAddress"] = c.SupportAddress
+	return data
+}
+
+// InitializeEmail sets the default email service's branding and sender identity and initializes its SES client
+func InitializeEmail(config EmailConfig) error {
+	if err := SetEmailConfig(config); err != nil {
+		return err
+	}
+	return InitializeSES()
+}
+
+// SetEmailConfig sets the default email service's branding and sender identity without touching SES
+func SetEmailConfig(config EmailConfig) error {
+	return defaultEmailService.SetConfig(config)
+}
+
+// CurrentEmailConfig returns the default email service's branding and sender identity
+func CurrentEmailConfig() EmailConfig {
+	return defaultEmailService.Config()
+}
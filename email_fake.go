@@ -0,0 +1,237 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/mail"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// FakeEmail is a message captured by FakeEmailSender
+type FakeEmail struct {
+	MessageID        string
+	From             string
+	To               []string
+	ReplyTo          []string
+	Subject          string
+	HTMLBody         string
+	TextBody         string
+	Raw              []byte            // Set for messages with attachments
+	Template         string            // Set for bulk templated sends
+	TemplateData     string            // Per-recipient replacement data for bulk sends
+	Type             EmailType         // From the email_type tag
+	Tags             map[string]string // All message tags
+	ConfigurationSet string
+}
+
+// FakeEmailSender is an in-memory SESClient that records messages instead of sending them,
+// so services can test registration and reset flows end to end without AWS
+type FakeEmailSender struct {
+	mu       sync.Mutex
+	messages []FakeEmail
+	err      error
+	next     int
+}
+
+// NewFakeEmailSender creates an empty fake sender
+func NewFakeEmailSender() *FakeEmailSender {
+	return &FakeEmailSender{}
+}
+
+// UseFakeEmailSender installs a new fake sender as the default email service's SES client
+func UseFakeEmailSender() *FakeEmailSender {
+	fake := NewFakeEmailSender()
+	defaultEmailService.SetClient(fake)
+	return fake
+}
+
+// SendEmail records a message
+func (f *FakeEmailSender) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	email := FakeEmail{
+		From:             aws.ToString(params.FromEmailAddress),
+		ReplyTo:          params.ReplyToAddresses,
+		ConfigurationSet: aws.ToString(params.ConfigurationSetName),
+	}
+	if params.Destination != nil {
+		email.To = params.Destination.ToAddresses
+	}
+	email.Tags, email.Type = fakeEmailTags(params.EmailTags)
+
+	if params.Content != nil {
+		if simple := params.Content.Simple; simple != nil {
+			if simple.Subject != nil {
+				email.Subject = aws.ToString(simple.Subject.Data)
+			}
+			if simple.Body != nil && simple.Body.Html != nil {
+				email.HTMLBody = aws.ToString(simple.Body.Html.Data)
+			}
+			if simple.Body != nil && simple.Body.Text != nil {
+				email.TextBody = aws.ToString(simple.Body.Text.Data)
+			}
+		}
+		if raw := params.Content.Raw; raw != nil {
+			email.Raw = raw.Data
+			email.Subject = rawEmailSubject(raw.Data)
+		}
+		if template := params.Content.Template; template != nil {
+			email.Template = aws.ToString(template.TemplateName)
+			email.TemplateData = aws.ToString(template.TemplateData)
+		}
+	}
+
+	email.MessageID = f.record(email)
+	return &sesv2.SendEmailOutput{MessageId: aws.String(email.MessageID)}, nil
+}
+
+// SendBulkEmail records one message per bulk entry
+func (f *FakeEmailSender) SendBulkEmail(ctx context.Context, params *sesv2.SendBulkEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendBulkEmailOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	tags, emailType := fakeEmailTags(params.DefaultEmailTags)
+	var template string
+	if params.DefaultContent != nil && params.DefaultContent.Template != nil {
+		template = aws.ToString(params.DefaultContent.Template.TemplateName)
+	}
+
+	output := &sesv2.SendBulkEmailOutput{}
+	for _, entry := range params.BulkEmailEntries {
+		email := FakeEmail{
+			From:             aws.ToString(params.FromEmailAddress),
+			ReplyTo:          params.ReplyToAddresses,
+			Template:         template,
+			Type:             emailType,
+			Tags:             tags,
+			ConfigurationSet: aws.ToString(params.ConfigurationSetName),
+		}
+		if entry.Destination != nil {
+			email.To = entry.Destination.ToAddresses
+		}
+		if content := entry.ReplacementEmailContent; content != nil && content.ReplacementTemplate != nil {
+			email.TemplateData = aws.ToString(content.ReplacementTemplate.ReplacementTemplateData)
+		}
+
+		output.BulkEmailEntryResults = append(output.BulkEmailEntryResults, types.BulkEmailEntryResult{
+			MessageId: aws.String(f.record(email)),
+			Status:    types.BulkEmailStatusSuccess,
+		})
+	}
+	return output, nil
+}
+
+// record stores a message and returns its generated message ID; f.mu must be held
+func (f *FakeEmailSender) record(email FakeEmail) string {
+	f.next++
+	email.MessageID = fmt.Sprintf("fake-%d", f.next)
+	f.messages = append(f.messages, email)
+	return email.MessageID
+}
+
+// FailWith makes every following send return err; pass nil to succeed again
+func (f *FakeEmailSender) FailWith(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// Messages returns every recorded message, oldest first
+func (f *FakeEmailSender) Messages() []FakeEmail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]FakeEmail, len(f.messages))
+	copy(messages, f.messages)
+	return messages
+}
+
+// Count returns how many messages were recorded
+func (f *FakeEmailSender) Count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.messages)
+}
+
+// CountByType returns how many messages of a type were recorded
+func (f *FakeEmailSender) CountByType(emailType EmailType) int {
+	count := 0
+	for _, email := range f.Messages() {
+		if email.Type == emailType {
+			count++
+		}
+	}
+	return count
+}
+
+// LastTo returns the most recent message addressed to an email address
+func (f *FakeEmailSender) LastTo(email string) (FakeEmail, bool) {
+	email = normalizeEmail(email)
+
+	messages := f.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		for _, recipient := range messages[i].To {
+			if normalizeEmail(recipient) == email {
+				return messages[i], true
+			}
+		}
+	}
+	return FakeEmail{}, false
+}
+
+// Last returns the most recently recorded message
+func (f *FakeEmailSender) Last() (FakeEmail, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.messages) == 0 {
+		return FakeEmail{}, false
+	}
+	return f.messages[len(f.messages)-1], true
+}
+
+// Reset discards all recorded messages and clears any configured failure
+func (f *FakeEmailSender) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = nil
+	f.err = nil
+}
+
+// fakeEmailTags converts SES message tags to a map and extracts the email type
+func fakeEmailTags(messageTags []types.MessageTag) (map[string]string, EmailType) {
+	tags := make(map[string]string, len(messageTags))
+	for _, tag := range messageTags {
+		tags[aws.ToString(tag.Name)] = aws.ToString(tag.Value)
+	}
+	return tags, EmailType(tags["email_type"])
+}
+
+// rawEmailSubject extracts the decoded subject from a raw MIME message
+func rawEmailSubject(raw []byte) string {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+
+	subject := message.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		return decoded
+	}
+	return subject
+}
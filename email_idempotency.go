@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrEmailDuplicate is returned when a message's idempotency key was already sent within the window
+var ErrEmailDuplicate = errors.New("email with this idempotency key was already sent")
+
+// defaultEmailIdempotencyWindow is how long a key blocks resends when no window is given
+const defaultEmailIdempotencyWindow = 24 * time.Hour
+
+// EmailSendRecord represents a reserved idempotency key in the database
+type EmailSendRecord struct {
+	ID        string    `json:"id" bson:"_id"`                // SHA-256 of the idempotency key
+	Type      EmailType `json:"type" bson:"type"`             // Type of the message sent with the key
+	CreatedAt time.Time `json:"created_at" bson:"created_at"` // When the key was reserved
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"` // When the key may be sent again
+}
+
+// EnableIdempotency makes the service refuse to resend a message whose IdempotencyKey was sent
+// within window, recording keys in the database's email_sends collection
+// A zero window defaults to 24 hours. Expired records are removed by a TTL index.
+func (s *EmailService) EnableIdempotency(ctx context.Context, database *mongo.Database, window time.Duration) error {
+	if window <= 0 {
+		window = defaultEmailIdempotencyWindow
+	}
+
+	collection := database.Collection("email_sends")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends = collection
+	s.idempotencyWindow = window
+	return nil
+}
+
+// EnableEmailIdempotency enables idempotency keys on the default email service
+func EnableEmailIdempotency(ctx context.Context, database *mongo.Database, window time.Duration) error {
+	return defaultEmailService.EnableIdempotency(ctx, database, window)
+}
+
+// idempotency returns the send record collection and window, or nil if idempotency is disabled
+func (s *EmailService) idempotency() (*mongo.Collection, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sends, s.idempotencyWindow
+}
+
+// emailIdempotencyID hashes a key so tokens used as keys aren't stored in plain text
+func emailIdempotencyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// reserveIdempotencyKey records that msg is being sent, returning ErrEmailDuplicate if its key
+// was already reserved within the window
+// Lookup failures are logged and the send proceeds, since a double-send beats a lost email.
+func (s *EmailService) reserveIdempotencyKey(ctx context.Context, msg EmailMessage) error {
+	collection, window := s.idempotency()
+	if collection == nil || msg.IdempotencyKey == "" {
+		return nil
+	}
+
+	now := time.Now()
+	// Only an expired record matches, so a live one makes the upsert collide on _id
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": emailIdempotencyID(msg.IdempotencyKey), "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"type": msg.Type, "created_at": now, "expires_at": now.Add(window)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailDuplicate
+	}
+	if err != nil {
+		log.Printf("Failed to reserve email idempotency key, sending anyway: %v", err)
+	}
+	return nil
+}
+
+// releaseIdempotencyKey frees a key after a failed send so a retry can use it
+func (s *EmailService) releaseIdempotencyKey(ctx context.Context, msg EmailMessage) {
+	collection, _ := s.idempotency()
+	if collection == nil || msg.IdempotencyKey == "" {
+		return
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": emailIdempotencyID(msg.IdempotencyKey)}); err != nil {
+		log.Printf("Failed to release email idempotency key: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+var ErrSESIdentityUnsupported = errors.New("SES client does not support identity lookups")
+
+// SESIdentityClient is the subset of the SES v2 client used to check and configure sending identities
+type SESIdentityClient interface {
+	GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error)
+	GetEmailIdentity(ctx context.Context, params *sesv2.GetEmailIdentityInput, optFns ...func(*sesv2.Options)) (*sesv2.GetEmailIdentityOutput, error)
+	PutEmailIdentityMailFromAttributes(ctx context.Context, params *sesv2.PutEmailIdentityMailFromAttributesInput, optFns ...func(*sesv2.Options)) (*sesv2.PutEmailIdentityMailFromAttributesOutput, error)
+}
+
+// SESIdentityOptions describes what a sending identity must look like before the app starts sending
+type SESIdentityOptions struct {
+	Identity string // Domain or address to check; defaults to the domain of the configured from address
+
+	RequireDKIM             bool   // Fail unless DKIM signing is enabled and verified
+	MailFromDomain          string // Custom MAIL FROM domain to configure and require, e.g. "mail.example.com"
+	RequireProductionAccess bool   // Fail while the account is still in the SES sandbox
+}
+
+// SESIdentityStatus summarizes an SES sending identity
+type SESIdentityStatus struct {
+	Identity           string
+	VerifiedForSending bool
+	VerificationStatus string
+	DKIMSigningEnabled bool
+	DKIMStatus         string
+	DKIMTokens         []string
+	MailFromDomain     string
+	MailFromStatus     string
+	ProductionAccess   bool
+	SendingEnabled     bool
+}
+
+// CheckSESIdentity looks up an identity and returns its status along with an error describing every
+// problem found and how to fix it
+func CheckSESIdentity(ctx context.Context, client SESIdentityClient, options SESIdentityOptions) (*SESIdentityStatus, error) {
+	if options.Identity == "" {
+		return nil, fmt.Errorf("SES identity is required")
+	}
+
+	identity, err := client.GetEmailIdentity(ctx, &sesv2.GetEmailIdentityInput{EmailIdentity: aws.String(options.Identity)})
+	if err != nil {
+		var notFound *types.NotFoundException
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("SES identity %s does not exist in this account and region; create it in the SES console or with CreateEmailIdentity and publish its DKIM records", options.Identity)
+		}
+		return nil, fmt.Errorf("failed to look up SES identity %s: %w", options.Identity, err)
+	}
+
+	status := &SESIdentityStatus{
+		Identity:           options.Identity,
+		VerifiedForSending: identity.VerifiedForSendingStatus,
+		VerificationStatus: string(identity.VerificationStatus),
+	}
+	if dkim := identity.DkimAttributes; dkim != nil {
+		status.DKIMSigningEnabled = dkim.SigningEnabled
+		status.DKIMStatus = string(dkim.Status)
+		status.DKIMTokens = dkim.Tokens
+	}
+	if mailFrom := identity.MailFromAttributes; mailFrom != nil {
+		status.MailFromDomain = aws.ToString(mailFrom.MailFromDomain)
+		status.MailFromStatus = string(mailFrom.MailFromDomainStatus)
+	}
+
+	var problems []error
+	if !status.VerifiedForSending {
+		problems = append(problems, fmt.Errorf("SES identity %s is not verified for sending (status %s)%s",
+			options.Identity, orUnknown(status.VerificationStatus), dkimRecordHint(options.Identity, status.DKIMTokens)))
+	}
+
+	if options.RequireDKIM {
+		switch {
+		case !status.DKIMSigningEnabled:
+			problems = append(problems, fmt.Errorf("DKIM signing is disabled for %s; enable it with PutEmailIdentityDkimAttributes", options.Identity))
+		case status.DKIMStatus != string(types.DkimStatusSuccess):
+			problems = append(problems, fmt.Errorf("DKIM for %s is %s, not SUCCESS%s",
+				options.Identity, orUnknown(status.DKIMStatus), dkimRecordHint(options.Identity, status.DKIMTokens)))
+		}
+	}
+
+	if options.MailFromDomain != "" {
+		switch {
+		case !strings.EqualFold(status.MailFromDomain, options.MailFromDomain):
+			problems = append(problems, fmt.Errorf("custom MAIL FROM domain for %s is %q, expected %q; call ConfigureMailFromDomain",
+				options.Identity, status.MailFromDomain, options.MailFromDomain))
+		case status.MailFromStatus != string(types.MailFromDomainStatusSuccess):
+			problems = append(problems, fmt.Errorf("custom MAIL FROM domain %s is %s, not SUCCESS%s",
+				options.MailFromDomain, orUnknown(status.MailFromStatus), mailFromRecordHint(options.MailFromDomain)))
+		}
+	}
+
+	account, err := client.GetAccount(ctx, &sesv2.GetAccountInput{})
+	if err != nil {
+		return status, errors.Join(append(problems, fmt.Errorf("failed to look up SES account: %w", err))...)
+	}
+	status.ProductionAccess = account.ProductionAccessEnabled
+	status.SendingEnabled = account.SendingEnabled
+
+	if !status.SendingEnabled {
+		problems = append(problems, fmt.Errorf("sending is paused for this SES account; check the account's reputation dashboard before resuming"))
+	}
+	if options.RequireProductionAccess && !status.ProductionAccess {
+		problems = append(problems, fmt.Errorf("this SES account is in the sandbox and can only send to verified addresses; request production access"))
+	}
+
+	return status, errors.Join(problems...)
+}
+
+// ConfigureMailFromDomain sets an identity's custom MAIL FROM domain, leaving it unchanged if it already matches
+// Mail falls back to the amazonses.com MAIL FROM domain if the domain's MX record is missing
+func ConfigureMailFromDomain(ctx context.Context, client SESIdentityClient, identity, mailFromDomain string) error {
+	current, err := client.GetEmailIdentity(ctx, &sesv2.GetEmailIdentityInput{EmailIdentity: aws.String(identity)})
+	if err != nil {
+		return fmt.Errorf("failed to look up SES identity %s: %w", identity, err)
+	}
+	if current.MailFromAttributes != nil && strings.EqualFold(aws.ToString(current.MailFromAttributes.MailFromDomain), mailFromDomain) {
+		return nil
+	}
+
+	_, err = client.PutEmailIdentityMailFromAttributes(ctx, &sesv2.PutEmailIdentityMailFromAttributesInput{
+		EmailIdentity:       aws.String(identity),
+		MailFromDomain:      aws.String(mailFromDomain),
+		BehaviorOnMxFailure: types.BehaviorOnMxFailureUseDefaultValue,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set MAIL FROM domain %s for %s: %w", mailFromDomain, identity, err)
+	}
+
+	log.Printf("Configured MAIL FROM domain %s for SES identity %s%s", mailFromDomain, identity, mailFromRecordHint(mailFromDomain))
+	return nil
+}
+
+// VerifySendingIdentity checks the default email service's sending identity, configuring the custom
+// MAIL FROM domain first if one is requested
+func VerifySendingIdentity(ctx context.Context, options SESIdentityOptions) (*SESIdentityStatus, error) {
+	return defaultEmailService.VerifySendingIdentity(ctx, options)
+}
+
+// VerifySendingIdentity checks the service's sending identity, configuring the custom MAIL FROM domain
+// first if one is requested; call it at startup to fail fast on a misconfigured domain
+func (s *EmailService) VerifySendingIdentity(ctx context.Context, options SESIdentityOptions) (*SESIdentityStatus, error) {
+	sesClient, config, _, _, _ := s.state()
+	client, ok := sesClient.(SESIdentityClient)
+	if !ok {
+		return nil, ErrSESIdentityUnsupported
+	}
+
+	if options.Identity == "" {
+		domain, err := senderDomain(config.FromAddress)
+		if err != nil {
+			return nil, err
+		}
+		options.Identity = domain
+	}
+
+	if options.MailFromDomain != "" {
+		if err := ConfigureMailFromDomain(ctx, client, options.Identity, options.MailFromDomain); err != nil {
+			return nil, err
+		}
+	}
+
+	return CheckSESIdentity(ctx, client, options)
+}
+
+// senderDomain returns the domain part of a from address
+func senderDomain(fromAddress string) (string, error) {
+	if fromAddress == "" {
+		return "", fmt.Errorf("email from address is required to find the SES identity")
+	}
+	address, err := mail.ParseAddress(fromAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid email from address %q: %w", fromAddress, err)
+	}
+	at := strings.LastIndex(address.Address, "@")
+	return strings.ToLower(address.Address[at+1:]), nil
+}
+
+// dkimRecordHint lists the DKIM CNAME records SES expects for an identity
+func dkimRecordHint(identity string, tokens []string) string {
+	if len(tokens) == 0 || strings.Contains(identity, "@") {
+		return ""
+	}
+	records := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		records = append(records, fmt.Sprintf("%s._domainkey.%s CNAME %s.dkim.amazonses.com", token, identity, token))
+	}
+	return "; publish these DNS records: " + strings.Join(records, ", ")
+}
+
+// mailFromRecordHint describes the DNS records a custom MAIL FROM domain needs
+func mailFromRecordHint(mailFromDomain string) string {
+	return fmt.Sprintf("; publish %s MX 10 feedback-smtp.<region>.amazonses.com and %s TXT \"v=spf1 include:amazonses.com ~all\"",
+		mailFromDomain, mailFromDomain)
+}
+
+// orUnknown returns value, or "unknown" if it is empty
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
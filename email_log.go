@@ -0,0 +1,206 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmailLogStatus is the outcome recorded for an outbound email
+type EmailLogStatus string
+
+const (
+	EmailLogSent       EmailLogStatus = "sent"
+	EmailLogFailed     EmailLogStatus = "failed"
+	EmailLogSuppressed EmailLogStatus = "suppressed"
+	EmailLogDuplicate  EmailLogStatus = "duplicate"
+	EmailLogDryRun     EmailLogStatus = "dry_run"
+	EmailLogBounced    EmailLogStatus = "bounced"
+	EmailLogComplained EmailLogStatus = "complained"
+)
+
+// EmailLogEntry represents one outbound email in the database
+type EmailLogEntry struct {
+	ID        string         `json:"id" bson:"_id"`                                    // Unique ID for the entry
+	To        []string       `json:"to" bson:"to"`                                     // Lower-cased recipient addresses
+	Type      EmailType      `json:"type" bson:"type"`                                 // Type of the email
+	Subject   string         `json:"subject" bson:"subject"`                           // Subject line
+	MessageID string         `json:"message_id,omitempty" bson:"message_id,omitempty"` // SES message ID, if SES accepted the email
+	Status    EmailLogStatus `json:"status" bson:"status"`                             // Outcome of the send
+	Error     string         `json:"error,omitempty" bson:"error,omitempty"`           // Failure details
+	CreatedAt time.Time      `json:"created_at" bson:"created_at"`                     // When the send was attempted
+	UpdatedAt time.Time      `json:"updated_at" bson:"updated_at"`                     // When the status last changed
+}
+
+// EnableLog makes the service record every outbound email in the database's email_log collection
+func (s *EmailService) EnableLog(database *mongo.Database) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emailLog = database.Collection("email_log")
+}
+
+// EnableEmailLog records the default email service's outbound email in the email_log collection
+func EnableEmailLog(database *mongo.Database) {
+	defaultEmailService.EnableLog(database)
+}
+
+// logEmail records the outcome of a send if the email log is enabled
+// Failures to write the log are logged and otherwise ignored
+func (s *EmailService) logEmail(ctx context.Context, to []string, emailType EmailType, subject, messageID string, status EmailLogStatus, sendErr error) {
+	s.mu.RLock()
+	collection := s.emailLog
+	s.mu.RUnlock()
+	if collection == nil {
+		return
+	}
+
+	id, err := NewID()
+	if err != nil {
+		log.Printf("Failed to generate email log ID: %v", err)
+		return
+	}
+
+	recipients := make([]string, len(to))
+	for i, recipient := range to {
+		recipients[i] = normalizeEmail(recipient)
+	}
+
+	now := time.Now()
+	entry := EmailLogEntry{
+		ID:        id,
+		To:        recipients,
+		Type:      emailType,
+		Subject:   subject,
+		MessageID: messageID,
+		Status:    status,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if sendErr != nil {
+		entry.Error = sendErr.Error()
+	}
+
+	if _, err := collection.InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to record email log entry: %v", err)
+	}
+}
+
+// UpdateEmailLogStatus sets the status of the entry with an SES message ID, e.g. after a bounce
+func UpdateEmailLogStatus(ctx context.Context, database *mongo.Database, messageID string, status EmailLogStatus) error {
+	if messageID == "" {
+		return nil
+	}
+
+	_, err := database.Collection("email_log").UpdateOne(ctx,
+		bson.M{"message_id": messageID},
+		bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update email log: %w", err)
+	}
+	return nil
+}
+
+// EmailLogQuery filters email log entries; zero fields match everything
+type EmailLogQuery struct {
+	Email  string         // Recipient address
+	Type   EmailType      // Email type
+	Status EmailLogStatus // Send outcome
+	Since  time.Time      // Earliest creation time
+	Until  time.Time      // Latest creation time
+	Limit  int64          // Maximum entries; defaults to 100
+}
+
+// QueryEmailLog returns matching email log entries, newest first
+func QueryEmailLog(ctx context.Context, database *mongo.Database, query EmailLogQuery) ([]EmailLogEntry, error) {
+	filter := bson.M{}
+	if query.Email != "" {
+		filter["to"] = normalizeEmail(query.Email)
+	}
+	if query.Type != "" {
+		filter["type"] = query.Type
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+
+	created := bson.M{}
+	if !query.Since.IsZero() {
+		created["$gte"] = query.Since
+	}
+	if !query.Until.IsZero() {
+		created["$lte"] = query.Until
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(query.Limit)
+	cursor, err := database.Collection("email_log").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query email log: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	entries := []EmailLogEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode email log: %w", err)
+	}
+	return entries, nil
+}
+
+// ListEmailLogHandler lists email log entries for support tooling
+// Supports the optional query parameters email, type, status, since and until (RFC 3339),
+// and limit (default 100, max 1000)
+func ListEmailLogHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := EmailLogQuery{
+		Email:  SanitizeInput(params.Get("email")),
+		Type:   EmailType(params.Get("type")),
+		Status: EmailLogStatus(params.Get("status")),
+		Limit:  100,
+	}
+
+	for _, field := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if value := params.Get(field.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				RespondWithValidationError(w, field.name, "must be an RFC 3339 timestamp")
+				return
+			}
+			*field.dest = parsed
+		}
+	}
+
+	if value := params.Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			RespondWithValidationError(w, "limit", "must be between 1 and 1000")
+			return
+		}
+		query.Limit = parsed
+	}
+
+	entries, err := QueryEmailLog(r.Context(), database, query)
+	if err != nil {
+		log.Printf("Failed to query email log: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, entries)
+}
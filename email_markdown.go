@@ -0,0 +1,239 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/yuin/goldmark"
+)
+
+// defaultEmailLayout wraps rendered Markdown in a simple inline-styled page
+const defaultEmailLayout = `<html>
+<body style="font-family: -apple-system, Helvetica, Arial, sans-serif; line-height: 1.5; color: #222;">
+	<div style="max-width: 600px; margin: 0 auto; padding: 16px;">
+		{{.Content}}
+		<br>
+		{{.Footer}}
+	</div>
+</body>
+</html>
+`
+
+// markdownRenderer converts Markdown to HTML; raw HTML in the source is omitted
+var markdownRenderer = goldmark.New()
+
+// markdownFuncs are available in Markdown templates
+var markdownFuncs = texttemplate.FuncMap{
+	"md": EscapeMarkdown,
+}
+
+// markdownSpecial matches characters with meaning in Markdown
+var markdownSpecial = regexp.MustCompile("([\\\\`*_{}\\[\\]()#+\\-.!|<>])")
+
+// EscapeMarkdown backslash-escapes Markdown punctuation so user-provided values, e.g. names,
+// render as literal text; use it in templates as {{md .Name}}
+func EscapeMarkdown(value string) string {
+	return markdownSpecial.ReplaceAllString(value, `\$1`)
+}
+
+// EmailLayoutData is passed to the layout that wraps rendered Markdown emails
+type EmailLayoutData struct {
+	Subject string
+	AppName string
+	Content template.HTML // The rendered Markdown body
+	Footer  template.HTML // The sign-off from EmailConfig
+}
+
+// AddMarkdown registers a Markdown email body under name, e.g. "invite.md"
+// The source may use text/template actions and the md function to escape values.
+func (r *EmailTemplateRegistry) AddMarkdown(name, source string) error {
+	parsed, err := texttemplate.New(name).Funcs(markdownFuncs).Parse(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse markdown email template %s: %w", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.markdown[name] = parsed
+	return nil
+}
+
+// ParseMarkdownFS registers Markdown templates matching patterns from fsys under their base file names
+// Patterns default to "*.md"
+func (r *EmailTemplateRegistry) ParseMarkdownFS(fsys fs.FS, patterns ...string) error {
+	if len(patterns) == 0 {
+		patterns = []string{"*.md"}
+	}
+
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return fmt.Errorf("failed to parse markdown email templates: %w", err)
+		}
+		for _, match := range matches {
+			source, err := fs.ReadFile(fsys, match)
+			if err != nil {
+				return fmt.Errorf("failed to read markdown email template %s: %w", match, err)
+			}
+			if err := r.AddMarkdown(path.Base(match), string(source)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetLayout replaces the HTML layout Markdown emails are wrapped in
+// The layout is an html/template that receives EmailLayoutData.
+func (r *EmailTemplateRegistry) SetLayout(layout string) error {
+	parsed, err := template.New("layout").Parse(layout)
+	if err != nil {
+		return fmt.Errorf("failed to parse email layout: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.layout = parsed
+	return nil
+}
+
+// lookupMarkdown returns the most specific Markdown template for a locale
+func (r *EmailTemplateRegistry) lookupMarkdown(name, locale string) (*texttemplate.Template, string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, candidate := range localizedTemplateNames(name, locale) {
+		if t, ok := r.markdown[candidate]; ok {
+			return t, candidate, true
+		}
+		if t, ok := r.markdown[path.Base(candidate)]; ok {
+			return t, candidate, true
+		}
+	}
+	return nil, "", false
+}
+
+// RenderMarkdown renders a Markdown template into an HTML body wrapped in the layout and a
+// plain-text body derived from the Markdown, along with its subject
+func (r *EmailTemplateRegistry) RenderMarkdown(name, locale string, config EmailConfig, data any) (EmailTemplate, error) {
+	source, resolvedName, ok := r.lookupMarkdown(name, locale)
+	if !ok {
+		return EmailTemplate{}, fmt.Errorf("markdown email template %s is not registered", name)
+	}
+
+	var markdown strings.Builder
+	if err := source.Execute(&markdown, data); err != nil {
+		return EmailTemplate{}, fmt.Errorf("failed to execute markdown email template %s: %w", resolvedName, err)
+	}
+
+	var content bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(markdown.String()), &content); err != nil {
+		return EmailTemplate{}, fmt.Errorf("failed to convert markdown email template %s: %w", resolvedName, err)
+	}
+
+	var subject strings.Builder
+	if subjectTemplate, ok := r.lookupSubject(resolvedName); ok {
+		if err := subjectTemplate.Execute(&subject, data); err != nil {
+			return EmailTemplate{}, fmt.Errorf("failed to execute subject for email template %s: %w", resolvedName, err)
+		}
+	}
+
+	r.mu.RLock()
+	layout := r.layout
+	r.mu.RUnlock()
+
+	var body strings.Builder
+	err := layout.Execute(&body, EmailLayoutData{
+		Subject: subject.String(),
+		AppName: config.AppName,
+		Content: template.HTML(content.String()),
+		Footer:  template.HTML(config.footerHTML()),
+	})
+	if err != nil {
+		return EmailTemplate{}, fmt.Errorf("failed to execute email layout for %s: %w", resolvedName, err)
+	}
+
+	return EmailTemplate{
+		Subject: subject.String(),
+		Body:    body.String(),
+		Text:    markdownToText(markdown.String()) + "\n\n" + footerText(config),
+	}, nil
+}
+
+var (
+	markdownLink     = regexp.MustCompile(`!?\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	markdownHeading  = regexp.MustCompile(`(?m)^#{1,6}\s+`)
+	markdownEmphasis = regexp.MustCompile(`(\*\*|__|\*|_)(\S(?:.*?\S)?)(\*\*|__|\*|_)`)
+	markdownEscape   = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!|<>])")
+)
+
+// markdownToText turns rendered Markdown into readable plain text
+// Links become "text (url)" and heading and emphasis markers are dropped.
+func markdownToText(markdown string) string {
+	text := markdownLink.ReplaceAllStringFunc(markdown, func(link string) string {
+		parts := markdownLink.FindStringSubmatch(link)
+		if parts[1] == "" || parts[1] == parts[2] {
+			return parts[2]
+		}
+		return parts[1] + " (" + parts[2] + ")"
+	})
+	text = markdownHeading.ReplaceAllString(text, "")
+	text = markdownEmphasis.ReplaceAllString(text, "$2")
+	text = markdownEscape.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(text)
+}
+
+// footerText returns the plain-text version of EmailConfig's sign-off
+func footerText(config EmailConfig) string {
+	footer := "Best regards,\n" + config.AppName + " Team"
+	if config.SupportAddress != "" {
+		footer += "\n\nQuestions? Contact us at " + config.SupportAddress + "."
+	}
+	return footer
+}
+
+// SendMarkdownEmail renders a registered Markdown template and queues it with HTML and text bodies
+// data is merged with the branding fields from EmailConfig
+func (s *EmailService) SendMarkdownEmail(toEmail, fromEmail, templateName, locale string, emailType EmailType, data map[string]string) error {
+	config := s.Config()
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	rendered, err := s.templates.RenderMarkdown(templateName, locale, config, config.templateData(data))
+	if err != nil {
+		log.Printf("Failed to render markdown email %s: %v", templateName, err)
+		return err
+	}
+
+	err = s.Queue(EmailMessage{
+		From:     fromEmail,
+		To:       []string{toEmail},
+		Subject:  rendered.Subject,
+		HTMLBody: rendered.Body,
+		TextBody: rendered.Text,
+		Type:     emailType,
+	})
+	if err != nil {
+		log.Printf("Failed to send %s email to %s: %v", templateName, RedactedEmail(toEmail), err)
+		return fmt.Errorf("failed to send %s email: %w", templateName, err)
+	}
+	return nil
+}
+
+// SendMarkdownEmail renders a registered Markdown template and queues it using the default service
+func SendMarkdownEmail(toEmail, fromEmail, templateName, locale string, emailType EmailType, data map[string]string) error {
+	return defaultEmailService.SendMarkdownEmail(toEmail, fromEmail, templateName, locale, emailType, data)
+}
+
+// RegisterMarkdownEmailTemplates parses Markdown templates from fsys into the default registry
+func RegisterMarkdownEmailTemplates(fsys fs.FS, patterns ...string) error {
+	return defaultEmailTemplates.ParseMarkdownFS(fsys, patterns...)
+}
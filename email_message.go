@@ -0,0 +1,292 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// ErrEmailSuppressed is returned when every recipient of a message is on the suppression list
+var ErrEmailSuppressed = errors.New("all recipients are suppressed")
+
+// Attachment represents a file attached to an outgoing email
+type Attachment struct {
+	Filename    string // Name shown to the recipient, e.g. "receipt.pdf"
+	ContentType string // MIME type, e.g. "application/pdf"; detected from the filename if empty
+	Data        []byte // Raw file contents
+}
+
+// EmailMessage represents a general outgoing email
+type EmailMessage struct {
+	From        string
+	To          []string
+	ReplyTo     []string
+	Subject     string
+	HTMLBody    string
+	TextBody    string
+	Attachments []Attachment
+
+	// Transactional messages (verification, password reset) are still sent to unsubscribed addresses
+	Transactional bool
+
+	// Type labels the message in delivery metrics and is sent to SES as the email_type tag
+	Type EmailType
+
+	// ConfigurationSet overrides EmailConfig.ConfigurationSet for this message
+	ConfigurationSet string
+
+	// Tags are added to the SES message tags for this message
+	Tags map[string]string
+
+	// IdempotencyKey identifies the logical email, e.g. a reset record ID; when idempotency is
+	// enabled, a second message with the same key inside the window is not sent
+	IdempotencyKey string
+}
+
+// SendEmailMessage sends a message using the default email service
+func SendEmailMessage(msg EmailMessage) error {
+	return defaultEmailService.Send(msg)
+}
+
+// Send sends a message using SES v2, switching to a raw MIME message when it has attachments
+// The outcome is reported to the service's metrics hook
+func (s *EmailService) Send(msg EmailMessage) error {
+	ctx := context.TODO()
+	if err := s.reserveIdempotencyKey(ctx, msg); err != nil {
+		log.Printf("Skipping duplicate %s email to %v", msg.Type, RedactedEmails(msg.To))
+		s.logEmail(ctx, msg.To, msg.Type, msg.Subject, "", EmailLogDuplicate, nil)
+		return err
+	}
+
+	messageID, err := s.send(msg)
+
+	_, _, _, metrics, _ := s.state()
+	switch {
+	case err == nil:
+		metrics.EmailSent(msg.Type)
+		status := EmailLogSent
+		if s.DryRun() {
+			status = EmailLogDryRun
+		}
+		s.logEmail(ctx, msg.To, msg.Type, msg.Subject, messageID, status, nil)
+	case errors.Is(err, ErrEmailSuppressed):
+		metrics.EmailSuppressed(msg.Type)
+		s.logEmail(ctx, msg.To, msg.Type, msg.Subject, "", EmailLogSuppressed, nil)
+	default:
+		s.releaseIdempotencyKey(ctx, msg)
+		metrics.EmailFailed(msg.Type, err)
+		s.logEmail(ctx, msg.To, msg.Type, msg.Subject, "", EmailLogFailed, err)
+	}
+	return err
+}
+
+// permanentEmailError reports whether a send error should not be retried
+func permanentEmailError(err error) bool {
+	return errors.Is(err, ErrEmailSuppressed) || errors.Is(err, ErrEmailDuplicate)
+}
+
+// send performs a single delivery attempt and returns the SES message ID
+func (s *EmailService) send(msg EmailMessage) (string, error) {
+	client, config, _, _, suppressions := s.state()
+	msg.From = config.sender(msg.From)
+	if len(msg.ReplyTo) == 0 && config.ReplyTo != "" {
+		msg.ReplyTo = []string{config.ReplyTo}
+	}
+
+	if len(msg.To) == 0 {
+		return "", fmt.Errorf("email message has no recipients")
+	}
+
+	// Dry-run mode logs and records email instead of sending it
+	if s.DryRun() {
+		s.recordDryRun(msg)
+		return "", nil
+	}
+
+	if client == nil {
+		return "", fmt.Errorf("SES client not initialized")
+	}
+
+	if msg.From == "" {
+		return "", fmt.Errorf("email message has no sender")
+	}
+
+	msg.To = filterSuppressedRecipients(context.TODO(), suppressions, msg.To, msg.Transactional)
+	if len(msg.To) == 0 {
+		return "", ErrEmailSuppressed
+	}
+
+	input := &sesv2.SendEmailInput{
+		Destination: &types.Destination{
+			ToAddresses: msg.To,
+		},
+		FromEmailAddress:     aws.String(msg.From),
+		ReplyToAddresses:     msg.ReplyTo,
+		ConfigurationSetName: config.configurationSetFor(msg.Type, msg.ConfigurationSet),
+		EmailTags:            config.messageTags(msg.Type, msg.Tags),
+	}
+
+	if len(msg.Attachments) > 0 {
+		raw, err := BuildRawEmail(msg)
+		if err != nil {
+			return "", err
+		}
+		input.Content = &types.EmailContent{Raw: &types.RawMessage{Data: raw}}
+	} else {
+		body := &types.Body{}
+		if msg.HTMLBody != "" {
+			body.Html = &types.Content{
+				Data:    aws.String(msg.HTMLBody),
+				Charset: aws.String("UTF-8"),
+			}
+		}
+		if msg.TextBody != "" {
+			body.Text = &types.Content{
+				Data:    aws.String(msg.TextBody),
+				Charset: aws.String("UTF-8"),
+			}
+		}
+
+		input.Content = &types.EmailContent{
+			Simple: &types.Message{
+				Subject: &types.Content{
+					Data:    aws.String(msg.Subject),
+					Charset: aws.String("UTF-8"),
+				},
+				Body: body,
+			},
+		}
+	}
+
+	output, err := client.SendEmail(context.TODO(), input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.MessageId), nil
+}
+
+// BuildRawEmail builds a multipart MIME message containing the text/HTML bodies and attachments
+func BuildRawEmail(msg EmailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+
+	mixed := multipart.NewWriter(&buf)
+
+	// Top-level headers
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	if len(msg.ReplyTo) > 0 {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", strings.Join(msg.ReplyTo, ", "))
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mixed.Boundary())
+
+	// Bodies go into a nested multipart/alternative part
+	var alternativeBuf bytes.Buffer
+	alternative := multipart.NewWriter(&alternativeBuf)
+
+	if msg.TextBody != "" {
+		if err := writeQuotedPart(alternative, "text/plain; charset=UTF-8", msg.TextBody); err != nil {
+			return nil, err
+		}
+	}
+	if msg.HTMLBody != "" {
+		if err := writeQuotedPart(alternative, "text/html; charset=UTF-8", msg.HTMLBody); err != nil {
+			return nil, err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build email body: %w", err)
+	}
+
+	bodyPart, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%q", alternative.Boundary())},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build email body: %w", err)
+	}
+	if _, err := bodyPart.Write(alternativeBuf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to build email body: %w", err)
+	}
+
+	for _, attachment := range msg.Attachments {
+		if err := writeAttachmentPart(mixed, attachment); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build email: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPart writes a quoted-printable text part
+func writeQuotedPart(w *multipart.Writer, contentType, content string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build email body: %w", err)
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return fmt.Errorf("failed to build email body: %w", err)
+	}
+	return qp.Close()
+}
+
+// writeAttachmentPart writes a base64-encoded attachment part wrapped at 76 characters
+func writeAttachmentPart(w *multipart.Writer, attachment Attachment) error {
+	if attachment.Filename == "" {
+		return fmt.Errorf("attachment filename is required")
+	}
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(attachment.Filename))
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	params["name"] = attachment.Filename
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(mediaType, params)},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach %s: %w", attachment.Filename, err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		if _, err := fmt.Fprintf(part, "%s\r\n", encoded[:76]); err != nil {
+			return fmt.Errorf("failed to attach %s: %w", attachment.Filename, err)
+		}
+		encoded = encoded[76:]
+	}
+	if _, err := fmt.Fprintf(part, "%s\r\n", encoded); err != nil {
+		return fmt.Errorf("failed to attach %s: %w", attachment.Filename, err)
+	}
+
+	return nil
+}
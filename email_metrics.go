@@ -0,0 +1,131 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// EmailType identifies the kind of email for metrics and logging
+type EmailType string
+
+const (
+	EmailTypeVerification      EmailType = "verification"
+	EmailTypeWelcome           EmailType = "welcome"
+	EmailTypePasswordReset     EmailType = "password_reset"
+	EmailTypePasswordChanged   EmailType = "password_changed"
+	EmailTypeAccountUnlock     EmailType = "account_unlock"
+	EmailTypeAccountLocked     EmailType = "account_locked"
+	EmailTypeEmailChange       EmailType = "email_change"        // Confirmation sent to the new address
+	EmailTypeEmailChangeNotice EmailType = "email_change_notice" // Notice sent to the old address
+	EmailTypeOther             EmailType = "other"
+)
+
+// EmailMetrics receives email delivery events, e.g. to update Prometheus counters
+// Implementations must be safe for concurrent use
+type EmailMetrics interface {
+	EmailQueued(emailType EmailType)
+	EmailSent(emailType EmailType)
+	EmailFailed(emailType EmailType, err error)
+	EmailSuppressed(emailType EmailType)
+	EmailDeadLettered(emailType EmailType)
+}
+
+// EmailCounts holds delivery counters for one email type
+type EmailCounts struct {
+	Queued       int64 `json:"queued"`
+	Sent         int64 `json:"sent"`
+	Failed       int64 `json:"failed"` // Failed attempts, including ones that are retried
+	Suppressed   int64 `json:"suppressed"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+// emailCounters holds the atomic counters behind EmailCounts
+type emailCounters struct {
+	queued, sent, failed, suppressed, deadLettered atomic.Int64
+}
+
+// EmailCounters is an in-memory EmailMetrics implementation and the default
+type EmailCounters struct {
+	counters sync.Map // EmailType -> *emailCounters
+}
+
+// NewEmailCounters creates an empty set of counters
+func NewEmailCounters() *EmailCounters {
+	return &EmailCounters{}
+}
+
+func (c *EmailCounters) get(emailType EmailType) *emailCounters {
+	if emailType == "" {
+		emailType = EmailTypeOther
+	}
+	counters, _ := c.counters.LoadOrStore(emailType, &emailCounters{})
+	return counters.(*emailCounters)
+}
+
+func (c *EmailCounters) EmailQueued(emailType EmailType)          { c.get(emailType).queued.Add(1) }
+func (c *EmailCounters) EmailSent(emailType EmailType)            { c.get(emailType).sent.Add(1) }
+func (c *EmailCounters) EmailFailed(emailType EmailType, _ error) { c.get(emailType).failed.Add(1) }
+func (c *EmailCounters) EmailSuppressed(emailType EmailType)      { c.get(emailType).suppressed.Add(1) }
+func (c *EmailCounters) EmailDeadLettered(emailType EmailType)    { c.get(emailType).deadLettered.Add(1) }
+
+// Snapshot returns the current counts keyed by email type
+func (c *EmailCounters) Snapshot() map[EmailType]EmailCounts {
+	snapshot := map[EmailType]EmailCounts{}
+	c.counters.Range(func(key, value any) bool {
+		counters := value.(*emailCounters)
+		snapshot[key.(EmailType)] = EmailCounts{
+			Queued:       counters.queued.Load(),
+			Sent:         counters.sent.Load(),
+			Failed:       counters.failed.Load(),
+			Suppressed:   counters.suppressed.Load(),
+			DeadLettered: counters.deadLettered.Load(),
+		}
+		return true
+	})
+	return snapshot
+}
+
+// ServeHTTP writes the counters in the Prometheus text exposition format
+func (c *EmailCounters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := c.Snapshot()
+	types := make([]string, 0, len(snapshot))
+	for emailType := range snapshot {
+		types = append(types, string(emailType))
+	}
+	sort.Strings(types)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP email_messages_total Email delivery events by type and outcome.")
+	fmt.Fprintln(w, "# TYPE email_messages_total counter")
+	for _, emailType := range types {
+		counts := snapshot[EmailType(emailType)]
+		for _, outcome := range []struct {
+			name  string
+			value int64
+		}{
+			{"queued", counts.Queued},
+			{"sent", counts.Sent},
+			{"failed", counts.Failed},
+			{"suppressed", counts.Suppressed},
+			{"dead_lettered", counts.DeadLettered},
+		} {
+			fmt.Fprintf(w, "email_messages_total{type=%q,outcome=%q} %d\n", emailType, outcome.name, outcome.value)
+		}
+	}
+}
+
+var defaultEmailCounters = NewEmailCounters()
+
+// DefaultEmailCounters returns the in-memory counters used unless SetEmailMetrics is called
+// It can be mounted directly as a Prometheus scrape endpoint
+func DefaultEmailCounters() *EmailCounters {
+	return defaultEmailCounters
+}
+
+// SetEmailMetrics replaces the default email service's metrics hook; pass nil to restore the default counters
+func SetEmailMetrics(metrics EmailMetrics) {
+	defaultEmailService.SetMetrics(metrics)
+}
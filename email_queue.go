@@ -0,0 +1,262 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEmailQueueFull    = errors.New("email queue is full")
+	ErrEmailQueueStopped = errors.New("email queue is stopped")
+)
+
+// EmailQueuer accepts messages for asynchronous delivery
+type EmailQueuer interface {
+	Enqueue(msg EmailMessage) error
+	Stop(ctx context.Context) error
+}
+
+// EmailJob is a queued message together with its delivery state
+type EmailJob struct {
+	ID         string       `json:"id"`
+	Message    EmailMessage `json:"message"`
+	Attempts   int          `json:"attempts"`
+	EnqueuedAt time.Time    `json:"enqueued_at"`
+	LastError  string       `json:"last_error,omitempty"`
+}
+
+// EmailQueueConfig holds worker pool and retry settings for email queues
+type EmailQueueConfig struct {
+	Workers        int           // Number of concurrent senders
+	QueueSize      int           // Buffered jobs before Enqueue returns ErrEmailQueueFull
+	MaxAttempts    int           // Attempts before a job is dead-lettered
+	InitialBackoff time.Duration // Delay before the first retry
+	MaxBackoff     time.Duration // Upper bound for retry delays
+
+	// DeadLetter is called with jobs that failed every attempt; defaults to logging them
+	DeadLetter func(job EmailJob, err error)
+}
+
+// DefaultEmailQueueConfig returns sensible defaults for the email queue
+func DefaultEmailQueueConfig() *EmailQueueConfig {
+	return &EmailQueueConfig{
+		Workers:        4,
+		QueueSize:      1000,
+		MaxAttempts:    5,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+	}
+}
+
+// backoff returns the delay before the given retry attempt, with jitter
+func (c *EmailQueueConfig) backoff(attempt int) time.Duration {
+	delay := c.InitialBackoff << (attempt - 1)
+	if delay <= 0 || delay > c.MaxBackoff {
+		delay = c.MaxBackoff
+	}
+	// Up to 20% jitter so throttled retries don't arrive in lockstep
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - jitter
+}
+
+// deadLetter hands a failed job to the configured dead-letter handler
+func (c *EmailQueueConfig) deadLetter(job EmailJob, err error) {
+	currentEmailMetrics().EmailDeadLettered(job.Message.Type)
+	if c.DeadLetter != nil {
+		c.DeadLetter(job, err)
+		return
+	}
+	log.Printf("Email job %s to %v dead-lettered after %d attempts: %v", job.ID, RedactedEmails(job.Message.To), job.Attempts, err)
+}
+
+// EmailQueue delivers messages from an in-memory buffer using a worker pool with retries
+type EmailQueue struct {
+	config *EmailQueueConfig
+	send   func(EmailMessage) error
+	jobs   chan EmailJob
+
+	mu       sync.RWMutex
+	stopped  bool
+	stopCh   chan struct{}
+	workers  sync.WaitGroup
+	retrying sync.WaitGroup
+}
+
+// NewEmailQueue creates an in-memory email queue that delivers with send
+// If config is nil, the default configuration is used; if send is nil, SendEmailMessage is used
+func NewEmailQueue(config *EmailQueueConfig, send func(EmailMessage) error) *EmailQueue {
+	if config == nil {
+		config = DefaultEmailQueueConfig()
+	}
+	if send == nil {
+		send = SendEmailMessage
+	}
+
+	return &EmailQueue{
+		config: config,
+		send:   send,
+		jobs:   make(chan EmailJob, config.QueueSize),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start launches the worker pool
+func (q *EmailQueue) Start() {
+	for i := 0; i < q.config.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+}
+
+// Enqueue adds a message to the queue without blocking
+func (q *EmailQueue) Enqueue(msg EmailMessage) error {
+	id, err := NewID()
+	if err != nil {
+		return err
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.stopped {
+		return ErrEmailQueueStopped
+	}
+
+	select {
+	case q.jobs <- EmailJob{ID: id, Message: msg, EnqueuedAt: time.Now()}:
+		return nil
+	default:
+		return ErrEmailQueueFull
+	}
+}
+
+// Stop stops accepting messages and waits for queued jobs to drain or ctx to expire
+// Jobs waiting for a retry when Stop is called are dead-lettered
+func (q *EmailQueue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return nil
+	}
+	q.stopped = true
+	close(q.stopCh)
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.retrying.Wait()
+		close(q.jobs)
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work delivers jobs until the queue is closed
+func (q *EmailQueue) work() {
+	defer q.workers.Done()
+
+	for job := range q.jobs {
+		job.Attempts++
+		err := q.send(job.Message)
+		if err == nil || permanentEmailError(err) {
+			continue
+		}
+
+		job.LastError = err.Error()
+		if job.Attempts >= q.config.MaxAttempts {
+			q.config.deadLetter(job, err)
+			continue
+		}
+
+		q.scheduleRetry(job, err)
+	}
+}
+
+// scheduleRetry re-queues a failed job after its backoff delay
+func (q *EmailQueue) scheduleRetry(job EmailJob, err error) {
+	// Register the retry under the lock so Stop never closes the channel underneath it
+	q.mu.RLock()
+	if q.stopped {
+		q.mu.RUnlock()
+		q.config.deadLetter(job, fmt.Errorf("queue stopped before retry: %w", err))
+		return
+	}
+	q.retrying.Add(1)
+	q.mu.RUnlock()
+
+	go func() {
+		defer q.retrying.Done()
+
+		timer := time.NewTimer(q.config.backoff(job.Attempts))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-q.stopCh:
+			q.config.deadLetter(job, fmt.Errorf("queue stopped before retry: %w", err))
+			return
+		}
+
+		select {
+		case q.jobs <- job:
+		case <-q.stopCh:
+			q.config.deadLetter(job, fmt.Errorf("queue stopped before retry: %w", err))
+		}
+	}()
+}
+
+// SetEmailQueue routes the package's Send* functions through the given queue
+// Pass nil to go back to sending synchronously
+func SetEmailQueue(queue EmailQueuer) {
+	defaultEmailService.SetQueue(queue)
+}
+
+// StartEmailQueue creates and starts an in-memory email queue and routes the Send* functions through it
+func StartEmailQueue(config *EmailQueueConfig) *EmailQueue {
+	return defaultEmailService.StartQueue(config)
+}
+
+// StartQueue creates and starts an in-memory email queue and routes the service's Queue calls through it
+func (s *EmailService) StartQueue(config *EmailQueueConfig) *EmailQueue {
+	queue := NewEmailQueue(config, s.Send)
+	queue.Start()
+	s.SetQueue(queue)
+	return queue
+}
+
+// QueueEmail queues a message for asynchronous delivery using the default email service
+func QueueEmail(msg EmailMessage) error {
+	return defaultEmailService.Queue(msg)
+}
+
+// Queue queues a message for asynchronous delivery, or sends it immediately if no queue is configured
+// Messages over a recipient rate limit are refused with an *EmailRateLimitError
+func (s *EmailService) Queue(msg EmailMessage) error {
+	_, _, queue, metrics, _ := s.state()
+	if err := s.checkRecipientRateLimit(context.TODO(), msg); err != nil {
+		return err
+	}
+
+	if queue == nil {
+		return s.Send(msg)
+	}
+
+	if err := queue.Enqueue(msg); err != nil {
+		metrics.EmailFailed(msg.Type, err)
+		return err
+	}
+	metrics.EmailQueued(msg.Type)
+	return nil
+}
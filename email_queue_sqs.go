@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxSQSVisibilityTimeout is the longest visibility timeout SQS accepts (12 hours)
+const maxSQSVisibilityTimeout = 12 * time.Hour
+
+// SQSEmailQueue delivers messages through an SQS queue so queued email survives restarts
+// and is shared across instances. Retries use the message visibility timeout for backoff.
+// Messages (including attachments) must fit within the SQS 256 KB message size limit.
+type SQSEmailQueue struct {
+	client   *sqs.Client
+	queueURL string
+	config   *EmailQueueConfig
+	send     func(EmailMessage) error
+
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// NewSQSEmailQueue creates an SQS-backed email queue that delivers with send
+// If config is nil, the default configuration is used; if send is nil, SendEmailMessage is used
+func NewSQSEmailQueue(client *sqs.Client, queueURL string, config *EmailQueueConfig, send func(EmailMessage) error) *SQSEmailQueue {
+	if config == nil {
+		config = DefaultEmailQueueConfig()
+	}
+	if send == nil {
+		send = SendEmailMessage
+	}
+
+	return &SQSEmailQueue{
+		client:   client,
+		queueURL: queueURL,
+		config:   config,
+		send:     send,
+	}
+}
+
+// Start launches the pollers that receive and deliver queued messages
+func (q *SQSEmailQueue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	for i := 0; i < q.config.Workers; i++ {
+		q.workers.Add(1)
+		go q.poll(ctx)
+	}
+}
+
+// Enqueue publishes a message to the SQS queue
+func (q *SQSEmailQueue) Enqueue(msg EmailMessage) error {
+	id, err := NewID()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(EmailJob{ID: id, Message: msg, EnqueuedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode email job: %w", err)
+	}
+
+	_, err = q.client.SendMessage(context.TODO(), &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue email: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the pollers and waits for in-flight deliveries to finish or ctx to expire
+// Undelivered messages stay in SQS and are picked up by the next consumer
+func (q *SQSEmailQueue) Stop(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poll receives messages until ctx is cancelled
+func (q *SQSEmailQueue) poll(ctx context.Context) {
+	defer q.workers.Done()
+
+	for ctx.Err() == nil {
+		output, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(q.queueURL),
+			MaxNumberOfMessages:         10,
+			WaitTimeSeconds:             20,
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+		})
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("Failed to receive queued emails: %v", err)
+			time.Sleep(q.config.InitialBackoff)
+			continue
+		}
+
+		for _, message := range output.Messages {
+			// Deliveries use a fresh context so shutdown doesn't abort a send halfway
+			q.handle(context.Background(), message)
+		}
+	}
+}
+
+// handle delivers one SQS message, deleting it on success or dead-lettering, and otherwise
+// delaying its next delivery by the retry backoff
+func (q *SQSEmailQueue) handle(ctx context.Context, message types.Message) {
+	var job EmailJob
+	if err := json.Unmarshal([]byte(aws.ToString(message.Body)), &job); err != nil {
+		log.Printf("Discarding malformed email job %s: %v", aws.ToString(message.MessageId), err)
+		q.delete(ctx, message)
+		return
+	}
+
+	job.Attempts, _ = strconv.Atoi(message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if job.Attempts == 0 {
+		job.Attempts = 1
+	}
+
+	err := q.send(job.Message)
+	if err == nil || permanentEmailError(err) {
+		q.delete(ctx, message)
+		return
+	}
+
+	job.LastError = err.Error()
+	if job.Attempts >= q.config.MaxAttempts {
+		q.config.deadLetter(job, err)
+		q.delete(ctx, message)
+		return
+	}
+
+	delay := q.config.backoff(job.Attempts)
+	if delay > maxSQSVisibilityTimeout {
+		delay = maxSQSVisibilityTimeout
+	}
+
+	_, err = q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.queueURL),
+		ReceiptHandle:     message.ReceiptHandle,
+		VisibilityTimeout: int32(delay / time.Second),
+	})
+	if err != nil {
+		log.Printf("Failed to delay retry of email job %s: %v", job.ID, err)
+	}
+}
+
+// delete removes a processed message from the queue
+func (q *SQSEmailQueue) delete(ctx context.Context, message types.Message) {
+	_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueURL),
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		log.Printf("Failed to delete queued email %s: %v", aws.ToString(message.MessageId), err)
+	}
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrEmailRateLimited is matched by errors.Is for every *EmailRateLimitError
+var ErrEmailRateLimited = errors.New("too many emails sent to this address")
+
+// emailRateLimitWindow is the period recipient limits are counted over
+const emailRateLimitWindow = time.Hour
+
+// EmailRateLimitError is returned when a recipient has reached the hourly limit for an email type
+type EmailRateLimitError struct {
+	Email      string
+	Type       EmailType
+	RetryAfter time.Duration // Time until the current window ends
+}
+
+func (e *EmailRateLimitError) Error() string {
+	return fmt.Sprintf("too many %s emails sent to %s, retry after %s", e.Type, e.Email, e.RetryAfter.Round(time.Second))
+}
+
+func (e *EmailRateLimitError) Unwrap() error {
+	return ErrEmailRateLimited
+}
+
+// DefaultEmailRateLimits returns hourly per-recipient limits for the emails an attacker can trigger
+func DefaultEmailRateLimits() map[EmailType]int {
+	return map[EmailType]int{
+		EmailTypeVerification:  5,
+		EmailTypePasswordReset: 5,
+		EmailTypeAccountUnlock: 5,
+	}
+}
+
+// EnableRecipientRateLimit makes Queue refuse messages once a recipient has received limits[type]
+// emails of that type in the current hour, counting in the database's email_rate_limits collection
+// Types without a limit are not counted. Pass nil limits to use DefaultEmailRateLimits.
+func (s *EmailService) EnableRecipientRateLimit(ctx context.Context, database *mongo.Database, limits map[EmailType]int) error {
+	if limits == nil {
+		limits = DefaultEmailRateLimits()
+	}
+
+	collection := database.Collection("email_rate_limits")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimits = collection
+	s.recipientLimits = limits
+	return nil
+}
+
+// EnableEmailRecipientRateLimit enables per-recipient rate limits on the default email service
+func EnableEmailRecipientRateLimit(ctx context.Context, database *mongo.Database, limits map[EmailType]int) error {
+	return defaultEmailService.EnableRecipientRateLimit(ctx, database, limits)
+}
+
+// checkRecipientRateLimit counts msg against each recipient's hourly limit for its type
+// Database failures are logged and the message is allowed, so an outage doesn't block sign-ups.
+func (s *EmailService) checkRecipientRateLimit(ctx context.Context, msg EmailMessage) error {
+	s.mu.RLock()
+	collection, limit := s.rateLimits, s.recipientLimits[msg.Type]
+	s.mu.RUnlock()
+	if collection == nil || limit <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(emailRateLimitWindow)
+	windowEnd := windowStart.Add(emailRateLimitWindow)
+
+	for _, recipient := range msg.To {
+		email := normalizeEmail(recipient)
+		id := string(msg.Type) + ":" + email + ":" + strconv.FormatInt(windowStart.Unix(), 10)
+
+		var counter struct {
+			Count int `bson:"count"`
+		}
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"_id": id},
+			bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expires_at": windowEnd}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&counter)
+		if err != nil {
+			log.Printf("Failed to check email rate limit for %s, allowing: %v", RedactedEmail(email), err)
+			continue
+		}
+
+		if counter.Count > limit {
+			log.Printf("SECURITY: %s email to %s rate limited after %d sends this hour", msg.Type, RedactedEmail(email), limit)
+			return &EmailRateLimitError{Email: email, Type: msg.Type, RetryAfter: time.Until(windowEnd)}
+		}
+	}
+	return nil
+}
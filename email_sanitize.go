@@ -0,0 +1,140 @@
+package common
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// richTextTags are the elements kept by SanitizeRichText, with the attributes allowed on each
+var richTextTags = map[atom.Atom][]string{
+	atom.A:          {"href", "title"},
+	atom.B:          nil,
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Code:       nil,
+	atom.Em:         nil,
+	atom.I:          nil,
+	atom.Li:         nil,
+	atom.Ol:         nil,
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.Strong:     nil,
+	atom.U:          nil,
+	atom.Ul:         nil,
+}
+
+// richTextDropContent are elements removed together with everything inside them
+var richTextDropContent = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true, // Foreign content: drawing code and styles, not prose
+	atom.Math:     true,
+}
+
+// SanitizeRichText keeps a small allowlist of formatting tags from user-supplied HTML, e.g. a
+// personal note in an invite, and escapes everything else so it can be placed in an email body
+// Links are limited to http, https and mailto URLs.
+func SanitizeRichText(input string) template.HTML {
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	skipDepth := 0
+	var open []atom.Atom
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if richTextDropContent[token.DataAtom] {
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			allowed, ok := richTextTags[token.DataAtom]
+			if !ok {
+				continue
+			}
+			writeRichTextTag(&out, token, allowed)
+			if tokenType == html.StartTagToken && token.DataAtom != atom.Br {
+				open = append(open, token.DataAtom)
+			}
+		case html.EndTagToken:
+			if richTextDropContent[token.DataAtom] {
+				if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			// Only close tags that are open, so stray end tags can't break the surrounding layout
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == token.DataAtom {
+					for j := len(open) - 1; j >= i; j-- {
+						out.WriteString("</" + open[j].String() + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				out.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i].String() + ">")
+	}
+	return template.HTML(out.String())
+}
+
+// writeRichTextTag writes a start tag with only its allowed, safe attributes
+func writeRichTextTag(out *strings.Builder, token html.Token, allowed []string) {
+	out.WriteString("<" + token.DataAtom.String())
+	for _, attr := range token.Attr {
+		if attr.Namespace != "" || !containsString(allowed, attr.Key) {
+			continue
+		}
+		if attr.Key == "href" && !safeRichTextURL(attr.Val) {
+			continue
+		}
+		out.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	if token.DataAtom == atom.A {
+		out.WriteString(` rel="noopener noreferrer"`)
+	}
+	out.WriteString(">")
+}
+
+// safeRichTextURL reports whether a link target uses an allowed scheme
+func safeRichTextURL(value string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,69 @@
+package common
+
+import "testing"
+
+func TestSanitizeRichText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"allowed formatting", "<p>Hi <b>there</b>, <em>welcome</em></p>", "<p>Hi <b>there</b>, <em>welcome</em></p>"},
+		{"plain text is escaped", `1 < 2 & "3"`, "1 &lt; 2 &amp; &#34;3&#34;"},
+
+		// Scripts
+		{"script", `hi<script>alert(1)</script>there`, "hithere"},
+		{"uppercase script", `<SCRIPT>alert(1)</SCRIPT>ok`, "ok"},
+		{"self-closing script", `<script/>alert(1)`, "alert(1)"},
+		{"unclosed script", `ok<script>alert(1)`, "ok"},
+		{"script text with a fake end tag", `<script>"</scr" + "ipt>"</script>ok`, "ok"},
+
+		// Attributes
+		{"event handler", `<b onclick="alert(1)">x</b>`, "<b>x</b>"},
+		{"event handler on a link", `<a href="https://example.com" onmouseover="alert(1)">x</a>`, `<a href="https://example.com" rel="noopener noreferrer">x</a>`},
+		{"event handler on a dropped tag", `<img src=x onerror="alert(1)">`, ""},
+		{"style attribute", `<p style="background:url(javascript:alert(1))">x</p>`, "<p>x</p>"},
+		{"attribute value quoting", `<a href="https://example.com/?q=&quot;><script>" title='"x"'>y</a>`, `<a href="https://example.com/?q=&#34;&gt;&lt;script&gt;" title="&#34;x&#34;" rel="noopener noreferrer">y</a>`},
+
+		// Links
+		{"http link", `<a href="http://example.com">x</a>`, `<a href="http://example.com" rel="noopener noreferrer">x</a>`},
+		{"mailto link", `<a href="mailto:a@example.com">x</a>`, `<a href="mailto:a@example.com" rel="noopener noreferrer">x</a>`},
+		{"javascript href", `<a href="javascript:alert(1)">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+		{"uppercase javascript href", `<a HREF="JavaScript:alert(1)">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+		{"entity-encoded javascript href", `<a href="javascript&colon;alert(1)">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+		{"javascript href with a tab", "<a href=\"java\tscript:alert(1)\">x</a>", `<a rel="noopener noreferrer">x</a>`},
+		{"javascript href with leading space", `<a href="  javascript:alert(1)">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+		{"data href", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+		{"vbscript href", `<a href="vbscript:msgbox(1)">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+		{"protocol-relative href", `<a href="//evil.example.com">x</a>`, `<a rel="noopener noreferrer">x</a>`},
+
+		// Structure
+		{"unclosed tags", "<b><i>x", "<b><i>x</i></b>"},
+		{"mis-nested tags", "<b><i>x</b>y</i>", "<b><i>x</i></b>y"},
+		{"stray end tags", "</p></div>x</b>", "x"},
+		{"unknown tags keep their text", "<div><span>x</span></div>", "x"},
+		{"comment", "a<!-- <script>alert(1)</script> -->b", "ab"},
+
+		// Foreign content
+		{"svg", `<svg onload="alert(1)"><script>alert(1)</script><text>x</text></svg>ok`, "ok"},
+		{"svg link", `<svg><a href="javascript:alert(1)"><text>x</text></a></svg>ok`, "ok"},
+		{"math", `<math><mi xlink:href="javascript:alert(1)">x</mi></math>ok`, "ok"},
+		{"unclosed svg", `ok<svg><p>x`, "ok"},
+
+		// Raw text elements
+		{"title", `<title><img src=x onerror=alert(1)></title>`, "&lt;img src=x onerror=alert(1)&gt;"},
+		{"textarea", `<textarea></textarea><script>alert(1)</script></textarea>`, ""},
+		{"textarea content", `<textarea><b onclick=x>y</b></textarea>`, "&lt;b onclick=x&gt;y&lt;/b&gt;"},
+		{"noscript", `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>ok`, "&#34;&gt;ok"},
+		{"style", `<style>body{background:url(javascript:alert(1))}</style>ok`, "ok"},
+		{"xmp", `<xmp><script>alert(1)</script></xmp>`, "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"plaintext", `<plaintext><script>alert(1)</script>`, "&lt;script&gt;alert(1)&lt;/script&gt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(SanitizeRichText(tt.input)); got != tt.want {
+				t.Errorf("SanitizeRichText(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ScheduledEmailStatus tracks a scheduled email through delivery
+type ScheduledEmailStatus string
+
+const (
+	ScheduledEmailPending   ScheduledEmailStatus = "pending"
+	ScheduledEmailSending   ScheduledEmailStatus = "sending"
+	ScheduledEmailSent      ScheduledEmailStatus = "sent"
+	ScheduledEmailFailed    ScheduledEmailStatus = "failed"
+	ScheduledEmailCancelled ScheduledEmailStatus = "cancelled"
+)
+
+// ScheduledEmail represents an email waiting for future delivery in the database
+type ScheduledEmail struct {
+	ID        string               `json:"id" bson:"_id"`                          // Unique ID for the scheduled email
+	Message   EmailMessage         `json:"message" bson:"message"`                 // The message to send
+	SendAt    time.Time            `json:"send_at" bson:"send_at"`                 // When the message becomes due
+	Status    ScheduledEmailStatus `json:"status" bson:"status"`                   // pending, sending, sent, failed or cancelled
+	Attempts  int                  `json:"attempts" bson:"attempts"`               // Delivery attempts so far
+	LastError string               `json:"last_error,omitempty" bson:"last_error"` // Error from the last failed attempt
+	ClaimedAt *time.Time           `json:"claimed_at" bson:"claimed_at"`           // When a poller started sending it
+	SentAt    *time.Time           `json:"sent_at" bson:"sent_at"`                 // When it was handed to the email service
+	CreatedAt time.Time            `json:"created_at" bson:"created_at"`           // When it was scheduled
+	UpdatedAt time.Time            `json:"updated_at" bson:"updated_at"`           // When its status last changed
+}
+
+// ScheduleEmail stores a message for delivery at sendAt and returns its ID
+func ScheduleEmail(ctx context.Context, database *mongo.Database, msg EmailMessage, sendAt time.Time) (string, error) {
+	if len(msg.To) == 0 {
+		return "", fmt.Errorf("email message has no recipients")
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = database.Collection("scheduled_emails").InsertOne(ctx, ScheduledEmail{
+		ID:        id,
+		Message:   msg,
+		SendAt:    sendAt,
+		Status:    ScheduledEmailPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to schedule email: %w", err)
+	}
+	return id, nil
+}
+
+// CancelScheduledEmail cancels a scheduled email that has not been sent yet
+// It reports whether a pending email was cancelled
+func CancelScheduledEmail(ctx context.Context, database *mongo.Database, id string) (bool, error) {
+	result, err := database.Collection("scheduled_emails").UpdateOne(ctx,
+		bson.M{"_id": id, "status": ScheduledEmailPending},
+		bson.M{"$set": bson.M{"status": ScheduledEmailCancelled, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel scheduled email: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// EmailSchedulerConfig holds polling and retry settings for the email scheduler
+type EmailSchedulerConfig struct {
+	PollInterval time.Duration // How often to look for due emails
+	BatchSize    int           // Maximum emails sent per poll
+	MaxAttempts  int           // Attempts before an email is marked failed
+	RetryDelay   time.Duration // Delay before retrying a failed send
+	ClaimTimeout time.Duration // After this long, an email stuck in sending is retried
+}
+
+// DefaultEmailSchedulerConfig returns sensible defaults for the email scheduler
+func DefaultEmailSchedulerConfig() *EmailSchedulerConfig {
+	return &EmailSchedulerConfig{
+		PollInterval: 30 * time.Second,
+		BatchSize:    100,
+		MaxAttempts:  3,
+		RetryDelay:   5 * time.Minute,
+		ClaimTimeout: 10 * time.Minute,
+	}
+}
+
+// EmailScheduler polls for due scheduled emails and sends them
+// Several instances may poll the same collection; each email is claimed by exactly one
+type EmailScheduler struct {
+	collection *mongo.Collection
+	config     *EmailSchedulerConfig
+	send       func(EmailMessage) error
+
+	stop chan struct{}
+	done sync.WaitGroup
+	once sync.Once
+}
+
+// NewEmailScheduler creates a scheduler for the database's scheduled_emails collection
+// If config is nil, the default configuration is used. If send is nil, due emails go through QueueEmail.
+func NewEmailScheduler(database *mongo.Database, config *EmailSchedulerConfig, send func(EmailMessage) error) *EmailScheduler {
+	if config == nil {
+		config = DefaultEmailSchedulerConfig()
+	}
+	if send == nil {
+		send = QueueEmail
+	}
+	return &EmailScheduler{
+		collection: database.Collection("scheduled_emails"),
+		config:     config,
+		send:       send,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start launches the poller goroutine
+func (s *EmailScheduler) Start() {
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+
+		ticker := time.NewTicker(s.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			s.poll(context.Background())
+
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the poller and waits for the current poll to finish
+func (s *EmailScheduler) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	s.done.Wait()
+}
+
+// poll sends up to BatchSize due emails
+func (s *EmailScheduler) poll(ctx context.Context) {
+	for i := 0; i < s.config.BatchSize; i++ {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		email, err := s.claim(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to claim scheduled email: %v", err)
+			return
+		}
+
+		s.deliver(ctx, email)
+	}
+}
+
+// claim atomically marks the next due email as sending, reclaiming ones abandoned by a crashed poller
+func (s *EmailScheduler) claim(ctx context.Context) (*ScheduledEmail, error) {
+	now := time.Now()
+	filter := bson.M{
+		"send_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"status": ScheduledEmailPending},
+			bson.M{"status": ScheduledEmailSending, "claimed_at": bson.M{"$lt": now.Add(-s.config.ClaimTimeout)}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": ScheduledEmailSending, "claimed_at": now, "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"send_at": 1}).
+		SetReturnDocument(options.After)
+
+	var email ScheduledEmail
+	if err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&email); err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+// deliver sends a claimed email and records the outcome
+func (s *EmailScheduler) deliver(ctx context.Context, email *ScheduledEmail) {
+	now := time.Now()
+	err := s.send(email.Message)
+
+	var set bson.M
+	switch {
+	case err == nil:
+		set = bson.M{"status": ScheduledEmailSent, "sent_at": now, "last_error": ""}
+	case permanentEmailError(err) || email.Attempts >= s.config.MaxAttempts:
+		log.Printf("Scheduled email %s failed after %d attempts: %v", email.ID, email.Attempts, err)
+		set = bson.M{"status": ScheduledEmailFailed, "last_error": err.Error()}
+	default:
+		log.Printf("Scheduled email %s failed, retrying: %v", email.ID, err)
+		set = bson.M{
+			"status":     ScheduledEmailPending,
+			"send_at":    now.Add(s.config.RetryDelay),
+			"last_error": err.Error(),
+		}
+	}
+	set["updated_at"] = now
+
+	if _, err := s.collection.UpdateOne(ctx, bson.M{"_id": email.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to update scheduled email %s: %v", email.ID, err)
+	}
+}
+
+// CancelScheduledEmailHandler cancels the scheduled email in the {id} path parameter for admin tooling
+func CancelScheduledEmailHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	id := GetPathParam(r, "id")
+	if id == "" {
+		RespondWithValidationError(w, "id", "is required")
+		return
+	}
+
+	cancelled, err := CancelScheduledEmail(r.Context(), database, id)
+	if err != nil {
+		log.Printf("Failed to cancel scheduled email: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	if !cancelled {
+		RespondWithJSON(w, 404, map[string]string{"error": "Pending scheduled email not found"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]string{"message": "Scheduled email cancelled"})
+}
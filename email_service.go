@@ -3,53 +3,202 @@ package common
 import (
 	"context"
 	"fmt"
-	"html/template"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ses"
-	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var sesClient *ses.Client
+// SESClient is the subset of the SES v2 client used by EmailService, so tests can substitute a fake
+type SESClient interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+	SendBulkEmail(ctx context.Context, params *sesv2.SendBulkEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendBulkEmailOutput, error)
+}
+
+// EmailService sends the package's emails with its own SES client, branding and templates,
+// so several configurations can run in one process
+type EmailService struct {
+	templates *EmailTemplateRegistry
+	sink      *EmailSink
+
+	mu           sync.RWMutex
+	client       SESClient
+	config       EmailConfig
+	queue        EmailQueuer
+	metrics      EmailMetrics
+	suppressions *mongo.Collection
+	dryRun       bool
+
+	sends             *mongo.Collection
+	idempotencyWindow time.Duration
+	emailLog          *mongo.Collection
+	rateLimits        *mongo.Collection
+	recipientLimits   map[EmailType]int
+}
+
+// NewEmailService creates an email service
+// If templates is nil, an empty registry is used and templates are loaded from disk on first use
+func NewEmailService(client SESClient, config EmailConfig, templates *EmailTemplateRegistry) (*EmailService, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		templates = NewEmailTemplateRegistry()
+	}
+
+	return &EmailService{
+		templates: templates,
+		sink:      NewEmailSink(defaultEmailSinkLimit),
+		client:    client,
+		config:    config,
+		metrics:   defaultEmailCounters,
+	}, nil
+}
+
+// defaultEmailService backs the package-level email functions
+var defaultEmailService = &EmailService{
+	templates: defaultEmailTemplates,
+	sink:      defaultEmailSink,
+	config:    DefaultEmailConfig(),
+	metrics:   defaultEmailCounters,
+}
+
+// DefaultEmailService returns the service used by the package-level email functions
+func DefaultEmailService() *EmailService {
+	return defaultEmailService
+}
 
-// InitializeSES initializes the SES client
+// InitializeSES initializes the default email service's SES v2 client
 func InitializeSES() error {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		return fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	sesClient = ses.NewFromConfig(cfg)
+	defaultEmailService.SetClient(sesv2.NewFromConfig(cfg))
 	return nil
 }
 
+// SetClient replaces the SES client
+func (s *EmailService) SetClient(client SESClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client = client
+}
+
+// Config returns the service's branding and sender identity
+func (s *EmailService) Config() EmailConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// SetConfig replaces the service's branding and sender identity
+func (s *EmailService) SetConfig(config EmailConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	return nil
+}
+
+// Templates returns the service's template registry
+func (s *EmailService) Templates() *EmailTemplateRegistry {
+	return s.templates
+}
+
+// Sink returns the sink the service records to in dry-run mode
+func (s *EmailService) Sink() *EmailSink {
+	return s.sink
+}
+
+// SetQueue routes the service's Queue calls through queue; pass nil to send synchronously
+func (s *EmailService) SetQueue(queue EmailQueuer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = queue
+}
+
+// SetMetrics replaces the service's metrics hook; pass nil to restore the default counters
+func (s *EmailService) SetMetrics(metrics EmailMetrics) {
+	if metrics == nil {
+		metrics = defaultEmailCounters
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = metrics
+}
+
+// EnableSuppression makes the service skip recipients in the database's email_suppressions collection
+func (s *EmailService) EnableSuppression(database *mongo.Database) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.suppressions = database.Collection("email_suppressions")
+}
+
+// SetDryRun makes the service log and record email instead of sending it
+// The service is also in dry-run mode whenever EmailDryRun reports true
+func (s *EmailService) SetDryRun(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dryRun = enabled
+}
+
+// DryRun reports whether the service logs and records email instead of sending it
+func (s *EmailService) DryRun() bool {
+	s.mu.RLock()
+	dryRun := s.dryRun
+	s.mu.RUnlock()
+	return dryRun || EmailDryRun()
+}
+
+// state returns a consistent snapshot of the service's mutable fields
+func (s *EmailService) state() (SESClient, EmailConfig, EmailQueuer, EmailMetrics, *mongo.Collection) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.client, s.config, s.queue, s.metrics, s.suppressions
+}
+
+// currentEmailMetrics returns the default service's metrics hook
+func currentEmailMetrics() EmailMetrics {
+	_, _, _, metrics, _ := defaultEmailService.state()
+	return metrics
+}
+
 // EmailTemplate represents an email template
 type EmailTemplate struct {
 	Subject string
 	Body    string
+	Text    string // Plain-text body, set for Markdown templates
 }
 
-// GetVerificationEmailTemplate returns the email verification template
-func GetVerificationEmailTemplate(name, templateName, baseURL,verificationToken string) EmailTemplate {
-	subject := "Verify Your Email - Flight History App"
+// GetVerificationEmailTemplate returns the email verification template for a locale
+func (s *EmailService) GetVerificationEmailTemplate(name, templateName, baseURL, verificationToken, locale string) EmailTemplate {
+	config := s.Config()
+	subject := localizedSubject("verification", locale, config.AppName)
 
-	verificationLink := fmt.Sprintf("%s/verify-email?token=%s", baseURL, verificationToken)
+	verificationLink := config.link(LinkVerifyEmail, baseURL, verificationToken)
 
-	body, err := template.ParseFiles(templateName)
+	body, err := s.templates.loadLocalized(templateName, locale)
 	if err != nil {
 		log.Printf("Failed to parse verification email template: %v", err)
 		return EmailTemplate{}
 	}
 
 	var bodyString strings.Builder
-	err = body.Execute(&bodyString, map[string]string{
+	err = body.Execute(&bodyString, config.templateData(map[string]string{
 		"Name":              name,
 		"VerificationToken": verificationToken,
 		"VerificationLink":  verificationLink,
-	})
+	}))
 
 	if err != nil {
 		log.Printf("Failed to execute verification email template: %v", err)
@@ -62,197 +211,165 @@ func GetVerificationEmailTemplate(name, templateName, baseURL,verificationToken
 	}
 }
 
-// SendVerificationEmail sends an email verification email using SES
-func SendVerificationEmail(toEmail, name, templateName, baseURL, fromEmail, verificationToken string) error {
-	if sesClient == nil {
-		return fmt.Errorf("SES client not initialized")
+// renderRegisteredTemplate renders a template from the service's registry if one is registered
+// for the locale, so built-in bodies can be overridden and translated
+func (s *EmailService) renderRegisteredTemplate(name, locale string, data map[string]string) (string, bool) {
+	body, _, ok := s.templates.LookupLocalized(name, locale)
+	if !ok {
+		return "", false
 	}
 
-	template := GetVerificationEmailTemplate(name, templateName, baseURL, verificationToken)
-
-	input := &ses.SendEmailInput{
-		Destination: &types.Destination{
-			ToAddresses: []string{toEmail},
-		},
-		Message: &types.Message{
-			Subject: &types.Content{
-				Data:    aws.String(template.Subject),
-				Charset: aws.String("UTF-8"),
-			},
-			Body: &types.Body{
-				Html: &types.Content{
-					Data:    aws.String(template.Body),
-					Charset: aws.String("UTF-8"),
-				},
-			},
-		},
-		Source: aws.String(fromEmail),
+	var bodyString strings.Builder
+	if err := body.Execute(&bodyString, data); err != nil {
+		log.Printf("Failed to execute email template %s: %v", name, err)
+		return "", false
 	}
+	return bodyString.String(), true
+}
 
-	_, err := sesClient.SendEmail(context.TODO(), input)
+// SendVerificationEmail sends an email verification email
+// Empty fromEmail and baseURL fall back to the service's EmailConfig
+func (s *EmailService) SendVerificationEmail(toEmail, name, templateName, baseURL, fromEmail, verificationToken, locale string) error {
+	template := s.GetVerificationEmailTemplate(name, templateName, baseURL, verificationToken, locale)
+
+	err := s.Queue(EmailMessage{
+		From:          fromEmail,
+		To:            []string{toEmail},
+		Subject:       template.Subject,
+		HTMLBody:      template.Body,
+		Transactional: true,
+		Type:          EmailTypeVerification,
+	})
 	if err != nil {
-		log.Printf("Failed to send verification email to %s: %v", toEmail, err)
+		log.Printf("Failed to send verification email to %s: %v", RedactedEmail(toEmail), err)
 		return fmt.Errorf("failed to send verification email: %w", err)
 	}
 
-	log.Printf("Verification email sent successfully to %s", toEmail)
+	log.Printf("Verification email sent successfully to %s", RedactedEmail(toEmail))
 	return nil
 }
 
 // SendWelcomeEmail sends a welcome email after successful verification
-func SendWelcomeEmail(toEmail, fromEmail, name string) error {
-	if sesClient == nil {
-		return fmt.Errorf("SES client not initialized")
-	}
-
-	subject := "Welcome to Flight History App!"
-	bodyTemplate, err := template.ParseFiles("templates/verify.html")
+func (s *EmailService) SendWelcomeEmail(toEmail, fromEmail, name, locale string) error {
+	config := s.Config()
+	subject := localizedSubject("welcome", locale, config.AppName)
+	bodyTemplate, err := s.templates.loadLocalized("templates/verify.html", locale)
 	if err != nil {
 		log.Printf("Failed to parse welcome email template: %v", err)
 		return fmt.Errorf("failed to parse welcome email template: %w", err)
 	}
 
 	var bodyString strings.Builder
-	err = bodyTemplate.Execute(&bodyString, map[string]string{
+	err = bodyTemplate.Execute(&bodyString, config.templateData(map[string]string{
 		"Name":             name,
 		"VerificationLink": "", // No verification link needed for welcome email
-	})
+	}))
 	if err != nil {
 		log.Printf("Failed to execute welcome email template: %v", err)
 		return fmt.Errorf("failed to execute welcome email template: %w", err)
 	}
 
-	input := &ses.SendEmailInput{
-		Destination: &types.Destination{
-			ToAddresses: []string{toEmail},
-		},
-		Message: &types.Message{
-			Subject: &types.Content{
-				Data:    aws.String(subject),
-				Charset: aws.String("UTF-8"),
-			},
-			Body: &types.Body{
-				Html: &types.Content{
-					Data:    aws.String(bodyString.String()),
-					Charset: aws.String("UTF-8"),
-				},
-			},
-		},
-		Source: aws.String(fromEmail),
-	}
-
-	_, err = sesClient.SendEmail(context.TODO(), input)
+	err = s.Queue(EmailMessage{
+		From:     fromEmail,
+		To:       []string{toEmail},
+		Subject:  subject,
+		HTMLBody: bodyString.String(),
+		Type:     EmailTypeWelcome,
+	})
 	if err != nil {
-		log.Printf("Failed to send welcome email to %s: %v", toEmail, err)
+		log.Printf("Failed to send welcome email to %s: %v", RedactedEmail(toEmail), err)
 		return fmt.Errorf("failed to send welcome email: %w", err)
 	}
 
-	log.Printf("Welcome email sent successfully to %s", toEmail)
+	log.Printf("Welcome email sent successfully to %s", RedactedEmail(toEmail))
 	return nil
 }
 
-// SendPasswordResetEmail sends a password reset email using SES
-func SendPasswordResetEmail(toEmail, name, baseURL, fromEmail, resetToken string) error {
-	if sesClient == nil {
-		return fmt.Errorf("SES client not initialized")
-	}
+// SendPasswordResetEmail sends a password reset email
+// A registered "password_reset.html" template (e.g. "password_reset.es.html") overrides the built-in body
+func (s *EmailService) SendPasswordResetEmail(toEmail, name, baseURL, fromEmail, resetToken, locale string) error {
+	config := s.Config()
+	resetLink := config.link(LinkResetPassword, baseURL, resetToken)
 
-	resetLink := fmt.Sprintf("%s/reset-password?token=%s", baseURL, resetToken)
-
-	subject := "Reset Your Password - Flight History App"
-	body := fmt.Sprintf(`
-		<html>
-		<body>
-			<h2>Password Reset Request</h2>
-			<p>Hello %s,</p>
-			<p>You have requested to reset your password for your Flight History App account.</p>
-			<p>Click the link below to reset your password:</p>
-			<p><a href="%s" style="background-color: #007bff; color: white; padding: 10px 20px; text-decoration: none; border-radius: 5px;">Reset Password</a></p>
-			<p>Or copy and paste this link into your browser:</p>
-			<p>%s</p>
-			<p>This link will expire in 1 hour for security reasons.</p>
-			<p>If you didn't request this password reset, please ignore this email.</p>
-			<br>
-			<p>Best regards,<br>Flight History App Team</p>
-		</body>
-		</html>
-	`, name, resetLink, resetLink)
-
-	input := &ses.SendEmailInput{
-		Destination: &types.Destination{
-			ToAddresses: []string{toEmail},
-		},
-		Message: &types.Message{
-			Subject: &types.Content{
-				Data:    aws.String(subject),
-				Charset: aws.String("UTF-8"),
-			},
-			Body: &types.Body{
-				Html: &types.Content{
-					Data:    aws.String(body),
-					Charset: aws.String("UTF-8"),
-				},
-			},
-		},
-		Source: aws.String(fromEmail),
+	subject := localizedSubject("password_reset", locale, config.AppName)
+	body, err := s.renderBody("password_reset.html", locale, config, map[string]string{
+		"Name":      name,
+		"ResetLink": resetLink,
+	})
+	if err != nil {
+		log.Printf("Failed to render password reset email: %v", err)
+		return err
 	}
 
-	_, err := sesClient.SendEmail(context.TODO(), input)
+	err = s.Queue(EmailMessage{
+		From:           fromEmail,
+		To:             []string{toEmail},
+		Subject:        subject,
+		HTMLBody:       body,
+		Transactional:  true,
+		Type:           EmailTypePasswordReset,
+		IdempotencyKey: "password_reset:" + resetToken,
+	})
 	if err != nil {
-		log.Printf("Failed to send password reset email to %s: %v", toEmail, err)
+		log.Printf("Failed to send password reset email to %s: %v", RedactedEmail(toEmail), err)
 		return fmt.Errorf("failed to send password reset email: %w", err)
 	}
 
-	log.Printf("Password reset email sent successfully to %s", toEmail)
+	log.Printf("Password reset email sent successfully to %s", RedactedEmail(toEmail))
 	return nil
 }
 
 // SendPasswordChangeConfirmationEmail sends a confirmation email after password change
-func SendPasswordChangeConfirmationEmail(toEmail, fromEmail, name string) error {
-	if sesClient == nil {
-		return fmt.Errorf("SES client not initialized")
-	}
-
-	subject := "Password Changed - Flight History App"
-	body := fmt.Sprintf(`
-		<html>
-		<body>
-			<h2>Password Successfully Changed</h2>
-			<p>Hello %s,</p>
-			<p>Your password for your Flight History App account has been successfully changed.</p>
-			<p>If you made this change, no further action is required.</p>
-			<p>If you did not make this change, please contact our support team immediately.</p>
-			<br>
-			<p>Best regards,<br>Flight History App Team</p>
-		</body>
-		</html>
-	`, name)
-
-	input := &ses.SendEmailInput{
-		Destination: &types.Destination{
-			ToAddresses: []string{toEmail},
-		},
-		Message: &types.Message{
-			Subject: &types.Content{
-				Data:    aws.String(subject),
-				Charset: aws.String("UTF-8"),
-			},
-			Body: &types.Body{
-				Html: &types.Content{
-					Data:    aws.String(body),
-					Charset: aws.String("UTF-8"),
-				},
-			},
-		},
-		Source: aws.String(fromEmail),
+// A registered "password_changed.html" template (e.g. "password_changed.es.html") overrides the built-in body
+func (s *EmailService) SendPasswordChangeConfirmationEmail(toEmail, fromEmail, name, locale string) error {
+	config := s.Config()
+	subject := localizedSubject("password_changed", locale, config.AppName)
+	body, err := s.renderBody("password_changed.html", locale, config, map[string]string{
+		"Name": name,
+	})
+	if err != nil {
+		log.Printf("Failed to render password change confirmation email: %v", err)
+		return err
 	}
 
-	_, err := sesClient.SendEmail(context.TODO(), input)
+	err = s.Queue(EmailMessage{
+		From:          fromEmail,
+		To:            []string{toEmail},
+		Subject:       subject,
+		HTMLBody:      body,
+		Transactional: true,
+		Type:          EmailTypePasswordChanged,
+	})
 	if err != nil {
-		log.Printf("Failed to send password change confirmation email to %s: %v", toEmail, err)
+		log.Printf("Failed to send password change confirmation email to %s: %v", RedactedEmail(toEmail), err)
 		return fmt.Errorf("failed to send password change confirmation email: %w", err)
 	}
 
-	log.Printf("Password change confirmation email sent successfully to %s", toEmail)
+	log.Printf("Password change confirmation email sent successfully to %s", RedactedEmail(toEmail))
 	return nil
 }
+
+// GetVerificationEmailTemplate returns the email verification template for a locale using the default service
+func GetVerificationEmailTemplate(name, templateName, baseURL, verificationToken, locale string) EmailTemplate {
+	return defaultEmailService.GetVerificationEmailTemplate(name, templateName, baseURL, verificationToken, locale)
+}
+
+// SendVerificationEmail sends an email verification email using the default service
+func SendVerificationEmail(toEmail, name, templateName, baseURL, fromEmail, verificationToken, locale string) error {
+	return defaultEmailService.SendVerificationEmail(toEmail, name, templateName, baseURL, fromEmail, verificationToken, locale)
+}
+
+// SendWelcomeEmail sends a welcome email after successful verification using the default service
+func SendWelcomeEmail(toEmail, fromEmail, name, locale string) error {
+	return defaultEmailService.SendWelcomeEmail(toEmail, fromEmail, name, locale)
+}
+
+// SendPasswordResetEmail sends a password reset email using the default service
+func SendPasswordResetEmail(toEmail, name, baseURL, fromEmail, resetToken, locale string) error {
+	return defaultEmailService.SendPasswordResetEmail(toEmail, name, baseURL, fromEmail, resetToken, locale)
+}
+
+// SendPasswordChangeConfirmationEmail sends a confirmation email after password change using the default service
+func SendPasswordChangeConfirmationEmail(toEmail, fromEmail, name, locale string) error {
+	return defaultEmailService.SendPasswordChangeConfirmationEmail(toEmail, fromEmail, name, locale)
+}
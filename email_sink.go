@@ -0,0 +1,127 @@
+package common
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultEmailSinkLimit bounds how many dry-run messages are kept in memory
+const defaultEmailSinkLimit = 100
+
+// EmailSink records messages instead of sending them while dry-run mode is on,
+// so tests and staging environments can inspect what would have been sent
+type EmailSink struct {
+	mu       sync.Mutex
+	messages []EmailMessage
+	limit    int
+}
+
+// NewEmailSink creates a sink that keeps the most recent limit messages
+func NewEmailSink(limit int) *EmailSink {
+	if limit <= 0 {
+		limit = defaultEmailSinkLimit
+	}
+	return &EmailSink{limit: limit}
+}
+
+var defaultEmailSink = NewEmailSink(defaultEmailSinkLimit)
+
+// DefaultEmailSink returns the sink the default email service records to in dry-run mode
+func DefaultEmailSink() *EmailSink {
+	return defaultEmailSink
+}
+
+// Record stores a message, dropping the oldest one when the sink is full
+func (s *EmailSink) Record(msg EmailMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) >= s.limit {
+		s.messages = s.messages[1:]
+	}
+	s.messages = append(s.messages, msg)
+}
+
+// Messages returns the recorded messages, oldest first
+func (s *EmailSink) Messages() []EmailMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]EmailMessage, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
+
+// MessagesTo returns the recorded messages addressed to an email address, oldest first
+func (s *EmailSink) MessagesTo(email string) []EmailMessage {
+	email = normalizeEmail(email)
+
+	var messages []EmailMessage
+	for _, msg := range s.Messages() {
+		for _, recipient := range msg.To {
+			if normalizeEmail(recipient) == email {
+				messages = append(messages, msg)
+				break
+			}
+		}
+	}
+	return messages
+}
+
+// Last returns the most recently recorded message
+func (s *EmailSink) Last() (EmailMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) == 0 {
+		return EmailMessage{}, false
+	}
+	return s.messages[len(s.messages)-1], true
+}
+
+// Reset discards all recorded messages
+func (s *EmailSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+var (
+	emailDryRunOnce sync.Once
+	emailDryRun     atomic.Bool
+)
+
+// EmailDryRun reports whether email is logged and recorded instead of sent
+// It is on when EMAIL_DRY_RUN is true, the environment profile uses the email sink,
+// or SetEmailDryRun(true) was called
+func EmailDryRun() bool {
+	emailDryRunOnce.Do(func() {
+		if value := os.Getenv("EMAIL_DRY_RUN"); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Printf("Invalid EMAIL_DRY_RUN %q, ignoring", value)
+			}
+			if enabled {
+				emailDryRun.Store(true)
+			}
+		}
+	})
+	return emailDryRun.Load() || CurrentProfile().UseEmailSink
+}
+
+// SetEmailDryRun turns dry-run mode on or off, overriding EMAIL_DRY_RUN
+// Profiles that use the email sink stay in dry-run mode regardless
+func SetEmailDryRun(enabled bool) {
+	emailDryRunOnce.Do(func() {})
+	emailDryRun.Store(enabled)
+}
+
+// recordDryRun logs a message's envelope and records the full message in the service's sink
+// Bodies aren't logged, since they carry reset, verification and unlock tokens; read them from the sink.
+func (s *EmailService) recordDryRun(msg EmailMessage) {
+	log.Printf("EMAIL DRY RUN: type=%s from=%s to=%v subject=%q attachments=%d", msg.Type, msg.From, RedactedEmails(msg.To), msg.Subject, len(msg.Attachments))
+	s.sink.Record(msg)
+}
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestDryRunLogOmitsBody(t *testing.T) {
+	service, err := NewEmailService(nil, DefaultEmailConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.SetDryRun(true)
+	output := captureLog(t)
+
+	const token = "reset-token-0123456789abcdef"
+	err = service.Send(EmailMessage{
+		From:     "noreply@example.com",
+		To:       []string{"user@example.com"},
+		Subject:  "Reset your password",
+		HTMLBody: `<a href="https://example.com/reset?token=` + token + `">Reset</a>`,
+		TextBody: "https://example.com/reset?token=" + token,
+		Type:     EmailTypePasswordReset,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(output.String(), token) {
+		t.Fatalf("dry-run log contains the message body: %s", output)
+	}
+	if !strings.Contains(output.String(), "Reset your password") {
+		t.Fatalf("dry-run log is missing the subject: %s", output)
+	}
+	if last, ok := service.Sink().Last(); !ok || !strings.Contains(last.TextBody, token) {
+		t.Fatal("sink did not record the full message")
+	}
+}
+
+func TestSecretNeverPrints(t *testing.T) {
+	secret := Secret("hunter2")
+	output := captureLog(t)
+	log.Printf("%s %v %+v %#v %q", secret, secret, secret, secret, secret)
+	if strings.Contains(output.String(), "hunter2") {
+		t.Fatalf("log contains the secret: %s", output)
+	}
+}
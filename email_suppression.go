@@ -0,0 +1,273 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SuppressionReason explains why an address no longer receives email
+type SuppressionReason string
+
+const (
+	SuppressionReasonBounce      SuppressionReason = "bounce"
+	SuppressionReasonComplaint   SuppressionReason = "complaint"
+	SuppressionReasonUnsubscribe SuppressionReason = "unsubscribe"
+)
+
+// ValidSuppressionReason reports whether reason is a known suppression reason
+func ValidSuppressionReason(reason SuppressionReason) bool {
+	switch reason {
+	case SuppressionReasonBounce, SuppressionReasonComplaint, SuppressionReasonUnsubscribe:
+		return true
+	default:
+		return false
+	}
+}
+
+// SuppressionForm is the request body for adding a suppression
+type SuppressionForm struct {
+	Email   string            `json:"email" binding:"required"`  // The address to suppress
+	Reason  SuppressionReason `json:"reason" binding:"required"` // bounce, complaint or unsubscribe
+	Details string            `json:"details"`                   // Optional free-form details
+}
+
+// EmailSuppression represents a suppressed address in the database
+type EmailSuppression struct {
+	Email     string            `json:"email" bson:"_id"`             // Lower-cased email address
+	Reason    SuppressionReason `json:"reason" bson:"reason"`         // Why the address is suppressed
+	Details   string            `json:"details" bson:"details"`       // Bounce type, complaint feedback type, etc.
+	CreatedAt time.Time         `json:"created_at" bson:"created_at"` // When the address was first suppressed
+	UpdatedAt time.Time         `json:"updated_at" bson:"updated_at"` // When the suppression was last recorded
+}
+
+// EnableEmailSuppression makes the package's Send* functions skip suppressed recipients
+// stored in the database's email_suppressions collection
+func EnableEmailSuppression(database *mongo.Database) {
+	defaultEmailService.EnableSuppression(database)
+}
+
+// RecordEmailSuppression stores or refreshes a suppression for an address
+func RecordEmailSuppression(ctx context.Context, database *mongo.Database, email string, reason SuppressionReason, details string) error {
+	now := time.Now()
+	_, err := database.Collection("email_suppressions").UpdateOne(ctx,
+		bson.M{"_id": normalizeEmail(email)},
+		bson.M{
+			"$set": bson.M{
+				"reason":     reason,
+				"details":    details,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record email suppression: %w", err)
+	}
+	return nil
+}
+
+// IsEmailSuppressed reports whether an address is on the suppression list for any reason
+func IsEmailSuppressed(ctx context.Context, database *mongo.Database, email string) (bool, error) {
+	count, err := database.Collection("email_suppressions").CountDocuments(ctx, bson.M{"_id": normalizeEmail(email)}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check email suppression: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GetEmailSuppression returns the suppression for an address, or mongo.ErrNoDocuments if there is none
+func GetEmailSuppression(ctx context.Context, database *mongo.Database, email string) (*EmailSuppression, error) {
+	var suppression EmailSuppression
+	err := database.Collection("email_suppressions").FindOne(ctx, bson.M{"_id": normalizeEmail(email)}).Decode(&suppression)
+	if err != nil {
+		return nil, err
+	}
+	return &suppression, nil
+}
+
+// RemoveEmailSuppression takes an address off the suppression list
+// It reports whether a suppression existed
+func RemoveEmailSuppression(ctx context.Context, database *mongo.Database, email string) (bool, error) {
+	result, err := database.Collection("email_suppressions").DeleteOne(ctx, bson.M{"_id": normalizeEmail(email)})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove email suppression: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// ListEmailSuppressions returns suppressions, newest first, optionally filtered by reason
+func ListEmailSuppressions(ctx context.Context, database *mongo.Database, reason SuppressionReason, limit int64) ([]EmailSuppression, error) {
+	filter := bson.M{}
+	if reason != "" {
+		filter["reason"] = reason
+	}
+
+	opts := options.Find().SetSort(bson.M{"updated_at": -1}).SetLimit(limit)
+	cursor, err := FindWithOptions(ctx, database.Collection("email_suppressions"), filter, opts, int(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	suppressions := make([]EmailSuppression, 0, limit)
+	if err := cursor.All(&suppressions); err != nil {
+		return nil, fmt.Errorf("failed to decode email suppressions: %w", err)
+	}
+	return suppressions, nil
+}
+
+// filterSuppressedRecipients removes addresses suppressed in collection; a nil collection disables suppression
+// Transactional messages are only blocked by bounces and complaints, since an unsubscribe
+// must not stop someone from resetting their password
+// If the check fails, recipients are kept so a database outage doesn't block all email
+func filterSuppressedRecipients(ctx context.Context, collection *mongo.Collection, recipients []string, transactional bool) []string {
+	if collection == nil {
+		return recipients
+	}
+
+	allowed := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		filter := bson.M{"_id": normalizeEmail(recipient)}
+		if transactional {
+			filter["reason"] = bson.M{"$in": bson.A{SuppressionReasonBounce, SuppressionReasonComplaint}}
+		}
+
+		count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			log.Printf("Failed to check email suppression for %s: %v", RedactedEmail(recipient), err)
+			allowed = append(allowed, recipient)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Skipping suppressed recipient %s", RedactedEmail(recipient))
+			continue
+		}
+		allowed = append(allowed, recipient)
+	}
+	return allowed
+}
+
+// ListEmailSuppressionsHandler lists suppressions for admin tooling
+// Supports the optional query parameters reason and limit (default 100, max 1000)
+func ListEmailSuppressionsHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	reason := SuppressionReason(r.URL.Query().Get("reason"))
+	if reason != "" && !ValidSuppressionReason(reason) {
+		RespondWithValidationError(w, "reason", "must be bounce, complaint or unsubscribe")
+		return
+	}
+
+	limit := int64(100)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			RespondWithValidationError(w, "limit", "must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	suppressions, err := ListEmailSuppressions(r.Context(), database, reason, limit)
+	if err != nil {
+		log.Printf("Failed to list email suppressions: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, suppressions)
+}
+
+// AddEmailSuppressionHandler adds an address to the suppression list for admin tooling
+func AddEmailSuppressionHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	var form SuppressionForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+
+	form.Email = SanitizeInput(form.Email)
+	if err := ValidateEmail(form.Email); err != nil {
+		RespondWithValidationError(w, "email", err.Error())
+		return
+	}
+
+	if !ValidSuppressionReason(form.Reason) {
+		RespondWithValidationError(w, "reason", "must be bounce, complaint or unsubscribe")
+		return
+	}
+
+	if err := RecordEmailSuppression(r.Context(), database, form.Email, form.Reason, SanitizeInput(form.Details)); err != nil {
+		log.Printf("Failed to add email suppression: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]string{"message": "Address suppressed"})
+}
+
+// RemoveEmailSuppressionHandler removes the address in the {email} path parameter from the
+// suppression list for admin tooling
+func RemoveEmailSuppressionHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	email := GetPathParam(r, "email")
+	if email == "" {
+		RespondWithValidationError(w, "email", "is required")
+		return
+	}
+
+	removed, err := RemoveEmailSuppression(r.Context(), database, email)
+	if err != nil {
+		log.Printf("Failed to remove email suppression: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	if !removed {
+		RespondWithJSON(w, 404, map[string]string{"error": "Suppression not found"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]string{"message": "Suppression removed"})
+}
+
+// UnsubscribeFromEmail lets the authenticated user stop receiving non-transactional email
+func UnsubscribeFromEmail(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	var user User
+	if err := database.Collection("users").FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+		return
+	}
+
+	// Never downgrade a bounce or complaint to an unsubscribe
+	existing, err := GetEmailSuppression(r.Context(), database, user.Email)
+	if err == nil && existing.Reason != SuppressionReasonUnsubscribe {
+		RespondWithJSON(w, 200, map[string]string{"message": "You have been unsubscribed"})
+		return
+	}
+
+	if err := RecordEmailSuppression(r.Context(), database, user.Email, SuppressionReasonUnsubscribe, "user request"); err != nil {
+		log.Printf("Failed to unsubscribe user %s: %v", userID, err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]string{"message": "You have been unsubscribed"})
+}
+
+// normalizeEmail lower-cases and trims an email address for comparisons
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// EmailTemplateRegistry parses email templates once and renders them by name,
+// so sending an email never touches the filesystem after startup
+type EmailTemplateRegistry struct {
+	mu        sync.RWMutex
+	templates *template.Template
+	subjects  map[string]*texttemplate.Template
+	markdown  map[string]*texttemplate.Template
+	layout    *template.Template
+}
+
+// NewEmailTemplateRegistry creates an empty template registry
+func NewEmailTemplateRegistry() *EmailTemplateRegistry {
+	return &EmailTemplateRegistry{
+		subjects: make(map[string]*texttemplate.Template),
+		markdown: make(map[string]*texttemplate.Template),
+		layout:   template.Must(template.New("layout").Parse(defaultEmailLayout)),
+	}
+}
+
+// defaultEmailTemplates is the registry used by the package-level email functions
+var defaultEmailTemplates = NewEmailTemplateRegistry()
+
+// DefaultEmailTemplateRegistry returns the registry used by the package-level email functions
+func DefaultEmailTemplateRegistry() *EmailTemplateRegistry {
+	return defaultEmailTemplates
+}
+
+// RegisterEmailTemplates parses email templates from fsys (e.g. an embed.FS) into the default
+// registry so they no longer depend on the working directory. Call it once at startup;
+// templates are registered under their base file name, e.g. "verify.html". Patterns default to "*.html".
+func RegisterEmailTemplates(fsys fs.FS, patterns ...string) error {
+	return defaultEmailTemplates.ParseFS(fsys, patterns...)
+}
+
+// ParseFS parses templates matching patterns from fsys and adds them to the registry
+// Patterns default to "*.html"
+func (r *EmailTemplateRegistry) ParseFS(fsys fs.FS, patterns ...string) error {
+	if len(patterns) == 0 {
+		patterns = []string{"*.html"}
+	}
+
+	parsed, err := template.ParseFS(fsys, patterns...)
+	if err != nil {
+		return fmt.Errorf("failed to parse email templates: %w", err)
+	}
+	return r.add(parsed)
+}
+
+// ParseFiles parses the named files from disk and adds them to the registry
+func (r *EmailTemplateRegistry) ParseFiles(filenames ...string) error {
+	parsed, err := template.ParseFiles(filenames...)
+	if err != nil {
+		return fmt.Errorf("failed to parse email templates: %w", err)
+	}
+	return r.add(parsed)
+}
+
+// add merges parsed templates into the registry
+func (r *EmailTemplateRegistry) add(parsed *template.Template) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.templates == nil {
+		r.templates = parsed
+		return nil
+	}
+
+	for _, t := range parsed.Templates() {
+		if _, err := r.templates.AddParseTree(t.Name(), t.Tree); err != nil {
+			return fmt.Errorf("failed to register email template %s: %w", t.Name(), err)
+		}
+	}
+	return nil
+}
+
+// SetSubject sets the subject line rendered alongside the named template
+// The subject may use text/template actions and receives the same data as the body
+func (r *EmailTemplateRegistry) SetSubject(name, subject string) error {
+	parsed, err := texttemplate.New(name).Parse(subject)
+	if err != nil {
+		return fmt.Errorf("failed to parse subject for email template %s: %w", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subjects[name] = parsed
+	return nil
+}
+
+// Lookup returns the template registered under name, or under name's base file name
+func (r *EmailTemplateRegistry) Lookup(name string) (*template.Template, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.templates == nil {
+		return nil, false
+	}
+	if t := r.templates.Lookup(name); t != nil {
+		return t, true
+	}
+	if t := r.templates.Lookup(path.Base(name)); t != nil {
+		return t, true
+	}
+	return nil, false
+}
+
+// LookupLocalized returns the most specific template for a locale, trying e.g.
+// "verify.es-MX.html", then "verify.es.html", then "verify.html"
+// It also returns the name the template was found under
+func (r *EmailTemplateRegistry) LookupLocalized(name, locale string) (*template.Template, string, bool) {
+	for _, candidate := range localizedTemplateNames(name, locale) {
+		if t, ok := r.Lookup(candidate); ok {
+			return t, candidate, true
+		}
+	}
+	return nil, "", false
+}
+
+// Render executes the named template and its subject with the given data
+func (r *EmailTemplateRegistry) Render(name string, data any) (EmailTemplate, error) {
+	return r.RenderLocalized(name, "", data)
+}
+
+// RenderLocalized executes the most specific template and subject for a locale with the given data
+func (r *EmailTemplateRegistry) RenderLocalized(name, locale string, data any) (EmailTemplate, error) {
+	body, resolvedName, ok := r.LookupLocalized(name, locale)
+	if !ok {
+		return EmailTemplate{}, fmt.Errorf("email template %s is not registered", name)
+	}
+
+	var bodyString strings.Builder
+	if err := body.Execute(&bodyString, data); err != nil {
+		return EmailTemplate{}, fmt.Errorf("failed to execute email template %s: %w", resolvedName, err)
+	}
+
+	subject, ok := r.lookupSubject(resolvedName)
+
+	var subjectString strings.Builder
+	if ok {
+		if err := subject.Execute(&subjectString, data); err != nil {
+			return EmailTemplate{}, fmt.Errorf("failed to execute subject for email template %s: %w", resolvedName, err)
+		}
+	}
+
+	return EmailTemplate{
+		Subject: subjectString.String(),
+		Body:    bodyString.String(),
+	}, nil
+}
+
+// lookupSubject returns the subject registered under name, or under name's base file name
+func (r *EmailTemplateRegistry) lookupSubject(name string) (*texttemplate.Template, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if subject, ok := r.subjects[name]; ok {
+		return subject, true
+	}
+	subject, ok := r.subjects[path.Base(name)]
+	return subject, ok
+}
+
+// localizedTemplateNames returns template names to try for a locale, most specific first
+func localizedTemplateNames(name, locale string) []string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	var names []string
+	for _, candidate := range localeFallbacks(locale) {
+		names = append(names, stem+"."+candidate+ext)
+	}
+	return append(names, name)
+}
+
+// loadLocalized returns the most specific template for a locale from the registry, falling back
+// to the most specific file that exists on disk and caching it when it was not registered at startup
+func (r *EmailTemplateRegistry) loadLocalized(name, locale string) (*template.Template, error) {
+	if t, _, ok := r.LookupLocalized(name, locale); ok {
+		return t, nil
+	}
+
+	for _, candidate := range localizedTemplateNames(name, locale) {
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+
+		if err := r.ParseFiles(candidate); err != nil {
+			return nil, err
+		}
+		if t, ok := r.Lookup(candidate); ok {
+			return t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("email template %s is not registered", name)
+}
@@ -2,11 +2,12 @@ package common
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -19,17 +20,28 @@ type ResendVerificationEmailForm struct {
 	Email string `json:"email" binding:"required"` // The email of the user
 }
 
+// EmailVerificationType tells what confirming an email verification does
+type EmailVerificationType string
+
+const (
+	EmailVerificationSignup EmailVerificationType = "signup" // Verifies a new account's address; also records without a type
+	EmailVerificationChange EmailVerificationType = "change" // Moves an account to a new address, see RequestEmailChange
+)
+
 // EmailVerification represents an email verification request in the database
 type EmailVerification struct {
-	ID        string     `json:"id" bson:"_id"`                // Unique ID for the verification request
-	Name      string     `json:"name" bson:"name"`             // Name of the user requesting verification
-	UserID    string     `json:"user_id" bson:"user_id"`       // ID of the user requesting verification
-	Email     string     `json:"email" bson:"email"`           // Email of the user (for easier queries)
-	Token     string     `json:"token" bson:"token"`           // The verification token
-	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"` // When the token expires
-	CreatedAt time.Time  `json:"created_at" bson:"created_at"` // When the verification was requested
-	Used      bool       `json:"used" bson:"used"`             // Whether the token has been used
-	UsedAt    *time.Time `json:"used_at" bson:"used_at"`       // When the token was used
+	ID        string `json:"id" bson:"_id"`                // Unique ID for the verification request
+	Name      string `json:"name" bson:"name"`             // Name of the user requesting verification
+	UserID    string `json:"user_id" bson:"user_id"`       // ID of the user requesting verification
+	Email     string `json:"email" bson:"email"`           // Email of the user (for easier queries)
+	Token     string `json:"token" bson:"token"`           // The verification token
+	ExpiresAt Time   `json:"expires_at" bson:"expires_at"` // When the token expires
+	CreatedAt Time   `json:"created_at" bson:"created_at"` // When the verification was requested
+	Used      bool   `json:"used" bson:"used"`             // Whether the token has been used
+	UsedAt    *Time  `json:"used_at" bson:"used_at"`       // When the token was used
+
+	Type          EmailVerificationType `json:"type" bson:"type,omitempty"`                     // Signup or email change
+	PreviousEmail string                `json:"previous_email" bson:"previous_email,omitempty"` // Address an email change replaces
 }
 
 // CreateEmailVerification creates a new email verification record
@@ -37,7 +49,7 @@ func CreateEmailVerification(database *mongo.Database, userID, email, token stri
 	verificationsCollection := database.Collection("email_verifications")
 
 	// Generate unique ID for the verification request
-	verificationID, err := uuid.NewV7()
+	verificationID, err := NewID()
 	if err != nil {
 		return err
 	}
@@ -45,14 +57,15 @@ func CreateEmailVerification(database *mongo.Database, userID, email, token stri
 	// Create email verification record
 	now := time.Now()
 	emailVerification := EmailVerification{
-		ID:        verificationID.String(),
+		ID:        verificationID,
 		UserID:    userID,
 		Email:     email,
 		Token:     token,
-		ExpiresAt: now.Add(24 * time.Hour), // Token expires in 24 hours
-		CreatedAt: now,
+		ExpiresAt: NewTime(now.Add(24 * time.Hour)), // Token expires in 24 hours
+		CreatedAt: NewTime(now),
 		Used:      false,
 		UsedAt:    nil,
+		Type:      EmailVerificationSignup,
 	}
 
 	// Insert the verification record
@@ -88,8 +101,9 @@ func VerifyEmail(database *mongo.Database, w http.ResponseWriter, r *http.Reques
 	var verification EmailVerification
 	err := verificationsCollection.FindOne(r.Context(), bson.M{
 		"token":      form.Token,
-		"used":       false,                     // Token must not be used
-		"expires_at": bson.M{"$gt": time.Now()}, // Token must not be expired
+		"used":       false,                                  // Token must not be used
+		"expires_at": bson.M{"$gt": time.Now()},              // Token must not be expired
+		"type":       bson.M{"$ne": EmailVerificationChange}, // Email changes are confirmed with ConfirmEmailChange
 	}).Decode(&verification)
 
 	if err != nil {
@@ -136,6 +150,8 @@ func VerifyEmail(database *mongo.Database, w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventEmailVerified, UserID: user.ID, Email: user.Email})
+
 	// Mark verification token as used
 	verificationUpdate := bson.M{
 		"$set": bson.M{
@@ -151,7 +167,7 @@ func VerifyEmail(database *mongo.Database, w http.ResponseWriter, r *http.Reques
 	}
 
 	// Send welcome email (don't fail if this fails)
-	if err := SendWelcomeEmail(user.Email, fromEmail, user.Name); err != nil {
+	if err := SendWelcomeEmail(user.Email, fromEmail, user.Name, ResolveLocale(r, &user)); err != nil {
 		log.Printf("Failed to send welcome email: %v", err)
 		// Continue anyway, verification was successful
 	}
@@ -175,14 +191,20 @@ func ResendVerificationEmail(database *mongo.Database, w http.ResponseWriter, r
 	collection := database.Collection("email_verifications")
 
 	var emailVerification EmailVerification
-	err := collection.FindOne(r.Context(), bson.M{"email": form.Email}).Decode(&emailVerification)
+	err := collection.FindOne(r.Context(), bson.M{"email": form.Email, "type": bson.M{"$ne": EmailVerificationChange}}).Decode(&emailVerification)
 	if err != nil {
 		RespondWithJSON(w, 400, map[string]string{"error": "Email verification not found"})
 		return
 	}
 
 	// Send verification email
-	if err := SendVerificationEmail(emailVerification.Email, emailVerification.Name, templateName, baseURL, fromEmail, emailVerification.Token); err != nil {
+	if err := SendVerificationEmail(emailVerification.Email, emailVerification.Name, templateName, baseURL, fromEmail, emailVerification.Token, ResolveLocale(r, nil)); err != nil {
+		var rateLimitErr *EmailRateLimitError
+		if errors.As(err, &rateLimitErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(rateLimitErr.RetryAfter.Seconds())+1))
+			RespondWithJSON(w, 429, map[string]string{"error": "Too many verification emails requested. Please try again later."})
+			return
+		}
 		log.Printf("Failed to send verification email: %v", err)
 		// Don't fail the registration if email sending fails
 		// The user is still created and can request a new verification email
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment identifies the deployment stage a service runs in
+type Environment string
+
+const (
+	EnvironmentDevelopment Environment = "development"
+	EnvironmentStaging     Environment = "staging"
+	EnvironmentProduction  Environment = "production"
+)
+
+// ParseEnvironment parses an environment name, accepting short forms like "dev" and "prod"
+func ParseEnvironment(name string) (Environment, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "dev", "development", "local":
+		return EnvironmentDevelopment, nil
+	case "stage", "staging":
+		return EnvironmentStaging, nil
+	case "prod", "production":
+		return EnvironmentProduction, nil
+	default:
+		return "", fmt.Errorf("unknown environment %q", name)
+	}
+}
+
+// EnvironmentProfile holds the defaults that differ between environments
+type EnvironmentProfile struct {
+	Environment    Environment
+	UseEmailSink   bool           // Log and record outgoing email instead of sending it through SES (see EmailDryRun)
+	PasswordPolicy PasswordPolicy // Password rules applied by ValidatePassword
+	CorsOrigins    []string       // Origins allowed when a CORS middleware is given none
+	LogLevel       string         // Initial runtime log level
+	LogPII         bool           // Log full email addresses instead of masking them (see RedactedEmail)
+}
+
+// ProfileFor returns the default profile for an environment
+func ProfileFor(env Environment) EnvironmentProfile {
+	switch env {
+	case EnvironmentDevelopment:
+		return EnvironmentProfile{
+			Environment:    env,
+			UseEmailSink:   true,
+			PasswordPolicy: RelaxedPasswordPolicy(),
+			CorsOrigins: []string{
+				"http://localhost:3000",
+				"http://localhost:5173",
+				"http://localhost:5174",
+				"http://127.0.0.1:5173",
+			},
+			LogLevel: "debug",
+			LogPII:   true,
+		}
+	case EnvironmentStaging:
+		return EnvironmentProfile{
+			Environment:    env,
+			UseEmailSink:   false,
+			PasswordPolicy: DefaultPasswordPolicy(),
+			LogLevel:       "debug",
+		}
+	default:
+		return EnvironmentProfile{
+			Environment:    EnvironmentProduction,
+			UseEmailSink:   false,
+			PasswordPolicy: DefaultPasswordPolicy(),
+			LogLevel:       "info",
+		}
+	}
+}
+
+var (
+	environmentOnce    sync.Once
+	environmentMu      sync.RWMutex
+	environmentProfile EnvironmentProfile
+)
+
+// loadEnvironment reads APP_ENV once, defaulting to production so a missing
+// variable never enables development shortcuts
+func loadEnvironment() {
+	environmentOnce.Do(func() {
+		env := EnvironmentProduction
+		if name := os.Getenv("APP_ENV"); name != "" {
+			parsed, err := ParseEnvironment(name)
+			if err != nil {
+				log.Printf("Invalid APP_ENV %q, defaulting to production", name)
+			} else {
+				env = parsed
+			}
+		}
+
+		environmentMu.Lock()
+		environmentProfile = ProfileFor(env)
+		environmentMu.Unlock()
+	})
+}
+
+// CurrentEnvironment returns the environment the service runs in, read from APP_ENV
+func CurrentEnvironment() Environment {
+	return CurrentProfile().Environment
+}
+
+// CurrentProfile returns the active environment profile
+func CurrentProfile() EnvironmentProfile {
+	loadEnvironment()
+
+	environmentMu.RLock()
+	defer environmentMu.RUnlock()
+	return environmentProfile
+}
+
+// SetEnvironmentProfile overrides the active profile, e.g. to customize ProfileFor's defaults
+func SetEnvironmentProfile(profile EnvironmentProfile) {
+	loadEnvironment()
+
+	environmentMu.Lock()
+	defer environmentMu.Unlock()
+	environmentProfile = profile
+}
+
+// IsDevelopment reports whether the service runs in the development environment
+func IsDevelopment() bool {
+	return CurrentEnvironment() == EnvironmentDevelopment
+}
+
+// IsProduction reports whether the service runs in the production environment
+func IsProduction() bool {
+	return CurrentEnvironment() == EnvironmentProduction
+}
@@ -1,56 +1,30 @@
 package common
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
+
+	"github.com/adhiravishankar/ar-go-common/httpx"
 )
 
-// ErrorResponse represents a standard error response
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}
+// ErrorResponse represents a standard error response; it forwards to httpx.ErrorResponse
+type ErrorResponse = httpx.ErrorResponse
 
-// RespondWithError provides standardized error handling with proper HTTP codes
+// RespondWithError provides standardized error handling with proper HTTP codes; it forwards to httpx.RespondWithError
 func RespondWithError(w http.ResponseWriter, code int, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Error:   err.Error(),
-		Code:    code,
-		Message: GetErrorMessage(code),
-	})
+	httpx.RespondWithError(w, code, err)
 }
 
-// RespondWithValidationError provides specific validation error handling
+// RespondWithValidationError provides specific validation error handling; it forwards to httpx.RespondWithValidationError
 func RespondWithValidationError(w http.ResponseWriter, field string, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(400)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Error:   fmt.Sprintf("validation failed for field '%s': %s", field, message),
-		Code:    400,
-		Message: "Validation Error",
-	})
+	httpx.RespondWithValidationError(w, field, message)
 }
 
-// RespondWithJSON sends a JSON response
+// RespondWithJSON sends a JSON response; it forwards to httpx.RespondWithJSON
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(payload)
+	httpx.RespondWithJSON(w, code, payload)
 }
 
+// GetErrorMessage returns the standard message for an HTTP status code; it forwards to httpx.GetErrorMessage
 func GetErrorMessage(code int) string {
-	switch code {
-	case 400:
-		return "Bad Request"
-	case 404:
-		return "Not Found"
-	case 500:
-		return "Internal Server Error"
-	default:
-		return "Error"
-	}
+	return httpx.GetErrorMessage(code)
 }
@@ -0,0 +1,93 @@
+// Command authservice is a runnable example composing the package's auth, email and MongoDB
+// helpers into an API. It reads its settings from the environment (see app.ConfigFromEnv);
+// with APP_ENV=development email is recorded in the dry-run sink instead of sent through SES.
+//
+//	MONGODB_URL=mongodb://localhost:27017 JWT_SECRET=$(openssl rand -hex 32) \
+//	APP_ENV=development go run ./examples/authservice
+//
+// main_test.go serves the same routes and middleware stack over HTTP against a mock database, as
+// integration tests of the composition.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	common "github.com/adhiravishankar/ar-go-common"
+	"github.com/adhiravishankar/ar-go-common/app"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	service, err := app.New(app.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	// Fail fast on an unverified sending domain rather than on the first bounce
+	if !common.EmailDryRun() {
+		identity := common.SESIdentityOptions{
+			RequireDKIM:             true,
+			MailFromDomain:          os.Getenv("SES_MAIL_FROM_DOMAIN"),
+			RequireProductionAccess: common.IsProduction(),
+		}
+		if _, err := common.VerifySendingIdentity(ctx, identity); err != nil {
+			log.Fatalf("SES sending identity is misconfigured: %v", err)
+		}
+	}
+
+	if err := common.EnableEmailIdempotency(ctx, service.Database, 0); err != nil {
+		log.Fatalf("Failed to enable email idempotency: %v", err)
+	}
+	if err := common.EnableEmailRecipientRateLimit(ctx, service.Database, nil); err != nil {
+		log.Fatalf("Failed to enable email rate limits: %v", err)
+	}
+	if err := common.EnableRefreshTokens(ctx, service.Database, 0); err != nil {
+		log.Fatalf("Failed to enable refresh tokens: %v", err)
+	}
+	if err := common.EnableTokenRevocation(ctx, service.Database); err != nil {
+		log.Fatalf("Failed to enable token revocation: %v", err)
+	}
+	if err := common.EnableStreamTickets(ctx, service.Database); err != nil {
+		log.Fatalf("Failed to enable stream tickets: %v", err)
+	}
+	if err := common.EnableAuthEvents(ctx, service.Database, 90*24*time.Hour); err != nil {
+		log.Fatalf("Failed to enable the auth audit trail: %v", err)
+	}
+	providers, err := common.OIDCProviderConfigsFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read OIDC providers: %v", err)
+	}
+	if err := common.ConfigureOIDCProviders(ctx, providers...); err != nil {
+		log.Fatalf("Failed to configure OIDC providers: %v", err)
+	}
+	common.EnableEmailLog(service.Database)
+	common.EnableEmailSuppression(service.Database)
+	common.SetSelfServiceUnlock(true)
+
+	if service.Replay, err = common.NewReplayGuard(ctx, service.Database, 0); err != nil {
+		log.Fatalf("Failed to enable replay protection: %v", err)
+	}
+	if service.RateLimits, err = common.NewMongoRateLimitStore(ctx, service.Database); err != nil {
+		log.Fatalf("Failed to enable rate limiting: %v", err)
+	}
+
+	registerRoutes(service)
+	common.LogDiagnostics()
+
+	if err := service.Run(ctx); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+// registerRoutes registers the service's API
+func registerRoutes(service *app.App) {
+	service.RegisterAuthRoutes("/auth")
+	service.RegisterOperationalRoutes()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	common "github.com/adhiravishankar/ar-go-common"
+	"github.com/adhiravishankar/ar-go-common/app"
+	"github.com/adhiravishankar/ar-go-common/commontest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// newTestServer serves the example's routes and middleware stack against mt's mock database
+func newTestServer(mt *mtest.T) *httptest.Server {
+	service := &app.App{
+		Config:     app.Config{JWTSecret: commontest.Secret, BaseURL: "https://example.com"},
+		Database:   mt.DB,
+		Mux:        http.NewServeMux(),
+		Auth:       commontest.Auth(mt.T),
+		RateLimits: common.NewMemoryRateLimitStore(),
+	}
+	registerRoutes(service)
+	server := httptest.NewServer(service.Handler())
+	mt.Cleanup(server.Close)
+	return server
+}
+
+// do sends a request to server, returning the response and its decoded JSON body
+func do(mt *mtest.T, server *httptest.Server, method, path, body, token string) (*http.Response, map[string]any) {
+	mt.Helper()
+	r, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		mt.Fatal(err)
+	}
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := server.Client().Do(r)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var decoded map[string]any
+	data, _ := io.ReadAll(response.Body)
+	json.Unmarshal(data, &decoded)
+	return response, decoded
+}
+
+func TestAuthService(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	const userID = "0d9f8a57-3a5e-4f7e-9b38-3c1f0b7c2a11"
+	user := bson.D{{Key: "_id", Value: userID}, {Key: "email", Value: "user@example.com"}, {Key: "name", Value: "Ada"}, {Key: "is_verified", Value: true}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  func(t *testing.T) string
+		mocks  []bson.D // Database responses the request needs
+		want   int
+		check  func(mt *mtest.T, response *http.Response, body map[string]any)
+	}{
+		{
+			name: "health check", method: http.MethodGet, path: "/health", want: http.StatusOK,
+			check: func(mt *mtest.T, response *http.Response, body map[string]any) {
+				if response.Header.Get(common.RequestIDHeader) == "" {
+					mt.Fatal("response has no request ID")
+				}
+			},
+		},
+		{
+			name: "profile without a token", method: http.MethodGet, path: "/auth/me", want: http.StatusUnauthorized,
+			check: func(mt *mtest.T, response *http.Response, body map[string]any) {
+				if body["request_id"] != response.Header.Get(common.RequestIDHeader) {
+					mt.Fatalf("error body %v does not carry the request ID", body)
+				}
+			},
+		},
+		{
+			name: "profile", method: http.MethodGet, path: "/auth/me",
+			token: func(t *testing.T) string { return commontest.Token(t, userID) },
+			mocks: []bson.D{mtest.CreateCursorResponse(0, "app.users", mtest.FirstBatch, user)},
+			want:  http.StatusOK,
+			check: func(mt *mtest.T, response *http.Response, body map[string]any) {
+				if body["email"] != "user@example.com" {
+					mt.Fatalf("profile = %v, want the user's", body)
+				}
+			},
+		},
+		{
+			name: "login with an unknown email", method: http.MethodPost, path: "/auth/login",
+			body:  `{"email":"nobody@example.com","password":"secret-password"}`,
+			mocks: []bson.D{mtest.CreateCursorResponse(0, "app.users", mtest.FirstBatch)},
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name: "diagnostics as a user", method: http.MethodGet, path: "/debug/diagnostics",
+			token: func(t *testing.T) string { return commontest.Token(t, userID) },
+			want:  http.StatusForbidden,
+		},
+		{
+			name: "guest token on a user route", method: http.MethodGet, path: "/auth/me",
+			token: func(t *testing.T) string { return commontest.GuestToken(t) },
+			want:  http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			server := newTestServer(mt)
+			mt.AddMockResponses(tt.mocks...)
+			token := ""
+			if tt.token != nil {
+				token = tt.token(mt.T)
+			}
+
+			response, body := do(mt, server, tt.method, tt.path, tt.body, token)
+			if response.StatusCode != tt.want {
+				mt.Fatalf("status = %d, want %d: %v", response.StatusCode, tt.want, body)
+			}
+			if tt.check != nil {
+				tt.check(mt, response, body)
+			}
+		})
+	}
+}
+
+func TestAuthServiceRateLimits(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("registration", func(mt *mtest.T) {
+		server := newTestServer(mt)
+
+		// Malformed requests count too, and are refused before the database is used
+		for i := range 5 {
+			if response, body := do(mt, server, http.MethodPost, "/auth/register", "{", ""); response.StatusCode != http.StatusBadRequest {
+				mt.Fatalf("request %d: status = %d, want %d: %v", i+1, response.StatusCode, http.StatusBadRequest, body)
+			}
+		}
+		response, _ := do(mt, server, http.MethodPost, "/auth/register", "{", "")
+		if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") == "" {
+			mt.Fatalf("status = %d with Retry-After %q, want %d with a Retry-After", response.StatusCode, response.Header.Get("Retry-After"), http.StatusTooManyRequests)
+		}
+	})
+}
+
+func TestAuthServiceLogin(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("login then profile", func(mt *mtest.T) {
+		server := newTestServer(mt)
+		hash, err := common.GenerateFromPassword("correct horse battery", common.CurrentPasswordParams())
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user := bson.D{
+			{Key: "_id", Value: "0d9f8a57-3a5e-4f7e-9b38-3c1f0b7c2a11"},
+			{Key: "email", Value: "user@example.com"},
+			{Key: "password", Value: hash},
+			{Key: "is_verified", Value: true},
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "app.users", mtest.FirstBatch, user),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		response, body := do(mt, server, http.MethodPost, "/auth/login", `{"email":"user@example.com","password":"correct horse battery"}`, "")
+		if response.StatusCode != http.StatusOK {
+			mt.Fatalf("login status = %d, want %d: %v", response.StatusCode, http.StatusOK, body)
+		}
+		token, _ := body["token"].(string)
+		if token == "" {
+			mt.Fatalf("login response %v has no access token", body)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "app.users", mtest.FirstBatch, user))
+		response, body = do(mt, server, http.MethodGet, "/auth/me", "", token)
+		if response.StatusCode != http.StatusOK || body["email"] != "user@example.com" {
+			mt.Fatalf("profile = %d %v, want the signed-in user", response.StatusCode, body)
+		}
+		if _, ok := body["password"]; ok {
+			mt.Fatal("profile exposes the password hash")
+		}
+	})
+}
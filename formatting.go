@@ -0,0 +1,150 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// UnitSystem identifies the measurement system used when formatting distances
+type UnitSystem string
+
+const (
+	UnitSystemMetric   UnitSystem = "metric"
+	UnitSystemImperial UnitSystem = "imperial"
+)
+
+const kilometersPerMile = 1.609344
+
+// Regions that use miles for road distances
+var imperialRegions = map[string]bool{
+	"US": true,
+	"GB": true,
+	"LR": true,
+	"MM": true,
+}
+
+// FormatPreferences holds the user preferences that affect how values are displayed
+type FormatPreferences struct {
+	Locale     string     // BCP 47 language tag, e.g. "en-US"
+	UnitSystem UnitSystem // Metric or imperial distances
+	Currency   string     // ISO 4217 currency code used when none is given
+}
+
+// DefaultFormatPreferences returns preferences for en-US with metric units and USD
+func DefaultFormatPreferences() FormatPreferences {
+	return FormatPreferences{
+		Locale:     "en-US",
+		UnitSystem: UnitSystemMetric,
+		Currency:   "USD",
+	}
+}
+
+// FormatPreferencesForUser derives formatting preferences from the user's profile
+// If the user has no explicit unit system, it is inferred from the locale's region
+func FormatPreferencesForUser(user *User) FormatPreferences {
+	prefs := DefaultFormatPreferences()
+	if user == nil {
+		return prefs
+	}
+
+	if user.Locale != "" {
+		prefs.Locale = user.Locale
+	}
+
+	tag := language.Make(prefs.Locale)
+	region, _ := tag.Region()
+
+	switch UnitSystem(user.Units) {
+	case UnitSystemMetric, UnitSystemImperial:
+		prefs.UnitSystem = UnitSystem(user.Units)
+	default:
+		if imperialRegions[region.String()] {
+			prefs.UnitSystem = UnitSystemImperial
+		}
+	}
+
+	if unit, ok := currency.FromRegion(region); ok {
+		prefs.Currency = unit.String()
+	}
+
+	return prefs
+}
+
+// Formatter formats numbers, distances, durations and currencies for a set of preferences
+type Formatter struct {
+	prefs   FormatPreferences
+	printer *message.Printer
+}
+
+// NewFormatter creates a formatter for the given preferences
+func NewFormatter(prefs FormatPreferences) *Formatter {
+	if prefs.UnitSystem == "" {
+		prefs.UnitSystem = UnitSystemMetric
+	}
+
+	tag, err := language.Parse(prefs.Locale)
+	if err != nil {
+		tag = language.AmericanEnglish
+	}
+
+	return &Formatter{prefs: prefs, printer: message.NewPrinter(tag)}
+}
+
+// NewFormatterForUser creates a formatter using the user's profile preferences
+func NewFormatterForUser(user *User) *Formatter {
+	return NewFormatter(FormatPreferencesForUser(user))
+}
+
+// Preferences returns the preferences used by the formatter
+func (f *Formatter) Preferences() FormatPreferences {
+	return f.prefs
+}
+
+// Number formats a number with locale-specific grouping and at most the given fraction digits
+func (f *Formatter) Number(value float64, maxFractionDigits int) string {
+	return f.printer.Sprint(number.Decimal(value, number.MaxFractionDigits(maxFractionDigits)))
+}
+
+// Distance formats a distance given in kilometers in the preferred unit system
+func (f *Formatter) Distance(kilometers float64) string {
+	if f.prefs.UnitSystem == UnitSystemImperial {
+		return f.Number(kilometers/kilometersPerMile, 0) + " mi"
+	}
+	return f.Number(kilometers, 0) + " km"
+}
+
+// Duration formats a duration as hours and minutes, e.g. "2h 05m" or "45m"
+func (f *Formatter) Duration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < 0 {
+		return "-" + f.Duration(-d)
+	}
+
+	hours := int64(d / time.Hour)
+	minutes := int64((d % time.Hour) / time.Minute)
+
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%sh %02dm", f.printer.Sprint(number.Decimal(hours)), minutes)
+}
+
+// Currency formats an amount in the given ISO 4217 currency, or the preferred currency if code is empty
+func (f *Formatter) Currency(amount float64, code string) (string, error) {
+	if code == "" {
+		code = f.prefs.Currency
+	}
+
+	unit, err := currency.ParseISO(strings.ToUpper(code))
+	if err != nil {
+		return "", fmt.Errorf("invalid currency code %q: %w", code, err)
+	}
+
+	return f.printer.Sprint(currency.Symbol(unit.Amount(amount))), nil
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatterCurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		locale  string
+		amount  float64
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{"two decimals", "en-US", 1234.5, "USD", "$ 1,234.50", false},
+		{"rounds half up", "en-US", 0.125, "USD", "$ 0.13", false},
+		{"rounds the shortest decimal, not the binary value", "en-US", 2.675, "USD", "$ 2.68", false},
+		{"rounds up to a cent", "en-US", 0.005, "USD", "$ 0.01", false},
+		{"large amount", "en-US", 1e9, "USD", "$ 1,000,000,000.00", false},
+		{"yen has no minor unit", "en-US", 1234.5, "JPY", "¥ 1,235", false},
+		{"yen rounds half up, unlike Number", "ja-JP", 1235.5, "JPY", "￥ 1,236", false},
+		{"dinar has three decimals", "en-US", 1.2345, "KWD", "KWD 1.235", false},
+		{"German grouping", "de-DE", 1234.5, "EUR", "€ 1.234,50", false},
+		{"French grouping", "fr-FR", 1234.5, "EUR", "€ 1\u00a0234,50", false},
+		{"Swiss grouping", "de-CH", 1234.57, "CHF", "CHF 1’234.57", false},
+		{"Indian grouping", "en-IN", 1234567.891, "INR", "₹ 12,34,567.89", false},
+		{"lowercase code", "en-US", 1, "eur", "€ 1.00", false},
+		{"preferred currency", "en-US", 1, "", "$ 1.00", false},
+		{"invalid locale falls back to en-US", "not a locale", 5, "USD", "$ 5.00", false},
+		{"unknown code", "en-US", 1, "ZZZ", "", true},
+		{"malformed code", "en-US", 1, "dollars", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFormatter(FormatPreferences{Locale: tt.locale, Currency: "USD"})
+			got, err := f.Currency(tt.amount, tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Currency(%v, %q) error = %v, want error %v", tt.amount, tt.code, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Currency(%v, %q) in %s = %q, want %q", tt.amount, tt.code, tt.locale, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPreferencesForUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         *User
+		wantUnits    UnitSystem
+		wantCurrency string
+	}{
+		{"no user", nil, UnitSystemMetric, "USD"},
+		{"no locale", &User{}, UnitSystemImperial, "USD"},
+		{"British locale", &User{Locale: "en-GB"}, UnitSystemImperial, "GBP"},
+		{"German locale", &User{Locale: "de-DE"}, UnitSystemMetric, "EUR"},
+		{"Japanese locale", &User{Locale: "ja-JP"}, UnitSystemMetric, "JPY"},
+		{"language only", &User{Locale: "fr"}, UnitSystemMetric, "EUR"},
+		{"explicit units win", &User{Locale: "en-US", Units: "metric"}, UnitSystemMetric, "USD"},
+		{"unknown units are inferred", &User{Locale: "de-DE", Units: "furlongs"}, UnitSystemMetric, "EUR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := FormatPreferencesForUser(tt.user)
+			if prefs.UnitSystem != tt.wantUnits || prefs.Currency != tt.wantCurrency {
+				t.Fatalf("FormatPreferencesForUser = %+v, want %s and %s", prefs, tt.wantUnits, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestFormatterNumbers(t *testing.T) {
+	us := NewFormatter(FormatPreferences{Locale: "en-US", UnitSystem: UnitSystemImperial})
+	de := NewFormatter(FormatPreferences{Locale: "de-DE"})
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"number", us.Number(1234.5678, 2), "1,234.57"},
+		{"German number", de.Number(1234.5678, 2), "1.234,57"},
+		{"numbers round half to even", us.Number(0.5, 0) + " " + us.Number(1.5, 0) + " " + us.Number(2.5, 0), "0 2 2"},
+		{"miles", us.Distance(1609.344), "1,000 mi"},
+		{"kilometers", de.Distance(1234.4), "1.234 km"},
+		{"default units are metric", NewFormatter(FormatPreferences{Locale: "en-US"}).Distance(10), "10 km"},
+		{"minutes", us.Duration(45 * time.Minute), "45m"},
+		{"hours and minutes", us.Duration(2*time.Hour + 5*time.Minute), "2h 05m"},
+		{"rounds to the minute", us.Duration(59*time.Minute + 30*time.Second), "1h 00m"},
+		{"grouped hours", us.Duration(1234 * time.Hour), "1,234h 00m"},
+		{"negative", us.Duration(-90 * time.Minute), "-1h 30m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Fatalf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
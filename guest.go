@@ -0,0 +1,108 @@
+package common
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// RoleGuest is the role of guest tokens, whose subject is a random ID rather than a user
+const RoleGuest = "guest"
+
+// DefaultGuestTokenTTL is how long a guest token lasts; guests have no refresh token, so it outlives an access token
+const DefaultGuestTokenTTL = 24 * time.Hour
+
+// guestAccess is who an authentication middleware lets through besides registered users
+type guestAccess int
+
+const (
+	registeredOnly guestAccess = iota // Registered users only
+	allowGuests                       // Registered users and guest tokens
+	allowAnonymous                    // Anyone; requests without a token have no claims
+)
+
+// IsGuest reports whether the claims are a guest token's
+func (c *AppClaims) IsGuest() bool {
+	return c.HasRole(RoleGuest)
+}
+
+// IsGuest reports whether the request was authenticated with a guest token
+func IsGuest(r *http.Request) bool {
+	return ClaimsFromContext(r).IsGuest()
+}
+
+// IsAnonymous reports whether the request has no registered user, i.e. no token or a guest token
+func IsAnonymous(r *http.Request) bool {
+	claims := ClaimsFromContext(r)
+	return claims == nil || claims.IsGuest()
+}
+
+// IssueGuestToken signs a guest token for a new synthetic subject, valid for AuthConfig.GuestTokenTTL
+// The subject stays the same for the token's lifetime, so it can key carts, drafts or rate limits.
+func (a *Auth) IssueGuestToken(r *http.Request) (string, *AppClaims, error) {
+	claims := &AppClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(a.config.GuestTokenTTL)),
+		},
+		Roles: []string{RoleGuest},
+	}
+	token, err := a.IssueClaims(r, claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// GuestLogin responds with a guest token, so clients can use endpoints behind GuestMiddleware without registering
+// Guests aren't stored, so it needs no database.
+func GuestLogin(w http.ResponseWriter, r *http.Request, secret string) {
+	secretAuth(secret).GuestLogin(w, r)
+}
+
+// GuestLogin responds with a guest token; in cookie auth mode it is set as a cookie rather than returned
+func (a *Auth) GuestLogin(w http.ResponseWriter, r *http.Request) {
+	token, claims, err := a.IssueGuestToken(r)
+	if err != nil {
+		log.Printf("Failed to issue guest token: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"guest_id":   claims.Subject,
+		"expires_in": int(a.config.GuestTokenTTL.Seconds()),
+	}
+	if a.config.Cookie != nil {
+		a.writeTokenCookie(w, token, claims.ExpiresAt.Time)
+	} else {
+		response["token"] = token
+	}
+	RespondWithJSON(w, 200, response)
+}
+
+// GuestMiddleware is Middleware that also accepts guest tokens; use IsGuest to tell them apart
+func (a *Auth) GuestMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, allowGuests)
+}
+
+// OptionalMiddleware is GuestMiddleware that also lets through requests without a token, with no claims;
+// a token that is presented must still be valid. Use IsAnonymous to tell them apart.
+func (a *Auth) OptionalMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, allowAnonymous)
+}
+
+// AuthenticateGuests is Authenticate that also accepts guest tokens, see Auth.GuestMiddleware
+func AuthenticateGuests(next http.Handler) http.Handler {
+	return newAuth(DefaultAuthConfig(os.Getenv("JWT_SECRET"))).GuestMiddleware(next)
+}
+
+// AuthenticateOptional is Authenticate that also lets through guests and requests without a token,
+// see Auth.OptionalMiddleware
+func AuthenticateOptional(next http.Handler) http.Handler {
+	return newAuth(DefaultAuthConfig(os.Getenv("JWT_SECRET"))).OptionalMiddleware(next)
+}
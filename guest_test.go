@@ -0,0 +1,54 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGuestLogin(t *testing.T) {
+	auth, err := NewAuth(AuthConfig{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	auth.GuestLogin(w, httptest.NewRequest(http.MethodPost, "/auth/guest", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var body struct {
+		Token   string `json:"token"`
+		GuestID string `json:"guest_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Token == "" || body.GuestID == "" {
+		t.Fatalf("body = %s, want a guest token and ID", w.Body)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsGuest(r) || ClaimsFromContext(r).Subject != body.GuestID {
+			t.Errorf("claims = %+v, want the guest's", ClaimsFromContext(r))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"guest route", auth.GuestMiddleware(next), http.StatusNoContent},
+		{"optional route", auth.OptionalMiddleware(next), http.StatusNoContent},
+		{"user route", auth.Middleware(next), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cart", nil)
+			r.Header.Set("Authorization", "Bearer "+body.Token)
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
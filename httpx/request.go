@@ -0,0 +1,50 @@
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ValidateRequiredFields checks if required fields are not empty
+func ValidateRequiredFields(w http.ResponseWriter, fields map[string]string) bool {
+	for field, value := range fields {
+		if strings.TrimSpace(value) == "" {
+			RespondWithValidationError(w, field, "is required")
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateAndBindJSON validates and binds JSON input with proper error handling
+func ValidateAndBindJSON(w http.ResponseWriter, r *http.Request, target interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(target); err != nil {
+		RespondWithError(w, 400, err)
+		return false
+	}
+	return true
+}
+
+// ParseIfMatchVersion reads the expected document version from the If-Match header
+// It returns nil when the header is absent, so the update is unconditional
+func ParseIfMatchVersion(r *http.Request) (*int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("If-Match header must contain a document version")
+	}
+	return &version, nil
+}
+
+// SetVersionETag exposes a document version as an ETag so clients can send it back in If-Match
+func SetVersionETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
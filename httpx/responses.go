@@ -0,0 +1,89 @@
+// Package httpx provides JSON response, request binding and conditional request helpers
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader is the response header request ID middleware sets, which error responses repeat in their body
+const RequestIDHeader = "X-Request-ID"
+
+// ErrorResponse represents a standard error response
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// RespondWithError provides standardized error handling with proper HTTP codes
+func RespondWithError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:     err.Error(),
+		Code:      code,
+		Message:   GetErrorMessage(code),
+		RequestID: w.Header().Get(RequestIDHeader),
+	})
+}
+
+// RespondWithValidationError provides specific validation error handling
+func RespondWithValidationError(w http.ResponseWriter, field string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:     fmt.Sprintf("validation failed for field '%s': %s", field, message),
+		Code:      400,
+		Message:   "Validation Error",
+		RequestID: w.Header().Get(RequestIDHeader),
+	})
+}
+
+// RespondWithJSON sends a JSON response
+// Error responses given as a map get the request ID, if there is one, as "request_id".
+func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	if requestID := w.Header().Get(RequestIDHeader); code >= 400 && requestID != "" {
+		payload = withRequestID(payload, requestID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// withRequestID returns a copy of a map payload with the request ID added, leaving other payloads as they are
+func withRequestID(payload interface{}, requestID string) interface{} {
+	switch body := payload.(type) {
+	case map[string]string:
+		copied := make(map[string]string, len(body)+1)
+		for k, v := range body {
+			copied[k] = v
+		}
+		copied["request_id"] = requestID
+		return copied
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(body)+1)
+		for k, v := range body {
+			copied[k] = v
+		}
+		copied["request_id"] = requestID
+		return copied
+	}
+	return payload
+}
+
+// GetErrorMessage returns the standard message for an HTTP status code
+func GetErrorMessage(code int) string {
+	switch code {
+	case 400:
+		return "Bad Request"
+	case 404:
+		return "Not Found"
+	case 500:
+		return "Internal Server Error"
+	default:
+		return "Error"
+	}
+}
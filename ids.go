@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// crockfordAlphabet is Crockford's base32 alphabet (no I, L, O or U)
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// MaxSlugLength is the maximum length of generated slugs
+const MaxSlugLength = 64
+
+// maxUniqueSlugAttempts is how many numbered suffixes UniqueSlug tries before using a random one
+const maxUniqueSlugAttempts = 20
+
+// NewID generates a new time-ordered UUIDv7 string, the standard ID format for documents
+func NewID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return id.String(), nil
+}
+
+// UUIDv7Time extracts the creation time embedded in a UUIDv7
+func UUIDv7Time(id string) (time.Time, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid UUID %q: %w", id, err)
+	}
+
+	if parsed.Version() != 7 {
+		return time.Time{}, fmt.Errorf("UUID %q is version %d, not 7", id, parsed.Version())
+	}
+
+	sec, nsec := parsed.Time().UnixTime()
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// Slugify converts a name into a lowercase, URL-safe slug, e.g. "São Paulo Int'l" -> "sao-paulo-int-l"
+func Slugify(name string) string {
+	// Strip accents by decomposing characters and dropping combining marks
+	stripAccents := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	normalized, _, err := transform.String(stripAccents, name)
+	if err != nil {
+		normalized = name
+	}
+
+	var b strings.Builder
+	lastHyphen := true // Avoid a leading hyphen
+	for _, r := range strings.ToLower(normalized) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			lastHyphen = false
+		case !lastHyphen:
+			b.WriteByte('-')
+			lastHyphen = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > MaxSlugLength {
+		slug = strings.TrimSuffix(slug[:MaxSlugLength], "-")
+	}
+	return slug
+}
+
+// UniqueSlug generates a slug for name that exists reports as unused, appending "-2", "-3", ...
+// and finally a random suffix when the plain slug is taken
+func UniqueSlug(ctx context.Context, name string, exists func(ctx context.Context, slug string) (bool, error)) (string, error) {
+	base := Slugify(name)
+	if base == "" {
+		return "", ErrInvalidInput
+	}
+
+	candidate := base
+	for attempt := 2; attempt <= maxUniqueSlugAttempts+1; attempt++ {
+		taken, err := exists(ctx, candidate)
+		if err != nil {
+			return "", fmt.Errorf("failed to check slug %q: %w", candidate, err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = withSlugSuffix(base, fmt.Sprintf("%d", attempt))
+	}
+
+	suffix, err := NewPublicID(6)
+	if err != nil {
+		return "", err
+	}
+	candidate = withSlugSuffix(base, strings.ToLower(suffix))
+
+	taken, err := exists(ctx, candidate)
+	if err != nil {
+		return "", fmt.Errorf("failed to check slug %q: %w", candidate, err)
+	}
+	if taken {
+		return "", fmt.Errorf("could not generate a unique slug for %q", name)
+	}
+	return candidate, nil
+}
+
+// withSlugSuffix appends a suffix while keeping the slug within MaxSlugLength
+func withSlugSuffix(base, suffix string) string {
+	maxBase := MaxSlugLength - len(suffix) - 1
+	if len(base) > maxBase {
+		base = strings.TrimSuffix(base[:maxBase], "-")
+	}
+	return base + "-" + suffix
+}
+
+// newOpaqueToken generates a random 256-bit bearer token, such as a refresh token or session ID
+func newOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashOpaqueToken returns the stored form of an opaque token, so a database leak doesn't leak usable tokens
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewPublicID generates a random, case-insensitive public ID of the given length
+// using Crockford's base32 alphabet, suitable for short shareable identifiers
+func NewPublicID(length int) (string, error) {
+	if length <= 0 {
+		return "", ErrInvalidInput
+	}
+
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	id := make([]byte, length)
+	for i, b := range bytes {
+		// 256 is a multiple of 32, so masking keeps the distribution uniform
+		id[i] = crockfordAlphabet[b&31]
+	}
+	return string(id), nil
+}
+
+// NormalizePublicID canonicalizes a user-entered public ID: it upper-cases it, removes
+// hyphens, and maps the ambiguous letters I/L to 1 and O to 0 as Crockford's spec requires
+func NormalizePublicID(id string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(strings.TrimSpace(id)) {
+		switch r {
+		case '-':
+			continue
+		case 'I', 'L':
+			r = '1'
+		case 'O':
+			r = '0'
+		}
+
+		if !strings.ContainsRune(crockfordAlphabet, r) {
+			return "", fmt.Errorf("public ID contains invalid character %q", r)
+		}
+		b.WriteRune(r)
+	}
+
+	if b.Len() == 0 {
+		return "", ErrInvalidInput
+	}
+	return b.String(), nil
+}
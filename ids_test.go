@@ -0,0 +1,202 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewID(t *testing.T) {
+	const n = 10000
+	before := time.Now().Truncate(time.Millisecond)
+	ids := make([]string, n)
+	seen := make(map[string]bool, n)
+	for i := range ids {
+		id, err := NewID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuidV7Pattern.MatchString(id) {
+			t.Fatalf("NewID() = %q, not a lowercase UUIDv7", id)
+		}
+		if seen[id] {
+			t.Fatalf("NewID() returned %q twice", id)
+		}
+		seen[id] = true
+		ids[i] = id
+	}
+	after := time.Now()
+
+	// IDs sort in the order they were generated, so they index like creation times
+	if !slices.IsSorted(ids) {
+		t.Fatal("IDs don't sort in generation order")
+	}
+	for _, id := range []string{ids[0], ids[n-1]} {
+		created, err := UUIDv7Time(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Before(before) || created.After(after) {
+			t.Fatalf("UUIDv7Time(%q) = %v, want between %v and %v", id, created, before, after)
+		}
+	}
+}
+
+func TestUUIDv7Time(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"UUIDv7", "018f4c3a-7b00-7000-8000-000000000000", time.UnixMilli(0x018f4c3a7b00).UTC(), false},
+		{"uppercase UUIDv7", "018F4C3A-7B00-7000-8000-000000000000", time.UnixMilli(0x018f4c3a7b00).UTC(), false},
+		{"UUIDv4", "9b2e6f1a-3c4d-4e5f-8a6b-7c8d9e0f1a2b", time.Time{}, true},
+		{"not a UUID", "not-a-uuid", time.Time{}, true},
+		{"empty", "", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UUIDv7Time(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UUIDv7Time(%q) error = %v, want error %v", tt.id, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("UUIDv7Time(%q) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPublicID(t *testing.T) {
+	for _, length := range []int{1, 6, 10, 32} {
+		id, err := NewPublicID(length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != length || strings.Trim(id, crockfordAlphabet) != "" {
+			t.Fatalf("NewPublicID(%d) = %q, want %d Crockford base32 characters", length, id, length)
+		}
+		if normalized, err := NormalizePublicID(id); err != nil || normalized != id {
+			t.Fatalf("NormalizePublicID(%q) = %q, %v, want it unchanged", id, normalized, err)
+		}
+	}
+
+	for _, length := range []int{0, -1} {
+		if _, err := NewPublicID(length); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("NewPublicID(%d) error = %v, want %v", length, err, ErrInvalidInput)
+		}
+	}
+
+	// 50 random bits: 10,000 IDs collide with probability around 4e-8
+	seen := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		id, err := NewPublicID(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("NewPublicID(10) returned %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestNormalizePublicID(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"ABC123", "ABC123", false},
+		{"abc123", "ABC123", false},
+		{"  ab-c1-23 ", "ABC123", false},
+		{"IlOo", "1100", false},
+		{"U", "", true}, // Excluded from the alphabet
+		{"AB C", "", true},
+		{"AB_C", "", true},
+		{"ÄBC", "", true},
+		{"", "", true},
+		{"---", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := NormalizePublicID(tt.input)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("NormalizePublicID(%q) = %q, %v, want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"São Paulo Int'l", "sao-paulo-int-l"},
+		{"  Hello,   World!  ", "hello-world"},
+		{"Zürich—Genève", "zurich-geneve"},
+		{"---", ""},
+		{"東京", ""},
+		{"A1 B2", "a1-b2"},
+		{strings.Repeat("a", 70), strings.Repeat("a", MaxSlugLength)},
+		{strings.Repeat("a", 63) + " b", strings.Repeat("a", 63)}, // No trailing hyphen after truncation
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := Slugify(tt.input); got != tt.want {
+				t.Fatalf("Slugify(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name    string
+		input   string
+		taken   func(slug string) bool
+		lookup  error
+		want    string // "" when a random suffix is expected
+		wantErr error
+	}{
+		{"free", "My Trip", func(string) bool { return false }, nil, "my-trip", nil},
+		{"taken once", "My Trip", func(s string) bool { return s == "my-trip" }, nil, "my-trip-2", nil},
+		{"taken several times", "My Trip", func(s string) bool { return s == "my-trip" || s == "my-trip-2" || s == "my-trip-3" }, nil, "my-trip-4", nil},
+		{"suffix keeps the length", long, func(s string) bool { return len(s) == MaxSlugLength && !strings.Contains(s, "-") }, nil, strings.Repeat("a", MaxSlugLength-2) + "-2", nil},
+		{"every number taken", "My Trip", func(s string) bool { return s == "my-trip" || regexp.MustCompile(`^my-trip-\d{1,2}$`).MatchString(s) }, nil, "", nil},
+		{"nothing to slugify", "!!!", func(string) bool { return false }, nil, "", ErrInvalidInput},
+		{"lookup error", "My Trip", func(string) bool { return false }, errLookup, "", errLookup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UniqueSlug(context.Background(), tt.input, func(ctx context.Context, slug string) (bool, error) {
+				return tt.taken(slug), tt.lookup
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UniqueSlug(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) > MaxSlugLength {
+				t.Fatalf("UniqueSlug(%q) = %q, longer than %d", tt.input, got, MaxSlugLength)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Fatalf("UniqueSlug(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if tt.want == "" && !regexp.MustCompile(`^my-trip-[0-9a-z]{6}$`).MatchString(got) {
+				t.Fatalf("UniqueSlug(%q) = %q, want a random 6 character suffix", tt.input, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JobState is the lifecycle state of a background job
+type JobState string
+
+const (
+	JobStateRunning   JobState = "running"
+	JobStateSucceeded JobState = "succeeded"
+	JobStateFailed    JobState = "failed"
+)
+
+// maxJobErrors caps the errors stored on a job so a failing bulk operation can't grow the document unbounded
+const maxJobErrors = 100
+
+// Job is the pollable status of a long-running operation such as a bulk import, bulk email or export
+type Job struct {
+	ID         string     `json:"id" bson:"_id"`
+	Type       string     `json:"type" bson:"type"`         // Operation name, e.g. "bulk_email"
+	Owner      string     `json:"-" bson:"owner"`           // ID of the user allowed to poll the job
+	State      JobState   `json:"state" bson:"state"`       // Current state
+	Progress   int        `json:"progress" bson:"progress"` // Percent complete, 0 to 100
+	Errors     []string   `json:"errors" bson:"errors"`     // Most recent item errors, capped at 100
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" bson:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at" bson:"finished_at"`
+}
+
+// JobFunc performs a job's work, reporting progress as it goes
+type JobFunc func(ctx context.Context, progress *JobProgress) error
+
+// JobStore records job status in the database's jobs collection
+type JobStore struct {
+	collection *mongo.Collection
+}
+
+// NewJobStore creates a job store backed by the database's jobs collection
+func NewJobStore(database *mongo.Database) *JobStore {
+	return &JobStore{collection: database.Collection("jobs")}
+}
+
+// Start records a running job and performs fn on the background runner, returning the job so callers
+// can respond with its ID straight away; fn's context is cancelled on shutdown, not with the request
+func (s *JobStore) Start(ctx context.Context, jobType, owner string, fn JobFunc) (*Job, error) {
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Type:      jobType,
+		Owner:     owner,
+		State:     JobStateRunning,
+		Errors:    []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := s.collection.InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	err = currentBackgroundRunner().Go("job "+job.ID, func(ctx context.Context) {
+		s.run(ctx, job, fn)
+	})
+	if err != nil {
+		s.finish(ctx, job, err)
+		return nil, err
+	}
+	return job, nil
+}
+
+// run performs fn and records its outcome
+func (s *JobStore) run(ctx context.Context, job *Job, fn JobFunc) {
+	progress := &JobProgress{store: s, id: job.ID}
+
+	err := func() (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("job panicked: %v", recovered)
+			}
+		}()
+		return fn(ctx, progress)
+	}()
+
+	// Record the outcome even if the job was cancelled by shutdown
+	s.finish(context.WithoutCancel(ctx), job, err)
+}
+
+// finish records a job as succeeded, or failed with err
+func (s *JobStore) finish(ctx context.Context, job *Job, err error) {
+	finished := time.Now()
+	set := bson.M{"state": JobStateSucceeded, "progress": 100, "updated_at": finished, "finished_at": finished}
+	update := bson.M{"$set": set}
+	if err != nil {
+		log.Printf("Job %s (%s) failed: %v", job.ID, job.Type, err)
+		set["state"] = JobStateFailed
+		delete(set, "progress")
+		update["$push"] = jobErrorPush(err.Error())
+	}
+
+	if _, err := s.collection.UpdateOne(ctx, bson.M{"_id": job.ID}, update); err != nil {
+		log.Printf("Failed to record result of job %s: %v", job.ID, err)
+	}
+}
+
+// Get returns a job by ID
+func (s *JobStore) Get(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// JobProgress lets a running job report progress and item errors
+type JobProgress struct {
+	store *JobStore
+	id    string
+}
+
+// Update records done out of total items as a percentage
+func (p *JobProgress) Update(ctx context.Context, done, total int) {
+	percent := 100
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	// Finishing is recorded by the job itself, so progress stops short of 100
+	percent = min(max(percent, 0), 99)
+
+	_, err := p.store.collection.UpdateOne(ctx, bson.M{"_id": p.id},
+		bson.M{"$set": bson.M{"progress": percent, "updated_at": time.Now()}})
+	if err != nil {
+		log.Printf("Failed to update progress of job %s: %v", p.id, err)
+	}
+}
+
+// Error records a non-fatal error, e.g. one row of an import failing
+func (p *JobProgress) Error(ctx context.Context, message string) {
+	_, err := p.store.collection.UpdateOne(ctx, bson.M{"_id": p.id},
+		bson.M{"$push": jobErrorPush(message), "$set": bson.M{"updated_at": time.Now()}})
+	if err != nil {
+		log.Printf("Failed to record error on job %s: %v", p.id, err)
+	}
+}
+
+// jobErrorPush appends an error to a job, keeping only the most recent maxJobErrors
+func jobErrorPush(message string) bson.M {
+	return bson.M{"errors": bson.M{"$each": bson.A{message}, "$slice": -maxJobErrors}}
+}
+
+// GetJobHandler responds with the status of the job named by the {id} path parameter
+// Only the user who started a job can see it.
+func GetJobHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	job, err := NewJobStore(database).Get(r.Context(), GetPathParam(r, "id"))
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && job.Owner != userID) {
+		RespondWithJSON(w, http.StatusNotFound, map[string]string{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get job: %v", err)
+		RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get job"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	RespondWithJSON(w, http.StatusOK, job)
+}
+
+// StartBulkEmailJob sends a bulk email as a background job, reporting progress per SES chunk
+func StartBulkEmailJob(ctx context.Context, store *JobStore, owner string, recipients []Recipient, templateName string, perRecipientData map[string]any, fromEmail string) (*Job, error) {
+	return store.Start(ctx, "bulk_email", owner, func(ctx context.Context, progress *JobProgress) error {
+		for start := 0; start < len(recipients); start += sesMaxBulkDestinations {
+			end := min(start+sesMaxBulkDestinations, len(recipients))
+
+			results, err := SendBulkEmail(recipients[start:end], templateName, perRecipientData, fromEmail)
+			if err != nil {
+				return err
+			}
+			for _, result := range results {
+				if result.Error != "" {
+					progress.Error(ctx, fmt.Sprintf("%s: %s", result.Email, result.Error))
+				}
+			}
+			progress.Update(ctx, end, len(recipients))
+		}
+		return nil
+	})
+}
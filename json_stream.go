@@ -0,0 +1,154 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+var (
+	ErrTooManyItems     = errors.New("too many items")
+	ErrItemTooLarge     = errors.New("item too large")
+	ErrRequestTooLarge  = errors.New("request body too large")
+	ErrExpectedJSONList = errors.New("expected a JSON array")
+)
+
+// StreamLimits bounds the work done decoding a JSON array one item at a time
+type StreamLimits struct {
+	MaxItems      int   // Maximum number of array items
+	MaxItemBytes  int64 // Maximum encoded size of a single item
+	MaxTotalBytes int64 // Maximum size of the whole body
+	Strict        bool  // Reject items with unknown fields
+}
+
+// DefaultStreamLimits returns limits suitable for admin batch endpoints on small pods
+func DefaultStreamLimits() StreamLimits {
+	return StreamLimits{
+		MaxItems:      10000,
+		MaxItemBytes:  64 * 1024,
+		MaxTotalBytes: 16 * 1024 * 1024,
+	}
+}
+
+// StreamItemError reports which array item stopped a streaming decode
+type StreamItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *StreamItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *StreamItemError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeJSONArray decodes a JSON array from r one item at a time, calling handle for each item
+// so the whole array is never held in memory. Decoding stops at the first malformed or oversized
+// item, or the first error returned by handle. It returns the number of items handled.
+func DecodeJSONArray[T any](r io.Reader, limits StreamLimits, handle func(index int, item T) error) (int, error) {
+	if limits.MaxTotalBytes > 0 {
+		r = &limitedReader{r: r, remaining: limits.MaxTotalBytes}
+	}
+
+	decoder := json.NewDecoder(r)
+	if limits.Strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, wrapStreamError(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return 0, ErrExpectedJSONList
+	}
+
+	count := 0
+	for decoder.More() {
+		if limits.MaxItems > 0 && count >= limits.MaxItems {
+			return count, &StreamItemError{Index: count, Err: ErrTooManyItems}
+		}
+
+		start := decoder.InputOffset()
+		var item T
+		if err := decoder.Decode(&item); err != nil {
+			return count, &StreamItemError{Index: count, Err: wrapStreamError(err)}
+		}
+		if limits.MaxItemBytes > 0 && decoder.InputOffset()-start > limits.MaxItemBytes {
+			return count, &StreamItemError{Index: count, Err: ErrItemTooLarge}
+		}
+
+		if err := handle(count, item); err != nil {
+			return count, &StreamItemError{Index: count, Err: err}
+		}
+		count++
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return count, wrapStreamError(err)
+	}
+	return count, nil
+}
+
+// StreamJSONArray decodes a request body with DecodeJSONArray and responds with an error if it fails
+// It returns the number of items handled and whether the whole body was processed
+func StreamJSONArray[T any](w http.ResponseWriter, r *http.Request, limits StreamLimits, handle func(index int, item T) error) (int, bool) {
+	count, err := DecodeJSONArray(r.Body, limits, handle)
+	if err == nil {
+		return count, true
+	}
+
+	code := http.StatusBadRequest
+	if errors.Is(err, ErrRequestTooLarge) || errors.Is(err, ErrItemTooLarge) || errors.Is(err, ErrTooManyItems) {
+		code = http.StatusRequestEntityTooLarge
+	}
+
+	response := map[string]interface{}{
+		"error":     err.Error(),
+		"processed": count,
+	}
+	var itemErr *StreamItemError
+	if errors.As(err, &itemErr) {
+		response["index"] = itemErr.Index
+	}
+
+	log.Printf("Aborted streaming decode after %d items: %v", count, err)
+	RespondWithJSON(w, code, response)
+	return count, false
+}
+
+// wrapStreamError maps a truncated stream caused by the total size limit to ErrRequestTooLarge
+func wrapStreamError(err error) error {
+	if errors.Is(err, ErrRequestTooLarge) {
+		return ErrRequestTooLarge
+	}
+	return err
+}
+
+// limitedReader fails with ErrRequestTooLarge, rather than a silent EOF, once the limit is exceeded
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// A body of exactly the limit is fine; only fail if more data follows
+		var probe [1]byte
+		if n, err := l.r.Read(probe[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, ErrRequestTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type streamItem struct {
+	Name string `json:"name"`
+}
+
+func TestDecodeJSONArray(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		name      string
+		body      string
+		limits    StreamLimits
+		stopAt    int // Index handle fails at; -1 never
+		want      int
+		wantErr   error
+		wantIndex int // Index in the StreamItemError; -1 if the error isn't about an item
+	}{
+		{"empty array", `[]`, StreamLimits{}, -1, 0, nil, -1},
+		{"items", `[{"name":"a"}, {"name":"b"}]`, StreamLimits{}, -1, 2, nil, -1},
+		{"not an array", `{"name":"a"}`, StreamLimits{}, -1, 0, ErrExpectedJSONList, -1},
+		{"scalar", `"a"`, StreamLimits{}, -1, 0, ErrExpectedJSONList, -1},
+		{"malformed item", `[{"name":"a"}, {"name":]`, StreamLimits{}, -1, 1, nil, 1},
+		{"wrong item type", `[{"name":"a"}, 3]`, StreamLimits{}, -1, 1, nil, 1},
+		{"unterminated array", `[{"name":"a"}`, StreamLimits{}, -1, 1, nil, 1},
+		{"missing closing bracket after a comma", `[{"name":"a"},`, StreamLimits{}, -1, 1, nil, 1},
+		{"unknown field allowed", `[{"name":"a","extra":1}]`, StreamLimits{}, -1, 1, nil, -1},
+		{"unknown field in strict mode", `[{"name":"a","extra":1}]`, StreamLimits{Strict: true}, -1, 0, nil, 0},
+		{"at the item limit", `[{"name":"a"},{"name":"b"}]`, StreamLimits{MaxItems: 2}, -1, 2, nil, -1},
+		{"over the item limit", `[{"name":"a"},{"name":"b"},{"name":"c"}]`, StreamLimits{MaxItems: 2}, -1, 2, ErrTooManyItems, 2},
+		{"oversized item", `[{"name":"a"},{"name":"` + strings.Repeat("x", 100) + `"}]`, StreamLimits{MaxItemBytes: 50}, -1, 1, ErrItemTooLarge, 1},
+		{"body at the size limit", `[{"name":"a"}]`, StreamLimits{MaxTotalBytes: int64(len(`[{"name":"a"}]`))}, -1, 1, nil, -1},
+		{"body over the size limit", `[{"name":"a"},{"name":"b"}]`, StreamLimits{MaxTotalBytes: 20}, -1, 1, ErrRequestTooLarge, 1},
+		{"handler error", `[{"name":"a"},{"name":"b"}]`, StreamLimits{}, 1, 1, errStop, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			count, err := DecodeJSONArray(strings.NewReader(tt.body), tt.limits, func(index int, item streamItem) error {
+				if index == tt.stopAt {
+					return errStop
+				}
+				names = append(names, item.Name)
+				return nil
+			})
+			if count != tt.want || len(names) != tt.want {
+				t.Fatalf("count = %d with %d items handled, want %d", count, len(names), tt.want)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if wantFail := tt.wantErr != nil || tt.wantIndex >= 0; (err != nil) != wantFail {
+				t.Fatalf("error = %v, want failure %v", err, wantFail)
+			}
+			var itemErr *StreamItemError
+			if isItemErr := errors.As(err, &itemErr); isItemErr != (tt.wantIndex >= 0) || (isItemErr && itemErr.Index != tt.wantIndex) {
+				t.Fatalf("error = %v, want an error at item %d", err, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		limits StreamLimits
+		ok     bool
+		want   int // Status code written on failure
+	}{
+		{"valid body", `[{"name":"a"}]`, StreamLimits{}, true, 0},
+		{"malformed body", `[{"name":`, StreamLimits{}, false, http.StatusBadRequest},
+		{"too many items", `[{},{}]`, StreamLimits{MaxItems: 1}, false, http.StatusRequestEntityTooLarge},
+		{"item too large", `[{"name":"abcdefghij"}]`, StreamLimits{MaxItemBytes: 5}, false, http.StatusRequestEntityTooLarge},
+		{"body too large", `[{"name":"abcdefghij"}]`, StreamLimits{MaxTotalBytes: 5}, false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			r := httptest.NewRequest(http.MethodPost, "/admin/batch", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			_, ok := StreamJSONArray(w, r, tt.limits, func(index int, item streamItem) error { return nil })
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v: %s", ok, tt.ok, w.Body)
+			}
+			if tt.ok {
+				if w.Body.Len() != 0 {
+					t.Fatalf("wrote %q on success", w.Body)
+				}
+				return
+			}
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := body["processed"]; !ok {
+				t.Fatalf("response %v doesn't report the items processed", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+)
+
+// jwksMaxAge is how long clients may cache the JWKS; publish new keys at least this long before signing with them
+const jwksMaxAge = "public, max-age=300"
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"` // OKP keys
+	X         string `json:"x,omitempty"`   // OKP and EC keys
+	Y         string `json:"y,omitempty"`   // EC keys
+	N         string `json:"n,omitempty"`   // RSA keys
+	E         string `json:"e,omitempty"`   // RSA keys
+}
+
+// JWKSet is a JSON Web Key Set, as served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// publicJWK returns the public half of key as a JWK; HMAC keys are secret and have none
+func publicJWK(key SigningKey) (JWK, bool) {
+	jwk := JWK{KeyID: key.ID, Use: "sig"}
+	if key.Method != nil {
+		jwk.Algorithm = key.Method.Alg()
+	}
+
+	switch public := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// PublicKey decodes the JWK into an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(name, value string) ([]byte, error) {
+		bytes, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(bytes) == 0 {
+			return nil, fmt.Errorf("JWK %q has an invalid %s", k.KeyID, name)
+		}
+		return bytes, nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode("modulus", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("exponent", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+			return nil, fmt.Errorf("JWK %q has an invalid exponent", k.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("JWK %q has unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, err := decode("x coordinate", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y coordinate", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		public := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("JWK %q is not on curve %s", k.KeyID, k.Curve)
+		}
+		return public, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("JWK %q has unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, err := decode("x coordinate", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("JWK %q has an invalid x coordinate", k.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("JWK %q has unsupported key type %q", k.KeyID, k.KeyType)
+	}
+}
+
+// thumbprint returns the key's RFC 7638 thumbprint, used as its default key ID
+func (k JWK) thumbprint() string {
+	// The thumbprint hashes only the required members, in lexicographic order
+	var members any
+	switch k.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.KeyType, k.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Curve, k.KeyType, k.X}
+	}
+
+	encoded, _ := json.Marshal(members)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS returns the public verification keys, including keys still in their rotation grace period
+func (s *JWTKeySet) JWKS() JWKSet {
+	_, keys := s.keys()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range keys {
+		if jwk, ok := publicJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// JWKSHandler serves the set's public verification keys so other services can validate access tokens
+func (s *JWTKeySet) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", jwksMaxAge)
+	RespondWithJSON(w, 200, s.JWKS())
+}
+
+// JWKS returns the public keys set with SetJWTSigningKey and related functions
+func JWKS() JWKSet {
+	return defaultJWTKeys.JWKS()
+}
+
+// JWKSHandler serves the public keys set with SetJWTSigningKey and related functions
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	defaultJWTKeys.JWKSHandler(w, r)
+}
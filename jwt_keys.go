@@ -0,0 +1,360 @@
+package common
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no JWT signing key configured")
+
+// SigningKey is a key that signs and verifies access tokens
+// Verify-only keys, e.g. on a resource server holding just the public key, have no private key.
+type SigningKey struct {
+	ID         string // Sent as the token's kid header; derived from the public key if empty
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey // []byte for HMAC, *rsa.PrivateKey or ed25519.PrivateKey; nil to only verify
+	PublicKey  crypto.PublicKey  // []byte for HMAC, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// HMACSigningKey returns an HS512 key for a shared secret, the scheme Login uses with JWT_SECRET
+func HMACSigningKey(secret string) SigningKey {
+	return SigningKey{Method: jwt.SigningMethodHS512, PrivateKey: []byte(secret), PublicKey: []byte(secret)}
+}
+
+// RSASigningKeyFromPEM returns an RS256 key from a PEM-encoded RSA private key
+func RSASigningKeyFromPEM(privatePEM []byte) (SigningKey, error) {
+	private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("invalid RSA private key: %w", err)
+	}
+	return SigningKey{Method: jwt.SigningMethodRS256, PrivateKey: private, PublicKey: &private.PublicKey}, nil
+}
+
+// RSAVerificationKeyFromPEM returns a verify-only RS256 key from a PEM-encoded RSA public key
+func RSAVerificationKeyFromPEM(publicPEM []byte) (SigningKey, error) {
+	public, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("invalid RSA public key: %w", err)
+	}
+	return SigningKey{Method: jwt.SigningMethodRS256, PublicKey: public}, nil
+}
+
+// Ed25519SigningKeyFromPEM returns an EdDSA key from a PEM-encoded Ed25519 private key
+func Ed25519SigningKeyFromPEM(privatePEM []byte) (SigningKey, error) {
+	private, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("invalid Ed25519 private key: %w", err)
+	}
+	edPrivate := private.(ed25519.PrivateKey)
+	return SigningKey{Method: jwt.SigningMethodEdDSA, PrivateKey: edPrivate, PublicKey: edPrivate.Public()}, nil
+}
+
+// Ed25519VerificationKeyFromPEM returns a verify-only EdDSA key from a PEM-encoded Ed25519 public key
+func Ed25519VerificationKeyFromPEM(publicPEM []byte) (SigningKey, error) {
+	public, err := jwt.ParseEdPublicKeyFromPEM(publicPEM)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("invalid Ed25519 public key: %w", err)
+	}
+	return SigningKey{Method: jwt.SigningMethodEdDSA, PublicKey: public}, nil
+}
+
+// validate checks that the key's material matches its signing method
+func (k SigningKey) validate() error {
+	if k.Method == nil || k.PublicKey == nil {
+		return fmt.Errorf("signing key needs a method and a public key")
+	}
+
+	var publicOK, privateOK bool
+	switch k.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret, ok := k.PublicKey.([]byte)
+		if ok && len(secret) < 32 {
+			return fmt.Errorf("HMAC secret must be at least 32 bytes long")
+		}
+		publicOK = ok
+		_, privateOK = k.PrivateKey.([]byte)
+	case *jwt.SigningMethodRSA:
+		_, publicOK = k.PublicKey.(*rsa.PublicKey)
+		_, privateOK = k.PrivateKey.(*rsa.PrivateKey)
+	case *jwt.SigningMethodEd25519:
+		_, publicOK = k.PublicKey.(ed25519.PublicKey)
+		_, privateOK = k.PrivateKey.(ed25519.PrivateKey)
+	default:
+		return fmt.Errorf("unsupported signing method %s", k.Method.Alg())
+	}
+
+	if !publicOK || (k.PrivateKey != nil && !privateOK) {
+		return fmt.Errorf("key material does not match signing method %s", k.Method.Alg())
+	}
+	return nil
+}
+
+// withID returns the key with its ID set, deriving it from the public key's JWK thumbprint if empty
+func (k SigningKey) withID() SigningKey {
+	if k.ID == "" {
+		if jwk, ok := publicJWK(k); ok {
+			k.ID = jwk.thumbprint()
+		}
+	}
+	return k
+}
+
+// verificationKey is a key Authenticate accepts, until retireAt if it was rotated out
+type verificationKey struct {
+	key      SigningKey
+	retireAt time.Time
+}
+
+// active reports whether the key is still accepted at now
+func (v verificationKey) active(now time.Time) bool {
+	return v.retireAt.IsZero() || now.Before(v.retireAt)
+}
+
+// JWTKeySet holds a signing key and the verification keys accepted for access tokens, rotated without
+// invalidating outstanding tokens. An empty set means tokens use HMAC with the shared secret.
+type JWTKeySet struct {
+	mu           sync.RWMutex
+	signing      *SigningKey
+	verification []verificationKey
+}
+
+// defaultJWTKeys is the key set used by Login, Authenticate and JWKSHandler, and by an Auth without its own
+var defaultJWTKeys = &JWTKeySet{}
+
+// NewJWTKeySet creates a key set that signs with key, which may be a verify-only key for services
+// that accept tokens but never issue them
+func NewJWTKeySet(key SigningKey) (*JWTKeySet, error) {
+	set := &JWTKeySet{}
+	if key.PrivateKey == nil {
+		return set, set.SetVerificationKeys(key)
+	}
+	return set, set.SetSigningKey(key)
+}
+
+// SetSigningKey makes key the signing key and the only verification key
+// It replaces every configured key; use RotateSigningKey to keep outstanding tokens valid.
+func (s *JWTKeySet) SetSigningKey(key SigningKey) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	if key.PrivateKey == nil {
+		return ErrNoSigningKey
+	}
+	key = key.withID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signing = &key
+	s.verification = []verificationKey{{key: key}}
+	return nil
+}
+
+// SetVerificationKeys replaces the verification keys; the signing key, if any, stays valid
+func (s *JWTKeySet) SetVerificationKeys(keys ...SigningKey) error {
+	verification := make([]verificationKey, 0, len(keys)+1)
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			return err
+		}
+		verification = append(verification, verificationKey{key: key.withID()})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signing != nil && !containsJWTKey(verification, s.signing.ID) {
+		verification = append(verification, verificationKey{key: *s.signing})
+	}
+	s.verification = verification
+	return nil
+}
+
+// AddVerificationKey adds an accepted key, replacing any key with the same ID
+// Publish the next signing key this way ahead of RotateSigningKey so other services learn it first.
+func (s *JWTKeySet) AddVerificationKey(key SigningKey) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	key = key.withID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verification = append(removeJWTKey(s.verification, key.ID), verificationKey{key: key})
+	return nil
+}
+
+// addRetiringKey accepts key until retireAt, unless a key with its ID is already accepted
+func (s *JWTKeySet) addRetiringKey(key SigningKey, retireAt time.Time) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	key = key.withID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !containsJWTKey(s.verification, key.ID) {
+		s.verification = append(s.verification, verificationKey{key: key, retireAt: retireAt})
+	}
+	return nil
+}
+
+// RemoveVerificationKey stops accepting tokens signed with the key with ID id
+// The current signing key can't be removed.
+func (s *JWTKeySet) RemoveVerificationKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signing != nil && s.signing.ID == id {
+		return fmt.Errorf("key %q is the current signing key", id)
+	}
+	s.verification = removeJWTKey(s.verification, id)
+	return nil
+}
+
+// RotateSigningKey makes key the signing key; the previous one still verifies tokens for grace,
+// after which it is dropped. If grace is zero, AccessTokenTTL is used, so no outstanding token is invalidated.
+func (s *JWTKeySet) RotateSigningKey(key SigningKey, grace time.Duration) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	if key.PrivateKey == nil {
+		return ErrNoSigningKey
+	}
+	if grace <= 0 {
+		grace = AccessTokenTTL
+	}
+	key = key.withID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	verification := make([]verificationKey, 0, len(s.verification)+1)
+	for _, k := range s.verification {
+		// Drop keys whose grace period is over, along with any older copy of the new key
+		if !k.active(now) || k.key.ID == key.ID {
+			continue
+		}
+		if s.signing != nil && k.key.ID == s.signing.ID {
+			k.retireAt = now.Add(grace)
+		}
+		verification = append(verification, k)
+	}
+	s.signing = &key
+	s.verification = append(verification, verificationKey{key: key})
+	return nil
+}
+
+// keys returns the signing key, which may be nil, and the verification keys still active
+func (s *JWTKeySet) keys() (*SigningKey, []SigningKey) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]SigningKey, 0, len(s.verification))
+	for _, k := range s.verification {
+		if k.active(now) {
+			keys = append(keys, k.key)
+		}
+	}
+	return s.signing, keys
+}
+
+// sign signs claims with the signing key, or HMAC with secret if there is none
+func (s *JWTKeySet) sign(claims jwt.Claims, secret string) (string, error) {
+	key, _ := s.keys()
+	if key == nil {
+		hmac := HMACSigningKey(secret)
+		key = &hmac
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.PrivateKey)
+}
+
+// keyfunc returns a jwt.Keyfunc that accepts the verification keys, or HMAC with secret if there are none
+// Only keys for the token's algorithm, and matching its kid if it has one, are tried,
+// so a token can't choose a different algorithm for a key.
+func (s *JWTKeySet) keyfunc(secret string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		_, keys := s.keys()
+		if len(keys) == 0 {
+			keys = []SigningKey{HMACSigningKey(secret)}
+		}
+		kid, _ := token.Header["kid"].(string)
+
+		var set jwt.VerificationKeySet
+		for _, key := range keys {
+			if key.Method.Alg() == token.Method.Alg() && (kid == "" || kid == key.ID) {
+				set.Keys = append(set.Keys, key.PublicKey)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("no key for signing method %v and key ID %q", token.Header["alg"], kid)
+		}
+		return set, nil
+	}
+}
+
+// usesSecret reports whether tokens are verified with the shared secret rather than configured keys
+func (s *JWTKeySet) usesSecret() bool {
+	_, keys := s.keys()
+	return len(keys) == 0
+}
+
+// SetJWTSigningKey makes Login sign access tokens with key, and Authenticate verify them with it,
+// instead of HMAC with JWT_SECRET. Pass a key from RSASigningKeyFromPEM or Ed25519SigningKeyFromPEM
+// so resource servers can verify tokens with only the public key.
+// It replaces every configured key; use RotateJWTSigningKey to keep outstanding tokens valid.
+func SetJWTSigningKey(key SigningKey) error {
+	return defaultJWTKeys.SetSigningKey(key)
+}
+
+// SetJWTVerificationKeys makes Authenticate verify access tokens with any of keys instead of HMAC with
+// JWT_SECRET, for services that accept tokens but never issue them. The signing key, if any, stays valid.
+func SetJWTVerificationKeys(keys ...SigningKey) error {
+	return defaultJWTKeys.SetVerificationKeys(keys...)
+}
+
+// AddJWTVerificationKey adds a key Authenticate accepts, see JWTKeySet.AddVerificationKey
+func AddJWTVerificationKey(key SigningKey) error {
+	return defaultJWTKeys.AddVerificationKey(key)
+}
+
+// RemoveJWTVerificationKey stops Authenticate accepting tokens signed with the key with ID id
+func RemoveJWTVerificationKey(id string) error {
+	return defaultJWTKeys.RemoveVerificationKey(id)
+}
+
+// RotateJWTSigningKey makes key the signing key, see JWTKeySet.RotateSigningKey
+func RotateJWTSigningKey(key SigningKey, grace time.Duration) error {
+	return defaultJWTKeys.RotateSigningKey(key, grace)
+}
+
+// containsJWTKey reports whether keys contains a key with ID id
+func containsJWTKey(keys []verificationKey, id string) bool {
+	for _, k := range keys {
+		if k.key.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// removeJWTKey returns keys without the key with ID id
+func removeJWTKey(keys []verificationKey, id string) []verificationKey {
+	kept := make([]verificationKey, 0, len(keys))
+	for _, k := range keys {
+		if k.key.ID != id {
+			kept = append(kept, k)
+		}
+	}
+	return kept
+}
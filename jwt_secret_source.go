@@ -0,0 +1,307 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// DefaultJWTSecretRefreshInterval is how often a JWTSecretWatcher checks its source for a rotated secret
+const DefaultJWTSecretRefreshInterval = 5 * time.Minute
+
+// JWTSecretVersion is one version of a signing secret held in a secret store
+type JWTSecretVersion struct {
+	ID    string // Version ID, sent as the kid of tokens signed with it
+	Value string // HMAC secret of at least 32 characters, or a PEM-encoded RSA or Ed25519 private key
+}
+
+// JWTSecrets are the versions of a signing secret a service should know about
+type JWTSecrets struct {
+	Current  JWTSecretVersion  // Signs new tokens
+	Previous *JWTSecretVersion // The version Current replaced, still accepted during the rotation window
+	Pending  *JWTSecretVersion // A version being rotated in, accepted before any instance signs with it
+}
+
+// JWTSecretSource fetches a signing secret from a secret store
+type JWTSecretSource interface {
+	FetchJWTSecret(ctx context.Context) (JWTSecrets, error)
+}
+
+// SecretsManagerClient is the subset of the Secrets Manager client used by SecretsManagerJWTSecret
+type SecretsManagerClient interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretsManagerJWTSecret reads the signing secret from an AWS Secrets Manager secret, using its
+// AWSCURRENT, AWSPREVIOUS and AWSPENDING stages so Secrets Manager rotation keeps tokens valid
+type SecretsManagerJWTSecret struct {
+	Client   SecretsManagerClient
+	SecretID string // Name or ARN
+}
+
+// NewSecretsManagerJWTSecret reads the signing secret from secretID with the default AWS configuration
+func NewSecretsManagerJWTSecret(ctx context.Context, secretID string) (*SecretsManagerJWTSecret, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &SecretsManagerJWTSecret{Client: secretsmanager.NewFromConfig(cfg), SecretID: secretID}, nil
+}
+
+// FetchJWTSecret returns the secret's current version and any previous and pending ones
+func (s *SecretsManagerJWTSecret) FetchJWTSecret(ctx context.Context) (JWTSecrets, error) {
+	current, err := s.version(ctx, "AWSCURRENT")
+	if err != nil {
+		return JWTSecrets{}, err
+	}
+	if current == nil {
+		return JWTSecrets{}, fmt.Errorf("secret %s not found", s.SecretID)
+	}
+	previous, err := s.version(ctx, "AWSPREVIOUS")
+	if err != nil {
+		return JWTSecrets{}, err
+	}
+	pending, err := s.version(ctx, "AWSPENDING")
+	if err != nil {
+		return JWTSecrets{}, err
+	}
+	return JWTSecrets{Current: *current, Previous: previous, Pending: pending}, nil
+}
+
+// version returns the secret version with a staging label, or nil if no version has it
+func (s *SecretsManagerJWTSecret) version(ctx context.Context, stage string) (*JWTSecretVersion, error) {
+	output, err := s.Client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(s.SecretID),
+		VersionStage: aws.String(stage),
+	})
+	var notFound *smtypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", s.SecretID, err)
+	}
+	if output.SecretString == nil {
+		return nil, fmt.Errorf("secret %s has no string value", s.SecretID)
+	}
+	return &JWTSecretVersion{ID: aws.ToString(output.VersionId), Value: *output.SecretString}, nil
+}
+
+// SSMClient is the subset of the Systems Manager client used by SSMJWTSecret
+type SSMClient interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SSMJWTSecret reads the signing secret from an SSM Parameter Store SecureString parameter
+// Writing a new value rotates it; the version before the latest is still accepted during the rotation window.
+type SSMJWTSecret struct {
+	Client SSMClient
+	Name   string
+}
+
+// NewSSMJWTSecret reads the signing secret from the parameter name with the default AWS configuration
+func NewSSMJWTSecret(ctx context.Context, name string) (*SSMJWTSecret, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &SSMJWTSecret{Client: ssm.NewFromConfig(cfg), Name: name}, nil
+}
+
+// FetchJWTSecret returns the parameter's latest version and the one before it
+func (s *SSMJWTSecret) FetchJWTSecret(ctx context.Context) (JWTSecrets, error) {
+	current, err := s.version(ctx, s.Name)
+	if err != nil {
+		return JWTSecrets{}, err
+	}
+	if current == nil {
+		return JWTSecrets{}, fmt.Errorf("parameter %s not found", s.Name)
+	}
+
+	secrets := JWTSecrets{Current: *current}
+	if number, _ := strconv.ParseInt(current.ID, 10, 64); number > 1 {
+		secrets.Previous, err = s.version(ctx, s.Name+":"+strconv.FormatInt(number-1, 10))
+		if err != nil {
+			return JWTSecrets{}, err
+		}
+	}
+	return secrets, nil
+}
+
+// version returns the parameter version selected by name, e.g. "/app/jwt:3", or nil if it doesn't exist
+func (s *SSMJWTSecret) version(ctx context.Context, name string) (*JWTSecretVersion, error) {
+	output, err := s.Client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	var parameterNotFound *ssmtypes.ParameterNotFound
+	var versionNotFound *ssmtypes.ParameterVersionNotFound
+	if errors.As(err, &parameterNotFound) || errors.As(err, &versionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parameter %s: %w", s.Name, err)
+	}
+	return &JWTSecretVersion{
+		ID:    strconv.FormatInt(output.Parameter.Version, 10),
+		Value: aws.ToString(output.Parameter.Value),
+	}, nil
+}
+
+// jwtSecretKey returns the signing key a secret version holds, named after the version
+// Surrounding whitespace is ignored, so values stored with a trailing newline still match other services.
+func jwtSecretKey(version JWTSecretVersion) (SigningKey, error) {
+	value := strings.TrimSpace(version.Value)
+	key := HMACSigningKey(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		var err error
+		if key, err = RSASigningKeyFromPEM([]byte(value)); err != nil {
+			if key, err = Ed25519SigningKeyFromPEM([]byte(value)); err != nil {
+				return SigningKey{}, fmt.Errorf("secret version %s holds neither an RSA nor an Ed25519 private key", version.ID)
+			}
+		}
+	}
+	key.ID = version.ID
+	return key, nil
+}
+
+// JWTSecretWatcher keeps a key set signing with the current version of a secret from a secret store
+type JWTSecretWatcher struct {
+	source   JWTSecretSource
+	keys     *JWTKeySet
+	interval time.Duration
+
+	mu      sync.Mutex
+	current string // Version ID of the signing key
+	pending string // Version ID of the pending key accepted, if any
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// WatchJWTSecret makes keys sign with the secret from source, instead of a static JWT_SECRET, and checks for
+// a rotated secret every interval until Stop. Pass nil keys for the set used by Login and Authenticate, and
+// a zero interval for DefaultJWTSecretRefreshInterval. It fails if the secret can't be loaded, so a service
+// doesn't start without a key; later failures are logged and the loaded keys kept.
+func WatchJWTSecret(ctx context.Context, source JWTSecretSource, keys *JWTKeySet, interval time.Duration) (*JWTSecretWatcher, error) {
+	if keys == nil {
+		keys = defaultJWTKeys
+	}
+	if interval <= 0 {
+		interval = DefaultJWTSecretRefreshInterval
+	}
+
+	w := &JWTSecretWatcher{source: source, keys: keys, interval: interval, done: make(chan struct{})}
+	if err := w.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load JWT secret: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go w.run(runCtx)
+	return w, nil
+}
+
+// run refreshes the secret every interval until ctx is cancelled
+func (w *JWTSecretWatcher) run(ctx context.Context) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Refresh(ctx); err != nil {
+				log.Printf("Failed to refresh JWT secret: %v", err)
+			}
+		}
+	}
+}
+
+// grace is how long a replaced version keeps verifying tokens: until every instance has seen the rotation
+// and stopped signing with it, plus the lifetime of the tokens signed with it
+func (w *JWTSecretWatcher) grace() time.Duration {
+	return w.interval + AccessTokenTTL
+}
+
+// Refresh fetches the secret now, signing with a new current version and accepting the previous and
+// pending ones, so tokens verify on every instance however far apart their refreshes are
+func (w *JWTSecretWatcher) Refresh(ctx context.Context) error {
+	secrets, err := w.source.FetchJWTSecret(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := jwtSecretKey(secrets.Current)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if current.ID != w.current {
+		if w.current == "" {
+			err = w.keys.SetSigningKey(current)
+		} else {
+			err = w.keys.RotateSigningKey(current, w.grace())
+		}
+		if err != nil {
+			return err
+		}
+		if w.current != "" {
+			log.Printf("SECURITY: JWT signing secret rotated from version %s to %s", w.current, current.ID)
+		}
+		w.current = current.ID
+	}
+
+	// Already retiring if this instance saw the rotation; otherwise it started during the rotation window
+	if secrets.Previous != nil {
+		previous, err := jwtSecretKey(*secrets.Previous)
+		if err != nil {
+			return err
+		}
+		if err := w.keys.addRetiringKey(previous, time.Now().Add(w.grace())); err != nil {
+			return err
+		}
+	}
+
+	pending := ""
+	if secrets.Pending != nil && secrets.Pending.ID != w.current {
+		key, err := jwtSecretKey(*secrets.Pending)
+		if err != nil {
+			return err
+		}
+		if err := w.keys.AddVerificationKey(key); err != nil {
+			return err
+		}
+		pending = key.ID
+	}
+	// A pending version that went away without becoming current belongs to an abandoned rotation
+	if w.pending != "" && w.pending != pending && w.pending != w.current {
+		if err := w.keys.RemoveVerificationKey(w.pending); err != nil {
+			return err
+		}
+	}
+	w.pending = pending
+	return nil
+}
+
+// Stop stops checking for a rotated secret; the loaded keys stay in use
+func (w *JWTSecretWatcher) Stop() {
+	w.cancel()
+	<-w.done
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// KeyedMutex is a set of mutexes created on demand per key, so a handler rebuilding an expensive
+// value can make concurrent requests for the same key wait instead of recomputing it too
+// Idle keys are removed, so the set stays as small as the number of keys currently locked.
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is one key's lock and the number of goroutines holding or waiting for it
+type keyedLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// NewKeyedMutex creates an empty keyed mutex
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// Lock blocks until key is free and returns the function that releases it
+func (m *KeyedMutex) Lock(key string) (unlock func()) {
+	unlock, _ = m.LockContext(context.Background(), key)
+	return unlock
+}
+
+// LockContext is like Lock but gives up when ctx is done, returning ctx's error
+func (m *KeyedMutex) LockContext(ctx context.Context, key string) (unlock func(), err error) {
+	lock := m.acquire(key)
+
+	select {
+	case lock.ch <- struct{}{}:
+		return m.unlocker(key, lock), nil
+	case <-ctx.Done():
+		m.release(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+// TryLock locks key if it is free, so callers can serve a stale value instead of waiting
+func (m *KeyedMutex) TryLock(key string) (unlock func(), ok bool) {
+	lock := m.acquire(key)
+
+	select {
+	case lock.ch <- struct{}{}:
+		return m.unlocker(key, lock), true
+	default:
+		m.release(key, lock)
+		return nil, false
+	}
+}
+
+// acquire returns key's lock, creating it if needed, and counts the caller as a user of it
+func (m *KeyedMutex) acquire(key string) *keyedLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyedLock{ch: make(chan struct{}, 1)}
+		m.locks[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release stops counting the caller as a user of key's lock, removing it once unused
+func (m *KeyedMutex) release(key string, lock *keyedLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// unlocker returns a function that unlocks key once, however many times it is called
+func (m *KeyedMutex) unlocker(key string, lock *keyedLock) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-lock.ch
+			m.release(key, lock)
+		})
+	}
+}
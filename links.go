@@ -0,0 +1,84 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// LinkKind identifies a link sent in a built-in email
+type LinkKind string
+
+const (
+	LinkVerifyEmail   LinkKind = "verify_email"
+	LinkResetPassword LinkKind = "reset_password"
+	LinkUnlockAccount LinkKind = "unlock_account"
+	LinkReferral      LinkKind = "referral"
+
+	LinkConfirmEmailChange LinkKind = "confirm_email_change"
+)
+
+// LinkBuilder builds the frontend URL a token is delivered to
+// baseURL is the frontend the link points at, e.g. "https://app.example.com"
+type LinkBuilder interface {
+	BuildLink(kind LinkKind, baseURL, token string) (string, error)
+}
+
+// LinkBuilderFunc adapts a function to LinkBuilder
+type LinkBuilderFunc func(kind LinkKind, baseURL, token string) (string, error)
+
+// BuildLink calls f
+func (f LinkBuilderFunc) BuildLink(kind LinkKind, baseURL, token string) (string, error) {
+	return f(kind, baseURL, token)
+}
+
+// PathTemplateLinkBuilder builds links from path templates containing a ":token" placeholder,
+// e.g. "/auth/verify/:token" or "/reset-password?token=:token"
+type PathTemplateLinkBuilder struct {
+	Paths map[LinkKind]string // Path templates used for every frontend
+
+	// Frontends overrides Paths for specific base URLs, for environments with several frontends
+	// whose routes differ, e.g. a web app and an admin console
+	Frontends map[string]map[LinkKind]string
+}
+
+// DefaultLinkBuilder returns the routes the package has always used
+func DefaultLinkBuilder() *PathTemplateLinkBuilder {
+	return &PathTemplateLinkBuilder{
+		Paths: map[LinkKind]string{
+			LinkVerifyEmail:   "/verify-email?token=:token",
+			LinkResetPassword: "/reset-password?token=:token",
+			LinkUnlockAccount: "/unlock-account?token=:token",
+			LinkReferral:      "/register?ref=:token",
+
+			LinkConfirmEmailChange: "/confirm-email-change?token=:token",
+		},
+	}
+}
+
+// BuildLink substitutes the token into the template for kind, escaping it for its position
+func (b *PathTemplateLinkBuilder) BuildLink(kind LinkKind, baseURL, token string) (string, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	template, ok := b.Frontends[baseURL][kind]
+	if !ok {
+		template, ok = b.Paths[kind]
+	}
+	if !ok {
+		template, ok = DefaultLinkBuilder().Paths[kind]
+	}
+	if !ok {
+		return "", fmt.Errorf("no link template for %s", kind)
+	}
+
+	i := strings.Index(template, ":token")
+	if i < 0 {
+		return "", fmt.Errorf("link template for %s has no :token placeholder", kind)
+	}
+
+	escaped := url.PathEscape(token)
+	if query := strings.Index(template, "?"); query >= 0 && query < i {
+		escaped = url.QueryEscape(token)
+	}
+	return baseURL + template[:i] + escaped + template[i+len(":token"):], nil
+}
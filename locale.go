@@ -0,0 +1,138 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is used when no supported locale can be resolved
+const DefaultLocale = "en"
+
+var (
+	supportedLocalesMu sync.RWMutex
+	supportedLocales   = []language.Tag{language.English, language.Spanish}
+	localeMatcher      = language.NewMatcher(supportedLocales)
+)
+
+// SetSupportedLocales sets the locales that emails are available in, most preferred first
+func SetSupportedLocales(locales ...string) error {
+	tags := make([]language.Tag, 0, len(locales))
+	for _, locale := range locales {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+	}
+
+	supportedLocalesMu.Lock()
+	defer supportedLocalesMu.Unlock()
+	supportedLocales = tags
+	localeMatcher = language.NewMatcher(tags)
+	return nil
+}
+
+// ResolveLocale picks the locale for a user's email: their profile preference if supported,
+// otherwise the request's Accept-Language header, otherwise DefaultLocale
+// Either r or user may be nil
+func ResolveLocale(r *http.Request, user *User) string {
+	var preferences []string
+	if user != nil && user.Locale != "" {
+		preferences = append(preferences, user.Locale)
+	}
+	if r != nil {
+		if header := r.Header.Get("Accept-Language"); header != "" {
+			preferences = append(preferences, header)
+		}
+	}
+
+	for _, preference := range preferences {
+		if locale, ok := matchLocale(preference); ok {
+			return locale
+		}
+	}
+	return DefaultLocale
+}
+
+// matchLocale matches a locale or Accept-Language value against the supported locales
+func matchLocale(preference string) (string, bool) {
+	tags, _, err := language.ParseAcceptLanguage(preference)
+	if err != nil || len(tags) == 0 {
+		return "", false
+	}
+
+	supportedLocalesMu.RLock()
+	defer supportedLocalesMu.RUnlock()
+
+	_, index, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return "", false
+	}
+	return supportedLocales[index].String(), true
+}
+
+// localeFallbacks returns a locale followed by its parents, e.g. "es-MX" -> ["es-MX", "es"]
+func localeFallbacks(locale string) []string {
+	if locale == "" {
+		return nil
+	}
+
+	fallbacks := []string{locale}
+	if i := strings.Index(locale, "-"); i > 0 {
+		fallbacks = append(fallbacks, locale[:i])
+	}
+	return fallbacks
+}
+
+// Built-in email subjects by message key and locale; %s is replaced with the app name
+var emailSubjects = map[string]map[string]string{
+	"verification": {
+		"en": "Verify Your Email - %s",
+		"es": "Verifica tu correo electrónico - %s",
+	},
+	"welcome": {
+		"en": "Welcome to %s!",
+		"es": "¡Bienvenido a %s!",
+	},
+	"password_reset": {
+		"en": "Reset Your Password - %s",
+		"es": "Restablece tu contraseña - %s",
+	},
+	"password_changed": {
+		"en": "Password Changed - %s",
+		"es": "Contraseña cambiada - %s",
+	},
+	"account_unlock": {
+		"en": "Unlock Your Account - %s",
+		"es": "Desbloquea tu cuenta - %s",
+	},
+	"account_locked": {
+		"en": "Your Account Was Locked - %s",
+		"es": "Tu cuenta fue bloqueada - %s",
+	},
+	"email_change": {
+		"en": "Confirm Your New Email - %s",
+		"es": "Confirma tu nuevo correo electrónico - %s",
+	},
+	"email_change_notice": {
+		"en": "Email Change Requested - %s",
+		"es": "Se solicitó un cambio de correo electrónico - %s",
+	},
+}
+
+// localizedSubject returns the branded subject for a message key in the most specific available locale
+func localizedSubject(key, locale, appName string) string {
+	subjects := emailSubjects[key]
+	subject := subjects[DefaultLocale]
+	for _, candidate := range localeFallbacks(locale) {
+		if localized, ok := subjects[candidate]; ok {
+			subject = localized
+			break
+		}
+	}
+	return fmt.Sprintf(subject, appName)
+}
@@ -0,0 +1,198 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LockoutPolicy decides when failed logins lock an account and for how long
+type LockoutPolicy struct {
+	Threshold      int           // Failed logins in a row that lock the account
+	BaseDuration   time.Duration // Length of the first lockout
+	BackoffFactor  float64       // Each further lockout lasts this many times longer than the last; 1 keeps it fixed
+	MaxDuration    time.Duration // Longest a single lockout may last; zero for no limit
+	PermanentAfter int           // Lockouts after which the account stays locked until UnlockUser; zero for never
+	Notify         bool          // Email the owner on lockout; with self-service unlock the unlock email does this
+}
+
+// DefaultLockoutPolicy returns the policy Login has always used: 15 minutes after 5 failed logins
+func DefaultLockoutPolicy() *LockoutPolicy {
+	return &LockoutPolicy{
+		Threshold:     5,
+		BaseDuration:  15 * time.Minute,
+		BackoffFactor: 1,
+	}
+}
+
+var activeLockoutPolicy atomic.Pointer[LockoutPolicy]
+
+func init() {
+	activeLockoutPolicy.Store(DefaultLockoutPolicy())
+}
+
+// CurrentLockoutPolicy returns the policy Login locks accounts with
+func CurrentLockoutPolicy() *LockoutPolicy {
+	return activeLockoutPolicy.Load()
+}
+
+// SetLockoutPolicy changes the policy Login locks accounts with; pass nil to restore the default
+func SetLockoutPolicy(p *LockoutPolicy) error {
+	if p == nil {
+		p = DefaultLockoutPolicy()
+	}
+	if p.Threshold < 1 || p.BaseDuration <= 0 || p.BackoffFactor < 1 || p.PermanentAfter < 0 {
+		return errors.New("lockout policy needs a positive threshold and base duration and a backoff factor of at least 1")
+	}
+	activeLockoutPolicy.Store(p)
+	return nil
+}
+
+// lockoutDuration returns how long the nth lockout in a row lasts
+func (p *LockoutPolicy) lockoutDuration(n int) time.Duration {
+	duration := float64(p.BaseDuration) * math.Pow(p.BackoffFactor, float64(n-1))
+	if p.MaxDuration > 0 && duration > float64(p.MaxDuration) {
+		return p.MaxDuration
+	}
+	if duration > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(duration)
+}
+
+// isLocked reports whether the user is locked out, for a while or until an admin unlocks them
+func (u *User) isLocked() bool {
+	return u.PermanentlyLocked || (u.LockedUntil != nil && time.Now().Before(u.LockedUntil.Time))
+}
+
+// recordFailedLogin counts a failed login, locking the account as the policy says, and stores the result
+// It returns whether this failure locked the account.
+func recordFailedLogin(r *http.Request, database *mongo.Database, user *User, secret string) bool {
+	policy := CurrentLockoutPolicy()
+
+	user.LoginAttempts++
+	locked := user.LoginAttempts >= policy.Threshold
+	if locked {
+		// Count the attempts afresh, so the next lockout follows another Threshold failures
+		user.LoginAttempts = 0
+		user.LockoutCount++
+		if policy.PermanentAfter > 0 && user.LockoutCount >= policy.PermanentAfter {
+			user.PermanentlyLocked = true
+			user.LockedUntil = nil
+		} else {
+			user.LockedUntil = TimePtr(time.Now().Add(policy.lockoutDuration(user.LockoutCount)))
+		}
+		currentLoginMetrics().AccountLocked()
+	}
+
+	database.Collection("users").UpdateOne(r.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"login_attempts":     user.LoginAttempts,
+			"locked_until":       user.LockedUntil,
+			"lockout_count":      user.LockoutCount,
+			"permanently_locked": user.PermanentlyLocked,
+		},
+	})
+
+	if !locked {
+		return false
+	}
+
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventAccountLocked, UserID: user.ID, Email: user.Email, Reason: lockReason(user)})
+	if user.PermanentlyLocked {
+		log.Printf("SECURITY: account %s locked until an admin unlocks it after %d lockouts", user.ID, user.LockoutCount)
+	} else {
+		log.Printf("SECURITY: account %s locked until %s after %d failed logins", user.ID, FormatRFC3339(user.LockedUntil.Time), policy.Threshold)
+	}
+
+	// Let the owner unlock immediately instead of waiting out the lockout
+	switch {
+	case !user.PermanentlyLocked && selfServiceUnlock.Load():
+		sendAccountUnlockEmail(r, user, secret)
+	case policy.Notify:
+		err := SendAccountLockedEmail(user.Email, "", user.Name, ResolveLocale(r, user), user.PermanentlyLocked)
+		if err != nil {
+			log.Printf("Failed to send account locked email: %v", err)
+		}
+	}
+	return true
+}
+
+// lockReason describes a lockout for the audit trail
+func lockReason(user *User) string {
+	if user.PermanentlyLocked {
+		return "permanent"
+	}
+	return "until " + FormatRFC3339(user.LockedUntil.Time)
+}
+
+// UnlockUser lifts any lockout on a user, including permanent ones, and clears their failed login history
+// It is meant for admin tools and returns mongo.ErrNoDocuments if there is no such user.
+func UnlockUser(ctx context.Context, database *mongo.Database, userID string) error {
+	result, err := database.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{
+			"login_attempts":     0,
+			"locked_until":       nil,
+			"lockout_count":      0,
+			"permanently_locked": false,
+			"updated_at":         Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	log.Printf("SECURITY: account %s unlocked by an admin", userID)
+	recordAuthEvent(ctx, nil, AuthEvent{Type: AuthEventAccountUnlocked, UserID: userID, Actor: "admin"})
+	return nil
+}
+
+// SendAccountLockedEmail tells a user their account was locked after failed logins
+// A registered "account_locked.html" template overrides the built-in body; it gets Permanent as "true" or "".
+func (s *EmailService) SendAccountLockedEmail(toEmail, fromEmail, name, locale string, permanent bool) error {
+	config := s.Config()
+
+	data := map[string]string{"Name": name}
+	if permanent {
+		data["Permanent"] = "true"
+	}
+
+	subject := localizedSubject("account_locked", locale, config.AppName)
+	body, err := s.renderBody("account_locked.html", locale, config, data)
+	if err != nil {
+		log.Printf("Failed to render account locked email: %v", err)
+		return err
+	}
+
+	err = s.Queue(EmailMessage{
+		From:          fromEmail,
+		To:            []string{toEmail},
+		Subject:       subject,
+		HTMLBody:      body,
+		Transactional: true,
+		Type:          EmailTypeAccountLocked,
+	})
+	if err != nil {
+		log.Printf("Failed to send account locked email to %s: %v", RedactedEmail(toEmail), err)
+		return fmt.Errorf("failed to send account locked email: %w", err)
+	}
+
+	log.Printf("Account locked email sent successfully to %s", RedactedEmail(toEmail))
+	return nil
+}
+
+// SendAccountLockedEmail sends an account locked notice using the default service
+func SendAccountLockedEmail(toEmail, fromEmail, name, locale string, permanent bool) error {
+	return defaultEmailService.SendAccountLockedEmail(toEmail, fromEmail, name, locale, permanent)
+}
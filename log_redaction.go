@@ -0,0 +1,66 @@
+package common
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactedEmail is an email address for log lines; it prints masked, e.g. "f***@example.com",
+// unless the environment profile sets LogPII
+type RedactedEmail string
+
+func (e RedactedEmail) String() string {
+	if CurrentProfile().LogPII {
+		return string(e)
+	}
+	return RedactEmail(string(e))
+}
+
+// RedactedEmails is a list of email addresses for log lines, printed like RedactedEmail
+type RedactedEmails []string
+
+func (e RedactedEmails) String() string {
+	masked := make([]string, len(e))
+	for i, email := range e {
+		masked[i] = RedactedEmail(email).String()
+	}
+	return "[" + strings.Join(masked, " ") + "]"
+}
+
+// RedactedPhone is a phone number for log lines; it prints with all but the last two digits masked,
+// e.g. "+1*********23", unless the environment profile sets LogPII
+type RedactedPhone string
+
+func (p RedactedPhone) String() string {
+	if CurrentProfile().LogPII || len(p) <= 4 {
+		return string(p)
+	}
+	return string(p[:2]) + strings.Repeat("*", len(p)-4) + string(p[len(p)-2:])
+}
+
+// Secret is a token, password or key that must never reach logs; it always prints as "[REDACTED]"
+type Secret string
+
+func (Secret) String() string {
+	return "[REDACTED]"
+}
+
+// GoString keeps %#v from printing the value
+func (Secret) GoString() string {
+	return "[REDACTED]"
+}
+
+// MarshalJSON keeps structured log encoders from printing the value
+func (Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"[REDACTED]"`), nil
+}
+
+// RedactEmail masks the local part of an email address after its first character, e.g. "f***@example.com"
+func RedactEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+	_, size := utf8.DecodeRuneInString(email)
+	return email[:size] + "***" + email[at:]
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,24 +12,103 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type LoginForm struct {
-	Email    string `json:"email" binding:"required"`    // The email of the user
-	Password string `json:"password" binding:"required"` // The password of the user
+	Email      string `json:"email" binding:"required"`    // The email of the user
+	Password   string `json:"password" binding:"required"` // The password of the user
+	RememberMe bool   `json:"remember_me"`                 // Keep the user signed in longer, see AuthConfig.RememberMeTTL
 }
 
 func Login(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) {
+	secretAuth(secret).Login(database, w, r)
+}
+
+// Login checks a login request and responds with an access token, plus a refresh token if they are enabled
+func (a *Auth) Login(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	user, form, ok := authenticateLogin(database, w, r, a.config.Secret)
+	if !ok {
+		return
+	}
+
+	response, err := a.loginResponse(w, r, user, form.RememberMe)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v", err)
+		currentLoginMetrics().LoginAttempt(LoginOutcomeError)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	recordLogin(r.Context(), database, user, form.Password)
+	recordLoginSuccess(r, "password", user)
+	RespondWithJSON(w, 200, response)
+}
+
+// loginResponse issues an access token, plus a refresh token if they are enabled, for a user who just signed in
+// rememberMe issues the refresh token with the longer AuthConfig.RememberMeTTL. In cookie auth mode both tokens
+// are set as cookies on w rather than returned.
+func (a *Auth) loginResponse(w http.ResponseWriter, r *http.Request, user *User, rememberMe bool) (map[string]interface{}, error) {
+	// Issue a refresh token first if refresh tokens are enabled; its ID identifies the session
+	ttl := a.config.RefreshTokenTTL
+	if rememberMe {
+		ttl = a.config.RememberMeTTL
+	}
+	refreshToken, session, err := issueRefreshToken(r.Context(), r, user.ID, ttl, a.config.RefreshIdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+	sessionID := ""
+	if session != nil {
+		sessionID = session.sessionID()
+	}
+
+	// Generate new token (don't store in database)
+	accessToken, err := a.IssueClaims(r, &AppClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: user.ID},
+		Roles:            user.Roles,
+		SessionID:        sessionID,
+		AuthTime:         jwt.NewNumericDate(time.Now()),
+	})
+	if err != nil {
+		// The refresh token would otherwise stay valid without ever reaching the client
+		discardRefreshToken(r.Context(), refreshToken)
+		return nil, fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	response := map[string]interface{}{
+		"user": map[string]string{
+			"id":    user.ID,
+			"email": user.Email,
+			"name":  user.Name,
+		},
+	}
+	if a.config.Cookie != nil {
+		a.setTokenCookie(w, accessToken)
+		if refreshToken != "" {
+			a.setRefreshCookie(w, refreshToken, session.absoluteExpiry())
+		}
+	} else {
+		response["token"] = accessToken
+		if refreshToken != "" {
+			response["refresh_token"] = refreshToken
+		}
+	}
+	return response, nil
+}
+
+// authenticateLogin checks a login request's credentials, lockout and verification status
+// It responds to the request and returns false if the login must not proceed.
+func authenticateLogin(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) (*User, *LoginForm, bool) {
 	collection := database.Collection("users")
 
 	// Get the request body
 	var form LoginForm
 	if !ValidateAndBindJSON(w, r, &form) {
-		return
+		return nil, nil, false
 	}
 
 	// Sanitize username
@@ -38,132 +118,132 @@ func Login(database *mongo.Database, w http.ResponseWriter, r *http.Request, sec
 	var user User
 	err := collection.FindOne(r.Context(), bson.M{"email": form.Email}).Decode(&user)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			recordLoginFailure(r, "password", form.Email, nil, LoginOutcomeUnknownUser)
+		} else {
+			currentLoginMetrics().LoginAttempt(LoginOutcomeError)
+		}
 		// Use generic error message to prevent user enumeration
 		RespondWithJSON(w, 401, map[string]string{"error": "Invalid credentials"})
-		return
+		return nil, nil, false
 	}
 
 	// Check if account is locked
-	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+	if user.isLocked() {
+		recordLoginFailure(r, "password", form.Email, &user, LoginOutcomeLocked)
 		RespondWithJSON(w, 423, map[string]string{"error": "Account temporarily locked"})
-		return
+		return nil, nil, false
 	}
 
 	// Check if the password matches
 	match, err := ComparePasswordAndHash(form.Password, user.Password)
 	if err != nil {
-		log.Printf("Password comparison error for user %s: %v", user.Email, err)
+		log.Printf("Password comparison error for user %s: %v", RedactedEmail(user.Email), err)
+		currentLoginMetrics().LoginAttempt(LoginOutcomeError)
 		RespondWithJSON(w, 401, map[string]string{"error": "Invalid credentials"})
-		return
+		return nil, nil, false
 	}
 
 	if !match {
-		// Increment failed login attempts
-		user.LoginAttempts++
-
-		// Lock account after 5 failed attempts for 15 minutes
-		if user.LoginAttempts >= 5 {
-			lockUntil := time.Now().Add(15 * time.Minute)
-			user.LockedUntil = &lockUntil
-		}
-
-		// Update user record
-		collection.UpdateOne(r.Context(), bson.M{"_id": user.ID}, bson.M{
-			"$set": bson.M{
-				"login_attempts": user.LoginAttempts,
-				"locked_until":   user.LockedUntil,
-			},
-		})
+		// Count the failure, locking the account as the lockout policy says
+		recordFailedLogin(r, database, &user, secret)
 
+		recordLoginFailure(r, "password", form.Email, &user, LoginOutcomeBadPassword)
 		RespondWithJSON(w, 401, map[string]string{"error": "Invalid credentials"})
-		return
+		return nil, nil, false
 	}
 
 	// Check if email is verified
 	if !user.IsVerified {
+		recordLoginFailure(r, "password", form.Email, &user, LoginOutcomeUnverified)
 		RespondWithJSON(w, 403, map[string]interface{}{
 			"error": "Please verify your email address before logging in. Check your email for a verification link.",
 			"email": user.Email,
 		})
-		return
+		return nil, nil, false
 	}
 
+	return &user, &form, true
+}
+
+// recordLogin resets a user's failed attempts, records the login time and upgrades the password hash if needed
+func recordLogin(ctx context.Context, database *mongo.Database, user *User, password string) {
 	// Reset login attempts on successful login
 	user.LoginAttempts = 0
 	user.LockedUntil = nil
-	user.LastLoginAt = time.Now()
-
-	// Generate new token (don't store in database)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-		"iat": time.Now().Unix(),
-		"sub": user.ID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-		"jti": uuid.New().String(),
-		"iss": "flight-history-app",
-		"aud": "flight-history-users",
-	})
-
-	tokenString, err := token.SignedString([]byte(secret))
-	if err != nil {
-		log.Printf("Failed to sign JWT: %v", err)
-		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
-		return
-	}
+	user.LockoutCount = 0
+	user.LastLoginAt = Now()
 
 	// Update user record
-	collection.UpdateOne(r.Context(), bson.M{"_id": user.ID}, bson.M{
+	database.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
 		"$set": bson.M{
 			"login_attempts": user.LoginAttempts,
 			"locked_until":   user.LockedUntil,
+			"lockout_count":  user.LockoutCount,
 			"last_login_at":  user.LastLoginAt,
 		},
 	})
 
 	// Upgrade password hash if needed
-	go RehashPasswordIfNeeded(database, form.Password, &user)
-
-	RespondWithJSON(w, 200, map[string]interface{}{
-		"token": tokenString,
-		"user": map[string]string{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-		},
+	rehashUser := *user
+	RunInBackground("rehash_password", func(ctx context.Context) {
+		rehashPasswordIfNeeded(ctx, database, password, &rehashUser)
 	})
+
+	currentLoginMetrics().LoginAttempt(LoginOutcomeSuccess)
 }
 
-// rehashPasswordIfNeeded checks if the user's password hash uses the latest
-// recommended parameters, and if not, re-hashes it and updates it in the database.
-// This is done in a goroutine to not block the login request.
 // RehashPasswordIfNeeded checks if the user's password hash uses the latest
 // recommended parameters, and if not, re-hashes it and updates it in the database.
-// This is done in a goroutine to not block the login request.
+// Login runs it as a background task so it doesn't block the login request.
 func RehashPasswordIfNeeded(database *mongo.Database, password string, user *User) {
-	p, _, _, err := DecodeHash(user.Password)
-	if err != nil {
-		log.Printf("rehash: could not decode password hash for user %s: %v\n", user.Email, err)
-		return
-	}
+	rehashPasswordIfNeeded(context.Background(), database, password, user)
+}
 
-	// For now, we only check the parallelism parameter.
-	if p.parallelism != defaultPasswordParams.parallelism || p.memory != defaultPasswordParams.memory || p.iterations != defaultPasswordParams.iterations {
-		log.Printf("rehash: parameters for user %s are outdated, re-hashing password\n", user.Email)
+// rehashPasswordIfNeeded implements RehashPasswordIfNeeded, stopping if ctx is cancelled
+func rehashPasswordIfNeeded(ctx context.Context, database *mongo.Database, password string, user *User) {
+	current := CurrentPasswordParams()
+	outdated := isBcryptHash(user.Password)
+	if outdated {
+		log.Printf("rehash: user %s has a legacy bcrypt hash, migrating to argon2id\n", RedactedEmail(user.Email))
+	} else {
+		p, _, _, err := DecodeHash(user.Password)
+		if err != nil {
+			log.Printf("rehash: could not decode password hash for user %s: %v\n", RedactedEmail(user.Email), err)
+			return
+		}
+		outdated = p.parallelism != current.parallelism || p.memory != current.memory || p.iterations != current.iterations
+		if outdated {
+			log.Printf("rehash: parameters for user %s are outdated, re-hashing password\n", RedactedEmail(user.Email))
+		}
+	}
 
-		hashedPassword, err := GenerateFromPassword(password, defaultPasswordParams)
+	if outdated {
+		hashedPassword, err := GenerateFromPassword(password, current)
 		if err != nil {
-			log.Printf("rehash: error re-hashing password for user %s: %v\n", user.Email, err)
+			log.Printf("rehash: error re-hashing password for user %s: %v\n", RedactedEmail(user.Email), err)
 			return
 		}
 
 		collection := database.Collection("users")
-		_, err = collection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password": hashedPassword}})
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password": hashedPassword}})
 		if err != nil {
-			log.Printf("rehash: error updating password for user %s: %v\n", user.Email, err)
+			log.Printf("rehash: error updating password for user %s: %v\n", RedactedEmail(user.Email), err)
 		}
 	}
 }
 
+// ComparePasswordAndHash reports whether password matches an argon2id hash, or a legacy bcrypt hash
+// from a migrated system
 func ComparePasswordAndHash(password string, encodedHash string) (match bool, err error) {
+	if isBcryptHash(encodedHash) {
+		err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
 	// Extract the parameters, salt and derived key from the encoded password
 	// hash.
 	p, salt, hash, err := DecodeHash(encodedHash)
@@ -183,6 +263,12 @@ func ComparePasswordAndHash(password string, encodedHash string) (match bool, er
 	return false, nil
 }
 
+// isBcryptHash reports whether encodedHash is a bcrypt hash rather than an argon2id one
+func isBcryptHash(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") || strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
 func DecodeHash(encodedHash string) (p *PasswordParams, salt, hash []byte, err error) {
 	vals := strings.Split(encodedHash, "$")
 	if len(vals) != 6 {
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoginOutcome labels the result of a login attempt in metrics
+type LoginOutcome string
+
+const (
+	LoginOutcomeSuccess     LoginOutcome = "success"
+	LoginOutcomeUnknownUser LoginOutcome = "unknown_user"
+	LoginOutcomeBadPassword LoginOutcome = "bad_password"
+	LoginOutcomeLocked      LoginOutcome = "locked"
+	LoginOutcomeUnverified  LoginOutcome = "unverified"
+	LoginOutcomeError       LoginOutcome = "error"
+)
+
+// loginOutcomes lists every outcome so exported metrics always include each label
+var loginOutcomes = []LoginOutcome{
+	LoginOutcomeSuccess,
+	LoginOutcomeUnknownUser,
+	LoginOutcomeBadPassword,
+	LoginOutcomeLocked,
+	LoginOutcomeUnverified,
+	LoginOutcomeError,
+}
+
+// LoginMetrics receives login events, e.g. to update Prometheus counters
+// Implementations must be safe for concurrent use
+type LoginMetrics interface {
+	LoginAttempt(outcome LoginOutcome)
+	AccountLocked()
+}
+
+// LoginCounters is an in-memory LoginMetrics implementation and the default
+type LoginCounters struct {
+	attempts sync.Map // LoginOutcome -> *atomic.Int64
+	lockouts atomic.Int64
+
+	mu             sync.Mutex
+	recentLockouts []time.Time
+}
+
+// NewLoginCounters creates an empty set of counters
+func NewLoginCounters() *LoginCounters {
+	return &LoginCounters{}
+}
+
+func (c *LoginCounters) LoginAttempt(outcome LoginOutcome) {
+	counter, _ := c.attempts.LoadOrStore(outcome, &atomic.Int64{})
+	counter.(*atomic.Int64).Add(1)
+}
+
+func (c *LoginCounters) AccountLocked() {
+	c.lockouts.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recentLockouts = append(c.pruneLockouts(time.Now()), time.Now())
+}
+
+// pruneLockouts drops lockouts older than a minute; the caller must hold c.mu
+func (c *LoginCounters) pruneLockouts(now time.Time) []time.Time {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(c.recentLockouts) && c.recentLockouts[i].Before(cutoff) {
+		i++
+	}
+	c.recentLockouts = c.recentLockouts[i:]
+	return c.recentLockouts
+}
+
+// Attempts returns the number of login attempts with an outcome
+func (c *LoginCounters) Attempts(outcome LoginOutcome) int64 {
+	counter, ok := c.attempts.Load(outcome)
+	if !ok {
+		return 0
+	}
+	return counter.(*atomic.Int64).Load()
+}
+
+// Lockouts returns the total number of accounts locked
+func (c *LoginCounters) Lockouts() int64 {
+	return c.lockouts.Load()
+}
+
+// LockoutsLastMinute returns the number of accounts locked in the past minute
+func (c *LoginCounters) LockoutsLastMinute() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pruneLockouts(time.Now()))
+}
+
+// ServeHTTP writes the counters in the Prometheus text exposition format
+func (c *LoginCounters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP auth_login_attempts_total Login attempts by outcome.")
+	fmt.Fprintln(w, "# TYPE auth_login_attempts_total counter")
+	for _, outcome := range loginOutcomes {
+		fmt.Fprintf(w, "auth_login_attempts_total{outcome=%q} %d\n", outcome, c.Attempts(outcome))
+	}
+	fmt.Fprintln(w, "# HELP auth_lockouts_total Accounts locked after repeated failed logins.")
+	fmt.Fprintln(w, "# TYPE auth_lockouts_total counter")
+	fmt.Fprintf(w, "auth_lockouts_total %d\n", c.Lockouts())
+	fmt.Fprintln(w, "# HELP auth_lockouts_last_minute Accounts locked in the past minute.")
+	fmt.Fprintln(w, "# TYPE auth_lockouts_last_minute gauge")
+	fmt.Fprintf(w, "auth_lockouts_last_minute %d\n", c.LockoutsLastMinute())
+}
+
+var (
+	defaultLoginCounters = NewLoginCounters()
+
+	loginMetricsMu sync.RWMutex
+	loginMetrics   LoginMetrics = defaultLoginCounters
+)
+
+// DefaultLoginCounters returns the in-memory counters used unless SetLoginMetrics is called
+// It can be mounted directly as a Prometheus scrape endpoint
+func DefaultLoginCounters() *LoginCounters {
+	return defaultLoginCounters
+}
+
+// SetLoginMetrics replaces the login metrics hook; pass nil to restore the default counters
+func SetLoginMetrics(metrics LoginMetrics) {
+	if metrics == nil {
+		metrics = defaultLoginCounters
+	}
+
+	loginMetricsMu.Lock()
+	defer loginMetricsMu.Unlock()
+	loginMetrics = metrics
+}
+
+// currentLoginMetrics returns the active login metrics hook
+func currentLoginMetrics() LoginMetrics {
+	loginMetricsMu.RLock()
+	defer loginMetricsMu.RUnlock()
+	return loginMetrics
+}
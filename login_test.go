@@ -0,0 +1,40 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLoginResponseDiscardsRefreshTokenWhenSigningFails(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("signing fails", func(mt *mtest.T) {
+		useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+		ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(ok, ok)
+
+		// Too short a secret can't sign
+		auth := newAuth(AuthConfig{Secret: "short"})
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		if _, err := auth.loginResponse(httptest.NewRecorder(), r, &User{ID: testUserID}, false); err == nil {
+			mt.Fatal("loginResponse succeeded without a usable secret")
+		}
+
+		insert := mt.GetStartedEvent()
+		if insert == nil || insert.CommandName != "insert" {
+			mt.Fatal("no refresh token was stored")
+		}
+		stored := insert.Command.Lookup("documents").Array().Index(0).Value().Document().Lookup("token_hash").StringValue()
+		discard := mt.GetStartedEvent()
+		if discard == nil || discard.CommandName != "delete" {
+			mt.Fatal("the refresh token was not discarded")
+		}
+		if deleted := discard.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q", "token_hash").StringValue(); deleted != stored {
+			mt.Fatalf("discarded token %s, want the stored %s", deleted, stored)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LogoutForm is the optional body of a logout request
+type LogoutForm struct {
+	RefreshToken string `json:"refresh_token"` // Revoked too if given
+}
+
+// Logout revokes the access token that authenticated the request, its session's refresh tokens and the other
+// access tokens issued with them, and the refresh token in the body if any, so they stop working before they
+// expire. It must be mounted behind Authenticate or Auth.Middleware.
+func Logout(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	claims := ClaimsFromContext(r)
+	if claims == nil {
+		RespondWithJSON(w, 401, map[string]string{"error": "Authorization required"})
+		return
+	}
+
+	// The body is optional; clients without refresh tokens may send none
+	var form LogoutForm
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil && !errors.Is(err, io.EOF) {
+		RespondWithJSON(w, 400, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		err := RevokeToken(r.Context(), claims.ID, claims.Subject, claims.ExpiresAt.Time)
+		if err != nil && !errors.Is(err, ErrTokenRevocationDisabled) {
+			log.Printf("Failed to revoke access token: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+	}
+
+	if claims.SessionID != "" {
+		err := RevokeUserSession(r.Context(), claims.Subject, claims.SessionID)
+		if errors.Is(err, ErrSessionNotFound) {
+			// Its refresh tokens are already revoked or expired; still reject access tokens issued with it
+			err = revokeSessionAccessTokens(r.Context(), claims.Subject, claims.SessionID)
+		}
+		if err != nil {
+			log.Printf("Failed to revoke session: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+	}
+
+	if token := SanitizeInput(form.RefreshToken); token != "" {
+		if err := revokeRefreshToken(r.Context(), claims.Subject, token); err != nil {
+			log.Printf("Failed to revoke refresh token: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+	}
+
+	log.Printf("SECURITY: user %s logged out", claims.Subject)
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogout, UserID: claims.Subject, Method: "token"})
+	RespondWithJSON(w, 200, map[string]string{"message": "Logged out"})
+}
+
+// Logout ends the request's session and clears its cookie
+func (m *SessionManager) Logout(w http.ResponseWriter, r *http.Request) {
+	session, err := m.Get(r.Context(), r)
+	if err != nil && !errors.Is(err, ErrSessionInvalid) {
+		log.Printf("Failed to load session: %v", err)
+	}
+
+	if err := m.Destroy(r.Context(), w, r); err != nil {
+		log.Printf("Failed to destroy session: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	if session != nil {
+		log.Printf("SECURITY: user %s logged out", session.UserID)
+		recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventLogout, UserID: session.UserID, Method: "session"})
+	}
+	RespondWithJSON(w, 200, map[string]string{"message": "Logged out"})
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLogoutRevokesSession(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name          string
+		sessionTokens int // Refresh tokens of the session still active
+	}{
+		{"active session", 1},
+		{"session already revoked", 0},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+			useTokenRevocation(mt.T, mt.DB)
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),                                                                   // Access token jti
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: tt.sessionTokens}, bson.E{Key: "nModified", Value: tt.sessionTokens}), // Session's refresh tokens
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),                                                                   // Session's access tokens
+			)
+
+			// No refresh token in the body: the session is still revoked
+			r := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+			r = SetClaims(r, &AppClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "0d9f8a57-3a5e-4f7e-9b38-3c1f0b7c2a11",
+					ID:        "access-jti",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+				SessionID: "session-1",
+			})
+			w := httptest.NewRecorder()
+			Logout(nil, w, r)
+			if w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			jti := mt.GetStartedEvent()
+			if id := jti.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q", "_id").StringValue(); id != "access-jti" {
+				mt.Fatalf("first revocation = %s, want the access token's jti", id)
+			}
+			refresh := mt.GetStartedEvent()
+			if collection := refresh.Command.Lookup("update").StringValue(); collection != "refresh_tokens" {
+				mt.Fatalf("second command updates %s, want refresh_tokens", collection)
+			}
+			session := mt.GetStartedEvent()
+			if session == nil {
+				mt.Fatal("the session's access tokens were not revoked")
+			}
+			if id := session.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q", "_id").StringValue(); id != sessionRevocationPrefix+"session-1" {
+				mt.Fatalf("session revocation = %s, want %s", id, sessionRevocationPrefix+"session-1")
+			}
+		})
+	}
+}
@@ -31,6 +31,7 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				log.Printf("Recovered from panic in %s %s (request %s): %v", r.Method, r.URL.Path, GetRequestID(r), err)
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte("Internal Server Error"))
 			}
@@ -69,19 +70,41 @@ func SecurityLogging(next http.Handler) http.Handler {
 		latency := time.Since(start)
 
 		if status >= 400 {
-			log.Printf("SECURITY: %s %s - Status: %d, Latency: %v, IP: %s, User-Agent: %s",
-				method, path, status, latency, GetClientIP(r), r.UserAgent())
+			log.Printf("SECURITY: %s %s - Status: %d, Latency: %v, IP: %s, User-Agent: %s, Request-ID: %s",
+				method, path, status, latency, GetClientIP(r), r.UserAgent(), GetRequestID(r))
 		}
 	})
 }
 
 // CorsMiddleware returns a middleware that applies CORS headers for native net/http handlers.
-// - `allowedOrigins`: list of origins to allow; if empty allows `*`.
+// - `allowedOrigins`: list of origins to allow; if empty uses the environment profile's origins, or `*` if it has none.
 // - `allowedMethods`: list of allowed methods; if empty defaults to GET,POST,PUT,DELETE,OPTIONS
 // - `allowedHeaders`: list of allowed request headers; if empty will echo requested headers
 // - `allowCredentials`: whether to expose Access-Control-Allow-Credentials
 // - `maxAge`: seconds for Access-Control-Max-Age (0 to omit)
 func CorsMiddleware(allowedOrigins []string, allowedMethods []string, allowedHeaders []string, allowCredentials bool, maxAge int) func(http.Handler) http.Handler {
+	// Fall back to the environment's origins, e.g. localhost in development
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = CurrentProfile().CorsOrigins
+	}
+
+	return corsMiddleware(func(*http.Request) []string { return allowedOrigins }, allowedMethods, allowedHeaders, allowCredentials, maxAge)
+}
+
+// RuntimeCorsMiddleware works like CorsMiddleware but reads the allowed origins from the
+// runtime configuration on every request, so origins can be reloaded without a restart
+func RuntimeCorsMiddleware(allowedMethods []string, allowedHeaders []string, allowCredentials bool, maxAge int) func(http.Handler) http.Handler {
+	return corsMiddleware(func(*http.Request) []string { return CurrentRuntimeConfig().CorsOrigins }, allowedMethods, allowedHeaders, allowCredentials, maxAge)
+}
+
+// StoreCorsMiddleware works like CorsMiddleware but looks up the allowed origins for each
+// request's tenant and route in a CorsOriginStore
+func StoreCorsMiddleware(store *CorsOriginStore, allowedMethods []string, allowedHeaders []string, allowCredentials bool, maxAge int) func(http.Handler) http.Handler {
+	return corsMiddleware(store.OriginsFor, allowedMethods, allowedHeaders, allowCredentials, maxAge)
+}
+
+// corsMiddleware implements the CORS middlewares with origins resolved per request
+func corsMiddleware(originsFor func(*http.Request) []string, allowedMethods []string, allowedHeaders []string, allowCredentials bool, maxAge int) func(http.Handler) http.Handler {
 	// prepare joined header values
 	if len(allowedMethods) == 0 {
 		allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
@@ -105,6 +128,7 @@ func CorsMiddleware(allowedOrigins []string, allowedMethods []string, allowedHea
 			}
 
 			// Determine if origin is allowed
+			allowedOrigins := originsFor(r)
 			allowOrigin := ""
 			if len(allowedOrigins) == 0 {
 				allowOrigin = "*"
@@ -130,6 +154,7 @@ func CorsMiddleware(allowedOrigins []string, allowedMethods []string, allowedHea
 
 			// If allowedHeaders not provided, echo back requested headers for preflight
 			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
 
 			if allowCredentials {
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
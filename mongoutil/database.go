@@ -0,0 +1,207 @@
+// Package mongoutil provides MongoDB connection, cursor, conditional update and filter building helpers
+package mongoutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrVersionConflict is returned when a conditional update targets an outdated document version
+var ErrVersionConflict = errors.New("document was modified by another request")
+
+// DatabaseConfig holds optimized MongoDB connection settings
+type DatabaseConfig struct {
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	MaxConnIdleTime        time.Duration
+	MaxConnecting          uint64
+	HeartbeatInterval      time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+	ConnectTimeout         time.Duration
+}
+
+// DefaultDatabaseConfig returns optimized database configuration
+func DefaultDatabaseConfig() *DatabaseConfig {
+	return &DatabaseConfig{
+		MaxPoolSize:            25,               // Reduced from 50-100
+		MinPoolSize:            5,                // Reduced from 10
+		MaxConnIdleTime:        5 * time.Minute,  // Reduced idle time
+		MaxConnecting:          5,                // Limit concurrent connections
+		HeartbeatInterval:      60 * time.Second, // Increased heartbeat
+		ServerSelectionTimeout: 3 * time.Second,  // Faster timeout
+		SocketTimeout:          15 * time.Second, // Shorter socket timeout
+		ConnectTimeout:         5 * time.Second,  // Shorter connect timeout
+	}
+}
+
+// NewOptimizedClient creates a MongoDB client with memory-optimized settings
+// If uri is empty, it will use the MONGODB_URL environment variable
+// If config is nil, it will use the default configuration
+func NewOptimizedClient(uri string, config *DatabaseConfig) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Use environment variable if URI is not provided
+	if uri == "" {
+		return nil, fmt.Errorf("MongoDB URI not provided and MONGODB_URL environment variable is not set")
+	}
+
+	// Use default configuration if not provided
+	var cfg DatabaseConfig
+	if config != nil {
+		cfg = *config
+	} else {
+		cfg = *DefaultDatabaseConfig()
+	}
+
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetMaxPoolSize(cfg.MaxPoolSize).
+		SetMinPoolSize(cfg.MinPoolSize).
+		SetMaxConnIdleTime(cfg.MaxConnIdleTime).
+		SetMaxConnecting(cfg.MaxConnecting).
+		SetHeartbeatInterval(cfg.HeartbeatInterval).
+		SetServerSelectionTimeout(cfg.ServerSelectionTimeout).
+		SetSocketTimeout(cfg.SocketTimeout).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetRetryWrites(true).
+		SetRetryReads(true)
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB connection error: %w", err)
+	}
+
+	// Verify connection
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, fmt.Errorf("MongoDB ping failed: %w", err)
+	}
+
+	log.Println("MongoDB client connected with optimized settings")
+	return client, nil
+}
+
+// GetPictureCountsForEntities returns a map of entityID to picture count using optimized aggregation
+func GetPictureCountsForEntities(ctx context.Context, entityIDs []string, entityField string, collection *mongo.Collection) map[string]uint64 {
+	if len(entityIDs) == 0 {
+		return make(map[string]uint64)
+	}
+
+	// Use more efficient aggregation pipeline
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{entityField: bson.M{"$in": entityIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$" + entityField,
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":   1,
+			"count": 1,
+		}}},
+	}
+
+	opts := options.Aggregate().
+		SetBatchSize(100).
+		SetMaxTime(30 * time.Second) // Prevent long-running queries
+
+	cursor, err := collection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		log.Printf("Aggregation error: %v", err)
+		return make(map[string]uint64)
+	}
+
+	safeCursor := NewSafeCursor(cursor, ctx)
+	defer safeCursor.Close()
+
+	counts := make(map[string]uint64, len(entityIDs))
+
+	for safeCursor.Next() {
+		var result struct {
+			ID    string `bson:"_id"`
+			Count uint64 `bson:"count"`
+		}
+		if err := safeCursor.Decode(&result); err != nil {
+			log.Printf("Decode error: %v", err)
+			continue
+		}
+		counts[result.ID] = result.Count
+	}
+
+	if err := safeCursor.Err(); err != nil {
+		log.Printf("Cursor iteration error: %v", err)
+	}
+
+	return counts
+}
+
+// FindWithOptions performs a find operation with custom options and safe cursor handling
+func FindWithOptions(ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions, capacity int) (*SafeCursor, error) {
+	// Set default batch size if not specified
+	if opts.BatchSize == nil {
+		batchSize := int32(100)
+		opts.SetBatchSize(batchSize)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("find operation failed: %w", err)
+	}
+
+	return NewSafeCursor(cursor, ctx), nil
+}
+
+// UpdateAndReturnDocument applies update to the document matching filter and decodes the post-update
+// document into result, so callers respond with database state rather than echoing client input.
+// It also bumps the document's version and sets updated_at. If expectedVersion is not nil, the update
+// only succeeds when the stored version matches, otherwise ErrVersionConflict is returned.
+func UpdateAndReturnDocument(ctx context.Context, collection *mongo.Collection, filter bson.M, update bson.M, expectedVersion *int64, result interface{}) error {
+	conditionalFilter := bson.M{}
+	for key, value := range filter {
+		conditionalFilter[key] = value
+	}
+	if expectedVersion != nil {
+		conditionalFilter["version"] = *expectedVersion
+		if *expectedVersion == 0 {
+			// Documents created before versioning have no version field
+			conditionalFilter["version"] = bson.M{"$in": bson.A{0, nil}}
+		}
+	}
+
+	fullUpdate := bson.M{}
+	for key, value := range update {
+		fullUpdate[key] = value
+	}
+
+	set := bson.M{}
+	if existing, ok := fullUpdate["$set"].(bson.M); ok {
+		for key, value := range existing {
+			set[key] = value
+		}
+	}
+	set["updated_at"] = time.Now()
+	fullUpdate["$set"] = set
+	fullUpdate["$inc"] = bson.M{"version": 1}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := collection.FindOneAndUpdate(ctx, conditionalFilter, fullUpdate, opts).Decode(result)
+	if err == mongo.ErrNoDocuments && expectedVersion != nil {
+		// Distinguish a missing document from a stale version
+		count, countErr := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if countErr != nil {
+			return fmt.Errorf("failed to check document existence: %w", countErr)
+		}
+		if count > 0 {
+			return ErrVersionConflict
+		}
+	}
+	return err
+}
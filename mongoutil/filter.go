@@ -0,0 +1,198 @@
+package mongoutil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// ErrUnknownField is returned when a filter or sort names a field that isn't allowed, or an allowed field the model doesn't have
+	ErrUnknownField = errors.New("unknown filter field")
+	// ErrInvalidFilterValue is returned when a filter value isn't a plain scalar, e.g. a document that could carry operators
+	ErrInvalidFilterValue = errors.New("invalid filter value")
+)
+
+// FilterBuilder builds bson filters and sorts from untrusted field names and values.
+// Field names must be on the builder's allowlist, and values must be scalars, so user-supplied
+// parameters can never introduce operators such as $where or $expr, or probe fields such as password hashes.
+type FilterBuilder struct {
+	fields map[string]bool
+	filter bson.M
+	sort   bson.D
+	err    error
+}
+
+// NewFilterBuilder creates a builder that filters and sorts only on allowed, which must be bson field names
+// of model, a struct or struct pointer
+// Build fails if an allowed field isn't on the model, so a renamed field can't silently stop being filterable.
+func NewFilterBuilder(model interface{}, allowed ...string) *FilterBuilder {
+	b := &FilterBuilder{fields: map[string]bool{}, filter: bson.M{}}
+	modelFields := bsonFieldNames(reflect.TypeOf(model))
+	for _, field := range allowed {
+		if !modelFields[field] {
+			b.fail(fmt.Errorf("%w: %q is not a field of %T", ErrUnknownField, field, model))
+			continue
+		}
+		b.fields[field] = true
+	}
+	return b
+}
+
+// Eq matches documents where field equals value
+func (b *FilterBuilder) Eq(field string, value interface{}) *FilterBuilder {
+	return b.condition(field, "$eq", value)
+}
+
+// In matches documents where field equals any of values
+func (b *FilterBuilder) In(field string, values ...interface{}) *FilterBuilder {
+	for _, value := range values {
+		if err := checkFilterValue(field, value); err != nil {
+			b.fail(err)
+			return b
+		}
+	}
+	return b.condition(field, "$in", bson.A(values))
+}
+
+// Gt matches documents where field is greater than value
+func (b *FilterBuilder) Gt(field string, value interface{}) *FilterBuilder {
+	return b.condition(field, "$gt", value)
+}
+
+// Gte matches documents where field is greater than or equal to value
+func (b *FilterBuilder) Gte(field string, value interface{}) *FilterBuilder {
+	return b.condition(field, "$gte", value)
+}
+
+// Lt matches documents where field is less than value
+func (b *FilterBuilder) Lt(field string, value interface{}) *FilterBuilder {
+	return b.condition(field, "$lt", value)
+}
+
+// Lte matches documents where field is less than or equal to value
+func (b *FilterBuilder) Lte(field string, value interface{}) *FilterBuilder {
+	return b.condition(field, "$lte", value)
+}
+
+// TextSearch matches documents against the collection's text index
+func (b *FilterBuilder) TextSearch(query string) *FilterBuilder {
+	if query = strings.TrimSpace(query); query != "" {
+		b.filter["$text"] = bson.M{"$search": query}
+	}
+	return b
+}
+
+// Sort adds a sort key; prefix the field with "-" for descending order, e.g. "-created_at"
+func (b *FilterBuilder) Sort(spec string) *FilterBuilder {
+	field, order := spec, 1
+	if strings.HasPrefix(spec, "-") {
+		field, order = spec[1:], -1
+	}
+	if !b.fields[field] {
+		b.fail(fmt.Errorf("%w: %q", ErrUnknownField, field))
+		return b
+	}
+	b.sort = append(b.sort, bson.E{Key: field, Value: order})
+	return b
+}
+
+// Build returns the filter and sort, or the first error hit while building them
+func (b *FilterBuilder) Build() (bson.M, bson.D, error) {
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+	return b.filter, b.sort, nil
+}
+
+// condition adds an operator condition on a checked field, merging with earlier conditions on it
+func (b *FilterBuilder) condition(field, operator string, value interface{}) *FilterBuilder {
+	if b.err != nil {
+		return b
+	}
+	if !b.fields[field] {
+		b.fail(fmt.Errorf("%w: %q", ErrUnknownField, field))
+		return b
+	}
+	if operator != "$in" {
+		if err := checkFilterValue(field, value); err != nil {
+			b.fail(err)
+			return b
+		}
+	}
+
+	conditions, ok := b.filter[field].(bson.M)
+	if !ok {
+		conditions = bson.M{}
+		b.filter[field] = conditions
+	}
+	conditions[operator] = value
+	return b
+}
+
+// fail records the first error
+func (b *FilterBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// checkFilterValue rejects documents, arrays and other values that could carry query operators
+func checkFilterValue(field string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	switch value.(type) {
+	case time.Time, *time.Time, bson.ValueMarshaler:
+		return nil
+	}
+
+	kind := reflect.TypeOf(value).Kind()
+	switch kind {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	}
+	return fmt.Errorf("%w for %q: %s values are not allowed", ErrInvalidFilterValue, field, kind)
+}
+
+// bsonFieldNames returns the bson field names of a struct type, including inlined structs
+func bsonFieldNames(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("bson")
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(options, "inline") || (field.Anonymous && tag == "") {
+			for inlined := range bsonFieldNames(field.Type) {
+				fields[inlined] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = true
+	}
+	return fields
+}
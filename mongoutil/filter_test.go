@@ -0,0 +1,88 @@
+package mongoutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type filterModel struct {
+	ID        string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	Password  string    `bson:"password"`
+	CreatedAt time.Time `bson:"created_at"`
+	Profile   struct {
+		City string `bson:"city"`
+	} `bson:"profile"`
+}
+
+func TestFilterBuilder(t *testing.T) {
+	allowed := []string{"_id", "name", "created_at"}
+	tests := []struct {
+		name    string
+		build   func(b *FilterBuilder) *FilterBuilder
+		wantErr error
+	}{
+		{"allowed field", func(b *FilterBuilder) *FilterBuilder { return b.Eq("name", "ada") }, nil},
+		{"allowed time range", func(b *FilterBuilder) *FilterBuilder {
+			return b.Gte("created_at", time.Now().Add(-time.Hour)).Lt("created_at", time.Now())
+		}, nil},
+		{"allowed values in", func(b *FilterBuilder) *FilterBuilder { return b.In("_id", "a", "b") }, nil},
+		{"field not allowed", func(b *FilterBuilder) *FilterBuilder { return b.Eq("password", "hunter2") }, ErrUnknownField},
+		{"$where field", func(b *FilterBuilder) *FilterBuilder { return b.Eq("$where", "sleep(1000)") }, ErrUnknownField},
+		{"$expr field", func(b *FilterBuilder) *FilterBuilder { return b.Eq("$expr", true) }, ErrUnknownField},
+		{"$or field", func(b *FilterBuilder) *FilterBuilder { return b.In("$or", "x") }, ErrUnknownField},
+		{"dotted path", func(b *FilterBuilder) *FilterBuilder { return b.Eq("profile.city", "Paris") }, ErrUnknownField},
+		{"dotted path into an allowed field", func(b *FilterBuilder) *FilterBuilder { return b.Eq("name.first", "ada") }, ErrUnknownField},
+		{"operator map value", func(b *FilterBuilder) *FilterBuilder { return b.Eq("name", bson.M{"$ne": ""}) }, ErrInvalidFilterValue},
+		{"operator plain map value", func(b *FilterBuilder) *FilterBuilder {
+			return b.Gt("name", map[string]interface{}{"$regex": ".*"})
+		}, ErrInvalidFilterValue},
+		{"operator bson.D value", func(b *FilterBuilder) *FilterBuilder {
+			return b.Eq("name", bson.D{{Key: "$ne", Value: ""}})
+		}, ErrInvalidFilterValue},
+		{"operator value in", func(b *FilterBuilder) *FilterBuilder { return b.In("name", "ada", bson.M{"$gt": ""}) }, ErrInvalidFilterValue},
+		{"array value", func(b *FilterBuilder) *FilterBuilder { return b.Eq("name", []string{"ada"}) }, ErrInvalidFilterValue},
+		{"sort on allowed field", func(b *FilterBuilder) *FilterBuilder { return b.Sort("-created_at") }, nil},
+		{"sort on field not allowed", func(b *FilterBuilder) *FilterBuilder { return b.Sort("-password") }, ErrUnknownField},
+		{"sort on $natural", func(b *FilterBuilder) *FilterBuilder { return b.Sort("$natural") }, ErrUnknownField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, _, err := tt.build(NewFilterBuilder(filterModel{}, allowed...)).Build()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && filter != nil {
+				t.Fatalf("filter = %v alongside error %v", filter, err)
+			}
+		})
+	}
+}
+
+func TestFilterBuilderAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		wantErr error
+	}{
+		{"model fields", []string{"_id", "name"}, nil},
+		{"nothing allowed", nil, nil},
+		{"field missing from the model", []string{"name", "email"}, ErrUnknownField},
+		{"nested field", []string{"city"}, ErrUnknownField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := NewFilterBuilder(&filterModel{}, tt.allowed...).Build(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Fields are filterable only when allowed, even if the model has them
+	if _, _, err := NewFilterBuilder(filterModel{}).Eq("name", "ada").Build(); !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("error = %v, want %v", err, ErrUnknownField)
+	}
+}
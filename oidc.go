@@ -0,0 +1,592 @@
+package common
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidcFlowTTL is how long a user has to complete a login at the provider
+const oidcFlowTTL = 10 * time.Minute
+
+// oidcKeyRefreshInterval limits how often an unknown kid makes the provider's JWKS be fetched again
+const oidcKeyRefreshInterval = time.Minute
+
+// oidcClockSkew is the clock difference tolerated between this service and providers
+const oidcClockSkew = time.Minute
+
+var (
+	ErrOIDCProviderUnknown = errors.New("unknown OIDC provider")
+	ErrOIDCTokenInvalid    = errors.New("OIDC ID token is invalid")
+)
+
+// OIDCProviderConfig configures an OpenID Connect provider such as Okta, Auth0 or Keycloak
+type OIDCProviderConfig struct {
+	Name         string           `json:"name"`          // Identifies the provider in routes, e.g. "okta"
+	Issuer       string           `json:"issuer"`        // Issuer URL; its discovery document is read at startup
+	ClientID     string           `json:"client_id"`     // Client registered with the provider
+	ClientSecret string           `json:"client_secret"` // Secret of the client
+	RedirectURL  string           `json:"redirect_url"`  // Callback URL registered with the provider
+	Scopes       []string         `json:"scopes"`        // Requested scopes; defaults to openid, email and profile
+	Claims       OIDCClaimMapping `json:"claims"`        // ID token claims to read user fields from
+	TrustEmail   bool             `json:"trust_email"`   // Treat emails as verified for registration when the provider omits email_verified
+	LinkAccounts bool             `json:"link_accounts"` // Let owners of an account with the same verified email link it, see LinkOIDCAccount
+}
+
+// OIDCClaimMapping names the ID token claims that map to User fields; empty names use the standard claims
+type OIDCClaimMapping struct {
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Name          string `json:"name"`
+	Locale        string `json:"locale"`
+}
+
+// withDefaults fills in the standard OIDC claim names
+func (m OIDCClaimMapping) withDefaults() OIDCClaimMapping {
+	if m.Email == "" {
+		m.Email = "email"
+	}
+	if m.EmailVerified == "" {
+		m.EmailVerified = "email_verified"
+	}
+	if m.Name == "" {
+		m.Name = "name"
+	}
+	if m.Locale == "" {
+		m.Locale = "locale"
+	}
+	return m
+}
+
+// OIDCIdentity is a user as described by a verified ID token
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	EmailAssumed  bool // EmailVerified comes from TrustEmail rather than the provider
+	Name          string
+	Locale        string
+	Claims        jwt.MapClaims // Every claim in the ID token
+}
+
+// ExternalIdentity links a user to an account at an identity provider
+type ExternalIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// oidcMetadata is the part of a provider's discovery document the package uses
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcKey is a verification key published by a provider
+type oidcKey struct {
+	id  string
+	key crypto.PublicKey
+}
+
+// OIDCProvider verifies logins at one OpenID Connect provider
+type OIDCProvider struct {
+	config   OIDCProviderConfig
+	metadata oidcMetadata
+	client   *http.Client
+	parser   *jwt.Parser
+
+	mu            sync.Mutex
+	keys          []oidcKey
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider reads the provider's discovery document and keys
+func NewOIDCProvider(ctx context.Context, config OIDCProviderConfig) (*OIDCProvider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC provider needs a name, issuer, client ID and redirect URL")
+	}
+	if strings.ContainsAny(config.Name, "/?#") {
+		return nil, fmt.Errorf("OIDC provider name %q must be usable in a URL path", config.Name)
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	config.Claims = config.Claims.withDefaults()
+
+	p := &OIDCProvider{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+
+	discovery := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discovery, &p.metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", config.Name, err)
+	}
+	// The discovery document must be for the configured issuer, or ID tokens could come from anywhere
+	if strings.TrimSuffix(p.metadata.Issuer, "/") != strings.TrimSuffix(config.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC provider %s reports issuer %q, expected %q", config.Name, p.metadata.Issuer, config.Issuer)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC provider %s discovery document is missing endpoints", config.Name)
+	}
+
+	p.parser = jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(p.metadata.Issuer),
+		jwt.WithAudience(config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load OIDC provider %s keys: %w", config.Name, err)
+	}
+	return p, nil
+}
+
+// Name returns the provider's configured name
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the provider's login URL for an authorization code flow with PKCE
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades an authorization code for the provider's ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token response has no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, lifetime and nonce, and maps its claims
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, idToken, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := p.parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKeys(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCTokenInvalid, err)
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCTokenInvalid)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrOIDCTokenInvalid)
+	}
+
+	mapping := p.config.Claims
+	identity := &OIDCIdentity{Provider: p.config.Name, Subject: subject, Claims: claims}
+	identity.Email, _ = claims[mapping.Email].(string)
+	identity.Name, _ = claims[mapping.Name].(string)
+	identity.Locale, _ = claims[mapping.Locale].(string)
+	switch verified := claims[mapping.EmailVerified].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string: // Some providers send "true" as a string
+		identity.EmailVerified = verified == "true"
+	case nil:
+		identity.EmailVerified = p.config.TrustEmail
+		identity.EmailAssumed = p.config.TrustEmail
+	}
+	return identity, nil
+}
+
+// verificationKeys returns the provider keys matching kid, fetching the JWKS again if the kid is new
+func (p *OIDCProvider) verificationKeys(ctx context.Context, kid string) (jwt.VerificationKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	set := p.matchingKeys(kid)
+	if len(set.Keys) == 0 && time.Since(p.keysFetchedAt) >= oidcKeyRefreshInterval {
+		// The provider may have rotated its keys since they were last read
+		if err := p.fetchKeys(ctx); err != nil {
+			log.Printf("Failed to refresh OIDC provider %s keys: %v", p.config.Name, err)
+		}
+		set = p.matchingKeys(kid)
+	}
+	if len(set.Keys) == 0 {
+		return set, fmt.Errorf("no key with ID %q", kid)
+	}
+	return set, nil
+}
+
+// matchingKeys returns the cached keys matching kid, or all of them if kid is empty; p.mu must be held
+func (p *OIDCProvider) matchingKeys(kid string) jwt.VerificationKeySet {
+	var set jwt.VerificationKeySet
+	for _, key := range p.keys {
+		if kid == "" || key.id == kid {
+			set.Keys = append(set.Keys, key.key)
+		}
+	}
+	return set
+}
+
+// refreshKeys fetches the provider's JWKS
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fetchKeys(ctx)
+}
+
+// fetchKeys replaces the cached keys with the provider's JWKS; p.mu must be held
+func (p *OIDCProvider) fetchKeys(ctx context.Context) error {
+	p.keysFetchedAt = time.Now()
+
+	var set JWKSet
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	keys := make([]oidcKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping OIDC provider %s key: %v", p.config.Name, err)
+			continue
+		}
+		keys = append(keys, oidcKey{id: jwk.KeyID, key: key})
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS has no usable signing keys")
+	}
+	p.keys = keys
+	return nil
+}
+
+// getJSON fetches and decodes a JSON document from the provider
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+var (
+	oidcProvidersMu sync.RWMutex
+	oidcProviders   = map[string]*OIDCProvider{}
+)
+
+// ConfigureOIDCProviders discovers each provider and makes it available to OIDCLogin and OIDCCallback
+func ConfigureOIDCProviders(ctx context.Context, configs ...OIDCProviderConfig) error {
+	providers := make(map[string]*OIDCProvider, len(configs))
+	for _, config := range configs {
+		if _, ok := providers[config.Name]; ok {
+			return fmt.Errorf("OIDC provider %q is configured twice", config.Name)
+		}
+		provider, err := NewOIDCProvider(ctx, config)
+		if err != nil {
+			return err
+		}
+		providers[config.Name] = provider
+	}
+
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+	oidcProviders = providers
+	return nil
+}
+
+// OIDCProviderConfigsFromEnv reads provider configurations from the OIDC_PROVIDERS environment variable,
+// a JSON array of OIDCProviderConfig. It returns none if the variable is unset.
+func OIDCProviderConfigsFromEnv() ([]OIDCProviderConfig, error) {
+	raw := os.Getenv("OIDC_PROVIDERS")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var configs []OIDCProviderConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("CONFIG: OIDC_PROVIDERS is not a valid JSON array of providers: %w", err)
+	}
+	return configs, nil
+}
+
+// GetOIDCProvider returns a configured provider by name
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	oidcProvidersMu.RLock()
+	defer oidcProvidersMu.RUnlock()
+	provider, ok := oidcProviders[name]
+	if !ok {
+		return nil, ErrOIDCProviderUnknown
+	}
+	return provider, nil
+}
+
+// oidcCookieName returns the cookie holding a provider's in-progress login state
+func oidcCookieName(provider string) string {
+	return "oidc_" + provider
+}
+
+// OIDCLogin starts a login at the provider named by the {provider} path value, redirecting to it
+// The state, nonce and PKCE verifier are kept in a short-lived cookie checked by OIDCCallback.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := GetOIDCProvider(r.PathValue("provider"))
+	if err != nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "Unknown identity provider"})
+		return
+	}
+
+	values := make([]string, 3) // state, nonce, PKCE verifier
+	for i := range values {
+		if values[i], err = newOpaqueToken(); err != nil {
+			log.Printf("Failed to generate OIDC state: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName(provider.Name()),
+		Value:    strings.Join(values, "."),
+		Path:     "/",
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		Secure:   !IsDevelopment(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's top-level redirect back
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(values[0], values[1], values[2]), http.StatusFound)
+}
+
+// OIDCCallback completes a login started by OIDCLogin, signing in or registering the user
+func OIDCCallback(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) {
+	secretAuth(secret).OIDCCallback(database, w, r)
+}
+
+// OIDCCallback completes a login started by OIDCLogin and responds like Login
+func (a *Auth) OIDCCallback(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	provider, err := GetOIDCProvider(r.PathValue("provider"))
+	if err != nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "Unknown identity provider"})
+		return
+	}
+
+	// The flow state is single-use
+	cookie, err := r.Cookie(oidcCookieName(provider.Name()))
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName(provider.Name()), Path: "/", MaxAge: -1, Secure: !IsDevelopment(), HttpOnly: true})
+	if err != nil {
+		RespondWithJSON(w, 400, map[string]string{"error": "Login session expired, please try again"})
+		return
+	}
+	values := strings.Split(cookie.Value, ".")
+	query := r.URL.Query()
+	if len(values) != 3 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(query.Get("state"))) != 1 {
+		RespondWithJSON(w, 400, map[string]string{"error": "Login session expired, please try again"})
+		return
+	}
+	if providerError := query.Get("error"); providerError != "" {
+		RespondWithJSON(w, 401, map[string]string{"error": "Login was cancelled or refused by the identity provider"})
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), query.Get("code"), values[2])
+	if err != nil {
+		log.Printf("Failed to exchange OIDC code with %s: %v", provider.Name(), err)
+		RespondWithJSON(w, 401, map[string]string{"error": "Login failed"})
+		return
+	}
+
+	identity, err := provider.VerifyIDToken(r.Context(), idToken, values[1])
+	if err != nil {
+		log.Printf("SECURITY: rejected ID token from %s: %v", provider.Name(), err)
+		RespondWithJSON(w, 401, map[string]string{"error": "Login failed"})
+		return
+	}
+
+	user, err := findOrCreateOIDCUser(r.Context(), database, r, identity)
+	switch {
+	case errors.Is(err, ErrRegistrationClosed):
+		RespondWithJSON(w, 403, map[string]string{"error": "Registration is currently closed"})
+		return
+	case errors.Is(err, ErrRegistrationGated):
+		RespondWithJSON(w, 403, map[string]string{"error": "Registration is invite-only"})
+		return
+	case errors.Is(err, ErrOIDCTokenInvalid):
+		RespondWithJSON(w, 403, map[string]string{"error": "The identity provider did not supply a verified email address"})
+		return
+	case errors.Is(err, ErrOIDCAccountExists):
+		// Only the account's owner may link it, and only to an email the provider itself verified
+		if !provider.config.LinkAccounts || identity.EmailAssumed {
+			RespondWithJSON(w, 409, map[string]string{"error": "An account with this email already exists"})
+			return
+		}
+		linkToken, err := startOIDCLink(r.Context(), database, user, identity)
+		if err != nil {
+			log.Printf("Failed to start OIDC account link: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+		RespondWithJSON(w, 409, map[string]string{
+			"error":      "An account with this email already exists; sign in or enter its password to link it",
+			"link_token": linkToken,
+		})
+		return
+	case err != nil:
+		log.Printf("Failed to sign in OIDC user: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	if user.isLocked() {
+		recordLoginFailure(r, "oidc:"+provider.Name(), "", user, LoginOutcomeLocked)
+		RespondWithJSON(w, 423, map[string]string{"error": "Account temporarily locked"})
+		return
+	}
+
+	response, err := a.loginResponse(w, r, user, false)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	database.Collection("users").UpdateOne(r.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"last_login_at": Now()},
+	})
+	recordLoginSuccess(r, "oidc:"+provider.Name(), user)
+	RespondWithJSON(w, 200, response)
+}
+
+// findOrCreateOIDCUser returns the user linked to identity or registers a new one
+// An existing account with the identity's email is never linked here; it is returned with ErrOIDCAccountExists
+// so its owner can confirm the link through LinkOIDCAccount.
+func findOrCreateOIDCUser(ctx context.Context, database *mongo.Database, r *http.Request, identity *OIDCIdentity) (*User, error) {
+	collection := database.Collection("users")
+	link := bson.M{"provider": identity.Provider, "subject": identity.Subject}
+
+	var user User
+	err := collection.FindOne(ctx, bson.M{"identities": bson.M{"$elemMatch": link}}).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	email := SanitizeInput(identity.Email)
+	if !identity.EmailVerified || ValidateEmail(email) != nil {
+		return nil, ErrOIDCTokenInvalid
+	}
+
+	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		return &user, ErrOIDCAccountExists
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	now := Now()
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	inviteCode, err := authorizeRegistration(ctx, database, email, "", id)
+	if err != nil {
+		return nil, err
+	}
+
+	user = User{
+		ID:         id,
+		Email:      email,
+		Name:       SanitizeInput(identity.Name),
+		CreatedAt:  now,
+		IsVerified: true,
+		VerifiedAt: &now,
+		Locale:     ResolveLocale(r, &User{Locale: identity.Locale}), // Prefer the provider's locale if supported
+		InviteCode: inviteCode,
+		Identities: []ExternalIdentity{{Provider: identity.Provider, Subject: identity.Subject, LinkedAt: now.Time}},
+	}
+	if _, err := collection.InsertOne(ctx, user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
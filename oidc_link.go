@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidcLinkTTL is how long a user has to confirm linking an identity to their existing account
+const oidcLinkTTL = 10 * time.Minute
+
+// ErrOIDCAccountExists is returned when an unlinked identity's email belongs to an existing account
+var ErrOIDCAccountExists = errors.New("an account with the identity's email already exists")
+
+// oidcLink is an identity waiting for the owner of the account with its email to confirm linking it
+type oidcLink struct {
+	ID        string    `bson:"_id"` // Hash of the link token
+	UserID    string    `bson:"user_id"`
+	Email     string    `bson:"email"` // The account's email when the provider verified it
+	Provider  string    `bson:"provider"`
+	Subject   string    `bson:"subject"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type OIDCLinkForm struct {
+	LinkToken string `json:"link_token" binding:"required"` // The token OIDCCallback responded with
+	Password  string `json:"password"`                      // The account's password, unless signed in as its owner
+}
+
+// startOIDCLink records a pending link of identity to user, replacing earlier ones, and returns its token
+func startOIDCLink(ctx context.Context, database *mongo.Database, user *User, identity *OIDCIdentity) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	collection := database.Collection("oidc_links")
+	if _, err := collection.DeleteMany(ctx, bson.M{"provider": identity.Provider, "subject": identity.Subject}); err != nil {
+		return "", err
+	}
+	_, err = collection.InsertOne(ctx, oidcLink{
+		ID:        hashOpaqueToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		ExpiresAt: time.Now().Add(oidcLinkTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LinkOIDCAccount links the identity from an OIDCCallback that found an existing account with its email
+func LinkOIDCAccount(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) {
+	secretAuth(secret).LinkOIDCAccount(database, w, r)
+}
+
+// LinkOIDCAccount links the identity from an OIDCCallback that found an existing account with its email
+// The owner confirms by calling it signed in, on an authenticated route, or by re-entering the account's password,
+// which counts towards the lockout like a failed login. Signed-in owners get a confirmation, others respond like Login.
+func (a *Auth) LinkOIDCAccount(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	var form OIDCLinkForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+
+	links := database.Collection("oidc_links")
+	var link oidcLink
+	err := links.FindOne(r.Context(), bson.M{"_id": hashOpaqueToken(form.LinkToken), "expires_at": bson.M{"$gt": time.Now()}}).Decode(&link)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Failed to find OIDC account link: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+		RespondWithJSON(w, 400, map[string]string{"error": "Link expired, please sign in with the identity provider again"})
+		return
+	}
+
+	usersCollection := database.Collection("users")
+	var user User
+	if err := usersCollection.FindOne(r.Context(), bson.M{"_id": link.UserID}).Decode(&user); err != nil {
+		log.Printf("Failed to find user by ID: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	signedIn := GetUserID(r) != ""
+	if signedIn {
+		if GetUserID(r) != user.ID {
+			log.Printf("SECURITY: user %s tried to link a %s identity to user %s", GetUserID(r), link.Provider, user.ID)
+			RespondWithJSON(w, 403, map[string]string{"error": "This identity belongs to a different account"})
+			return
+		}
+	} else {
+		if user.isLocked() {
+			recordLoginFailure(r, "oidc:"+link.Provider, user.Email, &user, LoginOutcomeLocked)
+			RespondWithJSON(w, 423, map[string]string{"error": "Account temporarily locked"})
+			return
+		}
+		if user.Password == "" {
+			RespondWithJSON(w, 401, map[string]string{"error": "Sign in to link this identity"})
+			return
+		}
+		match, err := ComparePasswordAndHash(form.Password, user.Password)
+		if err != nil {
+			log.Printf("Password comparison error for user %s: %v", RedactedEmail(user.Email), err)
+		}
+		if !match {
+			recordFailedLogin(r, database, &user, a.config.Secret)
+			recordLoginFailure(r, "oidc:"+link.Provider, user.Email, &user, LoginOutcomeBadPassword)
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid credentials"})
+			return
+		}
+	}
+
+	// The link is single-use, and only valid while the account still has the address the provider verified
+	result, err := links.DeleteOne(r.Context(), bson.M{"_id": link.ID})
+	if err != nil {
+		log.Printf("Failed to consume OIDC account link: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	now := Now()
+	external := ExternalIdentity{Provider: link.Provider, Subject: link.Subject, LinkedAt: now.Time}
+	var updated *mongo.UpdateResult
+	if result.DeletedCount == 1 {
+		updated, err = usersCollection.UpdateOne(r.Context(),
+			bson.M{"_id": user.ID, "email": link.Email},
+			bson.M{
+				"$push": bson.M{"identities": external},
+				"$set":  bson.M{"is_verified": true, "verified_at": now},
+			},
+		)
+		if err != nil {
+			log.Printf("Failed to link OIDC identity: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+	}
+	if updated == nil || updated.MatchedCount == 0 {
+		RespondWithJSON(w, 400, map[string]string{"error": "Link expired, please sign in with the identity provider again"})
+		return
+	}
+	log.Printf("SECURITY: linked %s identity to user %s", link.Provider, RedactedEmail(user.Email))
+
+	if signedIn {
+		RespondWithJSON(w, 200, map[string]string{"message": "Identity linked"})
+		return
+	}
+
+	response, err := a.loginResponse(w, r, &user, false)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	recordLogin(r.Context(), database, &user, form.Password)
+	recordLoginSuccess(r, "oidc:"+link.Provider, &user)
+	RespondWithJSON(w, 200, response)
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFindOrCreateOIDCUserNeverLinks(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("existing account with the email", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, bson.D{{Key: "_id", Value: testUserID}, {Key: "email", Value: "user@example.com"}}),
+		)
+		identity := &OIDCIdentity{Provider: "okta", Subject: "okta-subject", Email: "user@example.com", EmailVerified: true}
+		r := httptest.NewRequest(http.MethodGet, "/auth/oidc/okta/callback", nil)
+
+		user, err := findOrCreateOIDCUser(context.Background(), mt.DB, r, identity)
+		if !errors.Is(err, ErrOIDCAccountExists) || user == nil || user.ID != testUserID {
+			mt.Fatalf("findOrCreateOIDCUser = %v, %v, want the existing user and ErrOIDCAccountExists", user, err)
+		}
+		mt.GetStartedEvent()
+		mt.GetStartedEvent()
+		if next := mt.GetStartedEvent(); next != nil {
+			mt.Fatalf("unexpected %s after finding the account", next.CommandName)
+		}
+	})
+}
+
+func TestLinkOIDCAccount(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	hash, err := GenerateFromPassword("correct horse", CurrentPasswordParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := bson.D{
+		{Key: "_id", Value: hashOpaqueToken("link-token")},
+		{Key: "user_id", Value: testUserID},
+		{Key: "email", Value: "user@example.com"},
+		{Key: "provider", Value: "okta"},
+		{Key: "subject", Value: "okta-subject"},
+		{Key: "expires_at", Value: time.Now().Add(time.Minute)},
+	}
+	user := bson.D{
+		{Key: "_id", Value: testUserID},
+		{Key: "email", Value: "user@example.com"},
+		{Key: "password", Value: hash},
+	}
+	ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+	tests := []struct {
+		name     string
+		signedIn string // The authenticated caller, if any
+		password string
+		want     int
+		linked   bool
+	}{
+		{"wrong password", "", "wrong", http.StatusUnauthorized, false},
+		{"right password", "", "correct horse", http.StatusOK, true},
+		{"signed in as the owner", testUserID, "", http.StatusOK, true},
+		{"signed in as someone else", "6b1c1b0e-8d4f-4c3a-9d7e-2f5a4b3c2d1e", "", http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			auth, err := NewAuth(AuthConfig{Secret: testSecret})
+			if err != nil {
+				mt.Fatal(err)
+			}
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "db.oidc_links", mtest.FirstBatch, link),
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, user),
+				ok, ok, ok,
+			)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/oidc/link", strings.NewReader(`{"link_token":"link-token","password":"`+tt.password+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.signedIn != "" {
+				r = SetUserID(r, tt.signedIn)
+			}
+			w := httptest.NewRecorder()
+			auth.LinkOIDCAccount(mt.DB, w, r)
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			mt.GetStartedEvent()
+			mt.GetStartedEvent()
+			linked, failureCounted := false, false
+			for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+				if event.CommandName != "update" {
+					continue
+				}
+				update := event.Command.Lookup("updates").Array().Index(0).Value().Document()
+				if _, err := update.LookupErr("u", "$push", "identities"); err == nil {
+					linked = true
+					if email := update.Lookup("q", "email").StringValue(); email != "user@example.com" {
+						mt.Fatalf("linked account filtered on email %q, want the one the provider verified", email)
+					}
+				}
+				if _, err := update.LookupErr("u", "$set", "lockout_count"); err == nil && update.Lookup("u", "$set", "login_attempts").AsInt64() == 1 {
+					failureCounted = true
+				}
+			}
+			if linked != tt.linked {
+				mt.Fatalf("linked = %v, want %v", linked, tt.linked)
+			}
+			if wantFailure := tt.want == http.StatusUnauthorized; failureCounted != wantFailure {
+				mt.Fatalf("failed login counted = %v, want %v", failureCounted, wantFailure)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Bounds for calibrated Argon2id parameters; the minimums follow OWASP's argon2id guidance
+const (
+	minPasswordMemory     = 19 * 1024 // KiB
+	minPasswordIterations = 2
+	maxPasswordIterations = 32
+)
+
+var activePasswordParams atomic.Pointer[PasswordParams]
+
+func init() {
+	activePasswordParams.Store(defaultPasswordParams)
+}
+
+// NewPasswordParams creates Argon2id parameters with the default salt and key lengths
+func NewPasswordParams(memoryKiB, iterations uint32, parallelism uint8) *PasswordParams {
+	return &PasswordParams{
+		memory:      memoryKiB,
+		iterations:  iterations,
+		parallelism: parallelism,
+		saltLength:  defaultPasswordParams.saltLength,
+		keyLength:   defaultPasswordParams.keyLength,
+	}
+}
+
+// String describes the parameters in the encoded hash format, e.g. "m=65536,t=10,p=1"
+func (p *PasswordParams) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.memory, p.iterations, p.parallelism)
+}
+
+// CurrentPasswordParams returns the parameters new password hashes are created with
+func CurrentPasswordParams() *PasswordParams {
+	return activePasswordParams.Load()
+}
+
+// SetPasswordParams changes the parameters new password hashes are created with; pass nil to restore
+// the defaults. Existing hashes are upgraded the next time their user logs in.
+func SetPasswordParams(p *PasswordParams) {
+	if p == nil {
+		p = defaultPasswordParams
+	}
+	activePasswordParams.Store(p)
+}
+
+// CalibratePasswordParams benchmarks Argon2id on this host and returns parameters that take about
+// target to hash. It keeps the default 64 MiB of memory and scales iterations, halving memory
+// (down to 19 MiB) only when even two iterations would exceed target, e.g. in a small container.
+// Call it at startup and pass the result to SetPasswordParams.
+func CalibratePasswordParams(target time.Duration) *PasswordParams {
+	memory := defaultPasswordParams.memory
+	parallelism := defaultPasswordParams.parallelism
+
+	for {
+		perIteration := benchmarkArgon2(memory, parallelism)
+		iterations := uint32(target / perIteration)
+
+		if iterations >= minPasswordIterations || memory/2 < minPasswordMemory {
+			iterations = min(max(iterations, minPasswordIterations), maxPasswordIterations)
+			params := NewPasswordParams(memory, iterations, parallelism)
+			log.Printf("Calibrated password hashing to %s (%v per iteration, target %v)", params, perIteration, target)
+			return params
+		}
+		memory /= 2
+	}
+}
+
+// benchmarkArgon2 returns the fastest of a few single-iteration hashes with the given memory
+func benchmarkArgon2(memory uint32, parallelism uint8) time.Duration {
+	password := []byte("calibration-password")
+	salt := make([]byte, defaultPasswordParams.saltLength)
+
+	fastest := time.Duration(0)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		argon2.IDKey(password, salt, 1, memory, parallelism, defaultPasswordParams.keyLength)
+		if elapsed := time.Since(start); fastest == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return max(fastest, time.Microsecond)
+}
@@ -1,14 +1,17 @@
 package common
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	mathrand "math/rand"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -24,14 +27,14 @@ type ResetPasswordForm struct {
 
 // PasswordReset represents a password reset request in the database
 type PasswordReset struct {
-	ID        string     `json:"id" bson:"_id"`                // Unique ID for the reset request
-	UserID    string     `json:"user_id" bson:"user_id"`       // ID of the user requesting reset
-	Email     string     `json:"email" bson:"email"`           // Email of the user (for easier queries)
-	Token     string     `json:"token" bson:"token"`           // The reset token
-	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"` // When the token expires
-	CreatedAt time.Time  `json:"created_at" bson:"created_at"` // When the reset was requested
-	Used      bool       `json:"used" bson:"used"`             // Whether the token has been used
-	UsedAt    *time.Time `json:"used_at" bson:"used_at"`       // When the token was used
+	ID        string `json:"id" bson:"_id"`                // Unique ID for the reset request
+	UserID    string `json:"user_id" bson:"user_id"`       // ID of the user requesting reset
+	Email     string `json:"email" bson:"email"`           // Email of the user (for easier queries)
+	Token     string `json:"token" bson:"token"`           // The reset token
+	ExpiresAt Time   `json:"expires_at" bson:"expires_at"` // When the token expires
+	CreatedAt Time   `json:"created_at" bson:"created_at"` // When the reset was requested
+	Used      bool   `json:"used" bson:"used"`             // Whether the token has been used
+	UsedAt    *Time  `json:"used_at" bson:"used_at"`       // When the token was used
 }
 
 // GeneratePasswordResetToken generates a cryptographically secure password reset token
@@ -43,8 +46,68 @@ func GeneratePasswordResetToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// ResponseTiming pads responses to a minimum duration plus random jitter, so response times
+// don't reveal which code path ran
+type ResponseTiming struct {
+	MinDuration time.Duration // Floor for the total handler time; zero disables padding
+	Jitter      time.Duration // Random extra delay of up to this duration
+}
+
+// DefaultForgotPasswordTiming returns a floor comfortably above a user lookup
+func DefaultForgotPasswordTiming() ResponseTiming {
+	return ResponseTiming{
+		MinDuration: 750 * time.Millisecond,
+		Jitter:      250 * time.Millisecond,
+	}
+}
+
+// wait sleeps until the padded duration since start has elapsed or ctx is done
+func (t ResponseTiming) wait(ctx context.Context, start time.Time) {
+	if t.MinDuration <= 0 && t.Jitter <= 0 {
+		return
+	}
+
+	target := t.MinDuration
+	if t.Jitter > 0 {
+		target += time.Duration(mathrand.Int63n(int64(t.Jitter)))
+	}
+
+	remaining := target - time.Since(start)
+	if remaining <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+var (
+	forgotPasswordTimingMu sync.RWMutex
+	forgotPasswordTiming   = DefaultForgotPasswordTiming()
+)
+
+// SetForgotPasswordTiming sets the response padding used by ForgotPassword
+// Pass a zero ResponseTiming to disable padding
+func SetForgotPasswordTiming(timing ResponseTiming) {
+	forgotPasswordTimingMu.Lock()
+	defer forgotPasswordTimingMu.Unlock()
+	forgotPasswordTiming = timing
+}
+
 // ForgotPassword handles forgot password requests
+// Responses for unknown, unverified and existing accounts are identical, and all are sent at the same deadline
+// after the request started (see SetForgotPasswordTiming): only the user lookup runs first, while an existing
+// account's reset is created and emailed in the background, so neither content nor timing enumerates accounts.
 func ForgotPassword(database *mongo.Database, w http.ResponseWriter, r *http.Request, baseURL, fromEmail string) {
+	start := time.Now()
+	forgotPasswordTimingMu.RLock()
+	timing := forgotPasswordTiming
+	forgotPasswordTimingMu.RUnlock()
+
 	usersCollection := database.Collection("users")
 	resetsCollection := database.Collection("password_resets")
 
@@ -70,75 +133,76 @@ func ForgotPassword(database *mongo.Database, w http.ResponseWriter, r *http.Req
 	// Find user by email
 	var user User
 	err := usersCollection.FindOne(r.Context(), bson.M{"email": form.Email}).Decode(&user)
-
-	// Always return success to prevent email enumeration
-	// Don't reveal whether the email exists or not
-	successResponse := map[string]string{
-		"message": "If an account with that email exists, we've sent a password reset link to it.",
-	}
-
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// Email doesn't exist, but return success to prevent enumeration
-			RespondWithJSON(w, 200, successResponse)
-			return
-		}
+	if err != nil && err != mongo.ErrNoDocuments {
 		log.Printf("Failed to find user by email: %v", err)
 		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
 		return
 	}
 
-	// Check if user is verified
-	if !user.IsVerified {
-		// Don't send reset email to unverified accounts
-		RespondWithJSON(w, 200, successResponse)
-		return
+	// Don't send reset emails to unknown or unverified accounts
+	if err == nil && user.IsVerified {
+		locale := ResolveLocale(r, &user)
+		origin := originOf(r)
+		RunInBackground("password_reset", func(ctx context.Context) {
+			startPasswordReset(contextWithRequestID(ctx, origin.RequestID), origin, resetsCollection, &user, baseURL, fromEmail, locale)
+		})
 	}
 
+	// Always return success to prevent email enumeration
+	// Don't reveal whether the email exists or not
+	timing.wait(r.Context(), start)
+	RespondWithJSON(w, 200, map[string]string{
+		"message": "If an account with that email exists, we've sent a password reset link to it.",
+	})
+}
+
+// startPasswordReset stores a reset token for user and emails them the link
+// It runs after ForgotPassword responds, so it takes the request's details rather than the request.
+func startPasswordReset(ctx context.Context, origin requestOrigin, resetsCollection *mongo.Collection, user *User, baseURL, fromEmail, locale string) {
 	// Generate password reset token
 	resetToken, err := GeneratePasswordResetToken()
 	if err != nil {
 		log.Printf("Failed to generate password reset token: %v", err)
-		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
 		return
 	}
 
 	// Generate unique ID for the reset request
-	resetID, err := uuid.NewV7()
+	resetID, err := NewID()
 	if err != nil {
 		log.Printf("Failed to generate reset ID: %v", err)
-		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
 		return
 	}
 
 	// Create password reset record
 	now := time.Now()
 	passwordReset := PasswordReset{
-		ID:        resetID.String(),
+		ID:        resetID,
 		UserID:    user.ID,
 		Email:     user.Email,
 		Token:     resetToken,
-		ExpiresAt: now.Add(1 * time.Hour), // Token expires in 1 hour
-		CreatedAt: now,
+		ExpiresAt: NewTime(now.Add(1 * time.Hour)), // Token expires in 1 hour
+		CreatedAt: NewTime(now),
 		Used:      false,
 		UsedAt:    nil,
 	}
 
 	// Insert the reset record
-	_, err = resetsCollection.InsertOne(r.Context(), passwordReset)
-	if err != nil {
+	if _, err := resetsCollection.InsertOne(ctx, passwordReset); err != nil {
 		log.Printf("Failed to create password reset record: %v", err)
-		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
 		return
 	}
 
 	// Send password reset email
-	if err := SendPasswordResetEmail(user.Email, user.Name, baseURL, fromEmail, resetToken); err != nil {
+	if err := SendPasswordResetEmail(user.Email, user.Name, baseURL, fromEmail, resetToken, locale); err != nil {
 		log.Printf("Failed to send password reset email: %v", err)
-		// Don't fail the request if email sending fails, but log it
 	}
-
-	RespondWithJSON(w, 200, successResponse)
+	recordAuthEvent(ctx, nil, AuthEvent{
+		Type:      AuthEventPasswordResetRequested,
+		UserID:    user.ID,
+		Email:     user.Email,
+		IP:        origin.IP,
+		UserAgent: origin.UserAgent,
+	})
 }
 
 // ResetPassword handles password reset with token
@@ -171,13 +235,17 @@ func ResetPassword(database *mongo.Database, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Find password reset record by token
+	// Claim the token atomically so concurrent requests can't both reset the password with it
+	now := time.Now()
 	var passwordReset PasswordReset
-	err := resetsCollection.FindOne(r.Context(), bson.M{
-		"token":      form.Token,
-		"used":       false,                     // Token must not be used
-		"expires_at": bson.M{"$gt": time.Now()}, // Token must not be expired
-	}).Decode(&passwordReset)
+	err := resetsCollection.FindOneAndUpdate(r.Context(),
+		bson.M{
+			"token":      form.Token,
+			"used":       false,              // Token must not be used
+			"expires_at": bson.M{"$gt": now}, // Token must not be expired
+		},
+		bson.M{"$set": bson.M{"used": true, "used_at": now}},
+	).Decode(&passwordReset)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -203,7 +271,7 @@ func ResetPassword(database *mongo.Database, w http.ResponseWriter, r *http.Requ
 	}
 
 	// Hash the new password
-	hashedPassword, err := GenerateFromPassword(form.NewPassword, defaultPasswordParams)
+	hashedPassword, err := GenerateFromPassword(form.NewPassword, CurrentPasswordParams())
 	if err != nil {
 		log.Printf("Failed to hash new password: %v", err)
 		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
@@ -211,13 +279,13 @@ func ResetPassword(database *mongo.Database, w http.ResponseWriter, r *http.Requ
 	}
 
 	// Update user with new password
-	now := time.Now()
 	userUpdate := bson.M{
 		"$set": bson.M{
-			"password":       hashedPassword,
-			"updated_at":     now,
-			"login_attempts": 0,   // Reset failed login attempts
-			"locked_until":   nil, // Unlock account if it was locked
+			"password":            hashedPassword,
+			"password_changed_at": now,
+			"updated_at":          now,
+			"login_attempts":      0,   // Reset failed login attempts
+			"locked_until":        nil, // Unlock account if it was locked
 		},
 	}
 
@@ -228,22 +296,15 @@ func ResetPassword(database *mongo.Database, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Mark password reset token as used
-	resetUpdate := bson.M{
-		"$set": bson.M{
-			"used":    true,
-			"used_at": now,
-		},
-	}
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventPasswordResetCompleted, UserID: user.ID, Email: user.Email})
 
-	_, err = resetsCollection.UpdateOne(r.Context(), bson.M{"_id": passwordReset.ID}, resetUpdate)
-	if err != nil {
-		log.Printf("Failed to mark password reset token as used: %v", err)
-		// Don't fail the request, password was already updated
+	// Sign out every session that used the old password
+	if err := RevokeUserTokens(r.Context(), user.ID); err != nil && !errors.Is(err, ErrTokenRevocationDisabled) {
+		log.Printf("Failed to revoke tokens after password reset: %v", err)
 	}
 
 	// Send password change confirmation email (don't fail if this fails)
-	if err := SendPasswordChangeConfirmationEmail(user.Email, fromEmail, user.Name); err != nil {
+	if err := SendPasswordChangeConfirmationEmail(user.Email, fromEmail, user.Name, ResolveLocale(r, &user)); err != nil {
 		log.Printf("Failed to send password change confirmation email: %v", err)
 		// Continue anyway, password reset was successful
 	}
@@ -0,0 +1,184 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestForgotPasswordTiming(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	const deadline = 200 * time.Millisecond
+	SetForgotPasswordTiming(ResponseTiming{MinDuration: deadline})
+	t.Cleanup(func() { SetForgotPasswordTiming(DefaultForgotPasswordTiming()) })
+	captureLog(t)
+
+	user := func(verified bool) bson.D {
+		return bson.D{{Key: "_id", Value: testUserID}, {Key: "email", Value: "user@example.com"}, {Key: "is_verified", Value: verified}}
+	}
+	tests := []struct {
+		name  string
+		users []bson.D // The users the lookup finds
+		reset bool     // Whether a reset is created
+	}{
+		{"unknown account", nil, false},
+		{"unverified account", []bson.D{user(false)}, false},
+		{"existing account", []bson.D{user(true)}, true},
+	}
+	var durations []time.Duration
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			previous := currentBackgroundRunner()
+			runner := NewBackgroundRunner(1)
+			SetBackgroundRunner(runner)
+			defer SetBackgroundRunner(previous)
+
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, tt.users...),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			)
+			r := httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(`{"email":"user@example.com"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			start := time.Now()
+			ForgotPassword(mt.DB, w, r, "https://example.com", "noreply@example.com")
+			durations = append(durations, time.Since(start))
+			if w.Code != http.StatusOK {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			if err := runner.Shutdown(context.Background()); err != nil {
+				mt.Fatal(err)
+			}
+			mt.GetStartedEvent()
+			insert := mt.GetStartedEvent()
+			if created := insert != nil && insert.CommandName == "insert"; created != tt.reset {
+				mt.Fatalf("reset created = %v, want %v", created, tt.reset)
+			}
+		})
+	}
+
+	// Every outcome responds at the deadline, not after its own work
+	const band = 50 * time.Millisecond
+	for i, d := range durations {
+		if d < deadline || d > deadline+band {
+			t.Errorf("%s took %v, want between %v and %v", tests[i].name, d, deadline, deadline+band)
+		}
+	}
+}
+
+func TestResetPasswordClaimsToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	captureLog(t)
+
+	reset := bson.D{{Key: "_id", Value: "reset-id"}, {Key: "user_id", Value: testUserID}, {Key: "token", Value: "reset-token"}}
+	user := bson.D{{Key: "_id", Value: testUserID}, {Key: "email", Value: "user@example.com"}}
+	tests := []struct {
+		name  string
+		reset any // The reset the claim finds, nil if none
+		want  int
+	}{
+		{"unused token", reset, http.StatusOK},
+		{"used or expired token", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: tt.reset}),
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, user),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			)
+			r := httptest.NewRequest(http.MethodPost, "/auth/reset-password", strings.NewReader(`{"token":"reset-token","new_password":"Correct-Horse-Battery-9"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			ResetPassword(mt.DB, w, r, "noreply@example.com")
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// The token is claimed before anything else, in one command
+			claim := mt.GetStartedEvent()
+			if claim == nil || claim.CommandName != "findAndModify" {
+				mt.Fatalf("first command = %v, want findAndModify", claim)
+			}
+			if used := claim.Command.Lookup("query", "used").Boolean(); used {
+				mt.Fatal("claim matched used tokens")
+			}
+			if used := claim.Command.Lookup("update", "$set", "used").Boolean(); !used {
+				mt.Fatal("claim didn't mark the token used")
+			}
+			for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+				if tt.want != http.StatusOK {
+					mt.Fatalf("unexpected %s after a failed claim", event.CommandName)
+				}
+				if event.CommandName == "update" && event.Command.Lookup("update").StringValue() == "password_resets" {
+					mt.Fatal("token marked used again after the reset")
+				}
+			}
+		})
+	}
+}
+
+func TestForgotPasswordRecordsRequestOrigin(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	SetForgotPasswordTiming(ResponseTiming{})
+	t.Cleanup(func() { SetForgotPasswordTiming(DefaultForgotPasswordTiming()) })
+	captureLog(t)
+
+	mt.Run("verified account", func(mt *mtest.T) {
+		previous := currentBackgroundRunner()
+		runner := NewBackgroundRunner(1)
+		SetBackgroundRunner(runner)
+		defer SetBackgroundRunner(previous)
+
+		authEventsMu.Lock()
+		authEvents = mt.DB.Collection("auth_events")
+		authEventsMu.Unlock()
+		defer func() {
+			authEventsMu.Lock()
+			authEvents = nil
+			authEventsMu.Unlock()
+		}()
+
+		ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, bson.D{{Key: "_id", Value: testUserID}, {Key: "email", Value: "user@example.com"}, {Key: "is_verified", Value: true}}),
+			ok, ok,
+		)
+		r := httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(`{"email":"user@example.com"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("User-Agent", "reset-test")
+		ctx, cancel := context.WithCancel(r.Context())
+		r = r.WithContext(ctx)
+		ForgotPassword(mt.DB, httptest.NewRecorder(), r, "https://example.com", "noreply@example.com")
+
+		// The task must not need the request once the handler has returned
+		cancel()
+		r.Header.Del("User-Agent")
+		r.RemoteAddr = ""
+		if err := runner.Shutdown(context.Background()); err != nil {
+			mt.Fatal(err)
+		}
+
+		for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+			if event.CommandName != "insert" || event.Command.Lookup("insert").StringValue() != "auth_events" {
+				continue
+			}
+			recorded := event.Command.Lookup("documents").Array().Index(0).Value().Document()
+			if ip, agent := recorded.Lookup("ip").StringValue(), recorded.Lookup("user_agent").StringValue(); ip != "192.0.2.1:1234" || agent != "reset-test" {
+				mt.Fatalf("event recorded ip %q and user agent %q, want the request's", ip, agent)
+			}
+			return
+		}
+		mt.Fatal("no auth event recorded")
+	})
+}
@@ -0,0 +1,292 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrPolicyDenied = errors.New("not allowed by policy")
+
+// PolicyEffect is whether a matching policy allows or denies
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "allow"
+	PolicyDeny  PolicyEffect = "deny"
+)
+
+// PolicyResource is the thing an action is performed on
+type PolicyResource struct {
+	Type       string         // e.g. "flight"
+	ID         string         // Empty for actions on the collection, e.g. creating a flight
+	OwnerID    string         // ID of the user who owns the resource, for IsOwner
+	Attributes map[string]any // Other fields conditions may check, e.g. "visibility"
+}
+
+// PolicyRequest is what an authorization decision is about
+type PolicyRequest struct {
+	Subject  *AppClaims // Claims of the caller; nil for anonymous requests
+	Action   string     // Action on the resource type, e.g. "flight:edit"
+	Resource *PolicyResource
+}
+
+// PolicyCondition is a check a policy needs to hold, in addition to its action matching
+type PolicyCondition func(request PolicyRequest) bool
+
+// Policy allows or denies actions when all its conditions hold
+type Policy struct {
+	Name       string // Reported in decisions and logs
+	Effect     PolicyEffect
+	Actions    []string // Actions it applies to; "flight:*" matches every flight action and "*" every action
+	Conditions []PolicyCondition
+}
+
+// PolicyDecision is the outcome of evaluating a request
+type PolicyDecision struct {
+	Allowed bool
+	Policy  string // The deciding policy, or "" if none matched and the request was denied by default
+}
+
+// PolicySet evaluates requests against policies: a matching deny wins over any allow, and requests
+// no policy allows are denied
+type PolicySet struct {
+	mu       sync.RWMutex
+	policies []Policy
+}
+
+// defaultPolicies is the set used by Can and RequirePolicy
+var defaultPolicies = &PolicySet{}
+
+// NewPolicySet creates a set holding policies
+func NewPolicySet(policies ...Policy) (*PolicySet, error) {
+	set := &PolicySet{}
+	if err := set.Add(policies...); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// DefaultPolicySet returns the set used by Can and RequirePolicy
+func DefaultPolicySet() *PolicySet {
+	return defaultPolicies
+}
+
+// AddPolicies adds policies to the default set
+func AddPolicies(policies ...Policy) error {
+	return defaultPolicies.Add(policies...)
+}
+
+// Add adds policies to the set
+func (s *PolicySet) Add(policies ...Policy) error {
+	for _, policy := range policies {
+		if policy.Effect != PolicyAllow && policy.Effect != PolicyDeny {
+			return fmt.Errorf("policy %q needs an effect of allow or deny", policy.Name)
+		}
+		if len(policy.Actions) == 0 {
+			return fmt.Errorf("policy %q applies to no actions", policy.Name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = append(s.policies, policies...)
+	return nil
+}
+
+// Evaluate decides a request
+func (s *PolicySet) Evaluate(request PolicyRequest) PolicyDecision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	decision := PolicyDecision{}
+	for _, policy := range s.policies {
+		if !policy.applies(request) {
+			continue
+		}
+		if policy.Effect == PolicyDeny {
+			return PolicyDecision{Allowed: false, Policy: policy.Name}
+		}
+		if !decision.Allowed {
+			decision = PolicyDecision{Allowed: true, Policy: policy.Name}
+		}
+	}
+	return decision
+}
+
+// Authorize returns ErrPolicyDenied unless the set allows subject to perform action on resource
+func (s *PolicySet) Authorize(subject *AppClaims, action string, resource *PolicyResource) error {
+	if !s.Evaluate(PolicyRequest{Subject: subject, Action: action, Resource: resource}).Allowed {
+		return ErrPolicyDenied
+	}
+	return nil
+}
+
+// applies reports whether the policy matches the request's action and all its conditions hold
+func (p Policy) applies(request PolicyRequest) bool {
+	matched := false
+	for _, action := range p.Actions {
+		if actionMatches(action, request.Action) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+
+	for _, condition := range p.Conditions {
+		if !condition(request) {
+			return false
+		}
+	}
+	return true
+}
+
+// actionMatches reports whether pattern, e.g. "flight:*", matches action
+func actionMatches(pattern, action string) bool {
+	if pattern == "*" || pattern == action {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	return ok && strings.HasPrefix(action, prefix)
+}
+
+// IsOwner holds when the caller owns the resource
+func IsOwner() PolicyCondition {
+	return func(request PolicyRequest) bool {
+		return request.Subject != nil && request.Resource != nil && request.Resource.OwnerID != "" &&
+			request.Resource.OwnerID == request.Subject.Subject
+	}
+}
+
+// IsAuthenticated holds for registered users, not guests or anonymous requests
+func IsAuthenticated() PolicyCondition {
+	return func(request PolicyRequest) bool {
+		return request.Subject != nil && !request.Subject.IsGuest()
+	}
+}
+
+// HasRole holds when the caller's token grants role
+func HasRole(role string) PolicyCondition {
+	return func(request PolicyRequest) bool {
+		return request.Subject.HasRole(role)
+	}
+}
+
+// HasScope holds when the caller's token grants scope
+func HasScope(scope string) PolicyCondition {
+	return func(request PolicyRequest) bool {
+		return request.Subject.HasScope(scope)
+	}
+}
+
+// AttributeEquals holds when the resource's attribute key equals value
+func AttributeEquals(key string, value any) PolicyCondition {
+	return func(request PolicyRequest) bool {
+		return request.Resource != nil && reflect.DeepEqual(request.Resource.Attributes[key], value)
+	}
+}
+
+// AnyOf holds when at least one of conditions does
+func AnyOf(conditions ...PolicyCondition) PolicyCondition {
+	return func(request PolicyRequest) bool {
+		for _, condition := range conditions {
+			if condition(request) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Can reports whether the default policy set allows the request's caller to perform action on resource
+func Can(r *http.Request, action string, resource *PolicyResource) bool {
+	return defaultPolicies.Authorize(ClaimsFromContext(r), action, resource) == nil
+}
+
+// ResourceResolver loads the resource a request acts on; mongo.ErrNoDocuments means it doesn't exist
+type ResourceResolver func(r *http.Request) (*PolicyResource, error)
+
+// MongoResource resolves the document in collection whose _id is the request's idParam path value,
+// taking its owner from ownerField and, if given, attributes from fields
+func MongoResource(collection *mongo.Collection, resourceType, idParam, ownerField string, fields ...string) ResourceResolver {
+	return func(r *http.Request) (*PolicyResource, error) {
+		id := r.PathValue(idParam)
+		if id == "" {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		var document bson.M
+		if err := collection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&document); err != nil {
+			return nil, err
+		}
+
+		resource := &PolicyResource{Type: resourceType, ID: id, Attributes: map[string]any{}}
+		resource.OwnerID, _ = document[ownerField].(string)
+		for _, field := range fields {
+			resource.Attributes[field] = document[field]
+		}
+		return resource, nil
+	}
+}
+
+// Middleware lets a request through only if the set allows its caller to perform action on the resource
+// resolve loads, which may be nil for actions on no particular resource. Put it behind an authentication
+// middleware; handlers can read the resource with PolicyResourceFromContext.
+func (s *PolicySet) Middleware(action string, resolve ResourceResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var resource *PolicyResource
+			if resolve != nil {
+				var err error
+				resource, err = resolve(r)
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					RespondWithJSON(w, 404, map[string]string{"error": "Not found"})
+					return
+				}
+				if err != nil {
+					log.Printf("Failed to load resource for %s: %v", action, err)
+					RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+					return
+				}
+			}
+
+			claims := ClaimsFromContext(r)
+			decision := s.Evaluate(PolicyRequest{Subject: claims, Action: action, Resource: resource})
+			if !decision.Allowed {
+				subject := "anonymous"
+				if claims != nil {
+					subject = claims.Subject
+				}
+				log.Printf("SECURITY: %s denied %s (policy: %q)", subject, action, decision.Policy)
+				RespondWithJSON(w, 403, map[string]string{"error": "Forbidden"})
+				return
+			}
+
+			if resource != nil {
+				r = r.WithContext(context.WithValue(r.Context(), policyResourceKey, resource))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePolicy is Middleware with the default policy set
+func RequirePolicy(action string, resolve ResourceResolver) func(http.Handler) http.Handler {
+	return defaultPolicies.Middleware(action, resolve)
+}
+
+// PolicyResourceFromContext returns the resource the policy middleware authorized, or nil if there is none
+func PolicyResourceFromContext(r *http.Request) *PolicyResource {
+	resource, _ := r.Context().Value(policyResourceKey).(*PolicyResource)
+	return resource
+}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPolicySetEvaluate(t *testing.T) {
+	set, err := NewPolicySet(
+		Policy{Name: "owners edit", Effect: PolicyAllow, Actions: []string{"flight:edit"}, Conditions: []PolicyCondition{IsOwner()}},
+		Policy{Name: "users view public", Effect: PolicyAllow, Actions: []string{"flight:view"}, Conditions: []PolicyCondition{IsAuthenticated(), AttributeEquals("visibility", "public")}},
+		Policy{Name: "admins do anything", Effect: PolicyAllow, Actions: []string{"*"}, Conditions: []PolicyCondition{HasRole(RoleAdmin)}},
+		Policy{Name: "locked flights", Effect: PolicyDeny, Actions: []string{"flight:*"}, Conditions: []PolicyCondition{AttributeEquals("locked", true)}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := func(id string, roles ...string) *AppClaims {
+		return &AppClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: id}, Roles: roles}
+	}
+	flight := func(attributes map[string]any) *PolicyResource {
+		return &PolicyResource{Type: "flight", ID: "flight-1", OwnerID: testUserID, Attributes: attributes}
+	}
+
+	tests := []struct {
+		name     string
+		subject  *AppClaims
+		action   string
+		resource *PolicyResource
+		want     PolicyDecision
+	}{
+		{"owner edits", user(testUserID), "flight:edit", flight(nil), PolicyDecision{Allowed: true, Policy: "owners edit"}},
+		{"someone else edits", user("other"), "flight:edit", flight(nil), PolicyDecision{}},
+		{"anonymous edits", nil, "flight:edit", flight(nil), PolicyDecision{}},
+		{"user views a public flight", user("other"), "flight:view", flight(map[string]any{"visibility": "public"}), PolicyDecision{Allowed: true, Policy: "users view public"}},
+		{"guest views a public flight", user("guest-1", RoleGuest), "flight:view", flight(map[string]any{"visibility": "public"}), PolicyDecision{}},
+		{"user views a private flight", user("other"), "flight:view", flight(map[string]any{"visibility": "private"}), PolicyDecision{}},
+		{"admin matches the wildcard", user("admin-1", RoleAdmin), "hotel:delete", nil, PolicyDecision{Allowed: true, Policy: "admins do anything"}},
+		{"deny wins over allow", user("admin-1", RoleAdmin), "flight:edit", flight(map[string]any{"locked": true}), PolicyDecision{Policy: "locked flights"}},
+		{"no matching policy", user(testUserID), "flight:delete", flight(nil), PolicyDecision{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := set.Evaluate(PolicyRequest{Subject: tt.subject, Action: tt.action, Resource: tt.resource})
+			if got != tt.want {
+				t.Fatalf("Evaluate = %+v, want %+v", got, tt.want)
+			}
+			if err := set.Authorize(tt.subject, tt.action, tt.resource); (err == nil) != tt.want.Allowed || (err != nil && !errors.Is(err, ErrPolicyDenied)) {
+				t.Fatalf("Authorize = %v, want allowed %v", err, tt.want.Allowed)
+			}
+		})
+	}
+}
+
+func TestPolicySetAddRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"no effect", Policy{Name: "p", Actions: []string{"*"}}},
+		{"no actions", Policy{Name: "p", Effect: PolicyAllow}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicySet(tt.policy); err == nil {
+				t.Fatal("NewPolicySet accepted an invalid policy")
+			}
+		})
+	}
+}
+
+func TestPolicySetMiddleware(t *testing.T) {
+	set, err := NewPolicySet(Policy{Name: "owners edit", Effect: PolicyAllow, Actions: []string{"flight:edit"}, Conditions: []PolicyCondition{IsOwner()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(r *http.Request) (*PolicyResource, error) {
+		switch r.PathValue("id") {
+		case "flight-1":
+			return &PolicyResource{Type: "flight", ID: "flight-1", OwnerID: testUserID}, nil
+		case "missing":
+			return nil, mongo.ErrNoDocuments
+		default:
+			return nil, errors.New("database unavailable")
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("PUT /flights/{id}", set.Middleware("flight:edit", resolve)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if resource := PolicyResourceFromContext(r); resource == nil || resource.ID != r.PathValue("id") {
+			t.Errorf("resource in context = %+v", resource)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	tests := []struct {
+		name   string
+		caller string
+		id     string
+		want   int
+	}{
+		{"owner", testUserID, "flight-1", http.StatusNoContent},
+		{"someone else", "other", "flight-1", http.StatusForbidden},
+		{"anonymous", "", "flight-1", http.StatusForbidden},
+		{"missing resource", testUserID, "missing", http.StatusNotFound},
+		{"resolver error", testUserID, "broken", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			r := httptest.NewRequest(http.MethodPut, "/flights/"+tt.id, nil)
+			if tt.caller != "" {
+				r = SetClaims(r, &AppClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: tt.caller}})
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimit is how many requests a key may make in any Window
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// PerMinute returns a limit of n requests a minute
+func PerMinute(n int) RateLimit {
+	return RateLimit{Requests: n, Window: time.Minute}
+}
+
+// RateLimitResult is the outcome of counting a request
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // Until the request would be allowed; zero if it is
+}
+
+// RateLimitStore counts requests in fixed windows; implementations must be safe for concurrent use
+type RateLimitStore interface {
+	// Increment counts a request in the window key, which expires at expiresAt, returning the window's new
+	// count and the count of previousKey, the window before it
+	Increment(ctx context.Context, key, previousKey string, expiresAt time.Time) (current, previous int64, err error)
+}
+
+// RateLimitKeyFunc returns what a request is limited by, e.g. its client IP
+type RateLimitKeyFunc func(r *http.Request) string
+
+// trustedProxies are the networks whose X-Forwarded-For entries KeyByIP believes
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// SetTrustedProxies sets the addresses or CIDRs of the proxies in front of the service, e.g. a load
+// balancer's subnet, so KeyByIP can find the client behind them; pass none to trust no proxy
+func SetTrustedProxies(cidrs ...string) error {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return fmt.Errorf("trusted proxy %q is neither an address nor a CIDR", cidr)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	trustedProxies.Store(&prefixes)
+	return nil
+}
+
+// isTrustedProxy reports whether ip belongs to a proxy set with SetTrustedProxies
+func isTrustedProxy(ip string) bool {
+	prefixes := trustedProxies.Load()
+	if prefixes == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOnly strips the port, if any, from an address
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// KeyByIP limits each client IP: the connecting address or, if that is a trusted proxy, the rightmost
+// X-Forwarded-For address that isn't one. Addresses left of it are whatever the client sent, so they are
+// never used; without SetTrustedProxies the header is ignored.
+func KeyByIP(r *http.Request) string {
+	ip := hostOnly(r.RemoteAddr)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hostOnly(strings.TrimSpace(hops[i]))
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// KeyByUser limits each authenticated user, and anonymous requests by IP
+func KeyByUser(r *http.Request) string {
+	if userID := GetUserID(r); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + KeyByIP(r)
+}
+
+// KeyByAPIKey limits each API key presented in header, and requests without one by IP
+// Keys are hashed so they never reach the store.
+func KeyByAPIKey(header string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if key := r.Header.Get(header); key != "" {
+			return "key:" + hashOpaqueToken(key)
+		}
+		return "ip:" + KeyByIP(r)
+	}
+}
+
+// RateLimiter limits requests with a sliding window: the previous window's count, weighted by how much of it
+// still overlaps the sliding window, is added to the current one's
+type RateLimiter struct {
+	name  string
+	limit RateLimit
+	key   RateLimitKeyFunc
+	store RateLimitStore
+}
+
+// NewRateLimiter creates a limiter allowing limit per key; a limit set for name in RuntimeConfig.RateLimits
+// overrides it, as requests per minute. A nil store counts in memory, which only suits a single instance.
+func NewRateLimiter(name string, limit RateLimit, key RateLimitKeyFunc, store RateLimitStore) (*RateLimiter, error) {
+	if limit.Requests <= 0 || limit.Window <= 0 {
+		return nil, fmt.Errorf("rate limit %q needs a positive number of requests and window", name)
+	}
+	if key == nil {
+		key = KeyByIP
+	}
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	return &RateLimiter{name: name, limit: limit, key: key, store: store}, nil
+}
+
+// currentLimit returns the limit in effect, from the runtime configuration if it sets one
+func (l *RateLimiter) currentLimit() RateLimit {
+	if perMinute := CurrentRuntimeConfig().RateLimits[l.name]; perMinute > 0 {
+		return PerMinute(perMinute)
+	}
+	return l.limit
+}
+
+// Allow counts a request and reports whether it is within the limit
+func (l *RateLimiter) Allow(r *http.Request) (RateLimitResult, error) {
+	limit := l.currentLimit()
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+	key := "rl:" + l.name + ":" + l.key(r) + ":"
+
+	current, previous, err := l.store.Increment(r.Context(),
+		key+strconv.FormatInt(windowStart.UnixNano(), 36),
+		key+strconv.FormatInt(windowStart.Add(-limit.Window).UnixNano(), 36),
+		windowStart.Add(2*limit.Window), // Kept through the next window, which weighs it
+	)
+	if err != nil {
+		return RateLimitResult{Allowed: true, Limit: limit.Requests, Remaining: limit.Requests}, err
+	}
+
+	overlap := 1 - float64(now.Sub(windowStart))/float64(limit.Window)
+	count := float64(previous)*overlap + float64(current)
+	result := RateLimitResult{
+		Allowed:   count <= float64(limit.Requests),
+		Limit:     limit.Requests,
+		Remaining: max(0, limit.Requests-int(math.Ceil(count))),
+	}
+	if !result.Allowed {
+		result.RetryAfter = retryAfter(limit, now.Sub(windowStart), current, previous)
+	}
+	return result, nil
+}
+
+// retryAfter returns how long until the sliding count drops to the limit, given elapsed time into the window
+func retryAfter(limit RateLimit, elapsed time.Duration, current, previous int64) time.Duration {
+	// A window's weight falls linearly through the next window, until it leaves room for the other's count
+	fade := func(count, room int64) time.Duration {
+		return time.Duration(float64(limit.Window) * (1 - float64(room)/float64(count)))
+	}
+
+	windowEnd := limit.Window - elapsed
+	if current >= int64(limit.Requests) || previous == 0 {
+		// Wait for this window's count to fade in the next one enough to allow one more request
+		return windowEnd + fade(current, int64(limit.Requests)-1)
+	}
+	return max(time.Second, fade(previous, int64(limit.Requests)-current)-elapsed)
+}
+
+// Middleware responds 429 with Retry-After to requests over the limit and sets X-RateLimit-Limit and
+// X-RateLimit-Remaining on the rest. If the store fails, requests are allowed and the failure logged.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := l.Allow(r)
+		if err != nil {
+			log.Printf("Failed to check rate limit %s, allowing: %v", l.name, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			RespondWithJSON(w, 429, map[string]string{"error": "Too many requests. Please try again later."})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitMiddleware returns the middleware of a new RateLimiter, panicking if limit is invalid,
+// for limits fixed at route registration
+func RateLimitMiddleware(name string, limit RateLimit, key RateLimitKeyFunc, store RateLimitStore) func(http.Handler) http.Handler {
+	limiter, err := NewRateLimiter(name, limit, key, store)
+	if err != nil {
+		panic(err)
+	}
+	return limiter.Middleware
+}
+
+// MemoryRateLimitStore counts requests in memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]memoryRateLimitCounter
+	lastSweep time.Time
+}
+
+type memoryRateLimitCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// memoryRateLimitSweepInterval is how often expired counters are dropped from a MemoryRateLimitStore
+const memoryRateLimitSweepInterval = time.Minute
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: make(map[string]memoryRateLimitCounter), lastSweep: time.Now()}
+}
+
+// Increment counts a request in key's window
+func (s *MemoryRateLimitStore) Increment(ctx context.Context, key, previousKey string, expiresAt time.Time) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > memoryRateLimitSweepInterval {
+		for k, counter := range s.counters {
+			if now.After(counter.expiresAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	counter := s.counters[key]
+	counter.count++
+	counter.expiresAt = expiresAt
+	s.counters[key] = counter
+	return counter.count, s.counters[previousKey].count, nil
+}
+
+// MongoRateLimitStore counts requests in a collection, shared by every instance
+type MongoRateLimitStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoRateLimitStore counts requests in the database's rate_limits collection, creating its TTL index
+func NewMongoRateLimitStore(ctx context.Context, database *mongo.Database) (*MongoRateLimitStore, error) {
+	collection := database.Collection("rate_limits")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoRateLimitStore{collection: collection}, nil
+}
+
+// Increment counts a request in key's window
+func (s *MongoRateLimitStore) Increment(ctx context.Context, key, previousKey string, expiresAt time.Time) (int64, int64, error) {
+	var current, previous struct {
+		Count int64 `bson:"count"`
+	}
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expires_at": expiresAt}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&current)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = s.collection.FindOne(ctx, bson.M{"_id": previousKey}).Decode(&previous)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, 0, err
+	}
+	return current.Count, previous.Count, nil
+}
+
+// RedisDoFunc runs a Redis command and returns its reply, so any client library can back a RedisRateLimitStore,
+// e.g. with go-redis: func(ctx context.Context, args ...any) (any, error) { return rdb.Do(ctx, args...).Result() }
+type RedisDoFunc func(ctx context.Context, args ...any) (any, error)
+
+// redisIncrementScript counts a request and reads the previous window in one round trip
+const redisIncrementScript = `
+local current = redis.call('INCR', KEYS[1])
+if current == 1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[1])
+end
+return {current, tonumber(redis.call('GET', KEYS[2]) or '0')}
+`
+
+// RedisRateLimitStore counts requests in Redis, shared by every instance
+type RedisRateLimitStore struct {
+	do RedisDoFunc
+}
+
+// NewRedisRateLimitStore counts requests with the Redis commands run by do
+func NewRedisRateLimitStore(do RedisDoFunc) *RedisRateLimitStore {
+	return &RedisRateLimitStore{do: do}
+}
+
+// Increment counts a request in key's window
+func (s *RedisRateLimitStore) Increment(ctx context.Context, key, previousKey string, expiresAt time.Time) (int64, int64, error) {
+	reply, err := s.do(ctx, "EVAL", redisIncrementScript, 2, key, previousKey, expiresAt.UnixMilli())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	counts, ok := reply.([]any)
+	if !ok || len(counts) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	current, ok := counts[0].(int64)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	previous, ok := counts[1].(int64)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	return current, previous, nil
+}
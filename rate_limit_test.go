@@ -0,0 +1,110 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyByIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxy", nil, "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"forged header without trusted proxies", nil, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"behind a trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"forged entry left of the client", []string{"10.0.0.0/8"}, "10.0.0.2:5000", []string{"192.0.2.99, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", []string{"10.0.0.0/8", "172.16.0.1"}, "10.0.0.2:5000", []string{"192.0.2.99, 198.51.100.1, 172.16.0.1"}, "198.51.100.1"},
+		{"repeated headers", []string{"10.0.0.0/8"}, "10.0.0.2:5000", []string{"192.0.2.99", "198.51.100.1"}, "198.51.100.1"},
+		{"only trusted hops", []string{"10.0.0.0/8"}, "10.0.0.2:5000", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"IPv6 peer", nil, "[2001:db8::1]:5000", nil, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetTrustedProxies(tt.trusted...); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { SetTrustedProxies() })
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := KeyByIP(r); got != tt.want {
+				t.Fatalf("KeyByIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesRejectsInvalid(t *testing.T) {
+	if err := SetTrustedProxies("not-an-ip"); err == nil {
+		t.Fatal("SetTrustedProxies accepted an invalid address")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	handler := RateLimitMiddleware("test", RateLimit{Requests: 3, Window: time.Hour}, nil, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := range 3 {
+		w := request("203.0.113.7:5000")
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, http.StatusNoContent)
+		}
+		if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != strconv.Itoa(2-i) {
+			t.Fatalf("request %d: X-RateLimit-Remaining = %s, want %d", i+1, remaining, 2-i)
+		}
+	}
+
+	w := request("203.0.113.7:5000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > int(time.Hour.Seconds())*2 {
+		t.Fatalf("Retry-After = %q, want seconds until the window allows a request", w.Header().Get("Retry-After"))
+	}
+
+	// Other clients have their own count
+	if w := request("198.51.100.1:5000"); w.Code != http.StatusNoContent {
+		t.Fatalf("other client: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	limit := RateLimit{Requests: 10, Window: time.Minute}
+	tests := []struct {
+		name              string
+		elapsed           time.Duration
+		current, previous int64
+		min, max          time.Duration
+	}{
+		{"current window full", 15 * time.Second, 11, 0, 45 * time.Second, time.Minute + 45*time.Second},
+		{"previous window still weighs", 15 * time.Second, 5, 10, time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryAfter(limit, tt.elapsed, tt.current, tt.previous)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("retryAfter = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package common
+
+import (
+	"context"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Dataset file names read from a ReferenceDataSource
+const (
+	CountriesDataset = "countries.csv"
+	AirportsDataset  = "airports.csv"
+	AirlinesDataset  = "airlines.csv"
+)
+
+//go:embed data/countries.csv
+var embeddedReferenceData embed.FS
+
+// Country represents an ISO 3166-1 country
+type Country struct {
+	Code string `json:"code"` // ISO 3166-1 alpha-2 code
+	Name string `json:"name"`
+}
+
+// Airport represents an airport identified by its IATA and/or ICAO code
+type Airport struct {
+	IATA      string  `json:"iata"`
+	ICAO      string  `json:"icao"`
+	Name      string  `json:"name"`
+	City      string  `json:"city"`
+	Country   string  `json:"country"` // ISO 3166-1 alpha-2 code
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Airline represents an airline identified by its IATA and/or ICAO code
+type Airline struct {
+	IATA     string `json:"iata"`
+	ICAO     string `json:"icao"`
+	Name     string `json:"name"`
+	Callsign string `json:"callsign"`
+	Country  string `json:"country"` // ISO 3166-1 alpha-2 code
+}
+
+// ReferenceDataSource opens a named CSV dataset
+// Implementations should return an error wrapping fs.ErrNotExist when the dataset is absent
+type ReferenceDataSource interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// FSReferenceSource reads datasets from a filesystem such as an embed.FS
+type FSReferenceSource struct {
+	FS fs.FS
+}
+
+// Open opens the named dataset from the filesystem
+func (s FSReferenceSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.FS.Open(name)
+}
+
+// S3ReferenceSource reads datasets from an S3 bucket under an optional key prefix
+type S3ReferenceSource struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+// Open downloads the named dataset from S3
+func (s S3ReferenceSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	key := path.Join(s.Prefix, name)
+	output, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to download s3://%s/%s: %w", s.Bucket, key, err)
+	}
+	return output.Body, nil
+}
+
+// DefaultReferenceSource returns the source holding the datasets embedded in this package
+func DefaultReferenceSource() ReferenceDataSource {
+	sub, _ := fs.Sub(embeddedReferenceData, "data")
+	return FSReferenceSource{FS: sub}
+}
+
+// ReferenceData holds cached country, airport and airline lookups
+type ReferenceData struct {
+	source ReferenceDataSource
+
+	mu              sync.RWMutex
+	countries       map[string]Country
+	airportsByIATA  map[string]Airport
+	airportsByICAO  map[string]Airport
+	airlinesByIATA  map[string]Airline
+	airlinesByICAO  map[string]Airline
+	lastRefreshedAt time.Time
+}
+
+// NewReferenceData creates a reference data cache backed by the given source
+// If source is nil, the embedded datasets are used
+func NewReferenceData(source ReferenceDataSource) *ReferenceData {
+	if source == nil {
+		source = DefaultReferenceSource()
+	}
+	return &ReferenceData{source: source}
+}
+
+// Load reads all datasets from the source and replaces the cached data
+// Countries fall back to the embedded dataset; airports and airlines are optional
+func (rd *ReferenceData) Load(ctx context.Context) error {
+	countryRows, err := readReferenceCSV(ctx, rd.source, CountriesDataset)
+	if errors.Is(err, fs.ErrNotExist) {
+		countryRows, err = readReferenceCSV(ctx, DefaultReferenceSource(), CountriesDataset)
+	}
+	if err != nil {
+		return err
+	}
+
+	airportRows, err := readReferenceCSV(ctx, rd.source, AirportsDataset)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	airlineRows, err := readReferenceCSV(ctx, rd.source, AirlinesDataset)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	countries := make(map[string]Country, len(countryRows))
+	for _, row := range countryRows {
+		country := Country{Code: strings.ToUpper(row["code"]), Name: row["name"]}
+		if country.Code != "" {
+			countries[country.Code] = country
+		}
+	}
+
+	airportsByIATA := make(map[string]Airport, len(airportRows))
+	airportsByICAO := make(map[string]Airport, len(airportRows))
+	for _, row := range airportRows {
+		airport := Airport{
+			IATA:    strings.ToUpper(row["iata"]),
+			ICAO:    strings.ToUpper(row["icao"]),
+			Name:    row["name"],
+			City:    row["city"],
+			Country: strings.ToUpper(row["country"]),
+		}
+		airport.Latitude, _ = strconv.ParseFloat(row["latitude"], 64)
+		airport.Longitude, _ = strconv.ParseFloat(row["longitude"], 64)
+
+		if airport.IATA != "" {
+			airportsByIATA[airport.IATA] = airport
+		}
+		if airport.ICAO != "" {
+			airportsByICAO[airport.ICAO] = airport
+		}
+	}
+
+	airlinesByIATA := make(map[string]Airline, len(airlineRows))
+	airlinesByICAO := make(map[string]Airline, len(airlineRows))
+	for _, row := range airlineRows {
+		airline := Airline{
+			IATA:     strings.ToUpper(row["iata"]),
+			ICAO:     strings.ToUpper(row["icao"]),
+			Name:     row["name"],
+			Callsign: row["callsign"],
+			Country:  strings.ToUpper(row["country"]),
+		}
+
+		if airline.IATA != "" {
+			airlinesByIATA[airline.IATA] = airline
+		}
+		if airline.ICAO != "" {
+			airlinesByICAO[airline.ICAO] = airline
+		}
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.countries = countries
+	rd.airportsByIATA = airportsByIATA
+	rd.airportsByICAO = airportsByICAO
+	rd.airlinesByIATA = airlinesByIATA
+	rd.airlinesByICAO = airlinesByICAO
+	rd.lastRefreshedAt = time.Now()
+
+	return nil
+}
+
+// StartRefresh reloads the datasets every interval until ctx is cancelled
+// Failed refreshes are logged and the previously loaded data is kept
+func (rd *ReferenceData) StartRefresh(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := rd.Load(ctx); err != nil {
+					log.Printf("Failed to refresh reference data: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// LastRefreshedAt returns when the data was last loaded successfully
+func (rd *ReferenceData) LastRefreshedAt() time.Time {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return rd.lastRefreshedAt
+}
+
+// Country looks up a country by its ISO 3166-1 alpha-2 code
+func (rd *ReferenceData) Country(code string) (Country, bool) {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	country, ok := rd.countries[strings.ToUpper(code)]
+	return country, ok
+}
+
+// Countries returns all countries sorted by code
+func (rd *ReferenceData) Countries() []Country {
+	rd.mu.RLock()
+	countries := make([]Country, 0, len(rd.countries))
+	for _, country := range rd.countries {
+		countries = append(countries, country)
+	}
+	rd.mu.RUnlock()
+
+	sort.Slice(countries, func(i, j int) bool { return countries[i].Code < countries[j].Code })
+	return countries
+}
+
+// Airport looks up an airport by IATA (3 letters) or ICAO (4 letters) code
+func (rd *ReferenceData) Airport(code string) (Airport, bool) {
+	code = strings.ToUpper(code)
+
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	if airport, ok := rd.airportsByIATA[code]; ok {
+		return airport, true
+	}
+	airport, ok := rd.airportsByICAO[code]
+	return airport, ok
+}
+
+// Airline looks up an airline by IATA (2 characters) or ICAO (3 letters) code
+func (rd *ReferenceData) Airline(code string) (Airline, bool) {
+	code = strings.ToUpper(code)
+
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	if airline, ok := rd.airlinesByIATA[code]; ok {
+		return airline, true
+	}
+	airline, ok := rd.airlinesByICAO[code]
+	return airline, ok
+}
+
+// readReferenceCSV reads a CSV dataset into rows keyed by lower-cased header names
+func readReferenceCSV(ctx context.Context, source ReferenceDataSource, name string) ([]map[string]string, error) {
+	file, err := source.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s header: %w", name, err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	var rows []map[string]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		row := make(map[string]string, len(header))
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
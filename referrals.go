@@ -0,0 +1,159 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// referralPurpose scopes TokenService tokens to referral links
+	referralPurpose = "referral"
+
+	// referralTTL is how long a shared referral link keeps attributing signups
+	referralTTL = 90 * 24 * time.Hour
+)
+
+// Referral is a user who signed up through another user's referral link
+type Referral struct {
+	ID        string `json:"id" bson:"_id"`
+	Name      string `json:"name" bson:"name"`
+	CreatedAt Time   `json:"created_at" bson:"created_at"`
+}
+
+// ReferralCount is the number of signups attributed to a user
+type ReferralCount struct {
+	UserID string `json:"user_id" bson:"_id"`
+	Count  int64  `json:"count" bson:"count"`
+}
+
+// IssueReferralToken creates a signed token attributing signups to userID
+func IssueReferralToken(secret, userID string) (string, error) {
+	tokens, err := NewTokenService(secret)
+	if err != nil {
+		return "", err
+	}
+	return tokens.Issue(referralPurpose, userID, "", referralTTL)
+}
+
+// ReferralLink returns a user's shareable signup link on the default email service's frontend
+// An empty baseURL falls back to EmailConfig.BaseURL
+func ReferralLink(secret, baseURL, userID string) (string, error) {
+	token, err := IssueReferralToken(secret, userID)
+	if err != nil {
+		return "", err
+	}
+	return CurrentEmailConfig().link(LinkReferral, baseURL, token), nil
+}
+
+// resolveReferrer returns the ID of the user a referral token attributes a signup to
+// Invalid or expired tokens and unknown referrers resolve to "" so they never block registration
+func resolveReferrer(ctx context.Context, database *mongo.Database, secret, token string) string {
+	if token == "" {
+		return ""
+	}
+
+	tokens, err := NewTokenService(secret)
+	if err != nil {
+		return ""
+	}
+	claims, err := tokens.Verify(token, referralPurpose)
+	if err != nil {
+		log.Printf("Ignoring invalid referral token: %v", err)
+		return ""
+	}
+
+	count, err := database.Collection("users").CountDocuments(ctx, bson.M{"_id": claims.Subject}, options.Count().SetLimit(1))
+	if err != nil || count == 0 {
+		return ""
+	}
+	return claims.Subject
+}
+
+// CountReferrals returns how many users signed up through a user's referral links
+func CountReferrals(ctx context.Context, database *mongo.Database, userID string) (int64, error) {
+	count, err := database.Collection("users").CountDocuments(ctx, bson.M{"referred_by": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count referrals: %w", err)
+	}
+	return count, nil
+}
+
+// ListReferrals returns the users referred by a user, newest first
+func ListReferrals(ctx context.Context, database *mongo.Database, userID string, limit int64) ([]Referral, error) {
+	opts := options.Find().
+		SetProjection(bson.M{"name": 1, "created_at": 1}).
+		SetSort(bson.M{"created_at": -1}).
+		SetLimit(limit)
+
+	cursor, err := database.Collection("users").Find(ctx, bson.M{"referred_by": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrals: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	referrals := []Referral{}
+	if err := cursor.All(ctx, &referrals); err != nil {
+		return nil, fmt.Errorf("failed to decode referrals: %w", err)
+	}
+	return referrals, nil
+}
+
+// TopReferrers returns the users with the most referred signups, optionally only counting signups since a time
+func TopReferrers(ctx context.Context, database *mongo.Database, since time.Time, limit int64) ([]ReferralCount, error) {
+	match := bson.M{"referred_by": bson.M{"$exists": true, "$ne": ""}}
+	if !since.IsZero() {
+		match["created_at"] = bson.M{"$gte": since}
+	}
+
+	cursor, err := database.Collection("users").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$referred_by", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate referrals: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	counts := []ReferralCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, fmt.Errorf("failed to decode referral counts: %w", err)
+	}
+	return counts, nil
+}
+
+// GetReferralsHandler returns the authenticated user's referral link and referral count
+func GetReferralsHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret, baseURL string) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	link, err := ReferralLink(secret, baseURL, userID)
+	if err != nil {
+		log.Printf("Failed to create referral link: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	count, err := CountReferrals(r.Context(), database, userID)
+	if err != nil {
+		log.Printf("Failed to count referrals: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]interface{}{
+		"link":  link,
+		"count": count,
+	})
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrRefreshTokenReused = errors.New("refresh token was already rotated")
+
+// RefreshTokenReuseAlert is called when a replaced refresh token is presented again, after its session
+// has been revoked, e.g. to page security or warn the user. It runs on the refresh request.
+type RefreshTokenReuseAlert func(ctx context.Context, r *http.Request, userID, sessionID string)
+
+var (
+	refreshReuseAlertMu sync.RWMutex
+	refreshReuseAlert   RefreshTokenReuseAlert
+)
+
+// SetRefreshTokenReuseAlert sets the alert raised on refresh token reuse; pass nil to only log it
+func SetRefreshTokenReuseAlert(alert RefreshTokenReuseAlert) {
+	refreshReuseAlertMu.Lock()
+	defer refreshReuseAlertMu.Unlock()
+	refreshReuseAlert = alert
+}
+
+// peekRefreshToken returns an active refresh token's record without consuming it, so the refresh can be checked
+// before the token is rotated. A token that was already replaced revokes its whole session, like rotateRefreshToken.
+func peekRefreshToken(ctx context.Context, r *http.Request, token string) (*RefreshToken, error) {
+	collection, _ := refreshTokenStore()
+	if collection == nil || token == "" {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	tokenHash := hashOpaqueToken(token)
+	var stored RefreshToken
+	err := collection.FindOne(ctx, bson.M{"token_hash": tokenHash, "revoked_at": nil, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, detectRefreshTokenReuse(ctx, r, collection, tokenHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// rotateRefreshToken replaces an active refresh token with a new one in the same session, returning the
+// replaced token's record and the new token. A token that was already replaced revokes its whole session.
+func rotateRefreshToken(ctx context.Context, r *http.Request, token string) (*RefreshToken, string, error) {
+	collection, _ := refreshTokenStore()
+	if collection == nil || token == "" {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	// Claiming the token atomically means two refreshes racing with it can't both succeed
+	now := time.Now()
+	tokenHash := hashOpaqueToken(token)
+	var stored RefreshToken
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"token_hash": tokenHash, "revoked_at": nil, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"revoked_at": now, "rotated_at": now, "last_used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", detectRefreshTokenReuse(ctx, r, collection, tokenHash)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The replacement keeps the session's start, lifetime and idle timeout
+	absolute := stored.absoluteExpiry()
+	next := RefreshToken{
+		UserID:            stored.UserID,
+		FamilyID:          stored.sessionID(),
+		CreatedAt:         stored.CreatedAt,
+		LastUsedAt:        now,
+		ExpiresAt:         absolute,
+		AbsoluteExpiresAt: absolute,
+		IdleTimeout:       stored.IdleTimeout,
+	}
+	if next.IdleTimeout > 0 && now.Add(next.IdleTimeout).Before(absolute) {
+		next.ExpiresAt = now.Add(next.IdleTimeout)
+	}
+
+	newToken, err := storeRefreshToken(ctx, collection, r, &next)
+	if err != nil {
+		return nil, "", err
+	}
+	return &stored, newToken, nil
+}
+
+// detectRefreshTokenReuse handles a refresh token that isn't active: if it was replaced by rotation, someone
+// holds a copy, so the session is revoked and the alert raised. It returns the error to report.
+func detectRefreshTokenReuse(ctx context.Context, r *http.Request, collection *mongo.Collection, tokenHash string) error {
+	var stored RefreshToken
+	err := collection.FindOne(ctx, bson.M{"token_hash": tokenHash, "rotated_at": bson.M{"$ne": nil}}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	sessionID := stored.sessionID()
+	log.Printf("SECURITY: rotated refresh token reused for user %s, revoking session %s", stored.UserID, sessionID)
+	if err := RevokeUserSession(ctx, stored.UserID, sessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("Failed to revoke session after refresh token reuse: %v", err)
+	}
+	recordAuthEvent(ctx, r, AuthEvent{Type: AuthEventRefreshTokenReused, Outcome: AuthOutcomeFailure, UserID: stored.UserID, Reason: "session_revoked"})
+
+	refreshReuseAlertMu.RLock()
+	alert := refreshReuseAlert
+	refreshReuseAlertMu.RUnlock()
+	if alert != nil {
+		alert(ctx, r, stored.UserID, sessionID)
+	}
+	return ErrRefreshTokenReused
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	expires := time.Now().Add(time.Hour)
+	token := func(fields ...bson.E) bson.D {
+		return append(bson.D{
+			{Key: "_id", Value: "token-2"},
+			{Key: "user_id", Value: testUserID},
+			{Key: "family_id", Value: "session-1"},
+			{Key: "token_hash", Value: hashOpaqueToken("presented-token")},
+			{Key: "expires_at", Value: expires},
+			{Key: "absolute_expires_at", Value: expires},
+		}, fields...)
+	}
+	ok := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	notFound := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})
+
+	tests := []struct {
+		name      string
+		responses []bson.D
+		wantError error
+		revoked   bool // Whether the session is revoked and the alert raised
+	}{
+		{
+			name:      "active token",
+			responses: []bson.D{mtest.CreateSuccessResponse(bson.E{Key: "value", Value: token()}), ok},
+		},
+		{
+			name: "replaced token presented again",
+			responses: []bson.D{
+				notFound,
+				mtest.CreateCursorResponse(0, "db.refresh_tokens", mtest.FirstBatch, token(bson.E{Key: "rotated_at", Value: time.Now()})),
+				ok, ok,
+			},
+			wantError: ErrRefreshTokenReused,
+			revoked:   true,
+		},
+		{
+			name:      "unknown token",
+			responses: []bson.D{notFound, mtest.CreateCursorResponse(0, "db.refresh_tokens", mtest.FirstBatch)},
+			wantError: ErrRefreshTokenInvalid,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+			useTokenRevocation(mt.T, mt.DB)
+			var alerted string
+			SetRefreshTokenReuseAlert(func(ctx context.Context, r *http.Request, userID, sessionID string) { alerted = sessionID })
+			defer SetRefreshTokenReuseAlert(nil)
+			mt.AddMockResponses(tt.responses...)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/token/refresh", nil)
+			_, next, err := rotateRefreshToken(context.Background(), r, "presented-token")
+			if !errors.Is(err, tt.wantError) {
+				mt.Fatalf("error = %v, want %v", err, tt.wantError)
+			}
+			if tt.wantError == nil && next == "" {
+				mt.Fatal("no replacement token")
+			}
+
+			var familyRevoked, accessRevoked bool
+			for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+				switch {
+				case event.CommandName == "insert":
+					// The replacement stays in the session
+					document := event.Command.Lookup("documents").Array().Index(0).Value().Document()
+					if family := document.Lookup("family_id").StringValue(); family != "session-1" {
+						mt.Fatalf("replacement family_id = %q, want session-1", family)
+					}
+				case event.CommandName == "update" && event.Command.Lookup("update").StringValue() == "refresh_tokens":
+					filter := event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q")
+					if family, err := filter.Document().LookupErr("$or"); err == nil && family.Array().Index(0).Value().Document().Lookup("family_id").StringValue() == "session-1" {
+						familyRevoked = true
+					}
+				case event.CommandName == "update" && event.Command.Lookup("update").StringValue() == "revoked_tokens":
+					id := event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q", "_id").StringValue()
+					accessRevoked = id == sessionRevocationPrefix+"session-1"
+				}
+			}
+			if familyRevoked != tt.revoked || accessRevoked != tt.revoked {
+				mt.Fatalf("refresh tokens revoked = %v, access tokens revoked = %v, want %v", familyRevoked, accessRevoked, tt.revoked)
+			}
+			if wantAlert := tt.revoked; (alerted == "session-1") != wantAlert {
+				mt.Fatalf("alert raised for %q, want alert %v", alerted, wantAlert)
+			}
+		})
+	}
+}
+
+func TestRefreshAccessTokenConsumesTokenLast(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	captureLog(t)
+
+	expires := time.Now().Add(time.Hour)
+	stored := bson.D{
+		{Key: "_id", Value: "session-1"},
+		{Key: "user_id", Value: testUserID},
+		{Key: "token_hash", Value: hashOpaqueToken("presented-token")},
+		{Key: "expires_at", Value: expires},
+		{Key: "absolute_expires_at", Value: expires},
+	}
+	user := func(lockedUntil any) []bson.D {
+		return []bson.D{{{Key: "_id", Value: testUserID}, {Key: "email", Value: "user@example.com"}, {Key: "locked_until", Value: lockedUntil}}}
+	}
+
+	tests := []struct {
+		name     string
+		users    []bson.D // The users the lookup finds
+		want     int
+		consumed bool
+	}{
+		{"active user", user(nil), http.StatusOK, true},
+		{"locked user", user(time.Now().Add(time.Hour)), http.StatusUnauthorized, false},
+		{"deleted user", nil, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+			auth, err := NewAuth(AuthConfig{Secret: testSecret, RotateRefreshTokens: true})
+			if err != nil {
+				mt.Fatal(err)
+			}
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "db.refresh_tokens", mtest.FirstBatch, stored),
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, tt.users...),
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: stored}),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			)
+
+			r := httptest.NewRequest(http.MethodPost, "/auth/token/refresh", strings.NewReader(`{"refresh_token":"presented-token"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			auth.RefreshAccessToken(mt.DB, w, r)
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// The token is only consumed after the user is checked
+			var commands []string
+			for event := mt.GetStartedEvent(); event != nil; event = mt.GetStartedEvent() {
+				commands = append(commands, event.CommandName)
+			}
+			if consumed := slices.Contains(commands, "findAndModify"); consumed != tt.consumed {
+				mt.Fatalf("commands %v: token consumed = %v, want %v", commands, consumed, tt.consumed)
+			}
+			if tt.consumed && slices.Index(commands, "findAndModify") < 2 {
+				mt.Fatalf("commands %v: token consumed before the user was checked", commands)
+			}
+		})
+	}
+}
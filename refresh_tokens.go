@@ -0,0 +1,340 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lifetimes of the tokens Login issues
+const (
+	AccessTokenTTL         = 24 * time.Hour
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	DefaultRememberMeTTL   = 90 * 24 * time.Hour
+)
+
+var ErrRefreshTokenInvalid = errors.New("refresh token is invalid, expired or revoked")
+
+// RefreshToken is a stored refresh token; only a hash of the token itself is kept
+type RefreshToken struct {
+	ID         string     `json:"id" bson:"_id"`
+	UserID     string     `json:"-" bson:"user_id"`
+	TokenHash  string     `json:"-" bson:"token_hash"`
+	UserAgent  string     `json:"user_agent" bson:"user_agent"`
+	IP         string     `json:"ip" bson:"ip"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at" bson:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at" bson:"expires_at"`
+	RevokedAt  *time.Time `json:"-" bson:"revoked_at"`
+
+	// Rotation: every token replacing another shares its family, which identifies the login session
+	FamilyID  string     `json:"-" bson:"family_id,omitempty"`
+	RotatedAt *time.Time `json:"-" bson:"rotated_at,omitempty"` // When the token was replaced by a new one
+
+	// Sliding expiration: each use moves ExpiresAt to IdleTimeout from now, capped at AbsoluteExpiresAt
+	IdleTimeout       time.Duration `json:"-" bson:"idle_timeout,omitempty"`
+	AbsoluteExpiresAt time.Time     `json:"absolute_expires_at" bson:"absolute_expires_at"`
+
+	Fingerprint string `json:"fingerprint,omitempty" bson:"fingerprint,omitempty"` // Hash identifying the device, see deviceFingerprint
+	Current     bool   `json:"current" bson:"-"`                                   // Set by ListUserSessions for the caller's own session
+}
+
+// RefreshTokenForm is the body of a refresh request
+type RefreshTokenForm struct {
+	RefreshToken string `json:"refresh_token" binding:"required"` // The refresh token issued at login
+}
+
+var (
+	refreshTokensMu sync.RWMutex
+	refreshTokens   *mongo.Collection
+	refreshTokenTTL time.Duration
+)
+
+// EnableRefreshTokens makes Login issue a refresh token alongside the access token, stored hashed in
+// the database's refresh_tokens collection. If ttl is zero, DefaultRefreshTokenTTL is used.
+func EnableRefreshTokens(ctx context.Context, database *mongo.Database, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultRefreshTokenTTL
+	}
+
+	collection := database.Collection("refresh_tokens")
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	refreshTokensMu.Lock()
+	defer refreshTokensMu.Unlock()
+	refreshTokens = collection
+	refreshTokenTTL = ttl
+	return nil
+}
+
+// refreshTokenStore returns the refresh token collection and lifetime, or nil if refresh tokens are disabled
+func refreshTokenStore() (*mongo.Collection, time.Duration) {
+	refreshTokensMu.RLock()
+	defer refreshTokensMu.RUnlock()
+	return refreshTokens, refreshTokenTTL
+}
+
+// issueRefreshToken creates and stores a refresh token for userID, recording the requesting device
+// It returns the token and its record, or "" and nil when refresh tokens are disabled. If ttl is zero,
+// the enabled lifetime is used; if idle is set, the token expires after that long unused, sliding forward
+// with each use.
+func issueRefreshToken(ctx context.Context, r *http.Request, userID string, ttl, idle time.Duration) (string, *RefreshToken, error) {
+	collection, defaultTTL := refreshTokenStore()
+	if collection == nil {
+		return "", nil, nil
+	}
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	now := time.Now()
+	stored := RefreshToken{
+		UserID:            userID,
+		CreatedAt:         now,
+		LastUsedAt:        now,
+		ExpiresAt:         now.Add(ttl),
+		AbsoluteExpiresAt: now.Add(ttl),
+	}
+	if idle > 0 && idle < ttl {
+		stored.IdleTimeout = idle
+		stored.ExpiresAt = now.Add(idle)
+	}
+
+	token, err := storeRefreshToken(ctx, collection, r, &stored)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &stored, nil
+}
+
+// storeRefreshToken generates a token for stored, records the requesting device and inserts it
+// A token without a family starts its own.
+func storeRefreshToken(ctx context.Context, collection *mongo.Collection, r *http.Request, stored *RefreshToken) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return "", err
+	}
+
+	stored.ID = id
+	if stored.FamilyID == "" {
+		stored.FamilyID = id
+	}
+	stored.TokenHash = hashOpaqueToken(token)
+	stored.UserAgent = r.UserAgent()
+	stored.IP = GetClientIP(r)
+	stored.Fingerprint = deviceFingerprint(r)
+
+	if _, err := collection.InsertOne(ctx, stored); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sessionID returns the ID of the login session the token belongs to
+// Tokens issued before rotation existed have no family and are their own session.
+func (t *RefreshToken) sessionID() string {
+	if t.FamilyID != "" {
+		return t.FamilyID
+	}
+	return t.ID
+}
+
+// absoluteExpiry returns when the token's session ends however much it is used
+// Tokens issued before sliding expiration existed end at their fixed expiry.
+func (t *RefreshToken) absoluteExpiry() time.Time {
+	if t.AbsoluteExpiresAt.IsZero() {
+		return t.ExpiresAt
+	}
+	return t.AbsoluteExpiresAt
+}
+
+// sessionFilter matches the tokens of a login session, including one issued before rotation existed
+func sessionFilter(sessionID string) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"family_id": sessionID}, bson.M{"_id": sessionID}}}
+}
+
+// lookupRefreshToken returns the active stored refresh token matching token and marks it used
+func lookupRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
+	collection, _ := refreshTokenStore()
+	if collection == nil || token == "" {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	// Tokens with an idle timeout slide their expiry forward from now, capped at their absolute expiry
+	now := time.Now()
+	slidExpiry := bson.M{"$min": bson.A{
+		bson.M{"$add": bson.A{now, bson.M{"$divide": bson.A{"$idle_timeout", int64(time.Millisecond)}}}},
+		"$absolute_expires_at",
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"last_used_at": now,
+		"expires_at":   bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$idle_timeout", 0}}, slidExpiry, "$expires_at"}},
+	}}}}
+
+	var stored RefreshToken
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"token_hash": hashOpaqueToken(token), "revoked_at": nil, "expires_at": bson.M{"$gt": now}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// revokeRefreshToken revokes one of a user's refresh tokens; unknown tokens and disabled refresh tokens are ignored
+func revokeRefreshToken(ctx context.Context, userID, token string) error {
+	collection, _ := refreshTokenStore()
+	if collection == nil {
+		return nil
+	}
+
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"token_hash": hashOpaqueToken(token), "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// discardRefreshToken deletes a refresh token that was stored but could not be handed out
+func discardRefreshToken(ctx context.Context, token string) {
+	collection, _ := refreshTokenStore()
+	if collection == nil || token == "" {
+		return
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"token_hash": hashOpaqueToken(token)}); err != nil {
+		log.Printf("Failed to discard refresh token: %v", err)
+	}
+}
+
+// rejectRefreshToken responds 401 to an invalid refresh token, clearing it in cookie auth mode
+func (a *Auth) rejectRefreshToken(w http.ResponseWriter) {
+	if a.config.Cookie != nil {
+		a.clearRefreshCookie(w)
+	}
+	RespondWithJSON(w, 401, map[string]string{"error": "Invalid refresh token"})
+}
+
+// RefreshAccessToken exchanges a refresh token for a new access token
+func RefreshAccessToken(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) {
+	secretAuth(secret).RefreshAccessToken(database, w, r)
+}
+
+// RefreshAccessToken exchanges a refresh token for a new access token, and for a new refresh token too
+// if AuthConfig.RotateRefreshTokens is set
+func (a *Auth) RefreshAccessToken(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	if err := a.signingError(); err != nil {
+		log.Printf("JWT secret validation failed: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server configuration error"})
+		return
+	}
+
+	// In cookie auth mode browsers send the refresh token cookie; other clients send the token in the body
+	presented := a.requestRefreshToken(r)
+	if presented == "" {
+		var form RefreshTokenForm
+		if !ValidateAndBindJSON(w, r, &form) {
+			return
+		}
+		presented = form.RefreshToken
+	}
+
+	// With rotation the presented token is replaced, and presenting it again revokes its session.
+	// It is only read here, and consumed once the new access token is signed, so a refresh that fails
+	// leaves the session usable.
+	var stored *RefreshToken
+	var err error
+	presented = SanitizeInput(presented)
+	if a.config.RotateRefreshTokens {
+		stored, err = peekRefreshToken(r.Context(), r, presented)
+	} else {
+		stored, err = lookupRefreshToken(r.Context(), presented)
+	}
+	if errors.Is(err, ErrRefreshTokenInvalid) || errors.Is(err, ErrRefreshTokenReused) {
+		a.rejectRefreshToken(w)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up refresh token: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	// Refuse accounts that were deleted or locked since the refresh token was issued
+	var user User
+	err = database.Collection("users").FindOne(r.Context(), bson.M{"_id": stored.UserID}).Decode(&user)
+	if err != nil || user.isLocked() {
+		recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventTokenRefreshed, Outcome: AuthOutcomeFailure, UserID: stored.UserID, Reason: "account_unavailable"})
+		RespondWithJSON(w, 401, map[string]string{"error": "Invalid refresh token"})
+		return
+	}
+
+	// Rotated tokens keep their session's CreatedAt, so it is when the user signed in
+	accessToken, err := a.IssueClaims(r, &AppClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: user.ID},
+		Roles:            user.Roles,
+		SessionID:        stored.sessionID(),
+		AuthTime:         jwt.NewNumericDate(stored.CreatedAt),
+	})
+	if err != nil {
+		log.Printf("Failed to sign JWT: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	// A concurrent refresh may have rotated the token since it was read; the signed token is then never sent
+	var refreshToken string
+	if a.config.RotateRefreshTokens {
+		stored, refreshToken, err = rotateRefreshToken(r.Context(), r, presented)
+		if errors.Is(err, ErrRefreshTokenInvalid) || errors.Is(err, ErrRefreshTokenReused) {
+			a.rejectRefreshToken(w)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to rotate refresh token: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+	}
+
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventTokenRefreshed, UserID: user.ID})
+
+	response := map[string]interface{}{"expires_in": int(a.config.AccessTokenTTL.Seconds())}
+	if a.config.Cookie != nil {
+		a.setTokenCookie(w, accessToken)
+		if refreshToken != "" {
+			a.setRefreshCookie(w, refreshToken, stored.absoluteExpiry())
+		}
+	} else {
+		response["token"] = accessToken
+		if refreshToken != "" {
+			response["refresh_token"] = refreshToken
+		}
+	}
+	RespondWithJSON(w, 200, response)
+}
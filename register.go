@@ -2,15 +2,14 @@ package common
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
 	"strings"
-	"time"
 	"unicode"
 
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -22,6 +21,9 @@ type RegisterForm struct {
 	Email    string `json:"email" binding:"required"`    // The email of the user
 	Password string `json:"password" binding:"required"` // The password of the user
 	Name     string `json:"name" binding:"required"`     // The name of the user
+
+	InviteCode string `json:"invite_code"` // Required while registration is gated, unless the email is allowlisted
+	Referral   string `json:"referral"`    // Signed referral token from another user's link
 }
 
 // ValidateEmail checks if the email meets security requirements
@@ -37,14 +39,51 @@ func ValidateEmail(email string) error {
 	return nil
 }
 
-// ValidatePassword checks if the password meets security requirements
+// PasswordPolicy describes the rules a password must satisfy
+type PasswordPolicy struct {
+	MinLength          int
+	MaxLength          int
+	RequireUpper       bool
+	RequireLower       bool
+	RequireNumber      bool
+	RequireSpecial     bool
+	RejectWeakPatterns bool
+}
+
+// DefaultPasswordPolicy returns the production password policy
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:          16,
+		MaxLength:          128,
+		RequireUpper:       true,
+		RequireLower:       true,
+		RequireNumber:      true,
+		RequireSpecial:     true,
+		RejectWeakPatterns: true,
+	}
+}
+
+// RelaxedPasswordPolicy returns a short, complexity-free policy for local development
+func RelaxedPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 128,
+	}
+}
+
+// ValidatePassword checks if the password meets the active environment's password policy
 func ValidatePassword(password string) error {
-	if len(password) < 16 {
-		return fmt.Errorf("password must be at least 16 characters long")
+	return ValidatePasswordWithPolicy(password, CurrentProfile().PasswordPolicy)
+}
+
+// ValidatePasswordWithPolicy checks if the password meets the given policy
+func ValidatePasswordWithPolicy(password string, policy PasswordPolicy) error {
+	if len(password) < policy.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", policy.MinLength)
 	}
 
-	if len(password) > 128 {
-		return fmt.Errorf("password must be less than 128 characters")
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		return fmt.Errorf("password must be less than %d characters", policy.MaxLength)
 	}
 
 	var (
@@ -67,16 +106,19 @@ func ValidatePassword(password string) error {
 		}
 	}
 
-	if !hasUpper || !hasLower || !hasNumber || !hasSpecial {
+	if (policy.RequireUpper && !hasUpper) || (policy.RequireLower && !hasLower) ||
+		(policy.RequireNumber && !hasNumber) || (policy.RequireSpecial && !hasSpecial) {
 		return fmt.Errorf("password must contain at least one uppercase letter, one lowercase letter, one number, and one special character")
 	}
 
 	// Check for common weak patterns
-	weakPatterns := []string{"password", "123456", "qwerty", "admin", "letmein"}
-	lowerPassword := strings.ToLower(password)
-	for _, pattern := range weakPatterns {
-		if strings.Contains(lowerPassword, pattern) {
-			return fmt.Errorf("password contains common weak patterns")
+	if policy.RejectWeakPatterns {
+		weakPatterns := []string{"password", "123456", "qwerty", "admin", "letmein"}
+		lowerPassword := strings.ToLower(password)
+		for _, pattern := range weakPatterns {
+			if strings.Contains(lowerPassword, pattern) {
+				return fmt.Errorf("password contains common weak patterns")
+			}
 		}
 	}
 
@@ -149,7 +191,7 @@ func Register(database *mongo.Database, w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	id, err := uuid.NewV7()
+	id, err := NewID()
 	if err != nil {
 		log.Printf("Failed to generate UUID: %v", err)
 		w.WriteHeader(500)
@@ -167,7 +209,7 @@ func Register(database *mongo.Database, w http.ResponseWriter, r *http.Request,
 	}
 
 	// Hash the password before storing
-	hashedPassword, err := GenerateFromPassword(form.Password, defaultPasswordParams)
+	hashedPassword, err := GenerateFromPassword(form.Password, CurrentPasswordParams())
 	if err != nil {
 		log.Printf("Failed to hash password: %v", err)
 		w.WriteHeader(500)
@@ -175,15 +217,18 @@ func Register(database *mongo.Database, w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	now := Now()
 	user := User{
-		ID:            id.String(),
-		Email:         form.Email,
-		Password:      hashedPassword,
-		Name:          form.Name,
-		CreatedAt:     time.Now(),
-		LoginAttempts: 0,
-		IsVerified:    false,
-		VerifiedAt:    nil,
+		ID:                id,
+		Email:             form.Email,
+		Password:          hashedPassword,
+		Name:              form.Name,
+		CreatedAt:         now,
+		PasswordChangedAt: &now,
+		LoginAttempts:     0,
+		IsVerified:        false,
+		VerifiedAt:        nil,
+		Locale:            ResolveLocale(r, nil),
 	}
 
 	// Check if username already exists (use generic error message)
@@ -194,8 +239,31 @@ func Register(database *mongo.Database, w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// Enforce the registration mode before creating the account
+	inviteCode, err := authorizeRegistration(r.Context(), database, form.Email, SanitizeInput(form.InviteCode), user.ID)
+	switch {
+	case errors.Is(err, ErrRegistrationClosed):
+		RespondWithJSON(w, 403, map[string]string{"error": "Registration is currently closed"})
+		return
+	case errors.Is(err, ErrRegistrationGated):
+		RespondWithJSON(w, 403, map[string]string{"error": "Registration is invite-only"})
+		return
+	case errors.Is(err, ErrInviteCodeInvalid):
+		RespondWithValidationError(w, "invite_code", "is invalid, expired or already used")
+		return
+	case err != nil:
+		log.Printf("Failed to check registration mode: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	user.InviteCode = inviteCode
+	user.ReferredBy = resolveReferrer(r.Context(), database, secret, SanitizeInput(form.Referral))
+
 	_, err = collection.InsertOne(r.Context(), user)
 	if err != nil {
+		if inviteCode != "" {
+			releaseInviteCode(r.Context(), database, inviteCode, user.ID)
+		}
 		log.Printf("Failed to insert user: %v", err)
 		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
 		return
@@ -209,7 +277,7 @@ func Register(database *mongo.Database, w http.ResponseWriter, r *http.Request,
 	}
 
 	// Send verification email
-	if err := SendVerificationEmail(user.Email, user.Name, templateName, baseURL, fromEmail, verificationToken); err != nil {
+	if err := SendVerificationEmail(user.Email, user.Name, templateName, baseURL, fromEmail, verificationToken, user.Locale); err != nil {
 		log.Printf("Failed to send verification email: %v", err)
 		// Don't fail the registration if email sending fails
 		// The user is still created and can request a new verification email
@@ -0,0 +1,320 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RegistrationMode controls who may register
+type RegistrationMode string
+
+const (
+	RegistrationOpen   RegistrationMode = "open"   // Anyone may register
+	RegistrationGated  RegistrationMode = "gated"  // Only allowlisted emails or invite code holders may register
+	RegistrationClosed RegistrationMode = "closed" // Nobody may register
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrRegistrationGated  = errors.New("registration requires an invitation")
+	ErrInviteCodeInvalid  = errors.New("invite code is invalid, expired or used up")
+)
+
+var registrationMode atomic.Value
+
+// SetRegistrationMode sets who may register; the default is RegistrationOpen
+func SetRegistrationMode(mode RegistrationMode) error {
+	switch mode {
+	case RegistrationOpen, RegistrationGated, RegistrationClosed:
+	default:
+		return fmt.Errorf("registration mode %q must be open, gated or closed", mode)
+	}
+	registrationMode.Store(mode)
+	return nil
+}
+
+// CurrentRegistrationMode returns who may register
+func CurrentRegistrationMode() RegistrationMode {
+	if mode, ok := registrationMode.Load().(RegistrationMode); ok {
+		return mode
+	}
+	return RegistrationOpen
+}
+
+// AllowlistEntry represents an email allowed to register while registration is gated
+type AllowlistEntry struct {
+	Email     string    `json:"email" bson:"_id"`             // Lower-cased email address
+	Note      string    `json:"note" bson:"note"`             // Why the address was added
+	CreatedAt time.Time `json:"created_at" bson:"created_at"` // When the address was added
+}
+
+// InviteCode represents a code that lets its holders register while registration is gated
+type InviteCode struct {
+	Code      string     `json:"code" bson:"_id"`              // The code users enter
+	CreatedBy string     `json:"created_by" bson:"created_by"` // ID of the user or admin who created it
+	Note      string     `json:"note" bson:"note"`             // Free-form description, e.g. the campaign
+	MaxUses   int        `json:"max_uses" bson:"max_uses"`     // Registrations allowed with the code
+	Uses      int        `json:"uses" bson:"uses"`             // Registrations so far
+	UsedBy    []string   `json:"used_by" bson:"used_by"`       // IDs of users who registered with it
+	ExpiresAt *time.Time `json:"expires_at" bson:"expires_at"` // When the code stops working; nil never
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"` // When the code was created
+	Disabled  bool       `json:"disabled" bson:"disabled"`     // Revoked codes stay for tracking
+}
+
+// CreateInviteCodeForm is the request body for creating an invite code
+type CreateInviteCodeForm struct {
+	MaxUses       int    `json:"max_uses"`        // Defaults to 1
+	ExpiresInDays int    `json:"expires_in_days"` // Zero never expires
+	Note          string `json:"note"`
+}
+
+// AllowlistForm is the request body for allowlisting an email
+type AllowlistForm struct {
+	Email string `json:"email" binding:"required"`
+	Note  string `json:"note"`
+}
+
+// AddToRegistrationAllowlist allows an email to register while registration is gated
+func AddToRegistrationAllowlist(ctx context.Context, database *mongo.Database, email, note string) error {
+	_, err := database.Collection("registration_allowlist").UpdateOne(ctx,
+		bson.M{"_id": normalizeEmail(email)},
+		bson.M{
+			"$set":         bson.M{"note": note},
+			"$setOnInsert": bson.M{"created_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add email to allowlist: %w", err)
+	}
+	return nil
+}
+
+// RemoveFromRegistrationAllowlist removes an email from the allowlist
+func RemoveFromRegistrationAllowlist(ctx context.Context, database *mongo.Database, email string) (bool, error) {
+	result, err := database.Collection("registration_allowlist").DeleteOne(ctx, bson.M{"_id": normalizeEmail(email)})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove email from allowlist: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// IsAllowlisted reports whether an email is on the registration allowlist
+func IsAllowlisted(ctx context.Context, database *mongo.Database, email string) (bool, error) {
+	count, err := database.Collection("registration_allowlist").CountDocuments(ctx, bson.M{"_id": normalizeEmail(email)}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check allowlist: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GenerateInviteCode returns a random 10-character code without ambiguous padding
+func GenerateInviteCode() (string, error) {
+	bytes := make([]byte, 10)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(bytes)[:10], nil
+}
+
+// CreateInviteCode creates a code usable maxUses times, expiring after ttl unless ttl is zero
+func CreateInviteCode(ctx context.Context, database *mongo.Database, createdBy, note string, maxUses int, ttl time.Duration) (*InviteCode, error) {
+	if maxUses <= 0 {
+		maxUses = 1
+	}
+
+	code, err := GenerateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := &InviteCode{
+		Code:      code,
+		CreatedBy: createdBy,
+		Note:      note,
+		MaxUses:   maxUses,
+		UsedBy:    []string{},
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if _, err := database.Collection("invite_codes").InsertOne(ctx, invite); err != nil {
+		return nil, fmt.Errorf("failed to create invite code: %w", err)
+	}
+	return invite, nil
+}
+
+// ListInviteCodes returns invite codes, newest first, optionally only those created by one user
+func ListInviteCodes(ctx context.Context, database *mongo.Database, createdBy string, limit int64) ([]InviteCode, error) {
+	filter := bson.M{}
+	if createdBy != "" {
+		filter["created_by"] = createdBy
+	}
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(limit)
+	cursor, err := database.Collection("invite_codes").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invite codes: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	codes := []InviteCode{}
+	if err := cursor.All(ctx, &codes); err != nil {
+		return nil, fmt.Errorf("failed to decode invite codes: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableInviteCode revokes a code while keeping its usage history
+func DisableInviteCode(ctx context.Context, database *mongo.Database, code string) (bool, error) {
+	result, err := database.Collection("invite_codes").UpdateOne(ctx, bson.M{"_id": normalizeInviteCode(code)}, bson.M{"$set": bson.M{"disabled": true}})
+	if err != nil {
+		return false, fmt.Errorf("failed to disable invite code: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// normalizeInviteCode makes codes case-insensitive
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// redeemInviteCode atomically uses one of a code's remaining registrations for userID
+func redeemInviteCode(ctx context.Context, database *mongo.Database, code, userID string) (*InviteCode, error) {
+	var invite InviteCode
+	err := database.Collection("invite_codes").FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":      normalizeInviteCode(code),
+			"disabled": false,
+			"$expr":    bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+			"$or": bson.A{
+				bson.M{"expires_at": nil},
+				bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+			},
+		},
+		bson.M{"$inc": bson.M{"uses": 1}, "$push": bson.M{"used_by": userID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInviteCodeInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem invite code: %w", err)
+	}
+	return &invite, nil
+}
+
+// releaseInviteCode gives back a registration taken by redeemInviteCode when registration fails
+func releaseInviteCode(ctx context.Context, database *mongo.Database, code, userID string) {
+	_, err := database.Collection("invite_codes").UpdateOne(ctx,
+		bson.M{"_id": normalizeInviteCode(code)},
+		bson.M{"$inc": bson.M{"uses": -1}, "$pull": bson.M{"used_by": userID}},
+	)
+	if err != nil {
+		log.Printf("Failed to release invite code: %v", err)
+	}
+}
+
+// authorizeRegistration checks the registration mode for an email and optional invite code
+// It returns the redeemed invite code, if one was needed, so the caller can release it on failure
+func authorizeRegistration(ctx context.Context, database *mongo.Database, email, code, userID string) (string, error) {
+	switch CurrentRegistrationMode() {
+	case RegistrationClosed:
+		return "", ErrRegistrationClosed
+	case RegistrationGated:
+		allowed, err := IsAllowlisted(ctx, database, email)
+		if err != nil {
+			return "", err
+		}
+		if allowed {
+			return "", nil
+		}
+		if code == "" {
+			return "", ErrRegistrationGated
+		}
+		invite, err := redeemInviteCode(ctx, database, code, userID)
+		if err != nil {
+			return "", err
+		}
+		return invite.Code, nil
+	default:
+		return "", nil
+	}
+}
+
+// CreateInviteCodeHandler creates an invite code attributed to the authenticated user
+func CreateInviteCodeHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	var form CreateInviteCodeForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+
+	if form.MaxUses < 0 || form.MaxUses > 10000 {
+		RespondWithValidationError(w, "max_uses", "must be between 1 and 10000")
+		return
+	}
+	if form.ExpiresInDays < 0 {
+		RespondWithValidationError(w, "expires_in_days", "must not be negative")
+		return
+	}
+
+	invite, err := CreateInviteCode(r.Context(), database, GetUserID(r), SanitizeInput(form.Note), form.MaxUses, time.Duration(form.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		log.Printf("Failed to create invite code: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 201, invite)
+}
+
+// ListInviteCodesHandler lists invite codes for admin tooling
+// Supports the optional query parameter created_by
+func ListInviteCodesHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	codes, err := ListInviteCodes(r.Context(), database, r.URL.Query().Get("created_by"), 1000)
+	if err != nil {
+		log.Printf("Failed to list invite codes: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, codes)
+}
+
+// AddToRegistrationAllowlistHandler allowlists an email for admin tooling
+func AddToRegistrationAllowlistHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	var form AllowlistForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+
+	form.Email = SanitizeInput(form.Email)
+	if err := ValidateEmail(form.Email); err != nil {
+		RespondWithValidationError(w, "email", err.Error())
+		return
+	}
+
+	if err := AddToRegistrationAllowlist(r.Context(), database, form.Email, SanitizeInput(form.Note)); err != nil {
+		log.Printf("Failed to add email to allowlist: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]string{"message": "Email added to allowlist"})
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Headers a client sends on replay-protected requests
+const (
+	NonceHeader     = "X-Request-Nonce"     // Random value unique to each request, 16 to 128 characters
+	TimestampHeader = "X-Request-Timestamp" // Unix time in seconds when the request was created
+)
+
+var (
+	ErrRequestNonceMissing = errors.New("request nonce and timestamp are required")
+	ErrRequestExpired      = errors.New("request timestamp is outside the allowed window")
+	ErrRequestReplayed     = errors.New("request nonce has already been used")
+)
+
+// DefaultReplayWindow is how far a request timestamp may drift from the server clock
+const DefaultReplayWindow = 5 * time.Minute
+
+// ReplayGuard rejects requests whose nonce has been seen before or whose timestamp is stale,
+// so captured requests to high-value endpoints can't be replayed
+// Nonces are keyed on method, path and nonce and aren't signed, so the guard only stops byte-identical replays,
+// such as retried or logged requests. A sender who changes the nonce gets through; single-use tokens in the body,
+// like password reset tokens, are what stop a modified request from being reused.
+type ReplayGuard struct {
+	nonces *mongo.Collection
+	window time.Duration
+}
+
+// NewReplayGuard creates a replay guard that records nonces in the database's request_nonces collection
+// If window is zero, DefaultReplayWindow is used.
+func NewReplayGuard(ctx context.Context, database *mongo.Database, window time.Duration) (*ReplayGuard, error) {
+	if window <= 0 {
+		window = DefaultReplayWindow
+	}
+
+	collection := database.Collection("request_nonces")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayGuard{nonces: collection, window: window}, nil
+}
+
+// Check validates the request's timestamp and records its nonce, returning an error if either is missing,
+// the timestamp is outside the window, or the nonce was already used for this endpoint
+func (g *ReplayGuard) Check(ctx context.Context, r *http.Request) error {
+	nonce := SanitizeInput(r.Header.Get(NonceHeader))
+	timestamp := r.Header.Get(TimestampHeader)
+	if len(nonce) < 16 || len(nonce) > 128 || timestamp == "" {
+		return ErrRequestNonceMissing
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrRequestNonceMissing
+	}
+	sentAt := time.Unix(seconds, 0)
+	if drift := time.Since(sentAt); drift > g.window || drift < -g.window {
+		return ErrRequestExpired
+	}
+
+	// Requests older than the window are rejected above, so the nonce only needs to outlive it
+	_, err = g.nonces.InsertOne(ctx, bson.M{
+		"_id":        r.Method + " " + r.URL.Path + ":" + nonce,
+		"expires_at": sentAt.Add(g.window),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrRequestReplayed
+	}
+	return err
+}
+
+// Middleware rejects replayed or stale requests before they reach next
+func (g *ReplayGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := g.Check(r.Context(), r)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, ErrRequestNonceMissing), errors.Is(err, ErrRequestExpired):
+			RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, ErrRequestReplayed):
+			log.Printf("SECURITY: replayed request to %s %s from %s", r.Method, r.URL.Path, GetClientIP(r))
+			RespondWithJSON(w, http.StatusConflict, map[string]string{"error": "Request already processed"})
+		default:
+			log.Printf("Failed to check request nonce: %v", err)
+			RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Server error"})
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/adhiravishankar/ar-go-common/httpx"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID that correlates a request with its log lines and error response
+const RequestIDHeader = httpx.RequestIDHeader
+
+// maxRequestIDLength bounds an incoming request ID, which ends up in every log line for the request
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware gives each request an ID, keeping a valid incoming X-Request-ID so IDs from a proxy or
+// client carry through, and echoes it in the response. Put it outermost so every other middleware, and
+// error responses, can see it.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(contextWithRequestID(r.Context(), requestID))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetRequestID retrieves the request ID from the request context, or "" outside RequestIDMiddleware
+func GetRequestID(r *http.Request) string {
+	return RequestIDFromContext(r.Context())
+}
+
+// RequestIDFromContext retrieves the request ID from a request's context, e.g. in code that only gets a context
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// contextWithRequestID returns ctx carrying requestID, e.g. to correlate background work with its request
+func contextWithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// validRequestID reports whether an incoming request ID is safe to log and echo: short, and only
+// letters, digits and -_.:
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
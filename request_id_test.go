@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"no incoming ID", "", false},
+		{"valid incoming ID", "proxy-7f3a:1.2", true},
+		{"incoming ID with unsafe characters", "id\nforged log line", false},
+		{"overlong incoming ID", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = GetRequestID(r)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			echoed := w.Header().Get(RequestIDHeader)
+			if echoed == "" || echoed != seen {
+				t.Fatalf("echoed ID %q, handler saw %q", echoed, seen)
+			}
+			if kept := echoed == tt.incoming; kept != tt.keep {
+				t.Fatalf("kept incoming ID = %v, want %v", kept, tt.keep)
+			}
+		})
+	}
+}
+
+func TestErrorBodiesCarryRequestID(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(w http.ResponseWriter)
+		want    bool // Whether the body has the request ID
+	}{
+		{"error map", func(w http.ResponseWriter) { RespondWithJSON(w, 401, map[string]string{"error": "Unauthorized"}) }, true},
+		{"error map of any", func(w http.ResponseWriter) { RespondWithJSON(w, 403, map[string]interface{}{"error": "Forbidden"}) }, true},
+		{"error", func(w http.ResponseWriter) { RespondWithError(w, 500, errors.New("boom")) }, true},
+		{"validation error", func(w http.ResponseWriter) { RespondWithValidationError(w, "email", "is required") }, true},
+		{"success", func(w http.ResponseWriter) { RespondWithJSON(w, 200, map[string]string{"message": "ok"}) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { tt.respond(w) }))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(RequestIDHeader, "req-123")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if got := body["request_id"] == "req-123"; got != tt.want {
+				t.Fatalf("body %v has request ID = %v, want %v", body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Log levels accepted by RuntimeConfig
+var validLogLevels = map[string]bool{
+	"debug": true,
+	"info":  true,
+	"warn":  true,
+	"error": true,
+}
+
+// RuntimeConfig holds settings that can be reloaded without restarting the service
+type RuntimeConfig struct {
+	CorsOrigins  []string        `json:"cors_origins"`  // Allowed CORS origins; empty allows "*"
+	RateLimits   map[string]int  `json:"rate_limits"`   // Requests per minute keyed by limiter name
+	FeatureFlags map[string]bool `json:"feature_flags"` // Named feature toggles
+	LogLevel     string          `json:"log_level"`     // debug, info, warn or error
+}
+
+// DefaultRuntimeConfig returns the configuration used before anything is loaded
+// Origins and log level come from the environment profile
+func DefaultRuntimeConfig() *RuntimeConfig {
+	profile := CurrentProfile()
+	return &RuntimeConfig{
+		CorsOrigins:  profile.CorsOrigins,
+		RateLimits:   map[string]int{},
+		FeatureFlags: map[string]bool{},
+		LogLevel:     profile.LogLevel,
+	}
+}
+
+// Validate checks the configuration before it is applied
+func (c *RuntimeConfig) Validate() error {
+	for _, origin := range c.CorsOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("cors origin %q must start with http:// or https://", origin)
+		}
+	}
+
+	for name, limit := range c.RateLimits {
+		if limit < 0 {
+			return fmt.Errorf("rate limit %q must not be negative", name)
+		}
+	}
+
+	if !validLogLevels[c.LogLevel] {
+		return fmt.Errorf("log level %q must be one of debug, info, warn, error", c.LogLevel)
+	}
+
+	return nil
+}
+
+var (
+	runtimeConfig atomic.Pointer[RuntimeConfig]
+
+	runtimeConfigMu        sync.Mutex
+	runtimeConfigListeners []func(previous, current *RuntimeConfig)
+)
+
+// CurrentRuntimeConfig returns the active runtime configuration
+// The returned value must not be modified; use UpdateRuntimeConfig instead
+func CurrentRuntimeConfig() *RuntimeConfig {
+	if config := runtimeConfig.Load(); config != nil {
+		return config
+	}
+
+	// Initialize lazily so the defaults reflect the environment profile in effect at first use
+	runtimeConfig.CompareAndSwap(nil, DefaultRuntimeConfig())
+	return runtimeConfig.Load()
+}
+
+// OnRuntimeConfigChange registers a function called after each successful update
+func OnRuntimeConfigChange(listener func(previous, current *RuntimeConfig)) {
+	runtimeConfigMu.Lock()
+	defer runtimeConfigMu.Unlock()
+	runtimeConfigListeners = append(runtimeConfigListeners, listener)
+}
+
+// UpdateRuntimeConfig validates and applies a new runtime configuration, logging every changed
+// setting together with source (e.g. "SIGHUP" or the admin user ID) for auditing
+func UpdateRuntimeConfig(config *RuntimeConfig, source string) error {
+	if config == nil {
+		return ErrInvalidInput
+	}
+
+	if config.RateLimits == nil {
+		config.RateLimits = map[string]int{}
+	}
+	if config.FeatureFlags == nil {
+		config.FeatureFlags = map[string]bool{}
+	}
+
+	if err := config.Validate(); err != nil {
+		log.Printf("CONFIG: rejected runtime configuration from %s: %v", source, err)
+		return err
+	}
+
+	runtimeConfigMu.Lock()
+	defer runtimeConfigMu.Unlock()
+
+	previous := CurrentRuntimeConfig()
+	auditRuntimeConfigChanges(previous, config, source)
+	runtimeConfig.Store(config)
+
+	for _, listener := range runtimeConfigListeners {
+		listener(previous, config)
+	}
+	return nil
+}
+
+// auditRuntimeConfigChanges logs each setting that differs between two configurations
+func auditRuntimeConfigChanges(previous, current *RuntimeConfig, source string) {
+	logChange := func(setting string, from, to interface{}) {
+		if !reflect.DeepEqual(from, to) {
+			log.Printf("CONFIG: %s changed from %v to %v (source: %s)", setting, from, to, source)
+		}
+	}
+
+	logChange("cors_origins", previous.CorsOrigins, current.CorsOrigins)
+	logChange("rate_limits", previous.RateLimits, current.RateLimits)
+	logChange("feature_flags", previous.FeatureFlags, current.FeatureFlags)
+	logChange("log_level", previous.LogLevel, current.LogLevel)
+}
+
+// FeatureEnabled reports whether the named feature flag is turned on
+func FeatureEnabled(name string) bool {
+	return CurrentRuntimeConfig().FeatureFlags[name]
+}
+
+// LoadRuntimeConfigFile reads a runtime configuration from a JSON file
+func LoadRuntimeConfigFile(path string) (*RuntimeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runtime config: %w", err)
+	}
+
+	config := DefaultRuntimeConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse runtime config: %w", err)
+	}
+	return config, nil
+}
+
+// ReloadRuntimeConfigOnSignal calls load and applies its result every time the process
+// receives SIGHUP, until ctx is cancelled
+func ReloadRuntimeConfigOnSignal(ctx context.Context, load func() (*RuntimeConfig, error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				config, err := load()
+				if err != nil {
+					log.Printf("CONFIG: failed to reload runtime configuration: %v", err)
+					continue
+				}
+				if err := UpdateRuntimeConfig(config, "SIGHUP"); err != nil {
+					continue
+				}
+				log.Println("CONFIG: runtime configuration reloaded")
+			}
+		}
+	}()
+}
+
+// RuntimeConfigHandler serves the runtime configuration for admin tooling: GET returns it and
+// PUT replaces it. It must be mounted behind Authenticate and an admin check.
+func RuntimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		RespondWithJSON(w, http.StatusOK, CurrentRuntimeConfig())
+	case http.MethodPut:
+		config := DefaultRuntimeConfig()
+		if !ValidateAndBindJSON(w, r, config) {
+			return
+		}
+
+		source := fmt.Sprintf("admin user %s from %s", GetUserID(r), GetClientIP(r))
+		if err := UpdateRuntimeConfig(config, source); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, config)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		RespondWithJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
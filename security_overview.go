@@ -0,0 +1,146 @@
+package common
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// securityEventWindow is how far back the overview looks for security emails
+const securityEventWindow = 30 * 24 * time.Hour
+
+// Email types that signal activity on the account's credentials
+var securityEmailTypes = map[EmailType]bool{
+	EmailTypePasswordReset:     true,
+	EmailTypePasswordChanged:   true,
+	EmailTypeAccountUnlock:     true,
+	EmailTypeAccountLocked:     true,
+	EmailTypeEmailChange:       true,
+	EmailTypeEmailChangeNotice: true,
+}
+
+// SecurityEvent is a recent security-relevant event on an account
+type SecurityEvent struct {
+	Type      EmailType      `json:"type"`
+	Status    EmailLogStatus `json:"status"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// SecurityOverview summarizes an account's login security for a "Security" settings page
+type SecurityOverview struct {
+	IsVerified bool  `json:"is_verified"`
+	VerifiedAt *Time `json:"verified_at"`
+
+	PasswordChangedAt Time `json:"password_changed_at"`
+	PasswordAgeDays   int  `json:"password_age_days"`
+
+	LastLoginAt         *Time `json:"last_login_at"`
+	Locked              bool  `json:"locked"`
+	LockedUntil         *Time `json:"locked_until"`
+	FailedLoginAttempts int   `json:"failed_login_attempts"`
+
+	RecentEvents []SecurityEvent `json:"recent_events"`
+
+	// Sections holds data contributed by RegisterSecurityOverviewSection, keyed by section name
+	Sections map[string]any `json:"sections,omitempty"`
+}
+
+// SecurityOverviewSection loads one extra section of a user's security overview
+type SecurityOverviewSection func(ctx context.Context, database *mongo.Database, user *User) (any, error)
+
+var (
+	securitySectionsMu sync.RWMutex
+	securitySections   = map[string]SecurityOverviewSection{}
+)
+
+// RegisterSecurityOverviewSection adds a named section to every security overview,
+// so subsystems such as sessions or two-factor auth can report their state
+func RegisterSecurityOverviewSection(name string, section SecurityOverviewSection) {
+	securitySectionsMu.Lock()
+	defer securitySectionsMu.Unlock()
+	securitySections[name] = section
+}
+
+// LoadSecurityOverview assembles the security overview for a user
+func LoadSecurityOverview(ctx context.Context, database *mongo.Database, userID string) (*SecurityOverview, error) {
+	var user User
+	projection := bson.M{
+		"email": 1, "created_at": 1, "is_verified": 1, "verified_at": 1, "password_changed_at": 1,
+		"last_login_at": 1, "locked_until": 1, "login_attempts": 1, "permanently_locked": 1,
+	}
+	err := database.Collection("users").FindOne(ctx, bson.M{"_id": userID}, options.FindOne().SetProjection(projection)).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	overview := &SecurityOverview{
+		IsVerified:          user.IsVerified,
+		VerifiedAt:          user.VerifiedAt,
+		PasswordChangedAt:   user.CreatedAt,
+		LockedUntil:         user.LockedUntil,
+		FailedLoginAttempts: user.LoginAttempts,
+		RecentEvents:        []SecurityEvent{},
+	}
+	if user.PasswordChangedAt != nil {
+		overview.PasswordChangedAt = *user.PasswordChangedAt
+	}
+	if !overview.PasswordChangedAt.IsZero() {
+		overview.PasswordAgeDays = int(now.Sub(overview.PasswordChangedAt.Time).Hours() / 24)
+	}
+	if !user.LastLoginAt.IsZero() {
+		overview.LastLoginAt = &user.LastLoginAt
+	}
+	overview.Locked = user.isLocked()
+
+	entries, err := QueryEmailLog(ctx, database, EmailLogQuery{Email: user.Email, Since: now.Add(-securityEventWindow), Limit: 50})
+	if err != nil {
+		log.Printf("Failed to load security events for user %s: %v", userID, err)
+	}
+	for _, entry := range entries {
+		if securityEmailTypes[entry.Type] {
+			overview.RecentEvents = append(overview.RecentEvents, SecurityEvent{Type: entry.Type, Status: entry.Status, CreatedAt: entry.CreatedAt})
+		}
+	}
+
+	securitySectionsMu.RLock()
+	defer securitySectionsMu.RUnlock()
+	for name, section := range securitySections {
+		data, err := section(ctx, database, &user)
+		if err != nil {
+			log.Printf("Failed to load security overview section %s for user %s: %v", name, userID, err)
+			continue
+		}
+		if overview.Sections == nil {
+			overview.Sections = map[string]any{}
+		}
+		overview.Sections[name] = data
+	}
+
+	return overview, nil
+}
+
+// GetSecurityOverview responds with the authenticated user's security overview
+func GetSecurityOverview(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r)
+	if userID == "" {
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	overview, err := LoadSecurityOverview(r.Context(), database, userID)
+	if err != nil {
+		log.Printf("Failed to load security overview: %v", err)
+		RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load security overview"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	RespondWithJSON(w, http.StatusOK, overview)
+}
@@ -0,0 +1,341 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// snsHostRegex matches the hosts SNS serves signing certificates and subscription URLs from
+var snsHostRegex = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsHTTPClient fetches signing certificates and confirms subscriptions
+var snsHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	snsCertificatesMu sync.RWMutex
+	snsCertificates   = map[string]*x509.Certificate{}
+)
+
+// SNSMessage represents an HTTP(S) delivery from Amazon SNS
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageId        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// sesNotification is the subset of an SES bounce/complaint notification we use
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"` // Set instead of notificationType by SES event publishing
+	Mail             struct {
+		MessageID string `json:"messageId"`
+	} `json:"mail"`
+	Bounce *struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+// SESNotificationHandler consumes SES bounce and complaint notifications delivered by SNS,
+// verifies the SNS signature, confirms subscriptions, and suppresses permanently bounced and
+// complaining addresses. Only topics in allowedTopicARNs are accepted, or subscribed to, so nobody
+// can subscribe their own topic and suppress addresses; with none, every message is refused.
+func SESNotificationHandler(database *mongo.Database, w http.ResponseWriter, r *http.Request, allowedTopicARNs []string) {
+	if len(allowedTopicARNs) == 0 {
+		log.Printf("CONFIG: SES notification handler has no allowed topic ARNs, refusing every message")
+		RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Server error"})
+		return
+	}
+
+	var message SNSMessage
+	if !ValidateAndBindJSON(w, r, &message) {
+		return
+	}
+
+	if !containsString(allowedTopicARNs, message.TopicArn) {
+		log.Printf("SECURITY: rejected SNS message from unexpected topic %s", message.TopicArn)
+		RespondWithJSON(w, http.StatusForbidden, map[string]string{"error": "Unknown topic"})
+		return
+	}
+
+	if err := VerifySNSMessage(&message); err != nil {
+		log.Printf("SECURITY: rejected SNS message %s: %v", message.MessageId, err)
+		RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid signature"})
+		return
+	}
+
+	switch message.Type {
+	case "SubscriptionConfirmation":
+		if err := confirmSNSSubscription(message.SubscribeURL); err != nil {
+			log.Printf("Failed to confirm SNS subscription to %s: %v", message.TopicArn, err)
+			RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Server error"})
+			return
+		}
+		log.Printf("Confirmed SNS subscription to %s", message.TopicArn)
+	case "Notification":
+		if err := handleSESNotification(database, r, message.Message); err != nil {
+			log.Printf("Failed to process SES notification %s: %v", message.MessageId, err)
+			RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Server error"})
+			return
+		}
+	case "UnsubscribeConfirmation":
+		log.Printf("SNS subscription to %s was removed", message.TopicArn)
+	default:
+		RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown message type"})
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "OK"})
+}
+
+// handleSESNotification records suppressions and email log status from a bounce or complaint notification
+func handleSESNotification(database *mongo.Database, r *http.Request, body string) error {
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(body), &notification); err != nil {
+		return fmt.Errorf("invalid SES notification: %w", err)
+	}
+
+	notificationType := notification.NotificationType
+	if notificationType == "" {
+		notificationType = notification.EventType
+	}
+
+	switch {
+	case notificationType == "Bounce" && notification.Bounce != nil:
+		// Transient bounces (full mailbox, etc.) may succeed later
+		if notification.Bounce.BounceType != "Permanent" {
+			log.Printf("Ignoring %s bounce notification", notification.Bounce.BounceType)
+			return nil
+		}
+
+		details := notification.Bounce.BounceType + "/" + notification.Bounce.BounceSubType
+		for _, recipient := range notification.Bounce.BouncedRecipients {
+			if err := RecordEmailSuppression(r.Context(), database, recipient.EmailAddress, SuppressionReasonBounce, details); err != nil {
+				return err
+			}
+			log.Printf("Suppressed %s after permanent bounce", RedactedEmail(recipient.EmailAddress))
+		}
+		if err := UpdateEmailLogStatus(r.Context(), database, notification.Mail.MessageID, EmailLogBounced); err != nil {
+			return err
+		}
+	case notificationType == "Complaint" && notification.Complaint != nil:
+		for _, recipient := range notification.Complaint.ComplainedRecipients {
+			if err := RecordEmailSuppression(r.Context(), database, recipient.EmailAddress, SuppressionReasonComplaint, notification.Complaint.ComplaintFeedbackType); err != nil {
+				return err
+			}
+			log.Printf("Suppressed %s after complaint", RedactedEmail(recipient.EmailAddress))
+		}
+		if err := UpdateEmailLogStatus(r.Context(), database, notification.Mail.MessageID, EmailLogComplained); err != nil {
+			return err
+		}
+	default:
+		log.Printf("Ignoring SES notification of type %q", notificationType)
+	}
+
+	return nil
+}
+
+// VerifySNSMessage checks that a message was signed by Amazon SNS
+func VerifySNSMessage(message *SNSMessage) error {
+	var hash crypto.Hash
+	switch message.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version %q", message.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	certificate, err := fetchSNSCertificate(message.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("signing certificate does not contain an RSA key")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(snsStringToSign(message)))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(snsStringToSign(message)))
+		digest = sum[:]
+	}
+
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, signature); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// snsStringToSign builds the canonical string SNS signs for a message type
+func snsStringToSign(message *SNSMessage) string {
+	var fields [][2]string
+	if message.Type == "Notification" {
+		fields = [][2]string{
+			{"Message", message.Message},
+			{"MessageId", message.MessageId},
+			{"Subject", message.Subject},
+			{"Timestamp", message.Timestamp},
+			{"TopicArn", message.TopicArn},
+			{"Type", message.Type},
+		}
+	} else {
+		fields = [][2]string{
+			{"Message", message.Message},
+			{"MessageId", message.MessageId},
+			{"SubscribeURL", message.SubscribeURL},
+			{"Timestamp", message.Timestamp},
+			{"Token", message.Token},
+			{"TopicArn", message.TopicArn},
+			{"Type", message.Type},
+		}
+	}
+
+	var b strings.Builder
+	for _, field := range fields {
+		// Subject is only signed when present
+		if field[0] == "Subject" && field[1] == "" {
+			continue
+		}
+		b.WriteString(field[0])
+		b.WriteByte('\n')
+		b.WriteString(field[1])
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// fetchSNSCertificate downloads and caches an SNS signing certificate after validating its URL
+func fetchSNSCertificate(certURL string) (*x509.Certificate, error) {
+	if err := validateSNSURL(certURL); err != nil {
+		return nil, fmt.Errorf("invalid signing certificate URL: %w", err)
+	}
+	if !strings.HasSuffix(certURL, ".pem") {
+		return nil, fmt.Errorf("invalid signing certificate URL: not a .pem file")
+	}
+
+	snsCertificatesMu.RLock()
+	certificate, ok := snsCertificates[certURL]
+	snsCertificatesMu.RUnlock()
+	if ok {
+		return certificate, nil
+	}
+
+	response, err := snsHTTPClient.Get(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signing certificate: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download signing certificate: status %d", response.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing certificate is not PEM encoded")
+	}
+
+	certificate, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing certificate: %w", err)
+	}
+
+	snsCertificatesMu.Lock()
+	snsCertificates[certURL] = certificate
+	snsCertificatesMu.Unlock()
+
+	return certificate, nil
+}
+
+// confirmSNSSubscription visits the subscribe URL to confirm an SNS subscription
+func confirmSNSSubscription(subscribeURL string) error {
+	if err := validateSNSURL(subscribeURL); err != nil {
+		return fmt.Errorf("invalid subscribe URL: %w", err)
+	}
+
+	response, err := snsHTTPClient.Get(subscribeURL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("subscription confirmation returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// validateSNSURL ensures a URL points at an HTTPS SNS endpoint, preventing SSRF via forged messages
+func validateSNSURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("URL must use https")
+	}
+	if !snsHostRegex.MatchString(parsed.Hostname()) {
+		return fmt.Errorf("URL host %q is not an SNS endpoint", parsed.Hostname())
+	}
+	return nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
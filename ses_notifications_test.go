@@ -0,0 +1,37 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSESNotificationHandlerTopics(t *testing.T) {
+	const allowed = "arn:aws:sns:us-east-1:123456789012:ses-notifications"
+	subscription := func(topic string) string {
+		return `{"Type":"SubscriptionConfirmation","TopicArn":"` + topic + `","SubscribeURL":"https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription"}`
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		body    string
+		want    int
+	}{
+		{"no allowed topics", nil, subscription(allowed), http.StatusInternalServerError},
+		{"subscription from another topic", []string{allowed}, subscription("arn:aws:sns:us-east-1:999999999999:attacker"), http.StatusForbidden},
+		{"notification from another topic", []string{allowed}, `{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:999999999999:attacker"}`, http.StatusForbidden},
+		{"unsigned message from the allowed topic", []string{allowed}, `{"Type":"Notification","TopicArn":"` + allowed + `"}`, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/ses/notifications", strings.NewReader(tt.body))
+			SESNotificationHandler(nil, w, r, tt.allowed)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
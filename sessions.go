@@ -0,0 +1,223 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSessionInvalid = errors.New("session is invalid or expired")
+
+// sessionTouchInterval limits how often a session's sliding expiry is written back
+const sessionTouchInterval = time.Minute
+
+// Session is a stored server-side session; its ID is a hash of the cookie value
+type Session struct {
+	ID                string    `json:"id" bson:"_id"`
+	UserID            string    `json:"-" bson:"user_id"`
+	UserAgent         string    `json:"user_agent" bson:"user_agent"`
+	IP                string    `json:"ip" bson:"ip"`
+	CreatedAt         time.Time `json:"created_at" bson:"created_at"`
+	LastSeenAt        time.Time `json:"last_seen_at" bson:"last_seen_at"`
+	ExpiresAt         time.Time `json:"expires_at" bson:"expires_at"`                       // Slides forward with use
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at" bson:"absolute_expires_at"`     // Never extended
+	Fingerprint       string    `json:"fingerprint,omitempty" bson:"fingerprint,omitempty"` // Hash identifying the device
+	Current           bool      `json:"current" bson:"-"`                                   // Set by List for the caller's own session
+}
+
+// SessionConfig holds session lifetimes and cookie attributes
+type SessionConfig struct {
+	CookieName  string        // Name of the session cookie
+	IdleTimeout time.Duration // Sessions unused for this long expire
+	MaxLifetime time.Duration // Sessions expire this long after login regardless of use
+	Domain      string        // Optional cookie domain
+	Path        string        // Cookie path
+	Secure      bool          // Only send the cookie over HTTPS
+	SameSite    http.SameSite // Cookie SameSite mode
+}
+
+// DefaultSessionConfig returns a secure session configuration; Secure is relaxed in development
+// so sessions work over plain http://localhost
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		CookieName:  "session",
+		IdleTimeout: 24 * time.Hour,
+		MaxLifetime: 30 * 24 * time.Hour,
+		Path:        "/",
+		Secure:      !IsDevelopment(),
+		SameSite:    http.SameSiteLaxMode,
+	}
+}
+
+// SessionManager stores sessions in the database's sessions collection and authenticates requests by cookie,
+// for services that prefer revocable server-side sessions over JWTs
+type SessionManager struct {
+	config   SessionConfig
+	sessions *mongo.Collection
+}
+
+// NewSessionManager creates a session manager, creating the sessions collection's indexes
+func NewSessionManager(ctx context.Context, database *mongo.Database, config SessionConfig) (*SessionManager, error) {
+	collection := database.Collection("sessions")
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionManager{config: config, sessions: collection}, nil
+}
+
+// Create starts a session for userID and sets its cookie on w
+func (m *SessionManager) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, userID string) (*Session, error) {
+	value, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:                hashOpaqueToken(value),
+		UserID:            userID,
+		UserAgent:         r.UserAgent(),
+		IP:                GetClientIP(r),
+		CreatedAt:         now,
+		LastSeenAt:        now,
+		ExpiresAt:         m.slidingExpiry(now, now.Add(m.config.MaxLifetime)),
+		AbsoluteExpiresAt: now.Add(m.config.MaxLifetime),
+		Fingerprint:       deviceFingerprint(r),
+	}
+	if _, err := m.sessions.InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+
+	m.setCookie(w, value, session.AbsoluteExpiresAt)
+	return session, nil
+}
+
+// Get returns the active session identified by the request's cookie, extending its sliding expiry
+func (m *SessionManager) Get(ctx context.Context, r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrSessionInvalid
+	}
+
+	now := time.Now()
+	var session Session
+	err = m.sessions.FindOne(ctx, bson.M{"_id": hashOpaqueToken(cookie.Value), "expires_at": bson.M{"$gt": now}}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Slide the expiry forward, at most once a minute to keep busy sessions from writing on every request
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		session.LastSeenAt = now
+		session.ExpiresAt = m.slidingExpiry(now, session.AbsoluteExpiresAt)
+		_, err := m.sessions.UpdateOne(ctx, bson.M{"_id": session.ID},
+			bson.M{"$set": bson.M{"last_seen_at": session.LastSeenAt, "expires_at": session.ExpiresAt}})
+		if err != nil {
+			log.Printf("Failed to extend session: %v", err)
+		}
+	}
+	return &session, nil
+}
+
+// Destroy ends the request's session, if any, and clears its cookie
+func (m *SessionManager) Destroy(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	m.setCookie(w, "", time.Unix(0, 0))
+
+	cookie, err := r.Cookie(m.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	_, err = m.sessions.DeleteOne(ctx, bson.M{"_id": hashOpaqueToken(cookie.Value)})
+	return err
+}
+
+// RevokeUserSessions ends every session belonging to a user
+func (m *SessionManager) RevokeUserSessions(ctx context.Context, userID string) error {
+	_, err := m.sessions.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// Middleware authenticates requests by session cookie, like Authenticate does by bearer token
+func (m *SessionManager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := m.Get(r.Context(), r)
+		if errors.Is(err, ErrSessionInvalid) {
+			RespondWithJSON(w, 401, map[string]string{"error": "Authorization required"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load session: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+
+		next.ServeHTTP(w, SetUserID(r, session.UserID))
+	})
+}
+
+// slidingExpiry returns the idle expiry for a session used at now, capped at its absolute expiry
+func (m *SessionManager) slidingExpiry(now, absolute time.Time) time.Time {
+	expiry := now.Add(m.config.IdleTimeout)
+	if m.config.IdleTimeout <= 0 || expiry.After(absolute) {
+		return absolute
+	}
+	return expiry
+}
+
+// setCookie writes the session cookie; an empty value deletes it
+func (m *SessionManager) setCookie(w http.ResponseWriter, value string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Domain:   m.config.Domain,
+		Path:     m.config.Path,
+		Expires:  expires,
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: m.config.SameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// SessionLogin checks a login request like Login but starts a server-side session instead of issuing tokens
+func SessionLogin(database *mongo.Database, w http.ResponseWriter, r *http.Request, sessions *SessionManager, secret string) {
+	user, form, ok := authenticateLogin(database, w, r, secret)
+	if !ok {
+		return
+	}
+
+	if _, err := sessions.Create(r.Context(), w, r, user.ID); err != nil {
+		log.Printf("Failed to create session: %v", err)
+		currentLoginMetrics().LoginAttempt(LoginOutcomeError)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	recordLogin(r.Context(), database, user, form.Password)
+	recordLoginSuccess(r, "password", user)
+
+	RespondWithJSON(w, 200, map[string]interface{}{
+		"user": map[string]string{
+			"id":    user.ID,
+			"email": user.Email,
+			"name":  user.Name,
+		},
+	})
+}
@@ -0,0 +1,484 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// smsRateLimitWindow is the period per-number SMS limits are counted over
+const smsRateLimitWindow = time.Hour
+
+// maxSMSCodeAttempts is how many wrong guesses invalidate a code
+const maxSMSCodeAttempts = 5
+
+// Purposes of SMS codes; a code sent for one can't be used for the other
+const (
+	smsPurposeLogin       = "login"
+	smsPurposeVerifyPhone = "verify_phone"
+)
+
+var (
+	ErrSMSRateLimited     = errors.New("too many codes sent to this number")
+	ErrSMSCodeInvalid     = errors.New("code is invalid or expired")
+	ErrPhoneNumberInvalid = errors.New("phone number must be in international format, e.g. +14155550123")
+)
+
+// SNSClient is the subset of the SNS client used to send SMS, so tests can substitute a fake
+type SNSClient interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// SMSLoginConfig configures SMS one-time codes
+type SMSLoginConfig struct {
+	AppName     string        // Named in the message, e.g. "Your Flight History code is 123456"; defaults to the email app name
+	SenderID    string        // Optional alphanumeric sender ID, where the destination country supports one
+	CodeLength  int           // Digits per code; defaults to 6
+	CodeTTL     time.Duration // How long a code is valid; defaults to 5 minutes
+	HourlyLimit int           // Codes sent to one number per hour; defaults to 5
+}
+
+// smsLogin holds the state configured by EnableSMSLogin
+type smsLogin struct {
+	client SNSClient // Nil logs that a code was sent, without it, instead of sending it; in development only
+	config SMSLoginConfig
+	codes  *mongo.Collection
+	limits *mongo.Collection
+}
+
+var (
+	smsLoginMu     sync.RWMutex
+	smsLoginState  *smsLogin
+	smsLoginTiming = DefaultForgotPasswordTiming()
+)
+
+// smsCode is a stored one-time code; only its hash is kept
+type smsCode struct {
+	ID        string    `bson:"_id"` // Purpose and phone number
+	CodeHash  string    `bson:"code_hash"`
+	UserID    string    `bson:"user_id,omitempty"` // Set for phone verification codes
+	Attempts  int       `bson:"attempts"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// SMSCodeRequestForm is the body of a request for an SMS code
+type SMSCodeRequestForm struct {
+	Phone string `json:"phone" binding:"required"` // E.164 phone number, e.g. +14155550123
+}
+
+// SMSLoginForm is the body of an SMS code login or phone verification
+type SMSLoginForm struct {
+	Phone string `json:"phone" binding:"required"` // The number the code was sent to
+	Code  string `json:"code" binding:"required"`  // The code from the SMS
+}
+
+// EnableSMSLogin lets users with a verified phone number sign in with codes sent by SMS through client,
+// storing codes in the database's sms_codes collection and per-number counts in sms_rate_limits.
+// In development client may be nil, which logs that codes were sent, with the codes redacted, instead of
+// sending them; pass a fake SNSClient to read them.
+func EnableSMSLogin(ctx context.Context, database *mongo.Database, client SNSClient, config SMSLoginConfig) error {
+	if client == nil && !IsDevelopment() {
+		return fmt.Errorf("CONFIG: SMS login needs an SNS client outside development")
+	}
+	if config.AppName == "" {
+		_, emailConfig, _, _, _ := defaultEmailService.state()
+		config.AppName = emailConfig.AppName
+	}
+	if config.CodeLength <= 0 {
+		config.CodeLength = 6
+	}
+	if config.CodeTTL <= 0 {
+		config.CodeTTL = 5 * time.Minute
+	}
+	if config.HourlyLimit <= 0 {
+		config.HourlyLimit = 5
+	}
+
+	ttlIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	codes := database.Collection("sms_codes")
+	if _, err := codes.Indexes().CreateOne(ctx, ttlIndex); err != nil {
+		return err
+	}
+	limits := database.Collection("sms_rate_limits")
+	if _, err := limits.Indexes().CreateOne(ctx, ttlIndex); err != nil {
+		return err
+	}
+	if _, err := database.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"phone": bson.M{"$exists": true}}),
+	}); err != nil {
+		return err
+	}
+
+	smsLoginMu.Lock()
+	defer smsLoginMu.Unlock()
+	smsLoginState = &smsLogin{client: client, config: config, codes: codes, limits: limits}
+	return nil
+}
+
+// currentSMSLogin returns the SMS login state, or nil if SMS login is disabled
+func currentSMSLogin() *smsLogin {
+	smsLoginMu.RLock()
+	defer smsLoginMu.RUnlock()
+	return smsLoginState
+}
+
+// SetSMSLoginTiming sets the response padding used by SendSMSLoginCode, which defaults to DefaultForgotPasswordTiming
+// Pass a zero ResponseTiming to disable padding
+func SetSMSLoginTiming(timing ResponseTiming) {
+	smsLoginMu.Lock()
+	defer smsLoginMu.Unlock()
+	smsLoginTiming = timing
+}
+
+// NormalizePhoneNumber strips formatting from a phone number and checks it is in E.164 format
+func NormalizePhoneNumber(phone string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			continue
+		default:
+			return "", ErrPhoneNumberInvalid
+		}
+	}
+
+	normalized := b.String()
+	if !strings.HasPrefix(normalized, "+") || len(normalized) < 9 || len(normalized) > 16 || normalized[1] == '0' {
+		return "", ErrPhoneNumberInvalid
+	}
+	return normalized, nil
+}
+
+// sendCode counts a send against phone's rate limit, then generates, stores and sends a code for purpose to it
+func (s *smsLogin) sendCode(ctx context.Context, purpose, phone, userID string) error {
+	if err := s.checkRateLimit(ctx, phone); err != nil {
+		return err
+	}
+	return s.deliverCode(ctx, purpose, phone, userID)
+}
+
+// deliverCode generates, stores and sends a code for purpose to phone, replacing any earlier one
+func (s *smsLogin) deliverCode(ctx context.Context, purpose, phone, userID string) error {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(s.config.CodeLength)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return fmt.Errorf("failed to generate code: %w", err)
+	}
+	code := fmt.Sprintf("%0*d", s.config.CodeLength, n)
+
+	_, err = s.codes.ReplaceOne(ctx, bson.M{"_id": purpose + ":" + phone}, smsCode{
+		ID:        purpose + ":" + phone,
+		CodeHash:  hashOpaqueToken(code),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(s.config.CodeTTL),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to store code: %w", err)
+	}
+
+	if s.client == nil {
+		log.Printf("SMS DRY RUN: to=%s purpose=%s code=%s", RedactedPhone(phone), purpose, Secret(code))
+		return nil
+	}
+
+	message := fmt.Sprintf("Your %s code is %s. It expires in %d minutes.", s.config.AppName, code, int(s.config.CodeTTL.Minutes()))
+
+	input := &sns.PublishInput{
+		PhoneNumber: aws.String(phone),
+		Message:     aws.String(message),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"), StringValue: aws.String("Transactional")},
+		},
+	}
+	if s.config.SenderID != "" {
+		input.MessageAttributes["AWS.SNS.SMS.SenderID"] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(s.config.SenderID)}
+	}
+	if _, err := s.client.Publish(ctx, input); err != nil {
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+	return nil
+}
+
+// checkRateLimit counts a send to phone against its hourly limit
+// Database failures are logged and the send is allowed, like email rate limits.
+func (s *smsLogin) checkRateLimit(ctx context.Context, phone string) error {
+	windowStart := time.Now().Truncate(smsRateLimitWindow)
+
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	err := s.limits.FindOneAndUpdate(ctx,
+		bson.M{"_id": phone + ":" + strconv.FormatInt(windowStart.Unix(), 10)},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expires_at": windowStart.Add(smsRateLimitWindow)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		log.Printf("Failed to check SMS rate limit for %s, allowing: %v", RedactedPhone(phone), err)
+		return nil
+	}
+	if counter.Count > s.config.HourlyLimit {
+		log.Printf("SECURITY: SMS codes to %s rate limited after %d sends this hour", RedactedPhone(phone), s.config.HourlyLimit)
+		return ErrSMSRateLimited
+	}
+	return nil
+}
+
+// checkCode consumes a valid code for purpose and phone, returning it; every guess counts toward
+// maxSMSCodeAttempts before the code is compared, so concurrent guesses can't exceed it
+func (s *smsLogin) checkCode(ctx context.Context, purpose, phone, code string) (*smsCode, error) {
+	id := purpose + ":" + phone
+
+	var stored smsCode
+	err := s.codes.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "attempts": bson.M{"$lt": maxSMSCodeAttempts}, "expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSMSCodeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashOpaqueToken(code)), []byte(stored.CodeHash)) != 1 {
+		if stored.Attempts >= maxSMSCodeAttempts {
+			if _, err := s.codes.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+				log.Printf("Failed to delete SMS code after %d attempts: %v", stored.Attempts, err)
+			}
+		}
+		return nil, ErrSMSCodeInvalid
+	}
+
+	// Delete by hash too, so two concurrent uses of one code can't both succeed
+	result, err := s.codes.DeleteOne(ctx, bson.M{"_id": id, "code_hash": stored.CodeHash})
+	if err != nil {
+		return nil, err
+	}
+	if result.DeletedCount == 0 {
+		return nil, ErrSMSCodeInvalid
+	}
+	return &stored, nil
+}
+
+// respondSMSError responds to the errors sending a code can return
+func respondSMSError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSMSRateLimited):
+		RespondWithJSON(w, 429, map[string]string{"error": "Too many codes requested, please try again later"})
+	default:
+		log.Printf("Failed to send SMS code: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+	}
+}
+
+// SendSMSLoginCode texts a login code to a verified phone number
+// The per-number rate limit is counted before the lookup, so it applies whether or not the number belongs to a user,
+// and the code is sent in the background. Every other response is the same 200, sent at the same deadline
+// (see SetSMSLoginTiming), so neither content nor timing enumerates registered numbers.
+func SendSMSLoginCode(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	s := currentSMSLogin()
+	if s == nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "SMS login is not enabled"})
+		return
+	}
+	smsLoginMu.RLock()
+	timing := smsLoginTiming
+	smsLoginMu.RUnlock()
+
+	var form SMSCodeRequestForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+	phone, err := NormalizePhoneNumber(form.Phone)
+	if err != nil {
+		RespondWithValidationError(w, "phone", "must be in international format, e.g. +14155550123")
+		return
+	}
+
+	if err := s.checkRateLimit(r.Context(), phone); err != nil {
+		respondSMSError(w, err)
+		return
+	}
+
+	var user User
+	err = database.Collection("users").FindOne(r.Context(), bson.M{"phone": phone, "phone_verified_at": bson.M{"$ne": nil}}).Decode(&user)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Failed to look up phone number: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	if err == nil {
+		RunInBackground("sms_login_code", func(ctx context.Context) {
+			if err := s.deliverCode(ctx, smsPurposeLogin, phone, ""); err != nil {
+				log.Printf("Failed to send SMS login code: %v", err)
+			}
+		})
+	}
+
+	timing.wait(r.Context(), start)
+	RespondWithJSON(w, 200, map[string]string{"message": "If this number belongs to an account, a code has been sent."})
+}
+
+// VerifySMSLoginCode signs in with a code from SendSMSLoginCode and responds like Login
+func VerifySMSLoginCode(database *mongo.Database, w http.ResponseWriter, r *http.Request, secret string) {
+	secretAuth(secret).VerifySMSLoginCode(database, w, r)
+}
+
+// VerifySMSLoginCode signs in with a code from SendSMSLoginCode and responds like Login
+func (a *Auth) VerifySMSLoginCode(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	s := currentSMSLogin()
+	if s == nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "SMS login is not enabled"})
+		return
+	}
+
+	var form SMSLoginForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+	phone, err := NormalizePhoneNumber(form.Phone)
+	if err != nil {
+		RespondWithJSON(w, 401, map[string]string{"error": "Invalid code"})
+		return
+	}
+
+	if _, err := s.checkCode(r.Context(), smsPurposeLogin, phone, strings.TrimSpace(form.Code)); err != nil {
+		if !errors.Is(err, ErrSMSCodeInvalid) {
+			log.Printf("Failed to check SMS code: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+		recordLoginFailure(r, "sms", "", nil, LoginOutcomeBadPassword)
+		RespondWithJSON(w, 401, map[string]string{"error": "Invalid code"})
+		return
+	}
+
+	var user User
+	err = database.Collection("users").FindOne(r.Context(), bson.M{"phone": phone, "phone_verified_at": bson.M{"$ne": nil}}).Decode(&user)
+	if err != nil {
+		// The number was unlinked after the code was sent
+		RespondWithJSON(w, 401, map[string]string{"error": "Invalid code"})
+		return
+	}
+	if user.isLocked() {
+		recordLoginFailure(r, "sms", "", &user, LoginOutcomeLocked)
+		RespondWithJSON(w, 423, map[string]string{"error": "Account temporarily locked"})
+		return
+	}
+
+	response, err := a.loginResponse(w, r, &user, false)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v", err)
+		currentLoginMetrics().LoginAttempt(LoginOutcomeError)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	database.Collection("users").UpdateOne(r.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"last_login_at": Now()},
+	})
+	currentLoginMetrics().LoginAttempt(LoginOutcomeSuccess)
+	recordLoginSuccess(r, "sms", &user)
+	RespondWithJSON(w, 200, response)
+}
+
+// StartPhoneVerification texts a code to a number the authenticated user wants to add to their account
+func StartPhoneVerification(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	s := currentSMSLogin()
+	if s == nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "SMS login is not enabled"})
+		return
+	}
+
+	var form SMSCodeRequestForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+	phone, err := NormalizePhoneNumber(form.Phone)
+	if err != nil {
+		RespondWithValidationError(w, "phone", "must be in international format, e.g. +14155550123")
+		return
+	}
+
+	// Codes are per number, so the pending code remembers which user asked for it
+	if err := s.sendCode(r.Context(), smsPurposeVerifyPhone, phone, GetUserID(r)); err != nil {
+		respondSMSError(w, err)
+		return
+	}
+	RespondWithJSON(w, 200, map[string]string{"message": "A code has been sent to " + phone})
+}
+
+// ConfirmPhoneVerification sets the authenticated user's verified phone number from a StartPhoneVerification code
+func ConfirmPhoneVerification(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	s := currentSMSLogin()
+	if s == nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "SMS login is not enabled"})
+		return
+	}
+
+	var form SMSLoginForm
+	if !ValidateAndBindJSON(w, r, &form) {
+		return
+	}
+	phone, err := NormalizePhoneNumber(form.Phone)
+	if err != nil {
+		RespondWithValidationError(w, "phone", "must be in international format, e.g. +14155550123")
+		return
+	}
+
+	stored, err := s.checkCode(r.Context(), smsPurposeVerifyPhone, phone, strings.TrimSpace(form.Code))
+	if err == nil && stored.UserID != GetUserID(r) {
+		err = ErrSMSCodeInvalid
+	}
+	if errors.Is(err, ErrSMSCodeInvalid) {
+		RespondWithValidationError(w, "code", "is invalid or expired")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to check SMS code: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	_, err = database.Collection("users").UpdateOne(r.Context(), bson.M{"_id": GetUserID(r)}, bson.M{
+		"$set": bson.M{"phone": phone, "phone_verified_at": Now(), "updated_at": Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		RespondWithValidationError(w, "phone", "is already used by another account")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save phone number: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+	recordAuthEvent(r.Context(), r, AuthEvent{Type: AuthEventPhoneVerified, UserID: GetUserID(r)})
+	RespondWithJSON(w, 200, map[string]string{"phone": phone})
+}
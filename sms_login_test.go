@@ -0,0 +1,170 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSMSCheckCode(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	const phone = "+14155550123"
+	stored := func(code string, attempts int) bson.D {
+		return bson.D{
+			{Key: "_id", Value: smsPurposeLogin + ":" + phone},
+			{Key: "code_hash", Value: hashOpaqueToken(code)},
+			{Key: "attempts", Value: attempts},
+			{Key: "expires_at", Value: time.Now().Add(time.Minute)},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		value     any // The document findAndModify returns; nil when no code is under the attempt cap
+		code      string
+		deleted   bool // Whether the code is deleted
+		wantError error
+	}{
+		{"attempt cap reached", nil, "123456", false, ErrSMSCodeInvalid},
+		{"wrong code under the cap", stored("123456", 2), "654321", false, ErrSMSCodeInvalid},
+		{"wrong code using the last attempt", stored("123456", maxSMSCodeAttempts), "654321", true, ErrSMSCodeInvalid},
+		{"right code", stored("123456", 1), "123456", true, nil},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: tt.value}))
+			if tt.deleted {
+				mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+			}
+			s := &smsLogin{codes: mt.Coll}
+
+			_, err := s.checkCode(context.Background(), smsPurposeLogin, phone, tt.code)
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("error = %v, want %v", err, tt.wantError)
+			}
+
+			// The attempt is counted by the same command that reads the code, only while under the cap
+			started := mt.GetStartedEvent()
+			if started.CommandName != "findAndModify" {
+				t.Fatalf("first command = %s, want findAndModify", started.CommandName)
+			}
+			query := started.Command.Lookup("query").Document()
+			if limit := query.Lookup("attempts", "$lt").AsInt64(); limit != maxSMSCodeAttempts {
+				t.Fatalf("attempts filter = %d, want < %d", limit, maxSMSCodeAttempts)
+			}
+			if inc := started.Command.Lookup("update", "$inc", "attempts").AsInt64(); inc != 1 {
+				t.Fatalf("attempts increment = %d, want 1", inc)
+			}
+
+			next := mt.GetStartedEvent()
+			if deleted := next != nil && next.CommandName == "delete"; deleted != tt.deleted {
+				t.Fatalf("deleted = %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+// countingSNS counts the messages it's asked to publish
+type countingSNS struct {
+	published atomic.Int32
+}
+
+func (c *countingSNS) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	c.published.Add(1)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSendSMSLoginCode(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	const deadline = 200 * time.Millisecond
+	SetSMSLoginTiming(ResponseTiming{MinDuration: deadline})
+	t.Cleanup(func() { SetSMSLoginTiming(DefaultForgotPasswordTiming()) })
+	captureLog(t)
+
+	const phone = "+14155550123"
+	user := bson.D{{Key: "_id", Value: testUserID}, {Key: "phone", Value: phone}, {Key: "phone_verified_at", Value: time.Now()}}
+	tests := []struct {
+		name  string
+		count int      // Sends to the number this hour, including this one
+		users []bson.D // The users the lookup finds
+		want  int
+		sent  bool
+	}{
+		{"unknown number", 1, nil, http.StatusOK, false},
+		{"registered number", 1, []bson.D{user}, http.StatusOK, true},
+		{"unknown number over the limit", 6, nil, http.StatusTooManyRequests, false},
+		{"registered number over the limit", 6, []bson.D{user}, http.StatusTooManyRequests, false},
+	}
+	var durations []time.Duration
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			previous := currentBackgroundRunner()
+			runner := NewBackgroundRunner(1)
+			SetBackgroundRunner(runner)
+			defer SetBackgroundRunner(previous)
+
+			client := &countingSNS{}
+			smsLoginMu.Lock()
+			smsLoginState = &smsLogin{
+				client: client,
+				config: SMSLoginConfig{AppName: "Test", CodeLength: 6, CodeTTL: time.Minute, HourlyLimit: 5},
+				codes:  mt.DB.Collection("sms_codes"),
+				limits: mt.DB.Collection("sms_rate_limits"),
+			}
+			smsLoginMu.Unlock()
+			defer func() {
+				smsLoginMu.Lock()
+				smsLoginState = nil
+				smsLoginMu.Unlock()
+			}()
+
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "count", Value: tt.count}}}),
+				mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, tt.users...),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			)
+			r := httptest.NewRequest(http.MethodPost, "/auth/sms/send", strings.NewReader(`{"phone":"`+phone+`"}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			start := time.Now()
+			SendSMSLoginCode(mt.DB, w, r)
+			if tt.want == http.StatusOK {
+				durations = append(durations, time.Since(start))
+			}
+			if w.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if err := runner.Shutdown(context.Background()); err != nil {
+				mt.Fatal(err)
+			}
+
+			// The number's limit is counted before it is looked up
+			if first := mt.GetStartedEvent(); first == nil || first.CommandName != "findAndModify" {
+				mt.Fatalf("first command = %v, want the rate limit's findAndModify", first)
+			}
+			if sent := client.published.Load() == 1; sent != tt.sent {
+				mt.Fatalf("sent = %v, want %v", sent, tt.sent)
+			}
+		})
+	}
+
+	// Unknown and registered numbers respond at the deadline, not after their own work
+	const band = 50 * time.Millisecond
+	for i, d := range durations {
+		if d < deadline || d > deadline+band {
+			t.Errorf("response %d took %v, want between %v and %v", i, d, deadline, deadline+band)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StreamTicketTTL is how long a stream ticket can wait to be used
+const StreamTicketTTL = 30 * time.Second
+
+// streamTicketProtocolPrefix marks the Sec-WebSocket-Protocol entry that carries a ticket, e.g. "ticket.abc"
+const streamTicketProtocolPrefix = "ticket."
+
+var ErrStreamTicketsDisabled = errors.New("stream tickets are not enabled")
+
+// streamTicket is a stored single-use ticket; its ID is a hash of the ticket and it keeps the claims
+// of the access token it was issued for
+type streamTicket struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id"`
+	TokenID   string    `bson:"jti,omitempty"`
+	SessionID string    `bson:"sid,omitempty"`
+	Roles     []string  `bson:"roles,omitempty"`
+	Scopes    []string  `bson:"scopes,omitempty"`
+	Binding   string    `bson:"bnd,omitempty"`
+	IssuedAt  time.Time `bson:"iat"` // Of the access token, for revocation checks
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+var (
+	streamTicketsMu sync.RWMutex
+	streamTickets   *mongo.Collection
+)
+
+// EnableStreamTickets stores stream tickets in the database's stream_tickets collection, so a ticket issued
+// by one instance can open a connection on another
+func EnableStreamTickets(ctx context.Context, database *mongo.Database) error {
+	collection := database.Collection("stream_tickets")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	streamTicketsMu.Lock()
+	defer streamTicketsMu.Unlock()
+	streamTickets = collection
+	return nil
+}
+
+// currentStreamTickets returns the ticket collection, or nil if stream tickets are disabled
+func currentStreamTickets() *mongo.Collection {
+	streamTicketsMu.RLock()
+	defer streamTicketsMu.RUnlock()
+	return streamTickets
+}
+
+// IssueStreamTicket issues a single-use ticket for the access token that authenticated the request, valid for
+// StreamTicketTTL. Browsers can't set headers on WebSocket or EventSource connections, so they fetch a
+// ticket with their token and pass it to StreamMiddleware instead; the token itself never appears in a URL.
+func IssueStreamTicket(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
+	claims := ClaimsFromContext(r)
+	if claims == nil {
+		RespondWithJSON(w, 401, map[string]string{"error": "Authorization required"})
+		return
+	}
+	collection := currentStreamTickets()
+	if collection == nil {
+		RespondWithJSON(w, 404, map[string]string{"error": "Stream tickets are not enabled"})
+		return
+	}
+
+	ticket, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("Failed to generate stream ticket: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	// A ticket never outlives the token it was issued for
+	expiresAt := time.Now().Add(StreamTicketTTL)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(expiresAt) {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	stored := streamTicket{
+		ID:        hashOpaqueToken(ticket),
+		UserID:    claims.Subject,
+		TokenID:   claims.ID,
+		SessionID: claims.SessionID,
+		Roles:     claims.Roles,
+		Scopes:    claims.Scopes,
+		Binding:   claims.Binding,
+		ExpiresAt: expiresAt,
+	}
+	if claims.IssuedAt != nil {
+		stored.IssuedAt = claims.IssuedAt.Time
+	}
+	if _, err := collection.InsertOne(r.Context(), stored); err != nil {
+		log.Printf("Failed to store stream ticket: %v", err)
+		RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+		return
+	}
+
+	RespondWithJSON(w, 200, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+// requestStreamTicket returns the ticket presented with r, from the ticket query parameter or a
+// "ticket."-prefixed Sec-WebSocket-Protocol entry, or "" if there is none
+func requestStreamTicket(r *http.Request) string {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return ticket
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if ticket, ok := strings.CutPrefix(strings.TrimSpace(protocol), streamTicketProtocolPrefix); ok {
+				return ticket
+			}
+		}
+	}
+	return ""
+}
+
+// redeemStreamTicket consumes a ticket, returning the claims it was issued for
+// Tickets that don't exist, were already used or have expired are invalid.
+func redeemStreamTicket(ctx context.Context, ticket string) (*AppClaims, error) {
+	collection := currentStreamTickets()
+	if collection == nil {
+		return nil, ErrStreamTicketsDisabled
+	}
+
+	var stored streamTicket
+	err := collection.FindOneAndDelete(ctx, bson.M{"_id": hashOpaqueToken(ticket), "expires_at": bson.M{"$gt": time.Now()}}).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   stored.UserID,
+			ID:        stored.TokenID,
+			IssuedAt:  jwt.NewNumericDate(stored.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(stored.ExpiresAt),
+		},
+		Roles:     stored.Roles,
+		Scopes:    stored.Scopes,
+		TokenType: TokenTypeAccess,
+		Binding:   stored.Binding,
+		SessionID: stored.SessionID,
+	}, nil
+}
+
+// AuthenticateStream is Authenticate for WebSocket and EventSource endpoints; see Auth.StreamMiddleware
+func AuthenticateStream(next http.Handler) http.Handler {
+	return authenticateStream(Authenticate(next), next)
+}
+
+// StreamMiddleware is Middleware for WebSocket and EventSource endpoints: requests may also authenticate with
+// a ticket from IssueStreamTicket, in the ticket query parameter or as a "ticket.<ticket>" Sec-WebSocket-Protocol
+// entry. WebSocket servers must not echo that entry back as the selected protocol.
+func (a *Auth) StreamMiddleware(next http.Handler) http.Handler {
+	return authenticateStream(a.Middleware(next), next)
+}
+
+// authenticateStream serves requests with a ticket by redeeming it for next, and others with authenticated
+func authenticateStream(authenticated, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticket := requestStreamTicket(r)
+		if ticket == "" {
+			authenticated.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := redeemStreamTicket(r.Context(), ticket)
+		if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, ErrStreamTicketsDisabled) {
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid ticket"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to redeem stream ticket: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+
+		// Apply the checks the token itself would get
+		if !verifyTokenBinding(r, claims.Binding) {
+			log.Printf("SECURITY: stream ticket binding mismatch for user %s", claims.Subject)
+			RespondWithJSON(w, 401, map[string]string{"error": "Invalid ticket"})
+			return
+		}
+		revoked, err := isTokenRevoked(r.Context(), claims.ID, claims.SessionID, claims.Subject, claims.IssuedAt.Time)
+		if err != nil {
+			log.Printf("Failed to check token revocation: %v", err)
+			RespondWithJSON(w, 500, map[string]string{"error": "Server error"})
+			return
+		}
+		if revoked {
+			RespondWithJSON(w, 401, map[string]string{"error": "Token revoked"})
+			return
+		}
+
+		next.ServeHTTP(w, SetClaims(r, claims))
+	})
+}
@@ -0,0 +1,339 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// DateLayout is the layout used for date-only values (YYYY-MM-DD)
+const DateLayout = "2006-01-02"
+
+// ParseRFC3339 parses an RFC3339 timestamp (with or without fractional seconds) and returns it in UTC
+func ParseRFC3339(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid RFC3339 timestamp %q: %w", value, err)
+	}
+	return t.UTC(), nil
+}
+
+// FormatRFC3339 formats a time as an RFC3339 timestamp in UTC
+func FormatRFC3339(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// LoadLocation loads an IANA timezone name, falling back to UTC if it is empty or unknown
+func LoadLocation(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// UserLocation returns the user's preferred timezone, or UTC if none is set
+func UserLocation(user *User) *time.Location {
+	if user == nil {
+		return time.UTC
+	}
+	return LoadLocation(user.Timezone)
+}
+
+// InUserTimezone converts a time into the user's preferred timezone
+func InUserTimezone(t time.Time, user *User) time.Time {
+	return t.In(UserLocation(user))
+}
+
+// Date represents a calendar date without a time component
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// NewDate creates a date from its components
+func NewDate(year int, month time.Month, day int) Date {
+	return DateOf(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+}
+
+// DateOf returns the calendar date of t in t's location
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{Year: year, Month: month, Day: day}
+}
+
+// DateIn returns the calendar date of t in the given location
+func DateIn(t time.Time, loc *time.Location) Date {
+	return DateOf(t.In(loc))
+}
+
+// ParseDate parses a date in YYYY-MM-DD format
+func ParseDate(value string) (Date, error) {
+	t, err := time.Parse(DateLayout, value)
+	if err != nil {
+		return Date{}, fmt.Errorf("invalid date %q: expected YYYY-MM-DD", value)
+	}
+	return DateOf(t), nil
+}
+
+// String formats the date as YYYY-MM-DD
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// IsZero reports whether the date is unset
+func (d Date) IsZero() bool {
+	return d.Year == 0 && d.Month == 0 && d.Day == 0
+}
+
+// In returns the start of the date in the given location: midnight, or the end of the DST gap on days
+// whose midnight is skipped, e.g. in zones that spring forward at 00:00
+func (d Date) In(loc *time.Location) time.Time {
+	start := time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, loc)
+	if DateOf(start) != DateOf(time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)) {
+		// time.Date resolved the missing midnight to the evening before; the day starts when the gap ends
+		_, start = start.ZoneBounds()
+	}
+	return start
+}
+
+// AddDays returns the date n days after d (n may be negative)
+func (d Date) AddDays(n int) Date {
+	return DateOf(d.In(time.UTC).AddDate(0, 0, n))
+}
+
+// Before reports whether d is before other
+func (d Date) Before(other Date) bool {
+	return d.In(time.UTC).Before(other.In(time.UTC))
+}
+
+// After reports whether d is after other
+func (d Date) After(other Date) bool {
+	return d.In(time.UTC).After(other.In(time.UTC))
+}
+
+// MarshalJSON encodes the date as a "YYYY-MM-DD" string, or null if unset
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes a "YYYY-MM-DD" string
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value *string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	if value == nil || *value == "" {
+		*d = Date{}
+		return nil
+	}
+
+	parsed, err := ParseDate(*value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalBSONValue stores the date as a "YYYY-MM-DD" string so it sorts and compares correctly
+func (d Date) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if d.IsZero() {
+		return bson.TypeNull, nil, nil
+	}
+	return bson.MarshalValue(d.String())
+}
+
+// UnmarshalBSONValue decodes a date stored as a string or as a BSON datetime
+func (d *Date) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bson.TypeNull, bson.TypeUndefined:
+		*d = Date{}
+		return nil
+	case bson.TypeString:
+		parsed, err := ParseDate(raw.StringValue())
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	case bson.TypeDateTime:
+		*d = DateIn(raw.Time(), time.UTC)
+		return nil
+	default:
+		return fmt.Errorf("cannot decode BSON %s into Date", t)
+	}
+}
+
+// Time wraps time.Time so it always encodes as an RFC3339 UTC string in JSON and as a
+// UTC BSON datetime, regardless of the location it was created in
+type Time struct {
+	time.Time
+}
+
+// NewTime wraps a time.Time
+func NewTime(t time.Time) Time {
+	return Time{Time: t.UTC()}
+}
+
+// Now returns the current time as a Time
+func Now() Time {
+	return NewTime(time.Now())
+}
+
+// TimePtr returns a pointer to a wrapped time, for optional fields
+func TimePtr(t time.Time) *Time {
+	wrapped := NewTime(t)
+	return &wrapped
+}
+
+// MarshalJSON encodes the time as an RFC3339 UTC string, or null if unset
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(FormatRFC3339(t.Time))
+}
+
+// UnmarshalJSON decodes an RFC3339 string with any offset, converting it to UTC
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var value *string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	if value == nil || *value == "" {
+		*t = Time{}
+		return nil
+	}
+
+	parsed, err := ParseRFC3339(*value)
+	if err != nil {
+		return err
+	}
+	*t = Time{Time: parsed}
+	return nil
+}
+
+// MarshalBSONValue stores the time as a BSON datetime, or null if unset
+func (t Time) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if t.IsZero() {
+		return bson.TypeNull, nil, nil
+	}
+	return bson.MarshalValue(t.UTC())
+}
+
+// UnmarshalBSONValue decodes a BSON datetime, or an RFC3339 string written by older code
+func (t *Time) UnmarshalBSONValue(bt bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: bt, Value: data}
+
+	switch bt {
+	case bson.TypeNull, bson.TypeUndefined:
+		*t = Time{}
+		return nil
+	case bson.TypeDateTime:
+		*t = NewTime(raw.Time())
+		return nil
+	case bson.TypeString:
+		parsed, err := ParseRFC3339(raw.StringValue())
+		if err != nil {
+			return err
+		}
+		*t = Time{Time: parsed}
+		return nil
+	default:
+		return fmt.Errorf("cannot decode BSON %s into Time", bt)
+	}
+}
+
+// TimeRange represents a half-open time interval [Start, End)
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ParseTimeRange parses RFC3339 start and end timestamps and validates their order
+func ParseTimeRange(start, end string) (TimeRange, error) {
+	startTime, err := ParseRFC3339(start)
+	if err != nil {
+		return TimeRange{}, err
+	}
+
+	endTime, err := ParseRFC3339(end)
+	if err != nil {
+		return TimeRange{}, err
+	}
+
+	if !startTime.Before(endTime) {
+		return TimeRange{}, fmt.Errorf("range start must be before range end")
+	}
+
+	return TimeRange{Start: startTime, End: endTime}, nil
+}
+
+// DayRange returns the range covering the given date in loc
+func DayRange(d Date, loc *time.Location) TimeRange {
+	return TimeRange{Start: d.In(loc), End: d.AddDays(1).In(loc)}
+}
+
+// MonthRange returns the range covering the given month in loc
+func MonthRange(year int, month time.Month, loc *time.Location) TimeRange {
+	return TimeRange{Start: NewDate(year, month, 1).In(loc), End: NewDate(year, month+1, 1).In(loc)}
+}
+
+// LastNDays returns the range covering the last n whole days up to and including today in loc
+func LastNDays(n int, now time.Time, loc *time.Location) TimeRange {
+	today := DateIn(now, loc)
+	return TimeRange{
+		Start: today.AddDays(-(n - 1)).In(loc),
+		End:   today.AddDays(1).In(loc),
+	}
+}
+
+// Contains reports whether t falls within the range
+func (tr TimeRange) Contains(t time.Time) bool {
+	return !t.Before(tr.Start) && t.Before(tr.End)
+}
+
+// Duration returns the length of the range
+func (tr TimeRange) Duration() time.Duration {
+	return tr.End.Sub(tr.Start)
+}
+
+// Days returns every calendar date touched by the range in loc, useful for bucketing analytics
+func (tr TimeRange) Days(loc *time.Location) []Date {
+	if !tr.Start.Before(tr.End) {
+		return nil
+	}
+
+	first := DateIn(tr.Start, loc)
+	last := DateIn(tr.End.Add(-time.Nanosecond), loc)
+
+	var days []Date
+	for d := first; !d.After(last); d = d.AddDays(1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// BSONFilter returns a filter matching documents whose field falls within the range
+func (tr TimeRange) BSONFilter(field string) bson.M {
+	return bson.M{field: bson.M{"$gte": tr.Start, "$lt": tr.End}}
+}
@@ -0,0 +1,331 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	_ "time/tzdata" // The zone tests mustn't depend on the host's zoneinfo
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseRFC3339(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-03-10T07:00:00Z", time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), false},
+		{"2024-03-10T02:30:00-05:00", time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), false},
+		{"2024-03-10T13:00:00+05:30", time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), false},
+		{"2024-03-10T07:00:00.123456789Z", time.Date(2024, 3, 10, 7, 0, 0, 123456789, time.UTC), false},
+		{"2024-12-31T23:00:00-14:00", time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), false},
+		{"2024-03-10 07:00:00", time.Time{}, true},
+		{"2024-03-10", time.Time{}, true},
+		{"2016-12-31T23:59:60Z", time.Time{}, true}, // Leap seconds aren't representable
+		{"", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRFC3339(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRFC3339(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) || (err == nil && got.Location() != time.UTC) {
+				t.Fatalf("ParseRFC3339(%q) = %v, want %v in UTC", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "UTC"},
+		{"America/New_York", "America/New_York"},
+		{"Not/AZone", "UTC"},
+		{"../../etc/passwd", "UTC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LoadLocation(tt.name).String(); got != tt.want {
+				t.Fatalf("LoadLocation(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	if got := UserLocation(nil); got != time.UTC {
+		t.Fatalf("UserLocation(nil) = %s, want UTC", got)
+	}
+	user := &User{Timezone: "Asia/Kolkata"}
+	if got := InUserTimezone(time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), user); got.Hour() != 1 || got.Minute() != 30 || got.Day() != 2 {
+		t.Fatalf("InUserTimezone = %v, want 01:30 the next day", got)
+	}
+}
+
+func TestDateIn(t *testing.T) {
+	instant := time.Date(2024, 12, 31, 11, 30, 0, 0, time.UTC)
+	tests := []struct {
+		zone string
+		want Date
+	}{
+		{"UTC", NewDate(2024, 12, 31)},
+		{"Pacific/Kiritimati", NewDate(2025, 1, 1)},  // UTC+14
+		{"Pacific/Pago_Pago", NewDate(2024, 12, 31)}, // UTC-11
+		{"Asia/Kathmandu", NewDate(2024, 12, 31)},    // UTC+5:45
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			if got := DateIn(instant, mustLoadLocation(t, tt.zone)); got != tt.want {
+				t.Fatalf("DateIn(%v, %s) = %s, want %s", instant, tt.zone, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDayRangeAcrossDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	london := mustLoadLocation(t, "Europe/London")
+	lordHowe := mustLoadLocation(t, "Australia/Lord_Howe") // Shifts by 30 minutes
+	saoPaulo := mustLoadLocation(t, "America/Sao_Paulo")   // Used to spring forward at midnight
+
+	tests := []struct {
+		name      string
+		date      Date
+		loc       *time.Location
+		want      time.Duration
+		wantStart time.Time
+	}{
+		{"ordinary day", NewDate(2024, 6, 1), newYork, 24 * time.Hour, time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC)},
+		{"spring forward", NewDate(2024, 3, 10), newYork, 23 * time.Hour, time.Date(2024, 3, 10, 5, 0, 0, 0, time.UTC)},
+		{"fall back", NewDate(2024, 11, 3), newYork, 25 * time.Hour, time.Date(2024, 11, 3, 4, 0, 0, 0, time.UTC)},
+		{"London spring forward", NewDate(2024, 3, 31), london, 23 * time.Hour, time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"half hour shift", NewDate(2024, 4, 7), lordHowe, 24*time.Hour + 30*time.Minute, time.Date(2024, 4, 6, 13, 0, 0, 0, time.UTC)},
+		{"midnight skipped", NewDate(2018, 11, 4), saoPaulo, 23 * time.Hour, time.Date(2018, 11, 4, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := DayRange(tt.date, tt.loc)
+			if got := r.Duration(); got != tt.want {
+				t.Fatalf("DayRange(%s) lasts %v, want %v", tt.date, got, tt.want)
+			}
+			if !r.Start.Equal(tt.wantStart) {
+				t.Fatalf("DayRange(%s) starts at %v, want %v", tt.date, r.Start.UTC(), tt.wantStart)
+			}
+			if got := DateIn(r.Start, tt.loc); got != tt.date {
+				t.Fatalf("DayRange(%s) starts on %s", tt.date, got)
+			}
+			if got := DateIn(r.End, tt.loc); got != tt.date.AddDays(1) {
+				t.Fatalf("DayRange(%s) ends on %s, want the next day's start", tt.date, got)
+			}
+		})
+	}
+}
+
+func TestMonthRange(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name  string
+		year  int
+		month time.Month
+		loc   *time.Location
+		want  time.Duration
+	}{
+		{"leap February", 2024, time.February, time.UTC, 29 * 24 * time.Hour},
+		{"February", 2023, time.February, time.UTC, 28 * 24 * time.Hour},
+		{"December rolls into the next year", 2024, time.December, time.UTC, 31 * 24 * time.Hour},
+		{"month with spring forward", 2024, time.March, newYork, 31*24*time.Hour - time.Hour},
+		{"month with fall back", 2024, time.November, newYork, 30*24*time.Hour + time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MonthRange(tt.year, tt.month, tt.loc).Duration(); got != tt.want {
+				t.Fatalf("MonthRange(%d, %s) lasts %v, want %v", tt.year, tt.month, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastNDays(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	// 01:00 UTC on March 11 is still March 10 in New York, the day clocks sprang forward
+	now := time.Date(2024, 3, 11, 1, 0, 0, 0, time.UTC)
+	r := LastNDays(3, now, newYork)
+	if want := time.Date(2024, 3, 8, 0, 0, 0, 0, newYork); !r.Start.Equal(want) {
+		t.Fatalf("start = %v, want %v", r.Start, want)
+	}
+	if want := time.Date(2024, 3, 11, 0, 0, 0, 0, newYork); !r.End.Equal(want) {
+		t.Fatalf("end = %v, want %v", r.End, want)
+	}
+	if !r.Contains(now) {
+		t.Fatal("range doesn't contain now")
+	}
+	if got := r.Duration(); got != 3*24*time.Hour-time.Hour {
+		t.Fatalf("duration = %v, want 71h", got)
+	}
+	if got := LastNDays(1, now, time.UTC); !got.Start.Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)) || got.Duration() != 24*time.Hour {
+		t.Fatalf("LastNDays(1) in UTC = %v, want March 11", got)
+	}
+}
+
+func TestTimeRangeDays(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+
+	tests := []struct {
+		name string
+		r    TimeRange
+		loc  *time.Location
+		want []Date
+	}{
+		{"one UTC day", TimeRange{time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)}, time.UTC, []Date{NewDate(2024, 3, 10)}},
+		{"the same instants in New York", TimeRange{time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)}, newYork, []Date{NewDate(2024, 3, 9), NewDate(2024, 3, 10)}},
+		{"the same instants in Tokyo", TimeRange{time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)}, tokyo, []Date{NewDate(2024, 3, 10), NewDate(2024, 3, 11)}},
+		{"fall back day", DayRange(NewDate(2024, 11, 3), newYork), newYork, []Date{NewDate(2024, 11, 3)}},
+		{"spring forward day", DayRange(NewDate(2024, 3, 10), newYork), newYork, []Date{NewDate(2024, 3, 10)}},
+		{"across the new year", TimeRange{time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}, time.UTC, []Date{NewDate(2024, 12, 31), NewDate(2025, 1, 1)}},
+		{"empty", TimeRange{time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)}, time.UTC, nil},
+		{"reversed", TimeRange{time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)}, time.UTC, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.r.Days(tt.loc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Days = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Days = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		wantErr    bool
+	}{
+		{"ordered", "2024-03-10T00:00:00Z", "2024-03-11T00:00:00Z", false},
+		{"ordered across offsets", "2024-03-10T00:00:00+09:00", "2024-03-09T16:00:00Z", false},
+		{"equal instants in different offsets", "2024-03-10T00:00:00+01:00", "2024-03-09T23:00:00Z", true},
+		{"reversed", "2024-03-11T00:00:00Z", "2024-03-10T00:00:00Z", true},
+		{"malformed start", "yesterday", "2024-03-10T00:00:00Z", true},
+		{"malformed end", "2024-03-10T00:00:00Z", "tomorrow", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseTimeRange(tt.start, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeRange error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (r.Start.Location() != time.UTC || r.End.Location() != time.UTC) {
+				t.Fatalf("ParseTimeRange = %v, want UTC bounds", r)
+			}
+		})
+	}
+
+	r := TimeRange{Start: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)}
+	if !r.Contains(r.Start) || r.Contains(r.End) || !r.Contains(r.End.Add(-time.Nanosecond)) {
+		t.Fatal("Contains isn't half-open")
+	}
+}
+
+func TestDateEncoding(t *testing.T) {
+	type doc struct {
+		Date Date `json:"date" bson:"date"`
+	}
+
+	for _, d := range []Date{NewDate(2024, 2, 29), NewDate(1, 1, 1), {}} {
+		data, err := json.Marshal(doc{d})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded doc
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded.Date != d {
+			t.Fatalf("JSON round trip of %q = %v, %v", d, decoded.Date, err)
+		}
+
+		raw, err := bson.Marshal(doc{d})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = doc{}
+		if err := bson.Unmarshal(raw, &decoded); err != nil || decoded.Date != d {
+			t.Fatalf("BSON round trip of %q = %v, %v", d, decoded.Date, err)
+		}
+	}
+
+	// A datetime written in another zone decodes to its UTC date
+	raw, err := bson.Marshal(bson.M{"date": time.Date(2024, 3, 10, 23, 30, 0, 0, mustLoadLocation(t, "America/New_York"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded doc
+	if err := bson.Unmarshal(raw, &decoded); err != nil || decoded.Date != NewDate(2024, 3, 11) {
+		t.Fatalf("BSON datetime decoded as %v, %v, want 2024-03-11", decoded.Date, err)
+	}
+
+	for _, invalid := range []string{`{"date":"2024-02-30"}`, `{"date":"03/10/2024"}`, `{"date":20240310}`} {
+		if err := json.Unmarshal([]byte(invalid), &decoded); err == nil {
+			t.Fatalf("decoding %s succeeded", invalid)
+		}
+	}
+}
+
+func TestTimeEncoding(t *testing.T) {
+	type doc struct {
+		At Time `json:"at" bson:"at"`
+	}
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	// Encodes in UTC whatever zone it was created in
+	local := time.Date(2024, 11, 3, 1, 30, 0, 0, newYork) // The first 01:30, before falling back
+	data, err := json.Marshal(doc{NewTime(local)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"at":"2024-11-03T05:30:00Z"}`; string(data) != want {
+		t.Fatalf("JSON = %s, want %s", data, want)
+	}
+
+	var decoded doc
+	if err := json.Unmarshal([]byte(`{"at":"2024-11-03T01:30:00-05:00"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC); !decoded.At.Equal(want) || decoded.At.Location() != time.UTC {
+		t.Fatalf("decoded %v, want %v in UTC", decoded.At, want)
+	}
+
+	raw, err := bson.Marshal(doc{NewTime(local)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded = doc{}
+	if err := bson.Unmarshal(raw, &decoded); err != nil || !decoded.At.Equal(local) || decoded.At.Location() != time.UTC {
+		t.Fatalf("BSON round trip = %v, %v, want %v in UTC", decoded.At, err, local)
+	}
+
+	// Unset times encode as null and decode back to unset
+	data, err = json.Marshal(doc{})
+	if err != nil || string(data) != `{"at":null}` {
+		t.Fatalf("JSON of an unset time = %s, %v", data, err)
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil || !decoded.At.IsZero() {
+		t.Fatalf("decoded %v, %v, want an unset time", decoded.At, err)
+	}
+}
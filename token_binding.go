@@ -0,0 +1,97 @@
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// TokenBinder extracts the material an access token is bound to from a request
+// An empty result means the request carries nothing to bind to
+type TokenBinder func(r *http.Request) string
+
+// FingerprintBinder binds tokens to the User-Agent and any extra request headers
+// Fingerprints are easy to spoof, so this only raises the bar for replaying a stolen token
+func FingerprintBinder(headers ...string) TokenBinder {
+	return func(r *http.Request) string {
+		parts := []string{r.UserAgent()}
+		for _, header := range headers {
+			parts = append(parts, r.Header.Get(header))
+		}
+		if strings.Join(parts, "") == "" {
+			return ""
+		}
+		return strings.Join(parts, "\n")
+	}
+}
+
+// DeviceSecretBinder binds tokens to a secret the client generates once and sends in a header
+// The secret never appears in the token; only its hash does
+func DeviceSecretBinder(header string) TokenBinder {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// TokenBindingConfig controls whether access tokens are bound to the client that requested them
+type TokenBindingConfig struct {
+	Binder   TokenBinder // Extracts binding material; nil disables binding
+	Required bool        // Reject tokens without a binding claim instead of accepting them
+}
+
+var (
+	tokenBindingMu sync.RWMutex
+	tokenBinding   TokenBindingConfig
+)
+
+// SetTokenBinding sets the binding applied by Login and verified by Authenticate
+// Pass a zero TokenBindingConfig to disable binding
+func SetTokenBinding(config TokenBindingConfig) {
+	tokenBindingMu.Lock()
+	defer tokenBindingMu.Unlock()
+	tokenBinding = config
+}
+
+// currentTokenBinding returns the active token binding configuration
+func currentTokenBinding() TokenBindingConfig {
+	tokenBindingMu.RLock()
+	defer tokenBindingMu.RUnlock()
+	return tokenBinding
+}
+
+// TokenBindingHash returns the hash to embed in a token issued for the request, or "" if
+// binding is disabled or the request has no binding material
+func TokenBindingHash(r *http.Request) string {
+	binder := currentTokenBinding().Binder
+	if binder == nil {
+		return ""
+	}
+	return hashBindingMaterial(binder(r))
+}
+
+// hashBindingMaterial hashes binding material for storage in a claim
+func hashBindingMaterial(material string) string {
+	if material == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(material))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyTokenBinding checks a token's binding claim against the request
+// Tokens issued before binding was enabled are accepted unless binding is required
+func verifyTokenBinding(r *http.Request, claimed string) bool {
+	config := currentTokenBinding()
+	if config.Binder == nil {
+		return true
+	}
+	if claimed == "" {
+		return !config.Required
+	}
+
+	actual := hashBindingMaterial(config.Binder(r))
+	return actual != "" && subtle.ConstantTimeCompare([]byte(actual), []byte(claimed)) == 1
+}
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// revocationCacheTTL is how long a revocation lookup is reused before asking the database again
+// Revocations made by this process apply immediately; ones made by other instances within this delay.
+const revocationCacheTTL = 30 * time.Second
+
+// maxRevocationCacheEntries bounds the lookup cache; it is cleared when full
+const maxRevocationCacheEntries = 10000
+
+// allUsersRevocation is the token_revocations ID of the cutoff that applies to every user
+const allUsersRevocation = "*"
+
+// sessionRevocationPrefix prefixes session IDs in revoked_tokens, keeping them apart from jtis
+const sessionRevocationPrefix = "sid:"
+
+var ErrTokenRevocationDisabled = errors.New("token revocation is not enabled")
+
+// tokenRevocation holds the revocation collections and a short-lived lookup cache
+type tokenRevocation struct {
+	tokens *mongo.Collection // Revoked token IDs, removed once the token would have expired anyway
+	cutoff *mongo.Collection // "Issued before" cutoffs per user, plus one for all users
+
+	mu    sync.Mutex
+	cache map[string]cachedRevocation
+}
+
+// cachedRevocation is a cached jti or cutoff lookup
+type cachedRevocation struct {
+	revoked   bool
+	notBefore time.Time
+	fetchedAt time.Time
+}
+
+var (
+	revocationMu sync.RWMutex
+	revocation   *tokenRevocation
+)
+
+// EnableTokenRevocation makes Authenticate reject revoked tokens, tracked in the database's
+// revoked_tokens and token_revocations collections
+func EnableTokenRevocation(ctx context.Context, database *mongo.Database) error {
+	tokens := database.Collection("revoked_tokens")
+	_, err := tokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	revocationMu.Lock()
+	defer revocationMu.Unlock()
+	revocation = &tokenRevocation{
+		tokens: tokens,
+		cutoff: database.Collection("token_revocations"),
+		cache:  map[string]cachedRevocation{},
+	}
+	return nil
+}
+
+// currentTokenRevocation returns the revocation store, or nil if revocation is disabled
+func currentTokenRevocation() *tokenRevocation {
+	revocationMu.RLock()
+	defer revocationMu.RUnlock()
+	return revocation
+}
+
+// RevokeToken revokes a single access token by its jti claim until it expires
+func RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	store := currentTokenRevocation()
+	if store == nil {
+		return ErrTokenRevocationDisabled
+	}
+
+	_, err := store.tokens.UpdateOne(ctx,
+		bson.M{"_id": jti},
+		bson.M{"$setOnInsert": bson.M{"user_id": userID, "revoked_at": time.Now(), "expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	store.remember("jti:"+jti, cachedRevocation{revoked: true})
+	return nil
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to a user so far, e.g. after a compromise
+// Refresh tokens are revoked even when access token revocation is disabled, which is then reported with
+// ErrTokenRevocationDisabled.
+func RevokeUserTokens(ctx context.Context, userID string) error {
+	now := time.Now()
+	if err := revokeUserRefreshTokens(ctx, userID, ""); err != nil {
+		return err
+	}
+	return revokeIssuedBefore(ctx, userID, now)
+}
+
+// RevokeTokensIssuedBefore revokes every user's access tokens issued up to t, and the refresh tokens of
+// sessions started by then, so revoked users can't mint new access tokens. Refresh tokens are revoked even
+// when access token revocation is disabled, which is then reported with ErrTokenRevocationDisabled.
+func RevokeTokensIssuedBefore(ctx context.Context, t time.Time) error {
+	if err := revokeRefreshTokensCreatedBefore(ctx, t); err != nil {
+		return err
+	}
+	return revokeIssuedBefore(ctx, allUsersRevocation, t)
+}
+
+// revokeIssuedBefore records an "issued before" cutoff for a user, or for all users
+func revokeIssuedBefore(ctx context.Context, id string, t time.Time) error {
+	store := currentTokenRevocation()
+	if store == nil {
+		return ErrTokenRevocationDisabled
+	}
+
+	// iat has second precision, so a token issued in t's second may predate t: cut off at the next second.
+	// Tokens issued later in that second are revoked too, which is safer than keeping earlier ones valid.
+	notBefore := t.Truncate(time.Second).Add(time.Second)
+	_, err := store.cutoff.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$max": bson.M{"not_before": notBefore}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	store.remember("cutoff:"+id, cachedRevocation{notBefore: notBefore})
+	return nil
+}
+
+// revokeUserRefreshTokens revokes a user's refresh tokens, except those of the session except, if refresh tokens
+// are enabled
+func revokeUserRefreshTokens(ctx context.Context, userID, except string) error {
+	collection, _ := refreshTokenStore()
+	if collection == nil {
+		return nil
+	}
+
+	filter := bson.M{"user_id": userID, "revoked_at": nil}
+	if except != "" {
+		filter["$nor"] = bson.A{sessionFilter(except)}
+	}
+	_, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// revokeRefreshTokensCreatedBefore revokes every user's refresh tokens of sessions started up to t, if refresh
+// tokens are enabled; rotated tokens keep their session's creation time
+func revokeRefreshTokensCreatedBefore(ctx context.Context, t time.Time) error {
+	collection, _ := refreshTokenStore()
+	if collection == nil {
+		return nil
+	}
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"created_at": bson.M{"$lte": t}, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// isTokenRevoked reports whether an access token has been revoked by jti, by revoking its session,
+// or by an "issued before" cutoff. It always reports false when revocation is disabled.
+func isTokenRevoked(ctx context.Context, jti, sessionID, userID string, issuedAt time.Time) (bool, error) {
+	store := currentTokenRevocation()
+	if store == nil {
+		return false, nil
+	}
+
+	// Revoked sessions are stored alongside revoked jtis, under a prefix
+	ids := []string{jti}
+	if sessionID != "" {
+		ids = append(ids, sessionRevocationPrefix+sessionID)
+	}
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		entry, err := store.lookup(ctx, "jti:"+id, func(ctx context.Context) (cachedRevocation, error) {
+			err := store.tokens.FindOne(ctx, bson.M{"_id": id}).Err()
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return cachedRevocation{}, nil
+			}
+			return cachedRevocation{revoked: err == nil}, err
+		})
+		if err != nil || entry.revoked {
+			return entry.revoked, err
+		}
+	}
+
+	for _, id := range []string{userID, allUsersRevocation} {
+		entry, err := store.lookup(ctx, "cutoff:"+id, func(ctx context.Context) (cachedRevocation, error) {
+			var cutoff struct {
+				NotBefore time.Time `bson:"not_before"`
+			}
+			err := store.cutoff.FindOne(ctx, bson.M{"_id": id}).Decode(&cutoff)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return cachedRevocation{}, nil
+			}
+			return cachedRevocation{notBefore: cutoff.NotBefore}, err
+		})
+		if err != nil {
+			return false, err
+		}
+		if issuedAt.Before(entry.notBefore) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookup returns a cached entry for key, loading it if missing or stale
+func (s *tokenRevocation) lookup(ctx context.Context, key string, load func(context.Context) (cachedRevocation, error)) (cachedRevocation, error) {
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < revocationCacheTTL {
+		return entry, nil
+	}
+
+	entry, err := load(ctx)
+	if err != nil {
+		return entry, err
+	}
+	s.remember(key, entry)
+	return entry, nil
+}
+
+// remember caches an entry, clearing the cache first if it is full
+func (s *tokenRevocation) remember(key string, entry cachedRevocation) {
+	entry.fetchedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cache) >= maxRevocationCacheEntries {
+		clear(s.cache)
+	}
+	s.cache[key] = entry
+}
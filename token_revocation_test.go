@@ -0,0 +1,176 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testUserID = "0d9f8a57-3a5e-4f7e-9b38-3c1f0b7c2a11"
+
+func TestRevokeIssuedBeforeCutoff(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("tokens issued up to the revocation", func(mt *mtest.T) {
+		useTokenRevocation(mt.T, mt.DB)
+		store := currentTokenRevocation()
+		store.remember("cutoff:"+allUsersRevocation, cachedRevocation{})
+
+		revokedAt := time.Now().Truncate(time.Second).Add(600 * time.Millisecond)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		if err := revokeIssuedBefore(context.Background(), testUserID, revokedAt); err != nil {
+			mt.Fatal(err)
+		}
+
+		// iat has second precision, as in a parsed token
+		tests := []struct {
+			name     string
+			issuedAt time.Time
+			want     bool
+		}{
+			{"earlier second", revokedAt.Truncate(time.Second).Add(-time.Second), true},
+			{"same second, before the revocation", revokedAt.Truncate(time.Second), true},
+			{"next second", revokedAt.Truncate(time.Second).Add(time.Second), false},
+		}
+		for _, tt := range tests {
+			revoked, err := isTokenRevoked(context.Background(), "", "", testUserID, tt.issuedAt)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if revoked != tt.want {
+				mt.Errorf("%s: revoked = %v, want %v", tt.name, revoked, tt.want)
+			}
+		}
+	})
+}
+
+func TestRevokeTokensIssuedBeforeRevokesRefreshTokens(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	for _, enabled := range []bool{true, false} {
+		name := "access token revocation enabled"
+		if !enabled {
+			name = "access token revocation disabled"
+		}
+		mt.Run(name, func(mt *mtest.T) {
+			if enabled {
+				useTokenRevocation(mt.T, mt.DB)
+			}
+			useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}, bson.E{Key: "nModified", Value: 3}),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			)
+
+			cutoff := time.Now()
+			err := RevokeTokensIssuedBefore(context.Background(), cutoff)
+			if enabled && err != nil {
+				mt.Fatal(err)
+			}
+			if !enabled && !errors.Is(err, ErrTokenRevocationDisabled) {
+				mt.Fatalf("error = %v, want %v", err, ErrTokenRevocationDisabled)
+			}
+
+			refresh := mt.GetStartedEvent()
+			if refresh == nil || refresh.Command.Lookup("update").StringValue() != "refresh_tokens" {
+				mt.Fatal("refresh tokens were not revoked")
+			}
+			filter := refresh.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q")
+			if createdBefore := filter.Document().Lookup("created_at", "$lte").Time(); !createdBefore.Equal(cutoff.Truncate(time.Millisecond)) {
+				mt.Fatalf("refresh tokens revoked up to %v, want %v", createdBefore, cutoff)
+			}
+		})
+	}
+}
+
+func TestRevokeUserTokensWithoutAccessTokenRevocation(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("refresh tokens still revoked", func(mt *mtest.T) {
+		useRefreshTokens(mt.T, mt.DB.Collection("refresh_tokens"))
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}))
+
+		if err := RevokeUserTokens(context.Background(), testUserID); !errors.Is(err, ErrTokenRevocationDisabled) {
+			mt.Fatalf("error = %v, want %v", err, ErrTokenRevocationDisabled)
+		}
+		refresh := mt.GetStartedEvent()
+		if refresh == nil || refresh.Command.Lookup("update").StringValue() != "refresh_tokens" {
+			mt.Fatal("refresh tokens were not revoked")
+		}
+		update := refresh.Command.Lookup("updates").Array().Index(0).Value().Document()
+		if user := update.Lookup("q", "user_id").StringValue(); user != testUserID {
+			mt.Fatalf("revoked refresh tokens of %q, want %q", user, testUserID)
+		}
+		if _, err := update.LookupErr("u", "$set", "revoked_at"); err != nil {
+			mt.Fatal("refresh tokens were not marked revoked")
+		}
+	})
+}
+
+func TestMiddlewareRejectsRevokedTokens(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("revoked tokens", func(mt *mtest.T) {
+		useTokenRevocation(mt.T, mt.DB)
+		auth, err := NewAuth(AuthConfig{Secret: testSecret})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		// Lookups are served from the revocation cache, as after a revocation by this process
+		now := time.Now()
+		store := currentTokenRevocation()
+		store.remember("jti:revoked-jti", cachedRevocation{revoked: true})
+		store.remember("jti:"+sessionRevocationPrefix+"revoked-session", cachedRevocation{revoked: true})
+		store.remember("cutoff:"+testUserID, cachedRevocation{notBefore: now.Truncate(time.Second)})
+		store.remember("cutoff:"+allUsersRevocation, cachedRevocation{})
+		for _, id := range []string{"fresh-jti", "old-jti", "session-jti"} {
+			store.remember("jti:"+id, cachedRevocation{})
+		}
+		store.remember("jti:"+sessionRevocationPrefix+"active-session", cachedRevocation{})
+
+		tests := []struct {
+			name      string
+			jti       string
+			sessionID string
+			issuedAt  time.Time
+			want      int
+		}{
+			{"active token", "fresh-jti", "active-session", now, http.StatusNoContent},
+			{"revoked jti", "revoked-jti", "", now, http.StatusUnauthorized},
+			{"revoked session", "session-jti", "revoked-session", now, http.StatusUnauthorized},
+			{"issued before the user's cutoff", "old-jti", "", now.Add(-time.Hour), http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			token, err := auth.IssueClaims(httptest.NewRequest(http.MethodGet, "/", nil), &AppClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:  testUserID,
+					ID:       tt.jti,
+					IssuedAt: jwt.NewNumericDate(tt.issuedAt),
+				},
+				SessionID: tt.sessionID,
+			})
+			if err != nil {
+				mt.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/me", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				mt.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+			}
+		}
+	})
+}
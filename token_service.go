@@ -0,0 +1,110 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignedTokenInvalid = errors.New("signed token is invalid")
+	ErrSignedTokenExpired = errors.New("signed token has expired")
+)
+
+// SignedTokenClaims is the payload of a token issued by TokenService
+type SignedTokenClaims struct {
+	Purpose   string `json:"p"`             // What the token may be used for, e.g. "account_unlock"
+	Subject   string `json:"sub"`           // Usually a user ID
+	Binding   string `json:"bnd,omitempty"` // Hash of state the token is only valid for, see TokenService.Issue
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenService issues short-lived HMAC-signed tokens for links sent by email
+// Each purpose uses its own derived key, so a token for one flow can't be replayed in another.
+type TokenService struct {
+	secret []byte
+}
+
+// NewTokenService creates a token service from a secret of at least 32 characters, e.g. JWT_SECRET
+func NewTokenService(secret string) (*TokenService, error) {
+	if err := ValidateJWTSecret(secret); err != nil {
+		return nil, err
+	}
+	return &TokenService{secret: []byte(secret)}, nil
+}
+
+// Issue creates a token for purpose and subject that expires after ttl
+// binding is optional state the token is tied to, e.g. the time an account was locked; once that
+// state changes the token stops verifying, which makes it effectively single-use
+func (s *TokenService) Issue(purpose, subject, binding string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	payload, err := json.Marshal(SignedTokenClaims{
+		Purpose:   purpose,
+		Subject:   subject,
+		Binding:   hashTokenBinding(binding),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signed token: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(purpose, encoded), nil
+}
+
+// Verify checks a token's signature, purpose and expiry and returns its claims
+// Callers that issued the token with a binding must check it with MatchesBinding.
+func (s *TokenService) Verify(token, purpose string) (*SignedTokenClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrSignedTokenInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(purpose, encoded))) {
+		return nil, ErrSignedTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrSignedTokenInvalid
+	}
+
+	var claims SignedTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Purpose != purpose {
+		return nil, ErrSignedTokenInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrSignedTokenExpired
+	}
+	return &claims, nil
+}
+
+// MatchesBinding reports whether the claims were issued for the given binding state
+func (c *SignedTokenClaims) MatchesBinding(binding string) bool {
+	return hmac.Equal([]byte(c.Binding), []byte(hashTokenBinding(binding)))
+}
+
+// sign returns the signature of an encoded payload using the key derived for purpose
+func (s *TokenService) sign(purpose, encoded string) string {
+	key := hmac.New(sha256.New, s.secret)
+	key.Write([]byte("purpose:" + purpose))
+
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hashTokenBinding hashes binding state so it isn't readable from the token
+func hashTokenBinding(binding string) string {
+	if binding == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(binding))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
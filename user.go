@@ -2,6 +2,7 @@ package common
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
@@ -13,7 +14,11 @@ import (
 type contextKey string
 
 const (
-	userKey contextKey = "userID"
+	userKey   contextKey = "userID"
+	claimsKey contextKey = "claims"
+
+	policyResourceKey contextKey = "policyResource"
+	requestIDKey      contextKey = "requestID"
 )
 
 // SetUserID stores the user ID in the request context
@@ -31,21 +36,39 @@ func GetUserID(r *http.Request) string {
 // User has better field ordering for memory efficiency
 type User struct {
 	// time.Time fields first (largest)
-	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time  `json:"-" bson:"updated_at"`
-	LastLoginAt time.Time  `json:"-" bson:"last_login_at"`
-	VerifiedAt  *time.Time `json:"-" bson:"verified_at"`  // 8 bytes (pointer)
-	LockedUntil *time.Time `json:"-" bson:"locked_until"` // 8 bytes (pointer)
+	CreatedAt   Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt   Time  `json:"updated_at" bson:"updated_at"`
+	LastLoginAt Time  `json:"-" bson:"last_login_at"`
+	VerifiedAt  *Time `json:"-" bson:"verified_at"`  // 8 bytes (pointer)
+	LockedUntil *Time `json:"-" bson:"locked_until"` // 8 bytes (pointer)
+
+	PasswordChangedAt *Time `json:"-" bson:"password_changed_at,omitempty"` // Unset for accounts created before it was tracked
 
 	// String fields
 	ID       string `json:"id" bson:"_id"`
 	Email    string `json:"email" bson:"email"`
 	Password string `json:"-" bson:"password"`
 	Name     string `json:"name" bson:"name"`
+	Locale   string `json:"locale" bson:"locale"`     // Preferred locale, e.g. "en-US"
+	Timezone string `json:"timezone" bson:"timezone"` // IANA timezone name, e.g. "America/New_York"
+	Units    string `json:"units" bson:"units"`       // Preferred unit system, "metric" or "imperial"
+
+	InviteCode string `json:"-" bson:"invite_code,omitempty"` // Invite code used to register, if any
+	ReferredBy string `json:"-" bson:"referred_by,omitempty"` // ID of the user whose referral link was used
+
+	Phone           string `json:"phone,omitempty" bson:"phone,omitempty"` // Verified E.164 phone number, used for SMS login
+	PhoneVerifiedAt *Time  `json:"-" bson:"phone_verified_at,omitempty"`
+
+	Roles      []string           `json:"roles,omitempty" bson:"roles,omitempty"` // Granted roles, copied into access tokens
+	Identities []ExternalIdentity `json:"-" bson:"identities,omitempty"`          // Linked identity provider accounts
 
 	// Smaller integer and boolean fields grouped together
-	LoginAttempts int  `json:"-" bson:"login_attempts"` // 8 bytes on 64-bit
-	IsVerified    bool `json:"-" bson:"is_verified"`    // 1 byte
+	Version       int64 `json:"version" bson:"version"`  // 8 bytes, incremented on every update
+	LoginAttempts int   `json:"-" bson:"login_attempts"` // 8 bytes on 64-bit
+	LockoutCount  int   `json:"-" bson:"lockout_count"`  // Lockouts since the last successful login
+	IsVerified    bool  `json:"-" bson:"is_verified"`    // 1 byte
+
+	PermanentlyLocked bool `json:"-" bson:"permanently_locked,omitempty"` // Locked until UnlockUser, see LockoutPolicy
 }
 
 func GetUser(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
@@ -62,6 +85,7 @@ func GetUser(database *mongo.Database, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	SetVersionETag(w, user.Version)
 	RespondWithJSON(w, http.StatusOK, user)
 }
 
@@ -72,17 +96,51 @@ func UpdateUser(database *mongo.Database, w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	expectedVersion, err := ParseIfMatchVersion(r)
+	if err != nil {
+		RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
 	userForm := User{}
 	if !ValidateAndBindJSON(w, r, &userForm) {
 		return
 	}
-	userForm.UpdatedAt = time.Now()
 
-	_, err := database.Collection("users").UpdateOne(r.Context(), bson.M{"_id": userID}, bson.M{"$set": userForm})
+	// Only profile fields are client-editable; empty fields are left unchanged
+	fields := bson.M{}
+	if name := SanitizeInput(userForm.Name); name != "" {
+		fields["name"] = name
+	}
+	if userForm.Locale != "" {
+		fields["locale"] = SanitizeInput(userForm.Locale)
+	}
+	if userForm.Timezone != "" {
+		if _, err := time.LoadLocation(userForm.Timezone); err != nil {
+			RespondWithValidationError(w, "timezone", "must be a valid IANA timezone")
+			return
+		}
+		fields["timezone"] = userForm.Timezone
+	}
+	if userForm.Units != "" {
+		if userForm.Units != string(UnitSystemMetric) && userForm.Units != string(UnitSystemImperial) {
+			RespondWithValidationError(w, "units", "must be metric or imperial")
+			return
+		}
+		fields["units"] = userForm.Units
+	}
+
+	var user User
+	err = UpdateAndReturnDocument(r.Context(), database.Collection("users"), bson.M{"_id": userID}, bson.M{"$set": fields}, expectedVersion, &user)
 	if err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			RespondWithJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "User was modified by another request"})
+			return
+		}
 		RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, userForm)
+	SetVersionETag(w, user.Version)
+	RespondWithJSON(w, http.StatusOK, user)
 }
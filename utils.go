@@ -1,30 +1,30 @@
 package common
 
 import (
-	"encoding/json"
 	"net/http"
-	"strings"
 	"time"
+
+	"github.com/adhiravishankar/ar-go-common/httpx"
 )
 
-// ValidateRequiredFields checks if required fields are not empty
+// ValidateRequiredFields checks if required fields are not empty; it forwards to httpx.ValidateRequiredFields
 func ValidateRequiredFields(w http.ResponseWriter, fields map[string]string) bool {
-	for field, value := range fields {
-		if strings.TrimSpace(value) == "" {
-			RespondWithValidationError(w, field, "is required")
-			return false
-		}
-	}
-	return true
+	return httpx.ValidateRequiredFields(w, fields)
 }
 
-// ValidateAndBindJSON validates and binds JSON input with proper error handling
+// ValidateAndBindJSON validates and binds JSON input with proper error handling; it forwards to httpx.ValidateAndBindJSON
 func ValidateAndBindJSON(w http.ResponseWriter, r *http.Request, target interface{}) bool {
-	if err := json.NewDecoder(r.Body).Decode(target); err != nil {
-		RespondWithError(w, 400, err)
-		return false
-	}
-	return true
+	return httpx.ValidateAndBindJSON(w, r, target)
+}
+
+// ParseIfMatchVersion reads the expected document version from the If-Match header; it forwards to httpx.ParseIfMatchVersion
+func ParseIfMatchVersion(r *http.Request) (*int64, error) {
+	return httpx.ParseIfMatchVersion(r)
+}
+
+// SetVersionETag exposes a document version as an ETag so clients can send it back in If-Match; it forwards to httpx.SetVersionETag
+func SetVersionETag(w http.ResponseWriter, version int64) {
+	httpx.SetVersionETag(w, version)
 }
 
 // HealthCheckResponse represents a health check response